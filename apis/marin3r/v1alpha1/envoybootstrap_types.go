@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	envoy_bootstrap_options "github.com/3scale/marin3r/pkg/envoy/bootstrap/options"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -66,6 +67,24 @@ type EnvoyStaticConfig struct {
 	// AdminAccessLogPath configures where the envoy's admin server logs are written to
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	AdminAccessLogPath string `json:"adminAccessLogPath"`
+	// XdsConfigSource selects how the envoy client subscribes to the discovery service resources.
+	// With "ADS" a single aggregated discovery service stream is used for all the resource types while
+	// with "GRPC" envoy opens a separate gRPC stream per resource type (CDS, LDS, RTDS ...). Note that
+	// the config sources of EDS, RDS and SDS are part of the resources published in the EnvoyConfig.
+	// Defaults to "ADS".
+	// +kubebuilder:validation:Enum=ADS;GRPC
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	XdsConfigSource *string `json:"xdsConfigSource,omitempty"`
+}
+
+// GetXdsConfigSource returns the config source type that the
+// envoy client uses to subscribe to the discovery service
+func (esc *EnvoyStaticConfig) GetXdsConfigSource() envoy_bootstrap_options.XdsConfigSourceType {
+	if esc.XdsConfigSource != nil {
+		return envoy_bootstrap_options.XdsConfigSourceType(*esc.XdsConfigSource)
+	}
+	return envoy_bootstrap_options.AdsConfigSource
 }
 
 // ClientCertificate allows specifying options for the
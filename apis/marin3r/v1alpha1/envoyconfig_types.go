@@ -17,6 +17,9 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
+	legacy "github.com/3scale/marin3r/apis/marin3r/v1alpha1/legacy"
 	"github.com/3scale/marin3r/pkg/envoy"
 	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
 	"github.com/3scale/marin3r/pkg/util"
@@ -52,6 +55,69 @@ const (
 	// RollbackFailedState indicates that there is no untainted revision that
 	// can be pusblished in the xds server cache
 	RollbackFailedState string = "RollbackFailed"
+
+	// CanaryState indicates that a new revision is being rolled out to a subset
+	// of the envoy clients while the others are still served the previous one
+	CanaryState string = "Canary"
+
+	// PinnedState indicates that the revision published in the xds server
+	// cache has been pinned in the spec, regardless of the resources spec
+	PinnedState string = "Pinned"
+
+	/* Rollout phases */
+
+	// RolloutCanaryPhase indicates that the new revision is
+	// being served to the canary clients during the bake time
+	RolloutCanaryPhase string = "Canary"
+
+	// RolloutPromotedPhase indicates that the new revision has been
+	// promoted and is served to all the clients
+	RolloutPromotedPhase string = "Promoted"
+
+	// RolloutAbortedPhase indicates that the new revision has been rejected
+	// during the bake time and the canary clients have been rolled back
+	RolloutAbortedPhase string = "Aborted"
+
+	// RolloutNoCanariesPhase indicates that the bake time of the new revision
+	// is over but it has not been promoted because no client has been selected
+	// as canary
+	RolloutNoCanariesPhase string = "NoCanaries"
+
+	/* Taint policies */
+
+	// ImmediateTaintPolicy taints a revision as soon as
+	// any of the envoy clients returns a NACK for it
+	ImmediateTaintPolicy TaintPolicyMode = "Immediate"
+
+	// QuorumTaintPolicy taints a revision once a minimum number
+	// or percentage of the envoy clients return a NACK for it
+	QuorumTaintPolicy TaintPolicyMode = "Quorum"
+
+	// ReportOnlyTaintPolicy never taints a revision. The NACKs are
+	// only reported in the clients status of the revision.
+	ReportOnlyTaintPolicy TaintPolicyMode = "ReportOnly"
+
+	/* Defaults */
+
+	// DefaultCanaryPercentage is the percentage of clients that
+	// get a new revision first when no selector is set
+	DefaultCanaryPercentage int32 = 10
+
+	// DefaultCanaryBakeTime is the time new revisions are served
+	// to the canaries before being promoted
+	DefaultCanaryBakeTime = 5 * time.Minute
+
+	// DefaultMaxRevisions is the maximum number of revisions
+	// kept for an EnvoyConfig
+	DefaultMaxRevisions int32 = 10
+
+	// DefaultKeepUntaintedRevisions is the number of most recent
+	// untainted revisions that are never deleted
+	DefaultKeepUntaintedRevisions int32 = 1
+
+	// DefaultTaintQuorumPercentage is the percentage of clients that need to
+	// return a NACK for a revision to be tainted when no quorum is set
+	DefaultTaintQuorumPercentage int32 = 50
 )
 
 // EnvoyConfigSpec defines the desired state of EnvoyConfig
@@ -75,6 +141,198 @@ type EnvoyConfigSpec struct {
 	// EnvoyResources holds the different types of resources suported by the envoy discovery service
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	EnvoyResources *EnvoyResources `json:"envoyResources"`
+	// RolloutStrategy determines how new revisions are rolled out to the envoy
+	// clients. By default they are published to all the clients at once.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	RolloutStrategy *RolloutStrategy `json:"rolloutStrategy,omitempty"`
+	// RevisionPin is the version of one of the revisions listed in status.revisions. When set, that
+	// revision is published whatever the EnvoyResources field says, even if it is tainted. It is used
+	// to manually roll back to a previous config. Remove it to resume publishing the resources spec.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	RevisionPin *string `json:"revisionPin,omitempty"`
+	// RevisionRetention determines which of the revisions of the EnvoyConfig are deleted.
+	// By default the 10 most recent revisions are kept.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	RevisionRetention *RevisionRetention `json:"revisionRetention,omitempty"`
+	// TaintTTL is the time after which a tainted revision is untainted, so it becomes
+	// eligible for publishing again. This allows recovering from transient failures, like
+	// a Secret that was not ready yet. Taints never expire if unset.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	TaintTTL *metav1.Duration `json:"taintTTL,omitempty"`
+	// TaintPolicy determines when the NACKs returned by the envoy clients taint a
+	// revision. By default a revision is tainted as soon as any client returns a NACK.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	TaintPolicy *TaintPolicy `json:"taintPolicy,omitempty"`
+}
+
+// TaintPolicyMode is the mode of a TaintPolicy
+type TaintPolicyMode string
+
+// TaintPolicy determines when the NACKs returned by the envoy clients taint a revision
+type TaintPolicy struct {
+	// Mode is one of "Immediate", "Quorum" or "ReportOnly". "Immediate" taints a revision as soon
+	// as any client returns a NACK for it. "Quorum" taints it once the quorum set by MinClients or
+	// Percentage is reached. "ReportOnly" never taints it. Defaults to "Immediate".
+	// +kubebuilder:validation:Enum=Immediate;Quorum;ReportOnly
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Mode *TaintPolicyMode `json:"mode,omitempty"`
+	// MinClients is the number of clients that need to return a NACK
+	// for a revision to be tainted in "Quorum" mode.
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	MinClients *int32 `json:"minClients,omitempty"`
+	// Percentage is the percentage of the clients the revision is published to that need
+	// to return a NACK for a revision to be tainted in "Quorum" mode. If both MinClients and
+	// Percentage are set, the revision is tainted as soon as any of them is reached. Defaults
+	// to 50 if MinClients is not set either.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+}
+
+// GetMode returns the mode of the taint policy, with the default applied
+func (tp *TaintPolicy) GetMode() TaintPolicyMode {
+	if tp == nil || tp.Mode == nil {
+		return ImmediateTaintPolicy
+	}
+	return *tp.Mode
+}
+
+// IsQuorumReached returns true if 'nacked' clients out of 'connected'
+// reach the quorum required to taint a revision in "Quorum" mode
+func (tp *TaintPolicy) IsQuorumReached(nacked, connected int) bool {
+	if nacked == 0 {
+		return false
+	}
+	if tp != nil && tp.MinClients != nil && nacked >= int(*tp.MinClients) {
+		return true
+	}
+	percentage := DefaultTaintQuorumPercentage
+	switch {
+	case tp != nil && tp.Percentage != nil:
+		percentage = *tp.Percentage
+	case tp != nil && tp.MinClients != nil:
+		return false
+	}
+	return nacked*100 >= int(percentage)*connected
+}
+
+// RevisionRetention determines which of the revisions of an EnvoyConfig are
+// deleted. The revision for the resources spec and the published, canary and
+// pinned revisions are never deleted.
+type RevisionRetention struct {
+	// MaxRevisions is the maximum number of revisions kept. The oldest ones
+	// are deleted first. Defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	MaxRevisions *int32 `json:"maxRevisions,omitempty"`
+	// MaxAge is the time after which the revisions that have not been published
+	// since are deleted. The age of a revision that has never been published is
+	// counted from its creation. Revisions are not deleted by age if unset.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+	// KeepUntainted is the number of most recent untainted revisions that are always
+	// kept, regardless of MaxRevisions and MaxAge, so there are known good revisions
+	// to roll back to. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	KeepUntainted *int32 `json:"keepUntainted,omitempty"`
+}
+
+// GetMaxRevisions returns the maximum number of revisions
+// kept, with the default applied
+func (rr *RevisionRetention) GetMaxRevisions() int {
+	if rr == nil || rr.MaxRevisions == nil {
+		return int(DefaultMaxRevisions)
+	}
+	return int(*rr.MaxRevisions)
+}
+
+// GetMaxAge returns the time after which unpublished revisions
+// are deleted. Zero means that revisions are not deleted by age.
+func (rr *RevisionRetention) GetMaxAge() time.Duration {
+	if rr == nil || rr.MaxAge == nil {
+		return 0
+	}
+	return rr.MaxAge.Duration
+}
+
+// GetKeepUntainted returns the number of most recent untainted
+// revisions that are always kept, with the default applied
+func (rr *RevisionRetention) GetKeepUntainted() int {
+	if rr == nil || rr.KeepUntainted == nil {
+		return int(DefaultKeepUntaintedRevisions)
+	}
+	return int(*rr.KeepUntainted)
+}
+
+// RolloutStrategy determines how new revisions are rolled out to the envoy clients
+type RolloutStrategy struct {
+	// Canary publishes new revisions first to a subset of the connected clients. A new
+	// revision is promoted to all the clients after a bake time in which no client
+	// rejects it, as long as some client has been selected as canary. If a canary
+	// rejects it, the rollout is aborted and the canaries get the previous revision
+	// back, whatever the taint policy. Whether the revision is also tainted depends
+	// on the taint policy. An aborted rollout is only started again once the
+	// untaint annotation is set in the revision, whether it is tainted or not.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Canary *CanaryRollout `json:"canary,omitempty"`
+}
+
+// CanaryRollout determines which clients get new revisions first and for how long
+type CanaryRollout struct {
+	// Percentage of the clients, among the ones matched by the selector, that get new revisions
+	// first. The clients are picked by a hash of their envoy node ID and IP address, so a client is
+	// consistently picked or not. Defaults to 10 if no selector is set, and to 100 otherwise.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Percentage *int32 `json:"percentage,omitempty"`
+	// Selector selects the clients that get new revisions first. It is matched
+	// against the string fields at the top level of the envoy node metadata.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// BakeTime is the time new revisions are served to the canaries before
+	// being promoted to all the clients. Defaults to 5m.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	BakeTime *metav1.Duration `json:"bakeTime,omitempty"`
+}
+
+// GetPercentage returns the percentage of clients that
+// get new revisions first, with the default applied
+func (cr *CanaryRollout) GetPercentage() int32 {
+	if cr.Percentage != nil {
+		return *cr.Percentage
+	}
+	if cr.Selector != nil {
+		return 100
+	}
+	return DefaultCanaryPercentage
+}
+
+// GetBakeTime returns the time new revisions are served to
+// the canaries before being promoted, with the default applied
+func (cr *CanaryRollout) GetBakeTime() time.Duration {
+	if cr.BakeTime == nil {
+		return DefaultCanaryBakeTime
+	}
+	return cr.BakeTime.Duration
 }
 
 // EnvoyResources holds each envoy api resource type
@@ -84,6 +342,13 @@ type EnvoyResources struct {
 	// V3 reference: https://www.envoyproxy.io/docs/envoy/latest/api-v3/config/endpoint/v3/endpoint.proto
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	Endpoints []EnvoyResource `json:"endpoints,omitempty"`
+	// ServiceEndpoints is a list of references to Kubernetes Services from which envoy
+	// ClusterLoadAssignment resources are automatically generated. The discovery service
+	// watches the EndpointSlices of each Service and pushes the changes to the envoy clients
+	// without creating a new EnvoyConfigRevision.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	ServiceEndpoints []EnvoyServiceEndpointsResource `json:"serviceEndpoints,omitempty"`
 	// Clusters is a list of the envoy Cluster resource type.
 	// V2 reference: https://www.envoyproxy.io/docs/envoy/latest/api-v2/api/v2/cluster.proto
 	// V3 reference: https://www.envoyproxy.io/docs/envoy/latest/api-v3/config/cluster/v3/cluster.proto
@@ -104,6 +369,24 @@ type EnvoyResources struct {
 	// V3 reference: https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/runtime/v3/rtds.proto
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	Runtimes []EnvoyResource `json:"runtime,omitempty"`
+	// ExtensionConfigs is a list of the envoy TypedExtensionConfig resource type,
+	// served by the extension config discovery service (ECDS). Only supported in envoy
+	// API v3 and only served over the incremental variant of the xDS protocol.
+	// V3 reference: https://www.envoyproxy.io/docs/envoy/latest/api-v3/config/core/v3/extension.proto
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ExtensionConfigs []EnvoyResource `json:"extensionConfigs,omitempty"`
+	// ScopedRoutes is a list of the envoy ScopedRouteConfiguration resource type,
+	// served by the scoped route discovery service (SRDS). Only supported in envoy
+	// API v3 and only served over the incremental variant of the xDS protocol.
+	// V3 reference: https://www.envoyproxy.io/docs/envoy/latest/api-v3/config/route/v3/scoped_route.proto
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ScopedRoutes []EnvoyResource `json:"scopedRoutes,omitempty"`
+	// VirtualHosts is a list of the envoy VirtualHost resource type, served by
+	// the virtual host discovery service (VHDS). Only supported in envoy API v3
+	// and only served over the incremental variant of the xDS protocol.
+	// V3 reference: https://www.envoyproxy.io/docs/envoy/latest/api-v3/config/route/v3/route_components.proto
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	VirtualHosts []EnvoyResource `json:"virtualHosts,omitempty"`
 	// Secrets is a list of references to Kubernetes Secret objects.
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	Secrets []EnvoySecretResource `json:"secrets,omitempty"`
@@ -120,16 +403,99 @@ type EnvoyResource struct {
 	Value string `json:"value"`
 }
 
+// EnvoyServiceEndpointsResource holds a reference to a Kubernetes Service
+// from which to generate an envoy ClusterLoadAssignment resource
+type EnvoyServiceEndpointsResource struct {
+	// Name of the envoy resource. It must match the name of the cluster
+	// that consumes the endpoints.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Name string `json:"name"`
+	// ServiceName is the name of the Kubernetes Service, which must live in the
+	// namespace of the EnvoyConfig. The ready endpoints of the Service are reported
+	// as healthy and the ones that are terminating but still serving as draining.
+	// The locality of each endpoint is taken from the "topology.kubernetes.io/region"
+	// and "topology.kubernetes.io/zone" topology labels, and each locality is weighted
+	// by its number of ready endpoints.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	ServiceName string `json:"serviceName"`
+	// PortName is the name of the Service port to use. It can be
+	// omitted if the Service exposes a single port.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	PortName string `json:"portName,omitempty"`
+}
+
+// EnvoySecretType is the kind of envoy Secret resource generated
+// from a Kubernetes Secret or ConfigMap
+// +kubebuilder:validation:Enum=tlsCertificate;validationContext;genericSecret;sessionTicketKeys
+type EnvoySecretType string
+
+const (
+	// TLSCertificateSecretType generates a TlsCertificate envoy Secret from
+	// the "tls.crt" and "tls.key" keys of a "kubernetes.io/tls" Secret
+	TLSCertificateSecretType EnvoySecretType = "tlsCertificate"
+	// ValidationContextSecretType generates a ValidationContext envoy Secret
+	// with the bundle of trusted CAs held in a Secret or a ConfigMap
+	ValidationContextSecretType EnvoySecretType = "validationContext"
+	// GenericSecretType generates a GenericSecret envoy Secret with the
+	// value of a key of a Secret
+	GenericSecretType EnvoySecretType = "genericSecret"
+	// SessionTicketKeysSecretType generates a SessionTicketKeys envoy Secret
+	// with the values of one or more keys of a Secret
+	SessionTicketKeysSecretType EnvoySecretType = "sessionTicketKeys"
+
+	// DefaultValidationContextKey is the key that holds the bundle of
+	// trusted CAs if no other is specified
+	DefaultValidationContextKey string = "ca.crt"
+)
+
 // EnvoySecretResource holds a reference to a k8s
-// Secret from where to take a secret from
+// Secret or ConfigMap from where to take a secret from
 type EnvoySecretResource struct {
 	// Name of the envoy resource
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	Name string `json:"name"`
-	// Ref is a reference to a Kubernetes Secret of type "kubernetes.io/tls" from which
-	// an envoy Secret resource will be automatically created.
+	// Type is the kind of envoy Secret resource to generate. Defaults to "tlsCertificate".
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Type EnvoySecretType `json:"type,omitempty"`
+	// Ref is a reference to a Kubernetes Secret from which an envoy Secret resource will
+	// be automatically created. Secrets of type "tlsCertificate" must reference a Secret of
+	// type "kubernetes.io/tls". Either Ref or ConfigMapRef must be set.
 	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors="urn:alm:descriptor:io.kubernetes:SecretReference"
-	Ref corev1.SecretReference `json:"ref"`
+	// +optional
+	Ref corev1.SecretReference `json:"ref,omitempty"`
+	// ConfigMapRef is a reference to a Kubernetes ConfigMap from which an envoy
+	// Secret resource will be automatically created. Only allowed for the "validationContext"
+	// type. The namespace defaults to the namespace of the EnvoyConfig.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	ConfigMapRef *ConfigMapReference `json:"configMapRef,omitempty"`
+	// Keys are the data keys of the referenced object to use. Not allowed for the "tlsCertificate"
+	// type, which always uses "tls.crt" and "tls.key". The "validationContext" type takes the CA bundle
+	// from the first key, "ca.crt" by default. The "genericSecret" type requires exactly one key. The
+	// "sessionTicketKeys" type uses the keys in the given order, the first one being used to encrypt new
+	// sessions, and defaults to all the keys of the Secret in alphabetical order.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+}
+
+// GetType returns the type of the envoy Secret, with the default applied
+func (esr *EnvoySecretResource) GetType() EnvoySecretType {
+	if esr.Type == "" {
+		return TLSCertificateSecretType
+	}
+	return esr.Type
+}
+
+// ConfigMapReference holds a reference to a Kubernetes ConfigMap
+type ConfigMapReference struct {
+	// Name of the ConfigMap
+	Name string `json:"name"`
+	// Namespace of the ConfigMap
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // EnvoyConfigStatus defines the observed state of EnvoyConfig
@@ -155,6 +521,44 @@ type EnvoyConfigStatus struct {
 	// objects
 	// +operator-sdk:csv:customresourcedefinitions:type=status
 	ConfigRevisions []ConfigRevisionRef `json:"revisions,omitempty"`
+	// Clients summarizes the status of the envoy clients connected to the discovery
+	// service with this nodeID, as reported by the published revision
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Clients *ClientsStatus `json:"clients,omitempty"`
+	// Rollout holds the progress of the last canary rollout. Only
+	// reported when a canary rollout strategy is configured.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+}
+
+// RolloutStatus holds the progress of a canary rollout
+type RolloutStatus struct {
+	// Phase is the phase of the rollout: "Canary", "NoCanaries", "Promoted" or "Aborted"
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase string `json:"phase"`
+	// CanaryVersion is the version being rolled out
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	CanaryVersion string `json:"canaryVersion"`
+	// StableVersion is the version served to the clients
+	// that are not canaries during the rollout
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	StableVersion string `json:"stableVersion,omitempty"`
+	// StartedAt is the time the rollout started
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	StartedAt metav1.Time `json:"startedAt"`
+	// PromoteAt is the time the canary version will be promoted
+	// to all the clients if no client rejects it
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	PromoteAt *metav1.Time `json:"promoteAt,omitempty"`
+	// Canaries summarizes the status of the clients that
+	// are being served the canary version
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Canaries *ClientsStatus `json:"canaries,omitempty"`
 }
 
 // ConfigRevisionRef holds a reference to EnvoyConfigRevision object
@@ -181,6 +585,8 @@ type ConfigRevisionRef struct {
 // +kubebuilder:printcolumn:JSONPath=".status.desiredVersion",name=Desired Version,type=string
 // +kubebuilder:printcolumn:JSONPath=".status.publishedVersion",name=Published Version,type=string
 // +kubebuilder:printcolumn:JSONPath=".status.cacheState",name=Cache State,type=string
+// +kubebuilder:printcolumn:JSONPath=".status.clients.summary",name=Clients,type=string,priority=1
+// +kubebuilder:printcolumn:JSONPath=".status.rollout.phase",name=Rollout,type=string,priority=1
 // +operator-sdk:csv:customresourcedefinitions:displayName="EnvoyConfig"
 // +operator-sdk:csv:customresourcedefinitions:resources={{EnvoyConfigRevision,v1alpha1}}
 type EnvoyConfig struct {
@@ -207,10 +613,73 @@ func (ec *EnvoyConfig) GetSerialization() envoy_serializer.Serialization {
 	return envoy_serializer.Serialization(*ec.Spec.Serialization)
 }
 
+// GetTaintTTL returns the time after which tainted revisions are
+// untainted. Zero means that taints never expire.
+func (ec *EnvoyConfig) GetTaintTTL() time.Duration {
+	if ec.Spec.TaintTTL == nil {
+		return 0
+	}
+	return ec.Spec.TaintTTL.Duration
+}
+
+// GetTaintPolicy returns the taint policy of the EnvoyConfig, nil if unset
+func (ec *EnvoyConfig) GetTaintPolicy() *TaintPolicy {
+	return ec.Spec.TaintPolicy
+}
+
 // GetEnvoyResourcesVersion returns the hash of the resources in the spec which
-// univoquely identifies the version of the resources.
+// univoquely identifies the version of the resources. The resources that only use
+// the fields of the first releases are hashed as they were back then, so their version
+// doesn't change on upgrade. The rest are hashed from their JSON encoding, which leaves
+// out the unset fields, so adding new fields to the API doesn't change their version.
 func (ec *EnvoyConfig) GetEnvoyResourcesVersion() string {
-	return util.Hash(ec.Spec.EnvoyResources)
+	if legacy, ok := ec.Spec.EnvoyResources.legacy(); ok {
+		return util.Hash(legacy)
+	}
+	return util.HashJSON(ec.Spec.EnvoyResources)
+}
+
+// legacy returns a copy of the resources using the types of the first releases,
+// or false if any of the fields added since then is set
+func (er *EnvoyResources) legacy() (*legacy.EnvoyResources, bool) {
+	if er == nil {
+		return nil, true
+	}
+	if len(er.ServiceEndpoints) > 0 || len(er.ExtensionConfigs) > 0 ||
+		len(er.ScopedRoutes) > 0 || len(er.VirtualHosts) > 0 {
+		return nil, false
+	}
+
+	resources := func(list []EnvoyResource) []legacy.EnvoyResource {
+		if list == nil {
+			return nil
+		}
+		out := make([]legacy.EnvoyResource, len(list))
+		for idx, r := range list {
+			out[idx] = legacy.EnvoyResource{Name: r.Name, Value: r.Value}
+		}
+		return out
+	}
+
+	var secrets []legacy.EnvoySecretResource
+	if er.Secrets != nil {
+		secrets = make([]legacy.EnvoySecretResource, len(er.Secrets))
+		for idx, s := range er.Secrets {
+			if s.Type != "" || s.ConfigMapRef != nil || s.Keys != nil {
+				return nil, false
+			}
+			secrets[idx] = legacy.EnvoySecretResource{Name: s.Name, Ref: s.Ref}
+		}
+	}
+
+	return &legacy.EnvoyResources{
+		Endpoints: resources(er.Endpoints),
+		Clusters:  resources(er.Clusters),
+		Routes:    resources(er.Routes),
+		Listeners: resources(er.Listeners),
+		Runtimes:  resources(er.Runtimes),
+		Secrets:   secrets,
+	}, true
 }
 
 // +kubebuilder:object:root=true
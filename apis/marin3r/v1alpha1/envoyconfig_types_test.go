@@ -17,11 +17,13 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/3scale/marin3r/pkg/envoy"
 	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
 	"github.com/3scale/marin3r/pkg/util"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/utils/pointer"
 )
 
@@ -107,7 +109,39 @@ func TestEnvoyConfig_GetEnvoyResourcesVersion(t *testing.T) {
 					},
 				}
 			},
-			util.Hash(&EnvoyResources{}),
+			"c4547474b",
+		},
+		{"Without resources",
+			func() *EnvoyConfig {
+				return &EnvoyConfig{Spec: EnvoyConfigSpec{}}
+			},
+			"6ddbcdf795",
+		},
+		{"Keeps the version of previous releases",
+			func() *EnvoyConfig {
+				return &EnvoyConfig{
+					Spec: EnvoyConfigSpec{
+						EnvoyResources: &EnvoyResources{
+							Clusters: []EnvoyResource{{Name: "c", Value: "v"}},
+							Secrets:  []EnvoySecretResource{{Name: "s", Ref: corev1.SecretReference{Name: "n", Namespace: "ns"}}},
+						},
+					},
+				}
+			},
+			"6d99bb8564",
+		},
+		{"Hashes the set fields when newer fields are used",
+			func() *EnvoyConfig {
+				return &EnvoyConfig{
+					Spec: EnvoyConfigSpec{
+						EnvoyResources: &EnvoyResources{
+							Clusters:         []EnvoyResource{{Name: "c", Value: "v"}},
+							ExtensionConfigs: []EnvoyResource{{Name: "e", Value: "v"}},
+						},
+					},
+				}
+			},
+			util.HashJSON(json.RawMessage(`{"clusters":[{"name":"c","value":"v"}],"extensionConfigs":[{"name":"e","value":"v"}]}`)),
 		},
 	}
 
@@ -120,3 +154,31 @@ func TestEnvoyConfig_GetEnvoyResourcesVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestTaintPolicy_IsQuorumReached(t *testing.T) {
+	cases := []struct {
+		testName       string
+		policy         *TaintPolicy
+		nacked         int
+		connected      int
+		expectedResult bool
+	}{
+		{"No NACKs", &TaintPolicy{MinClients: pointer.Int32Ptr(1)}, 0, 4, false},
+		{"Default percentage reached", nil, 2, 4, true},
+		{"Default percentage not reached", nil, 1, 4, false},
+		{"MinClients reached", &TaintPolicy{MinClients: pointer.Int32Ptr(2)}, 2, 100, true},
+		{"MinClients not reached", &TaintPolicy{MinClients: pointer.Int32Ptr(3)}, 2, 2, false},
+		{"Percentage reached", &TaintPolicy{Percentage: pointer.Int32Ptr(25)}, 1, 4, true},
+		{"Percentage not reached", &TaintPolicy{Percentage: pointer.Int32Ptr(30)}, 1, 4, false},
+		{"Any of MinClients or Percentage reached", &TaintPolicy{MinClients: pointer.Int32Ptr(5), Percentage: pointer.Int32Ptr(25)}, 1, 4, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(subT *testing.T) {
+			receivedResult := tc.policy.IsQuorumReached(tc.nacked, tc.connected)
+			if receivedResult != tc.expectedResult {
+				subT.Errorf("Expected result differs: Expected: %v, Received: %v", tc.expectedResult, receivedResult)
+			}
+		})
+	}
+}
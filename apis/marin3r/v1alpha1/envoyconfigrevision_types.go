@@ -39,10 +39,63 @@ const (
 	// problems have been observed with this revision and should not be published
 	RevisionTaintedCondition status.ConditionType = "RevisionTainted"
 
+	// RevisionCanaryCondition is a condition that marks the EnvoyConfigRevision object
+	// as the one that should be published in the xds server cache for the canary clients
+	// of a canary rollout. Its last transition time is the time the rollout started.
+	RevisionCanaryCondition status.ConditionType = "RevisionCanary"
+
+	// ResourcesLintPassedCondition is a condition that reports the result of linting
+	// the resources of the revision. It is false if the linter has found errors, and
+	// true otherwise, with the warnings found, if any, in its message.
+	ResourcesLintPassedCondition status.ConditionType = "ResourcesLintPassed"
+
 	/* Finalizers */
 
 	// EnvoyConfigRevisionFinalizer is the finalizer for EnvoyConfig objects
 	EnvoyConfigRevisionFinalizer string = "finalizer.marin3r.3scale.net"
+
+	/* Annotations */
+
+	// UntaintAnnotation is set in an EnvoyConfigRevision to request that its
+	// taint is removed or, if it is not tainted, that its aborted canary rollout
+	// is started again. It is removed once the request has been processed.
+	UntaintAnnotation string = "marin3r.3scale.net/untaint"
+
+	/* Untaint reasons */
+
+	// UntaintedByUserReason is used when a revision is untainted
+	// at the request of a user, with the UntaintAnnotation
+	UntaintedByUserReason status.ConditionReason = "UntaintedByUser"
+
+	// TaintExpiredReason is used when a revision is untainted
+	// because its taint has outlived the taint TTL
+	TaintExpiredReason status.ConditionReason = "TaintExpired"
+
+	/* Taint reasons */
+
+	// NackQuorumReachedReason is used when a revision is tainted because the
+	// quorum of NACKs set by the taint policy of its EnvoyConfig has been reached
+	NackQuorumReachedReason status.ConditionReason = "NackQuorumReached"
+
+	/* Canary reasons */
+
+	// CanaryRolloutReason is used when a revision is being rolled out to the canaries
+	CanaryRolloutReason status.ConditionReason = "CanaryRollout"
+
+	// NoCanaryClientsReason is used when the bake time of a canary revision is over but no
+	// client has been selected as canary, so the revision is not promoted until one connects
+	NoCanaryClientsReason status.ConditionReason = "NoCanaryClients"
+
+	/* Lint reasons */
+
+	// LintErrorsReason is used when the linter has found errors in the resources
+	LintErrorsReason status.ConditionReason = "LintErrors"
+
+	// LintWarningsReason is used when the linter has only found warnings in the resources
+	LintWarningsReason status.ConditionReason = "LintWarnings"
+
+	// LintPassedReason is used when the linter has found no issues in the resources
+	LintPassedReason status.ConditionReason = "LintPassed"
 )
 
 // EnvoyConfigRevisionSpec defines the desired state of EnvoyConfigRevision
@@ -91,6 +144,117 @@ type EnvoyConfigRevisionStatus struct {
 	// Conditions represent the latest available observations of an object's state
 	// +operator-sdk:csv:customresourcedefinitions:type=status
 	Conditions status.Conditions `json:"conditions"`
+	// Clients holds information about the envoy clients connected to the
+	// discovery service with this revision's nodeID. It is only updated
+	// while the revision is published or rolled out to the canaries, and
+	// the last reported status is kept afterwards.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Clients *ClientsStatus `json:"clients,omitempty"`
+	// Canary holds the selection of the clients that get this revision while it
+	// is being rolled out to the canaries. It is only set while the RevisionCanary
+	// condition is true.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Canary *CanaryRollout `json:"canary,omitempty"`
+	// TaintHistory holds the most recent taints of the revision that have
+	// been removed, oldest first
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	TaintHistory []TaintRecord `json:"taintHistory,omitempty"`
+}
+
+// TaintRecord holds a taint that has been removed from an EnvoyConfigRevision
+type TaintRecord struct {
+	// Reason is the reason of the taint
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Reason status.ConditionReason `json:"reason"`
+	// Message is the message of the taint
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Message string `json:"message,omitempty"`
+	// TaintedAt is the time the revision was tainted
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	TaintedAt metav1.Time `json:"taintedAt"`
+	// UntaintedAt is the time the taint was removed
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	UntaintedAt metav1.Time `json:"untaintedAt"`
+	// UntaintReason is the reason the taint was removed, either "UntaintedByUser"
+	// or "TaintExpired"
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	UntaintReason status.ConditionReason `json:"untaintReason"`
+}
+
+// ClientsStatus summarizes the status of the envoy clients connected
+// to the discovery service for a given nodeID
+type ClientsStatus struct {
+	// Connected is the number of envoy clients currently connected. The
+	// streams opened by the same envoy, identified by its IP address and
+	// node ID, are counted as a single client.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Connected int `json:"connected"`
+	// InSync is the number of envoy clients that have ACKed the
+	// published version for all the resource types they have requested
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	InSync int `json:"inSync"`
+	// Nacked is the number of envoy clients that have returned
+	// a NACK for the version of this revision
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Nacked int `json:"nacked,omitempty"`
+	// Summary is a human readable summary of the clients status
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Summary string `json:"summary,omitempty"`
+	// Details holds the status of each of the xDS streams opened by the
+	// connected envoy clients
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Details []ClientStatus `json:"details,omitempty"`
+}
+
+// ClientStatus holds the status of an xDS stream opened by an
+// envoy client connected to the discovery service
+type ClientStatus struct {
+	// StreamID identifies the xDS stream the client is connected through
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	StreamID string `json:"streamID"`
+	// Replica is the name of the discovery service replica the client is connected to
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	Replica string `json:"replica,omitempty"`
+	// NodeID is the node ID the client presented in its requests
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	NodeID string `json:"nodeID,omitempty"`
+	// PeerAddress is the address of the client
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	PeerAddress string `json:"peerAddress,omitempty"`
+	// ConnectedAt is the time the client connected to the discovery service
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	ConnectedAt metav1.Time `json:"connectedAt"`
+	// AckedVersion is the version the client has ACKed for all the resource types
+	// it has requested. It is empty while the client is not running the same
+	// version for all the resource types.
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	AckedVersion string `json:"ackedVersion,omitempty"`
+	// NackedVersion is the last version rejected by the client
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	NackedVersion string `json:"nackedVersion,omitempty"`
+	// NackMessage is the error detail sent by the client along with the NACK
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	NackMessage string `json:"nackMessage,omitempty"`
+	// NackedAt is the time the client first returned a NACK for NackedVersion
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	// +optional
+	NackedAt *metav1.Time `json:"nackedAt,omitempty"`
+	// InSync is true when the client has ACKed the published version
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	InSync bool `json:"inSync"`
 }
 
 // IsPublished returns true if this revision is published, false otherwise
@@ -101,6 +265,11 @@ func (status *EnvoyConfigRevisionStatus) IsPublished() bool {
 	return *status.Published
 }
 
+// IsCanary returns true if this revision is being rolled out to the canaries, false otherwise
+func (status *EnvoyConfigRevisionStatus) IsCanary() bool {
+	return status.Canary != nil && status.Conditions.IsTrueFor(RevisionCanaryCondition)
+}
+
 // IsTainted returns true if this revision is tainted, false otherwise
 func (status *EnvoyConfigRevisionStatus) IsTainted() bool {
 	if status.Tainted == nil {
@@ -123,6 +292,7 @@ func (status *EnvoyConfigRevisionStatus) IsTainted() bool {
 // +kubebuilder:printcolumn:JSONPath=".metadata.creationTimestamp",name="Created At",type=string,format=date-time
 // +kubebuilder:printcolumn:JSONPath=".status.lastPublishedAt",name="Last Published At",type=string,format=date-time
 // +kubebuilder:printcolumn:JSONPath=".status.tainted",name=Tainted,type=boolean
+// +kubebuilder:printcolumn:JSONPath=".status.clients.summary",name=Clients,type=string,priority=1
 // +operator-sdk:csv:customresourcedefinitions:displayName="EnvoyConfigRevision"
 type EnvoyConfigRevision struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -0,0 +1,49 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 holds the EnvoyResources types as they were before any
+// optional field was added to them. They are only used to compute the version
+// of the resources of the EnvoyConfigs that don't use any of the newer fields,
+// so those keep the version they got from previous releases. The package name
+// matters: the hash is computed from a dump of the object that includes the
+// type names qualified with the package name.
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// EnvoyResources holds the original fields of the EnvoyResources type
+type EnvoyResources struct {
+	Endpoints []EnvoyResource
+	Clusters  []EnvoyResource
+	Routes    []EnvoyResource
+	Listeners []EnvoyResource
+	Runtimes  []EnvoyResource
+	Secrets   []EnvoySecretResource
+}
+
+// EnvoyResource holds the original fields of the EnvoyResource type
+type EnvoyResource struct {
+	Name  string
+	Value string
+}
+
+// EnvoySecretResource holds the original fields of the EnvoySecretResource type
+type EnvoySecretResource struct {
+	Name string
+	Ref  corev1.SecretReference
+}
@@ -22,9 +22,40 @@ package v1alpha1
 
 import (
 	"github.com/operator-framework/operator-lib/status"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryRollout) DeepCopyInto(out *CanaryRollout) {
+	*out = *in
+	if in.Percentage != nil {
+		in, out := &in.Percentage, &out.Percentage
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BakeTime != nil {
+		in, out := &in.BakeTime, &out.BakeTime
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryRollout.
+func (in *CanaryRollout) DeepCopy() *CanaryRollout {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryRollout)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClientCertificate) DeepCopyInto(out *ClientCertificate) {
 	*out = *in
@@ -41,6 +72,63 @@ func (in *ClientCertificate) DeepCopy() *ClientCertificate {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClientStatus) DeepCopyInto(out *ClientStatus) {
+	*out = *in
+	in.ConnectedAt.DeepCopyInto(&out.ConnectedAt)
+	if in.NackedAt != nil {
+		in, out := &in.NackedAt, &out.NackedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClientStatus.
+func (in *ClientStatus) DeepCopy() *ClientStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClientStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClientsStatus) DeepCopyInto(out *ClientsStatus) {
+	*out = *in
+	if in.Details != nil {
+		in, out := &in.Details, &out.Details
+		*out = make([]ClientStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClientsStatus.
+func (in *ClientsStatus) DeepCopy() *ClientsStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClientsStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapReference) DeepCopyInto(out *ConfigMapReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapReference.
+func (in *ConfigMapReference) DeepCopy() *ConfigMapReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigRevisionRef) DeepCopyInto(out *ConfigRevisionRef) {
 	*out = *in
@@ -127,7 +215,7 @@ func (in *EnvoyBootstrapSpec) DeepCopyInto(out *EnvoyBootstrapSpec) {
 	if in.EnvoyStaticConfig != nil {
 		in, out := &in.EnvoyStaticConfig, &out.EnvoyStaticConfig
 		*out = new(EnvoyStaticConfig)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 }
 
@@ -328,6 +416,23 @@ func (in *EnvoyConfigRevisionStatus) DeepCopyInto(out *EnvoyConfigRevisionStatus
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Clients != nil {
+		in, out := &in.Clients, &out.Clients
+		*out = new(ClientsStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanaryRollout)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TaintHistory != nil {
+		in, out := &in.TaintHistory, &out.TaintHistory
+		*out = make([]TaintRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvoyConfigRevisionStatus.
@@ -358,6 +463,31 @@ func (in *EnvoyConfigSpec) DeepCopyInto(out *EnvoyConfigSpec) {
 		*out = new(EnvoyResources)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RolloutStrategy != nil {
+		in, out := &in.RolloutStrategy, &out.RolloutStrategy
+		*out = new(RolloutStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RevisionPin != nil {
+		in, out := &in.RevisionPin, &out.RevisionPin
+		*out = new(string)
+		**out = **in
+	}
+	if in.RevisionRetention != nil {
+		in, out := &in.RevisionRetention, &out.RevisionRetention
+		*out = new(RevisionRetention)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TaintTTL != nil {
+		in, out := &in.TaintTTL, &out.TaintTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.TaintPolicy != nil {
+		in, out := &in.TaintPolicy, &out.TaintPolicy
+		*out = new(TaintPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvoyConfigSpec.
@@ -385,6 +515,16 @@ func (in *EnvoyConfigStatus) DeepCopyInto(out *EnvoyConfigStatus) {
 		*out = make([]ConfigRevisionRef, len(*in))
 		copy(*out, *in)
 	}
+	if in.Clients != nil {
+		in, out := &in.Clients, &out.Clients
+		*out = new(ClientsStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(RolloutStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvoyConfigStatus.
@@ -420,6 +560,11 @@ func (in *EnvoyResources) DeepCopyInto(out *EnvoyResources) {
 		*out = make([]EnvoyResource, len(*in))
 		copy(*out, *in)
 	}
+	if in.ServiceEndpoints != nil {
+		in, out := &in.ServiceEndpoints, &out.ServiceEndpoints
+		*out = make([]EnvoyServiceEndpointsResource, len(*in))
+		copy(*out, *in)
+	}
 	if in.Clusters != nil {
 		in, out := &in.Clusters, &out.Clusters
 		*out = make([]EnvoyResource, len(*in))
@@ -440,10 +585,27 @@ func (in *EnvoyResources) DeepCopyInto(out *EnvoyResources) {
 		*out = make([]EnvoyResource, len(*in))
 		copy(*out, *in)
 	}
+	if in.ExtensionConfigs != nil {
+		in, out := &in.ExtensionConfigs, &out.ExtensionConfigs
+		*out = make([]EnvoyResource, len(*in))
+		copy(*out, *in)
+	}
+	if in.ScopedRoutes != nil {
+		in, out := &in.ScopedRoutes, &out.ScopedRoutes
+		*out = make([]EnvoyResource, len(*in))
+		copy(*out, *in)
+	}
+	if in.VirtualHosts != nil {
+		in, out := &in.VirtualHosts, &out.VirtualHosts
+		*out = make([]EnvoyResource, len(*in))
+		copy(*out, *in)
+	}
 	if in.Secrets != nil {
 		in, out := &in.Secrets, &out.Secrets
 		*out = make([]EnvoySecretResource, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -461,6 +623,16 @@ func (in *EnvoyResources) DeepCopy() *EnvoyResources {
 func (in *EnvoySecretResource) DeepCopyInto(out *EnvoySecretResource) {
 	*out = *in
 	out.Ref = in.Ref
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		*out = new(ConfigMapReference)
+		**out = **in
+	}
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvoySecretResource.
@@ -473,9 +645,29 @@ func (in *EnvoySecretResource) DeepCopy() *EnvoySecretResource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvoyServiceEndpointsResource) DeepCopyInto(out *EnvoyServiceEndpointsResource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvoyServiceEndpointsResource.
+func (in *EnvoyServiceEndpointsResource) DeepCopy() *EnvoyServiceEndpointsResource {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvoyServiceEndpointsResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EnvoyStaticConfig) DeepCopyInto(out *EnvoyStaticConfig) {
 	*out = *in
+	if in.XdsConfigSource != nil {
+		in, out := &in.XdsConfigSource, &out.XdsConfigSource
+		*out = new(string)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvoyStaticConfig.
@@ -487,3 +679,125 @@ func (in *EnvoyStaticConfig) DeepCopy() *EnvoyStaticConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RevisionRetention) DeepCopyInto(out *RevisionRetention) {
+	*out = *in
+	if in.MaxRevisions != nil {
+		in, out := &in.MaxRevisions, &out.MaxRevisions
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxAge != nil {
+		in, out := &in.MaxAge, &out.MaxAge
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.KeepUntainted != nil {
+		in, out := &in.KeepUntainted, &out.KeepUntainted
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RevisionRetention.
+func (in *RevisionRetention) DeepCopy() *RevisionRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(RevisionRetention)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutStatus) DeepCopyInto(out *RolloutStatus) {
+	*out = *in
+	in.StartedAt.DeepCopyInto(&out.StartedAt)
+	if in.PromoteAt != nil {
+		in, out := &in.PromoteAt, &out.PromoteAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Canaries != nil {
+		in, out := &in.Canaries, &out.Canaries
+		*out = new(ClientsStatus)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutStatus.
+func (in *RolloutStatus) DeepCopy() *RolloutStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutStrategy) DeepCopyInto(out *RolloutStrategy) {
+	*out = *in
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanaryRollout)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutStrategy.
+func (in *RolloutStrategy) DeepCopy() *RolloutStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaintPolicy) DeepCopyInto(out *TaintPolicy) {
+	*out = *in
+	if in.Mode != nil {
+		in, out := &in.Mode, &out.Mode
+		*out = new(TaintPolicyMode)
+		**out = **in
+	}
+	if in.MinClients != nil {
+		in, out := &in.MinClients, &out.MinClients
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Percentage != nil {
+		in, out := &in.Percentage, &out.Percentage
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaintPolicy.
+func (in *TaintPolicy) DeepCopy() *TaintPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(TaintPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaintRecord) DeepCopyInto(out *TaintRecord) {
+	*out = *in
+	in.TaintedAt.DeepCopyInto(&out.TaintedAt)
+	in.UntaintedAt.DeepCopyInto(&out.UntaintedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaintRecord.
+func (in *TaintRecord) DeepCopy() *TaintRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(TaintRecord)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -42,6 +42,9 @@ const (
 	DiscoveryServiceLabelKey string = "marin3r.3scale.net/discovery-service"
 	// DiscoveryServiceCertificateHashLabelKey is the label in the discovery service Deployment that
 	// stores the hash of the current server certificate
+	//
+	// Deprecated: the discovery service reloads the server certificate without being restarted,
+	// so the label is no longer set in the Deployment.
 	DiscoveryServiceCertificateHashLabelKey string = "marin3r.3scale.net/server-certificate-hash"
 
 	// DiscoveryServiceFinalizer is the finalizer for DiscoveryService objects
@@ -51,10 +54,36 @@ const (
 
 	// DefaultMetricsPort is the default port where the discovery service metrics server listens
 	DefaultMetricsPort uint32 = 8383
+	// DefaultHealthProbePort is the default port where the discovery service serves the health and readiness probes
+	DefaultHealthProbePort uint32 = 8384
 	// DefaultWebhookPort is the default port where the discovery service webhook server listens
 	DefaultWebhookPort uint32 = 9443
 	// DefaultXdsServerPort is the default port where the discovery service xds server port listens
 	DefaultXdsServerPort uint32 = 18000
+	// DefaultReplicas is the default number of replicas of the discovery service Deployment
+	DefaultReplicas int32 = 1
+	// DefaultGrpcMaxConcurrentStreams is the default maximum number of concurrent
+	// streams in each connection to the discovery service xds server
+	DefaultGrpcMaxConcurrentStreams uint32 = 1000000
+	// DefaultGrpcKeepaliveMinTime is the default minimum time between the keepalive pings of a client
+	DefaultGrpcKeepaliveMinTime time.Duration = 50 * time.Second
+	// DefaultGrpcKeepaliveTime is the default time after which the xds
+	// server pings a client if there is no activity in the connection
+	DefaultGrpcKeepaliveTime time.Duration = 2 * time.Hour
+	// DefaultGrpcKeepaliveTimeout is the default time the xds server waits for
+	// the response to a keepalive ping before closing the connection
+	DefaultGrpcKeepaliveTimeout time.Duration = 20 * time.Second
+	// DefaultGrpcMaxConnectionAge is the default maximum age of a connection to the xds server
+	DefaultGrpcMaxConnectionAge time.Duration = 12 * time.Hour
+	// DefaultGrpcMaxConnectionAgeGrace is the default time the connections that reach the
+	// maximum age are given to complete the in flight requests before being closed
+	DefaultGrpcMaxConnectionAgeGrace time.Duration = 5 * time.Minute
+	// DefaultGrpcGracefulStopTimeout is the default time the xds server waits
+	// for the streams to finish when stopping before closing them
+	DefaultGrpcGracefulStopTimeout time.Duration = 10 * time.Second
+	// DefaultSnapshotMaxDelay is the default maximum time the publication
+	// of a snapshot can be delayed by the debounce window
+	DefaultSnapshotMaxDelay time.Duration = time.Second
 	// DefaultRootCertificateDuration is the default root CA certificate duration
 	DefaultRootCertificateDuration string = "26280h" // 3 years
 	// DefaultRootCertificateSecretNamePrefix is the default prefix for the Secret
@@ -81,6 +110,44 @@ const (
 	HeadlessType ServiceType = "Headless"
 )
 
+// NodeIDAuthorizationPolicy is an enum with the available policies to check
+// that the client certificate of an envoy is allowed to request a node ID
+// +kubebuilder:validation:Enum=None;Exact;Prefix;Mapping
+type NodeIDAuthorizationPolicy string
+
+const (
+	// NoneNodeIDAuthorizationPolicy allows any client to request the config of any node ID
+	NoneNodeIDAuthorizationPolicy NodeIDAuthorizationPolicy = "None"
+	// ExactNodeIDAuthorizationPolicy only allows a client to request a node ID that is
+	// equal to the common name or one of the DNS or URI SANs of its certificate
+	ExactNodeIDAuthorizationPolicy NodeIDAuthorizationPolicy = "Exact"
+	// PrefixNodeIDAuthorizationPolicy only allows a client to request a node ID that starts
+	// with the common name or one of the DNS or URI SANs of its certificate
+	PrefixNodeIDAuthorizationPolicy NodeIDAuthorizationPolicy = "Prefix"
+	// MappingNodeIDAuthorizationPolicy only allows a client to request the node IDs
+	// mapped to the common name or one of the DNS or URI SANs of its certificate
+	MappingNodeIDAuthorizationPolicy NodeIDAuthorizationPolicy = "Mapping"
+)
+
+// NodeHashKind is an enum with the properties of an envoy node
+// that can be used to select the EnvoyConfig it gets its config from
+// +kubebuilder:validation:Enum=ID;Cluster;Metadata;Regex;Prefix
+type NodeHashKind string
+
+const (
+	// IDNodeHashKind selects the EnvoyConfig by node ID
+	IDNodeHashKind NodeHashKind = "ID"
+	// ClusterNodeHashKind selects the EnvoyConfig by node cluster
+	ClusterNodeHashKind NodeHashKind = "Cluster"
+	// MetadataNodeHashKind selects the EnvoyConfig by the value of a node metadata field
+	MetadataNodeHashKind NodeHashKind = "Metadata"
+	// RegexNodeHashKind selects the EnvoyConfig by the part of
+	// the node ID that matches a regular expression
+	RegexNodeHashKind NodeHashKind = "Regex"
+	// PrefixNodeHashKind selects the EnvoyConfig by the prefix the node ID starts with
+	PrefixNodeHashKind NodeHashKind = "Prefix"
+)
+
 // DiscoveryServiceSpec defines the desired state of DiscoveryService
 type DiscoveryServiceSpec struct {
 	// Image holds the image to use for the discovery service Deployment
@@ -107,6 +174,12 @@ type DiscoveryServiceSpec struct {
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	// +optional
 	XdsServerPort *uint32 `json:"xdsServerPort,omitempty"`
+	// RestServerPort is the port where the REST-JSON variant of the xDS protocol is served.
+	// Clients need to authenticate using a client certificate, same as with the gRPC xDS
+	// server. The REST-JSON server is disabled when unset or 0.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	RestServerPort *uint32 `json:"restServerPort,omitempty"`
 	// MetricsPort is the port where metrics are served. Defaults to 8383.
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	// +optional
@@ -115,6 +188,39 @@ type DiscoveryServiceSpec struct {
 	// +operator-sdk:csv:customresourcedefinitions:type=spec
 	// +optional
 	ServiceConfig *ServiceConfig `json:"serviceConfig,omitempty"`
+	// Replicas is the number of replicas of the discovery service Deployment. All the
+	// replicas serve the xDS protocol, while writes to the Kubernetes API are performed
+	// by the replica that holds the leadership. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,xDescriptors="urn:alm:descriptor:com.tectonic.ui:podCount"
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// NodeIDAuthorization configures how the identity in the client certificate of an
+	// envoy is checked against the node ID it requests. Requests for node IDs the
+	// client is not allowed to request are rejected. Defaults to the None policy.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	NodeIDAuthorization *NodeIDAuthorization `json:"nodeIDAuthorization,omitempty"`
+	// NodeHash configures the property of the envoy nodes that is matched against the
+	// EnvoyConfig spec.nodeID field. It allows a single EnvoyConfig to serve a fleet of envoys
+	// whose node IDs are Pod names. Defaults to the node ID.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	NodeHash *NodeHash `json:"nodeHash,omitempty"`
+	// GrpcServerOptions tunes the gRPC server that serves the xDS protocol
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	GrpcServerOptions *GrpcServerOptions `json:"grpcServerOptions,omitempty"`
+	// SnapshotPublishing configures the debouncing of the config published
+	// to the envoy clients, so bursts of changes are merged into a single push
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	SnapshotPublishing *SnapshotPublishing `json:"snapshotPublishing,omitempty"`
+	// DebugServer enables a server that dumps the contents of the xDS caches,
+	// for troubleshooting. Disabled by default.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	DebugServer *DebugServer `json:"debugServer,omitempty"`
 }
 
 // DiscoveryServiceStatus defines the observed state of DiscoveryService
@@ -151,6 +257,203 @@ type ServiceConfig struct {
 	Type ServiceType `json:"type,omitempty"`
 }
 
+// NodeIDAuthorization configures the authorization of the
+// node IDs requested by the envoy clients
+type NodeIDAuthorization struct {
+	// Policy is the policy used to check the client certificate identity
+	// against the node ID. One of None, Exact, Prefix or Mapping.
+	// +kubebuilder:default=None
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Policy NodeIDAuthorizationPolicy `json:"policy,omitempty"`
+	// Mappings holds the node IDs each client certificate identity is
+	// allowed to request. Only used with the Mapping policy.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Mappings []NodeIDMapping `json:"mappings,omitempty"`
+}
+
+// NodeIDMapping holds the node IDs a client certificate identity is allowed to request
+type NodeIDMapping struct {
+	// Identity is the common name, DNS SAN or URI SAN of the client certificate
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Identity string `json:"identity"`
+	// NodeIDs is the list of node IDs the identity is allowed to request
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	NodeIDs []string `json:"nodeIDs"`
+}
+
+// NodeHash configures the way envoy nodes are matched with EnvoyConfigs. The node
+// ID is used for the nodes that lack the property used to match them.
+type NodeHash struct {
+	// Kind is the property of the envoy node used to select the EnvoyConfig.
+	// One of ID, Cluster, Metadata, Regex or Prefix.
+	// +kubebuilder:default=ID
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Kind NodeHashKind `json:"kind,omitempty"`
+	// MetadataField is the node metadata field used with the Metadata kind.
+	// Nested fields are separated by dots.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	MetadataField string `json:"metadataField,omitempty"`
+	// Regex is the regular expression matched against the node ID with the Regex
+	// kind. The first capturing group, or the whole match if there are no groups,
+	// selects the EnvoyConfig.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Regex string `json:"regex,omitempty"`
+	// Prefixes is the list of node ID prefixes used with the Prefix kind. The
+	// longest prefix the node ID starts with selects the EnvoyConfig.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// GrpcServerOptions has options to tune the gRPC server that serves the
+// xDS protocol. Unset fields take the default value.
+type GrpcServerOptions struct {
+	// MaxConcurrentStreams is the maximum number of concurrent streams in each
+	// client connection. Defaults to 1000000.
+	// +kubebuilder:validation:Minimum=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	MaxConcurrentStreams *uint32 `json:"maxConcurrentStreams,omitempty"`
+	// KeepaliveMinTime is the minimum time a client should wait between keepalive
+	// pings. Clients that ping more often get their connection closed. Defaults to 50s.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	KeepaliveMinTime *metav1.Duration `json:"keepaliveMinTime,omitempty"`
+	// KeepalivePermitWithoutStream allows clients to send keepalive pings when
+	// there are no active streams in the connection. Defaults to false.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	KeepalivePermitWithoutStream *bool `json:"keepalivePermitWithoutStream,omitempty"`
+	// KeepaliveTime is the time after which the server pings a client if there
+	// is no activity in the connection. Must be at least 1s. Defaults to 2h.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	KeepaliveTime *metav1.Duration `json:"keepaliveTime,omitempty"`
+	// KeepaliveTimeout is the time the server waits for the response to a keepalive
+	// ping before closing the connection. Defaults to 20s.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	KeepaliveTimeout *metav1.Duration `json:"keepaliveTimeout,omitempty"`
+	// MaxConnectionAge is the maximum age of a client connection. Shorter ages make
+	// the clients reconnect more often, which rebalances them across the discovery
+	// service replicas. Defaults to 12h.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	MaxConnectionAge *metav1.Duration `json:"maxConnectionAge,omitempty"`
+	// MaxConnectionAgeGrace is the time the connections that reach the maximum age
+	// are given to complete the in flight requests before being closed. Defaults to 5m.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	MaxConnectionAgeGrace *metav1.Duration `json:"maxConnectionAgeGrace,omitempty"`
+	// GracefulStopTimeout is the time the server waits for the streams to finish
+	// when the discovery service is stopped before closing them. Defaults to 10s.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	GracefulStopTimeout *metav1.Duration `json:"gracefulStopTimeout,omitempty"`
+}
+
+// Validate returns an error if any of the options holds an invalid value
+func (o *GrpcServerOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+
+	if o.MaxConcurrentStreams != nil && *o.MaxConcurrentStreams == 0 {
+		return fmt.Errorf("grpcServerOptions.maxConcurrentStreams must be greater than 0")
+	}
+	durations := []struct {
+		name    string
+		value   *metav1.Duration
+		minimum time.Duration
+	}{
+		{"keepaliveMinTime", o.KeepaliveMinTime, 0},
+		{"keepaliveTime", o.KeepaliveTime, time.Second},
+		{"keepaliveTimeout", o.KeepaliveTimeout, 0},
+		{"maxConnectionAge", o.MaxConnectionAge, 0},
+		{"maxConnectionAgeGrace", o.MaxConnectionAgeGrace, 0},
+		{"gracefulStopTimeout", o.GracefulStopTimeout, 0},
+	}
+	for _, d := range durations {
+		if d.value == nil {
+			continue
+		}
+		if d.value.Duration <= 0 {
+			return fmt.Errorf("grpcServerOptions.%s must be greater than 0", d.name)
+		}
+		if d.value.Duration < d.minimum {
+			return fmt.Errorf("grpcServerOptions.%s must be at least %s", d.name, d.minimum)
+		}
+	}
+	return nil
+}
+
+// SnapshotPublishing configures the debouncing of the snapshots published for each
+// node ID. Each change restarts the debounce window, so a burst of changes, like
+// several edits of an EnvoyConfig or the rotation of the secrets it uses, is published
+// once the changes stop or the maximum delay is reached.
+type SnapshotPublishing struct {
+	// DebounceWindow is the time a change waits for further changes of the same
+	// node ID before being published. Changes are published straight away when
+	// unset or 0.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	DebounceWindow *metav1.Duration `json:"debounceWindow,omitempty"`
+	// MaxDelay is the maximum time the publication of a change can be delayed by
+	// further changes. Must be greater than or equal to the debounce window. Defaults to 1s.
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	// +optional
+	MaxDelay *metav1.Duration `json:"maxDelay,omitempty"`
+}
+
+// Validate returns an error if any of the options holds an invalid value
+func (sp *SnapshotPublishing) Validate() error {
+	if sp == nil {
+		return nil
+	}
+
+	window := time.Duration(0)
+	if sp.DebounceWindow != nil {
+		if sp.DebounceWindow.Duration < 0 {
+			return fmt.Errorf("snapshotPublishing.debounceWindow cannot be negative")
+		}
+		window = sp.DebounceWindow.Duration
+	}
+	if sp.MaxDelay != nil {
+		if sp.MaxDelay.Duration <= 0 {
+			return fmt.Errorf("snapshotPublishing.maxDelay must be greater than 0")
+		}
+		if sp.MaxDelay.Duration < window {
+			return fmt.Errorf("snapshotPublishing.maxDelay must be greater than or equal to snapshotPublishing.debounceWindow")
+		}
+	} else if window > DefaultSnapshotMaxDelay {
+		return fmt.Errorf("snapshotPublishing.maxDelay must be set when snapshotPublishing.debounceWindow is greater than %s", DefaultSnapshotMaxDelay)
+	}
+	return nil
+}
+
+// DebugServer configures the debug server of the discovery service, which lists the
+// node IDs in the xDS caches and dumps their snapshots, with the private keys redacted.
+// Clients authenticate with a certificate signed by the discovery service CA, same as the
+// envoy clients, that must hold one of the allowed identities.
+type DebugServer struct {
+	// Port is the port where the debug server listens. It must differ
+	// from the ports of the other servers of the discovery service.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	Port uint32 `json:"port"`
+	// AllowedIdentities are the client certificate identities (subject common name,
+	// DNS SAN or URI SAN) allowed to use the debug server
+	// +kubebuilder:validation:MinItems=1
+	// +operator-sdk:csv:customresourcedefinitions:type=spec
+	AllowedIdentities []string `json:"allowedIdentities"`
+}
+
 // +kubebuilder:object:root=true
 
 // DiscoveryService represents an envoy discovery service server. Currently
@@ -238,6 +541,49 @@ func (d *DiscoveryService) defaultServerCertificateOptions() *CertificateOptions
 		}}
 }
 
+// Validate returns an error if the spec of the DiscoveryService holds an invalid value
+func (d *DiscoveryService) Validate() error {
+	if err := d.Spec.GrpcServerOptions.Validate(); err != nil {
+		return err
+	}
+	if err := d.Spec.SnapshotPublishing.Validate(); err != nil {
+		return err
+	}
+	return d.validatePorts()
+}
+
+// validatePorts returns an error if two of the servers of the
+// discovery service are set to listen on the same port
+func (d *DiscoveryService) validatePorts() error {
+	type namedPort struct {
+		name string
+		port uint32
+	}
+	ports := []namedPort{
+		{"xdsServerPort", d.GetXdsServerPort()},
+		{"restServerPort", d.GetRestServerPort()},
+		{"metricsPort", d.GetMetricsPort()},
+		{"the health probe port", DefaultHealthProbePort},
+		{"the webhook port", DefaultWebhookPort},
+	}
+	if d.Spec.DebugServer != nil {
+		ports = append(ports, namedPort{"debugServer.port", d.Spec.DebugServer.Port})
+	}
+
+	used := map[uint32]string{}
+	for _, p := range ports {
+		// A port set to 0 disables the server
+		if p.port == 0 {
+			continue
+		}
+		if name, ok := used[p.port]; ok {
+			return fmt.Errorf("%s and %s cannot use the same port %d", name, p.name, p.port)
+		}
+		used[p.port] = p.name
+	}
+	return nil
+}
+
 // GetXdsServerPort returns the port the xDS server will listen at
 func (d *DiscoveryService) GetXdsServerPort() uint32 {
 	if d.Spec.XdsServerPort != nil {
@@ -246,6 +592,15 @@ func (d *DiscoveryService) GetXdsServerPort() uint32 {
 	return DefaultXdsServerPort
 }
 
+// GetRestServerPort returns the port the REST-JSON xDS server will listen at.
+// A value of 0 means that the REST-JSON server is disabled.
+func (d *DiscoveryService) GetRestServerPort() uint32 {
+	if d.Spec.RestServerPort != nil {
+		return *d.Spec.RestServerPort
+	}
+	return 0
+}
+
 // GetMetricsPort returns the port the metrics server will listen at
 func (d *DiscoveryService) GetMetricsPort() uint32 {
 	if d.Spec.MetricsPort != nil {
@@ -254,6 +609,40 @@ func (d *DiscoveryService) GetMetricsPort() uint32 {
 	return DefaultMetricsPort
 }
 
+// GetReplicas returns the number of replicas of the discovery service Deployment
+func (d *DiscoveryService) GetReplicas() int32 {
+	if d.Spec.Replicas != nil {
+		return *d.Spec.Replicas
+	}
+	return DefaultReplicas
+}
+
+// GetNodeIDAuthorization returns the node ID authorization configuration
+func (d *DiscoveryService) GetNodeIDAuthorization() *NodeIDAuthorization {
+	if d.Spec.NodeIDAuthorization == nil {
+		return &NodeIDAuthorization{Policy: NoneNodeIDAuthorizationPolicy}
+	}
+	if d.Spec.NodeIDAuthorization.Policy == "" {
+		nia := d.Spec.NodeIDAuthorization.DeepCopy()
+		nia.Policy = NoneNodeIDAuthorizationPolicy
+		return nia
+	}
+	return d.Spec.NodeIDAuthorization
+}
+
+// GetNodeHash returns the configuration used to match envoy nodes with EnvoyConfigs
+func (d *DiscoveryService) GetNodeHash() *NodeHash {
+	if d.Spec.NodeHash == nil {
+		return &NodeHash{Kind: IDNodeHashKind}
+	}
+	if d.Spec.NodeHash.Kind == "" {
+		nh := d.Spec.NodeHash.DeepCopy()
+		nh.Kind = IDNodeHashKind
+		return nh
+	}
+	return d.Spec.NodeHash
+}
+
 // GetServiceConfig returns the Service configuration for the discovery service servers
 func (d *DiscoveryService) GetServiceConfig() *ServiceConfig {
 	if d.Spec.ServiceConfig != nil {
@@ -184,6 +184,40 @@ func TestDiscoveryService_GetXdsServerPort(t *testing.T) {
 	}
 }
 
+func TestDiscoveryService_GetRestServerPort(t *testing.T) {
+	cases := []struct {
+		testName                string
+		discoveryServiceFactory func() *DiscoveryService
+		expectedResult          uint32
+	}{
+		{"Disabled by default",
+			func() *DiscoveryService {
+				return &DiscoveryService{}
+			},
+			0,
+		},
+		{"With explicitly set value",
+			func() *DiscoveryService {
+				return &DiscoveryService{
+					Spec: DiscoveryServiceSpec{
+						RestServerPort: func() *uint32 { var u uint32 = 1000; return &u }(),
+					},
+				}
+			},
+			1000,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(subT *testing.T) {
+			receivedResult := tc.discoveryServiceFactory().GetRestServerPort()
+			if tc.expectedResult != receivedResult {
+				subT.Errorf("Expected result differs: Expected: %v, Received: %v", tc.expectedResult, receivedResult)
+			}
+		})
+	}
+}
+
 func TestDiscoveryService_GetMetricsPort(t *testing.T) {
 	cases := []struct {
 		testName                string
@@ -323,3 +357,247 @@ func TestDiscoveryService_Debug(t *testing.T) {
 		})
 	}
 }
+
+func TestDiscoveryService_GetReplicas(t *testing.T) {
+	cases := []struct {
+		testName                string
+		discoveryServiceFactory func() *DiscoveryService
+		expectedResult          int32
+	}{
+		{"With default",
+			func() *DiscoveryService {
+				return &DiscoveryService{}
+			},
+			DefaultReplicas,
+		},
+		{"With explicitly set value",
+			func() *DiscoveryService {
+				return &DiscoveryService{
+					Spec: DiscoveryServiceSpec{
+						Replicas: func() *int32 { var i int32 = 3; return &i }(),
+					},
+				}
+			},
+			3,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(subT *testing.T) {
+			receivedResult := tc.discoveryServiceFactory().GetReplicas()
+			if tc.expectedResult != receivedResult {
+				subT.Errorf("Expected result differs: Expected: %v, Received: %v", tc.expectedResult, receivedResult)
+			}
+		})
+	}
+}
+
+func TestDiscoveryService_GetNodeIDAuthorization(t *testing.T) {
+	cases := []struct {
+		testName                string
+		discoveryServiceFactory func() *DiscoveryService
+		expectedResult          *NodeIDAuthorization
+	}{
+		{"With default",
+			func() *DiscoveryService {
+				return &DiscoveryService{}
+			},
+			&NodeIDAuthorization{Policy: NoneNodeIDAuthorizationPolicy},
+		},
+		{"With mappings and no policy",
+			func() *DiscoveryService {
+				return &DiscoveryService{
+					Spec: DiscoveryServiceSpec{
+						NodeIDAuthorization: &NodeIDAuthorization{
+							Mappings: []NodeIDMapping{{Identity: "envoy", NodeIDs: []string{"node1"}}},
+						},
+					},
+				}
+			},
+			&NodeIDAuthorization{
+				Policy:   NoneNodeIDAuthorizationPolicy,
+				Mappings: []NodeIDMapping{{Identity: "envoy", NodeIDs: []string{"node1"}}},
+			},
+		},
+		{"With explicitly set value",
+			func() *DiscoveryService {
+				return &DiscoveryService{
+					Spec: DiscoveryServiceSpec{
+						NodeIDAuthorization: &NodeIDAuthorization{Policy: ExactNodeIDAuthorizationPolicy},
+					},
+				}
+			},
+			&NodeIDAuthorization{Policy: ExactNodeIDAuthorizationPolicy},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(subT *testing.T) {
+			receivedResult := tc.discoveryServiceFactory().GetNodeIDAuthorization()
+			if !equality.Semantic.DeepEqual(tc.expectedResult, receivedResult) {
+				subT.Errorf("Expected result differs: Expected: %v, Received: %v", tc.expectedResult, receivedResult)
+			}
+		})
+	}
+}
+
+func TestDiscoveryService_GetNodeHash(t *testing.T) {
+	cases := []struct {
+		testName                string
+		discoveryServiceFactory func() *DiscoveryService
+		expectedResult          *NodeHash
+	}{
+		{"With default",
+			func() *DiscoveryService {
+				return &DiscoveryService{}
+			},
+			&NodeHash{Kind: IDNodeHashKind},
+		},
+		{"With explicitly set value",
+			func() *DiscoveryService {
+				return &DiscoveryService{
+					Spec: DiscoveryServiceSpec{
+						NodeHash: &NodeHash{Kind: RegexNodeHashKind, Regex: "^(.*)-[a-z0-9]+-[a-z0-9]+$"},
+					},
+				}
+			},
+			&NodeHash{Kind: RegexNodeHashKind, Regex: "^(.*)-[a-z0-9]+-[a-z0-9]+$"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(subT *testing.T) {
+			receivedResult := tc.discoveryServiceFactory().GetNodeHash()
+			if !equality.Semantic.DeepEqual(tc.expectedResult, receivedResult) {
+				subT.Errorf("Expected result differs: Expected: %v, Received: %v", tc.expectedResult, receivedResult)
+			}
+		})
+	}
+}
+
+func TestDiscoveryService_Validate(t *testing.T) {
+	port := func(u uint32) *uint32 { return &u }
+	cases := []struct {
+		testName    string
+		spec        DiscoveryServiceSpec
+		expectedErr bool
+	}{
+		{"Defaults", DiscoveryServiceSpec{}, false},
+		{"Distinct ports",
+			DiscoveryServiceSpec{
+				XdsServerPort:  port(1000),
+				RestServerPort: port(1001),
+				MetricsPort:    port(1002),
+				DebugServer:    &DebugServer{Port: 1003, AllowedIdentities: []string{"admin"}},
+			},
+			false,
+		},
+		{"REST-JSON server port same as the xDS server port",
+			DiscoveryServiceSpec{XdsServerPort: port(1000), RestServerPort: port(1000)},
+			true,
+		},
+		{"Debug server port same as the metrics port",
+			DiscoveryServiceSpec{DebugServer: &DebugServer{Port: DefaultMetricsPort, AllowedIdentities: []string{"admin"}}},
+			true,
+		},
+		{"Metrics port same as the health probe port",
+			DiscoveryServiceSpec{MetricsPort: port(DefaultHealthProbePort)},
+			true,
+		},
+		{"Invalid snapshot publishing options",
+			DiscoveryServiceSpec{SnapshotPublishing: &SnapshotPublishing{DebounceWindow: &metav1.Duration{Duration: -time.Second}}},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(subT *testing.T) {
+			ds := &DiscoveryService{Spec: tc.spec}
+			if err := ds.Validate(); (err != nil) != tc.expectedErr {
+				subT.Errorf("Expected error: %v, Received: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestSnapshotPublishing_Validate(t *testing.T) {
+	cases := []struct {
+		testName    string
+		options     *SnapshotPublishing
+		expectedErr bool
+	}{
+		{"Nil options", nil, false},
+		{"Valid options",
+			&SnapshotPublishing{
+				DebounceWindow: &metav1.Duration{Duration: 200 * time.Millisecond},
+				MaxDelay:       &metav1.Duration{Duration: 2 * time.Second},
+			},
+			false,
+		},
+		{"Debounce window within the default max delay",
+			&SnapshotPublishing{DebounceWindow: &metav1.Duration{Duration: 500 * time.Millisecond}},
+			false,
+		},
+		{"Negative debounce window",
+			&SnapshotPublishing{DebounceWindow: &metav1.Duration{Duration: -time.Second}},
+			true,
+		},
+		{"Max delay lower than the debounce window",
+			&SnapshotPublishing{
+				DebounceWindow: &metav1.Duration{Duration: 2 * time.Second},
+				MaxDelay:       &metav1.Duration{Duration: time.Second},
+			},
+			true,
+		},
+		{"Debounce window greater than the default max delay",
+			&SnapshotPublishing{DebounceWindow: &metav1.Duration{Duration: 5 * time.Second}},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(subT *testing.T) {
+			if err := tc.options.Validate(); (err != nil) != tc.expectedErr {
+				subT.Errorf("Expected error: %v, Received: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGrpcServerOptions_Validate(t *testing.T) {
+	cases := []struct {
+		testName    string
+		options     *GrpcServerOptions
+		expectedErr bool
+	}{
+		{"Nil options", nil, false},
+		{"Valid options",
+			&GrpcServerOptions{
+				MaxConcurrentStreams: func() *uint32 { var i uint32 = 100; return &i }(),
+				KeepaliveTime:        &metav1.Duration{Duration: 30 * time.Second},
+				MaxConnectionAge:     &metav1.Duration{Duration: 30 * time.Minute},
+			},
+			false,
+		},
+		{"Zero max concurrent streams",
+			&GrpcServerOptions{MaxConcurrentStreams: func() *uint32 { var i uint32 = 0; return &i }()},
+			true,
+		},
+		{"Negative duration",
+			&GrpcServerOptions{MaxConnectionAge: &metav1.Duration{Duration: -time.Minute}},
+			true,
+		},
+		{"Keepalive time lower than 1s",
+			&GrpcServerOptions{KeepaliveTime: &metav1.Duration{Duration: 100 * time.Millisecond}},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(subT *testing.T) {
+			if err := tc.options.Validate(); (err != nil) != tc.expectedErr {
+				subT.Errorf("Expected error: %v, Received: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
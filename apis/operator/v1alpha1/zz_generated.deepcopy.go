@@ -23,6 +23,7 @@ package v1alpha1
 import (
 	"github.com/operator-framework/operator-lib/status"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -73,6 +74,26 @@ func (in *CertificateRenewalConfig) DeepCopy() *CertificateRenewalConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DebugServer) DeepCopyInto(out *DebugServer) {
+	*out = *in
+	if in.AllowedIdentities != nil {
+		in, out := &in.AllowedIdentities, &out.AllowedIdentities
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DebugServer.
+func (in *DebugServer) DeepCopy() *DebugServer {
+	if in == nil {
+		return nil
+	}
+	out := new(DebugServer)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DiscoveryService) DeepCopyInto(out *DiscoveryService) {
 	*out = *in
@@ -321,6 +342,11 @@ func (in *DiscoveryServiceSpec) DeepCopyInto(out *DiscoveryServiceSpec) {
 		*out = new(uint32)
 		**out = **in
 	}
+	if in.RestServerPort != nil {
+		in, out := &in.RestServerPort, &out.RestServerPort
+		*out = new(uint32)
+		**out = **in
+	}
 	if in.MetricsPort != nil {
 		in, out := &in.MetricsPort, &out.MetricsPort
 		*out = new(uint32)
@@ -331,6 +357,36 @@ func (in *DiscoveryServiceSpec) DeepCopyInto(out *DiscoveryServiceSpec) {
 		*out = new(ServiceConfig)
 		**out = **in
 	}
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.NodeIDAuthorization != nil {
+		in, out := &in.NodeIDAuthorization, &out.NodeIDAuthorization
+		*out = new(NodeIDAuthorization)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeHash != nil {
+		in, out := &in.NodeHash, &out.NodeHash
+		*out = new(NodeHash)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.GrpcServerOptions != nil {
+		in, out := &in.GrpcServerOptions, &out.GrpcServerOptions
+		*out = new(GrpcServerOptions)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SnapshotPublishing != nil {
+		in, out := &in.SnapshotPublishing, &out.SnapshotPublishing
+		*out = new(SnapshotPublishing)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DebugServer != nil {
+		in, out := &in.DebugServer, &out.DebugServer
+		*out = new(DebugServer)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscoveryServiceSpec.
@@ -365,6 +421,123 @@ func (in *DiscoveryServiceStatus) DeepCopy() *DiscoveryServiceStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrpcServerOptions) DeepCopyInto(out *GrpcServerOptions) {
+	*out = *in
+	if in.MaxConcurrentStreams != nil {
+		in, out := &in.MaxConcurrentStreams, &out.MaxConcurrentStreams
+		*out = new(uint32)
+		**out = **in
+	}
+	if in.KeepaliveMinTime != nil {
+		in, out := &in.KeepaliveMinTime, &out.KeepaliveMinTime
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.KeepalivePermitWithoutStream != nil {
+		in, out := &in.KeepalivePermitWithoutStream, &out.KeepalivePermitWithoutStream
+		*out = new(bool)
+		**out = **in
+	}
+	if in.KeepaliveTime != nil {
+		in, out := &in.KeepaliveTime, &out.KeepaliveTime
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.KeepaliveTimeout != nil {
+		in, out := &in.KeepaliveTimeout, &out.KeepaliveTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MaxConnectionAge != nil {
+		in, out := &in.MaxConnectionAge, &out.MaxConnectionAge
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MaxConnectionAgeGrace != nil {
+		in, out := &in.MaxConnectionAgeGrace, &out.MaxConnectionAgeGrace
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.GracefulStopTimeout != nil {
+		in, out := &in.GracefulStopTimeout, &out.GracefulStopTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrpcServerOptions.
+func (in *GrpcServerOptions) DeepCopy() *GrpcServerOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(GrpcServerOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeHash) DeepCopyInto(out *NodeHash) {
+	*out = *in
+	if in.Prefixes != nil {
+		in, out := &in.Prefixes, &out.Prefixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeHash.
+func (in *NodeHash) DeepCopy() *NodeHash {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeHash)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeIDAuthorization) DeepCopyInto(out *NodeIDAuthorization) {
+	*out = *in
+	if in.Mappings != nil {
+		in, out := &in.Mappings, &out.Mappings
+		*out = make([]NodeIDMapping, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeIDAuthorization.
+func (in *NodeIDAuthorization) DeepCopy() *NodeIDAuthorization {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeIDAuthorization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeIDMapping) DeepCopyInto(out *NodeIDMapping) {
+	*out = *in
+	if in.NodeIDs != nil {
+		in, out := &in.NodeIDs, &out.NodeIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeIDMapping.
+func (in *NodeIDMapping) DeepCopy() *NodeIDMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeIDMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PKIConfig) DeepCopyInto(out *PKIConfig) {
 	*out = *in
@@ -419,3 +592,28 @@ func (in *ServiceConfig) DeepCopy() *ServiceConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapshotPublishing) DeepCopyInto(out *SnapshotPublishing) {
+	*out = *in
+	if in.DebounceWindow != nil {
+		in, out := &in.DebounceWindow, &out.DebounceWindow
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MaxDelay != nil {
+		in, out := &in.MaxDelay, &out.MaxDelay
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapshotPublishing.
+func (in *SnapshotPublishing) DeepCopy() *SnapshotPublishing {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotPublishing)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+
+	"github.com/operator-framework/operator-lib/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileConfigMap_Reconcile(t *testing.T) {
+	newRevision := func(name string, ref *marin3rv1alpha1.ConfigMapReference) *marin3rv1alpha1.EnvoyConfigRevision {
+		return &marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{
+				NodeID:  "node1",
+				Version: "xxxx",
+				EnvoyResources: &marin3rv1alpha1.EnvoyResources{
+					Secrets: []marin3rv1alpha1.EnvoySecretResource{{
+						Name:         "ca",
+						Type:         marin3rv1alpha1.ValidationContextSecretType,
+						ConfigMapRef: ref,
+					}}},
+			},
+			Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+				Conditions: []status.Condition{
+					{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue},
+					{Type: marin3rv1alpha1.ResourcesInSyncCondition, Status: corev1.ConditionTrue},
+				},
+			},
+		}
+	}
+
+	t.Run("Sets ResourcesInSyncCondition to false in the EnvoyConfigRevisions that refer to the ConfigMap", func(t *testing.T) {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "bundle", Namespace: "default"},
+			Data:       map[string]string{"ca.crt": "xxxxx"},
+		}
+		referring := newRevision("referring", &marin3rv1alpha1.ConfigMapReference{Name: "bundle"})
+		other := newRevision("other", &marin3rv1alpha1.ConfigMapReference{Name: "bundle", Namespace: "other"})
+
+		cl := fake.NewFakeClient(cm, referring, other)
+		r := &ConfigMapReconciler{Client: cl, Scheme: s, Log: ctrl.Log.WithName("test")}
+
+		_, gotErr := r.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: "bundle", Namespace: "default"},
+		})
+		if gotErr != nil {
+			t.Errorf("TestReconcileConfigMap_Reconcile() returned error: '%v'", gotErr)
+			return
+		}
+
+		r.Client.Get(context.TODO(), types.NamespacedName{Name: "referring", Namespace: "default"}, referring)
+		if referring.Status.Conditions.IsTrueFor(marin3rv1alpha1.ResourcesInSyncCondition) {
+			t.Errorf("TestReconcileConfigMap_Reconcile() condition 'ResourcesInSyncCondition' was not set to false in EnvoyConfigRevision")
+		}
+		r.Client.Get(context.TODO(), types.NamespacedName{Name: "other", Namespace: "default"}, other)
+		if !other.Status.Conditions.IsTrueFor(marin3rv1alpha1.ResourcesInSyncCondition) {
+			t.Errorf("TestReconcileConfigMap_Reconcile() condition 'ResourcesInSyncCondition' was set to false in an unrelated EnvoyConfigRevision")
+		}
+	})
+}
@@ -0,0 +1,70 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ConfigMapReconciler triggers the regeneration of the envoy Secret
+// resources that take a bundle of trusted CAs from a ConfigMap
+type ConfigMapReconciler struct {
+	Client client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=core,namespace=placeholder,resources=configmaps,verbs=get;list;watch
+
+func (r *ConfigMapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+
+	log := r.Log.WithValues("name", req.Name, "namespace", req.Namespace)
+	log.V(1).Info("Reconciling from ConfigMap")
+
+	return reconcile.Result{}, markRevisionsOutOfSync(ctx, r.Client, log, "ConfigMapChanged",
+		"A configmap relevant to this envoyconfigrevision changed",
+		matchSecrets(func(namespace string, secret marin3rv1alpha1.EnvoySecretResource) bool {
+			if secret.ConfigMapRef == nil || secret.ConfigMapRef.Name != req.Name {
+				return false
+			}
+			// An empty namespace refers to the namespace of the EnvoyConfigRevision
+			if secret.ConfigMapRef.Namespace != "" {
+				namespace = secret.ConfigMapRef.Namespace
+			}
+			return namespace == req.Namespace
+		}),
+	)
+}
+
+func (r *ConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).For(&corev1.ConfigMap{}).
+		WithEventFilter(predicate.Funcs{
+			DeleteFunc: func(e event.DeleteEvent) bool { return false },
+		}).
+		Complete(r)
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+
+	"github.com/operator-framework/operator-lib/status"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileEndpointSlice_Reconcile(t *testing.T) {
+	newRevision := func(name, namespace, service string) *marin3rv1alpha1.EnvoyConfigRevision {
+		return &marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{
+				NodeID:  "node1",
+				Version: "xxxx",
+				EnvoyResources: &marin3rv1alpha1.EnvoyResources{
+					ServiceEndpoints: []marin3rv1alpha1.EnvoyServiceEndpointsResource{{Name: "web", ServiceName: service}},
+				},
+			},
+			Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+				Conditions: []status.Condition{
+					{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue},
+					{Type: marin3rv1alpha1.ResourcesInSyncCondition, Status: corev1.ConditionTrue},
+				},
+			},
+		}
+	}
+
+	t.Run("Sets ResourcesInSyncCondition to false in the EnvoyConfigRevisions that refer to the Service", func(t *testing.T) {
+		referring := newRevision("referring", "default", "web")
+		otherService := newRevision("other-service", "default", "api")
+		otherNamespace := newRevision("other-namespace", "other", "web")
+
+		cl := fake.NewFakeClient(referring, otherService, otherNamespace)
+		r := &EndpointSliceReconciler{Client: cl, Scheme: s, Log: ctrl.Log.WithName("test")}
+
+		_, gotErr := r.Reconcile(context.TODO(), reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: "web", Namespace: "default"},
+		})
+		if gotErr != nil {
+			t.Errorf("TestReconcileEndpointSlice_Reconcile() returned error: '%v'", gotErr)
+			return
+		}
+
+		for _, ecr := range []*marin3rv1alpha1.EnvoyConfigRevision{referring, otherService, otherNamespace} {
+			r.Client.Get(context.TODO(), types.NamespacedName{Name: ecr.GetName(), Namespace: ecr.GetNamespace()}, ecr)
+		}
+		if referring.Status.Conditions.IsTrueFor(marin3rv1alpha1.ResourcesInSyncCondition) {
+			t.Errorf("TestReconcileEndpointSlice_Reconcile() condition 'ResourcesInSyncCondition' was not set to false in EnvoyConfigRevision")
+		}
+		if !otherService.Status.Conditions.IsTrueFor(marin3rv1alpha1.ResourcesInSyncCondition) ||
+			!otherNamespace.Status.Conditions.IsTrueFor(marin3rv1alpha1.ResourcesInSyncCondition) {
+			t.Errorf("TestReconcileEndpointSlice_Reconcile() condition 'ResourcesInSyncCondition' was set to false in an unrelated EnvoyConfigRevision")
+		}
+	})
+}
+
+func Test_serviceForEndpointSlice(t *testing.T) {
+	tests := []struct {
+		name  string
+		slice *discoveryv1beta1.EndpointSlice
+		want  []reconcile.Request
+	}{
+		{
+			name: "Maps the EndpointSlice to its Service",
+			slice: &discoveryv1beta1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{
+				Name: "web-xxxx", Namespace: "default", Labels: map[string]string{discoveryv1beta1.LabelServiceName: "web"},
+			}},
+			want: []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "web", Namespace: "default"}}},
+		},
+		{
+			name:  "Ignores EndpointSlices without Service",
+			slice: &discoveryv1beta1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "custom", Namespace: "default"}},
+			want:  []reconcile.Request{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serviceForEndpointSlice(tt.slice); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("serviceForEndpointSlice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// EndpointSliceReconciler triggers the regeneration of the envoy ClusterLoadAssignment
+// resources generated from the EndpointSlices of a Service. It reconciles Services, and
+// the events of the EndpointSlices are mapped to the Service they belong to.
+type EndpointSliceReconciler struct {
+	Client client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=core,namespace=placeholder,resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups=discovery.k8s.io,namespace=placeholder,resources=endpointslices,verbs=get;list;watch
+
+func (r *EndpointSliceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+
+	log := r.Log.WithValues("name", req.Name, "namespace", req.Namespace)
+	log.V(1).Info("Reconciling from EndpointSlices")
+
+	return reconcile.Result{}, markRevisionsOutOfSync(ctx, r.Client, log, "EndpointsChanged",
+		"The endpoints of a service relevant to this envoyconfigrevision changed",
+		func(ecr *marin3rv1alpha1.EnvoyConfigRevision) bool {
+			if ecr.GetNamespace() != req.Namespace {
+				return false
+			}
+			for _, se := range ecr.Spec.EnvoyResources.ServiceEndpoints {
+				if se.ServiceName == req.Name {
+					return true
+				}
+			}
+			return false
+		},
+	)
+}
+
+// serviceForEndpointSlice maps an EndpointSlice to the Service it belongs to
+func serviceForEndpointSlice(o client.Object) []reconcile.Request {
+	name, ok := o.GetLabels()[discoveryv1beta1.LabelServiceName]
+	if !ok {
+		return []reconcile.Request{}
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: o.GetNamespace()}}}
+}
+
+func (r *EndpointSliceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).Named("endpointslice").For(&corev1.Service{}).
+		Watches(&source.Kind{Type: &discoveryv1beta1.EndpointSlice{}}, handler.EnqueueRequestsFromMapFunc(serviceForEndpointSlice)).
+		Complete(r)
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -32,9 +33,10 @@ import (
 
 // EnvoyConfigReconciler reconciles a EnvoyConfig object
 type EnvoyConfigReconciler struct {
-	Client client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Client   client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 // Reconcile progresses EnvoyConfig resources to its desired state
@@ -42,6 +44,7 @@ type EnvoyConfigReconciler struct {
 // +kubebuilder:rbac:groups=marin3r.3scale.net,namespace=placeholder,resources=envoyconfigs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=marin3r.3scale.net,namespace=placeholder,resources=envoyconfigrevisions,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=marin3r.3scale.net,namespace=placeholder,resources=envoyconfigrevisions/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="core",namespace=placeholder,resources=events,verbs=create;patch
 
 func (r *EnvoyConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("name", req.Name, "namespace", req.Namespace)
@@ -72,7 +75,7 @@ func (r *EnvoyConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	revisionReconciler := envoyconfig.NewRevisionReconciler(
-		ctx, log, r.Client, r.Scheme, ec,
+		ctx, log, r.Client, r.Scheme, r.Recorder, ec,
 	)
 
 	result, err := revisionReconciler.Reconcile()
@@ -86,10 +89,10 @@ func (r *EnvoyConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 		log.Info("status updated for EnvoyConfig resource")
-		return reconcile.Result{}, nil
+		return result, nil
 	}
 
-	return ctrl.Result{}, nil
+	return result, nil
 }
 
 // SetupWithManager adds the controller to the manager
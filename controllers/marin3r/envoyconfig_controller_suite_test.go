@@ -13,7 +13,6 @@ import (
 	"github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/filters"
 	"github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/revisions"
 	rollback "github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/rollback"
-	"github.com/3scale/marin3r/pkg/util"
 	testutil "github.com/3scale/marin3r/pkg/util/test"
 	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
@@ -140,7 +139,7 @@ var _ = Describe("EnvoyConfig controller", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Validate the cache for the nodeID
-				wantRevision := util.Hash(ec.Spec.EnvoyResources)
+				wantRevision := ec.GetEnvoyResourcesVersion()
 				wantSnap := xdss_v2.NewSnapshot(&cache_v2.Snapshot{
 					Resources: [6]cache_v2.Resources{
 						{Version: wantRevision, Items: map[string]cache_types.Resource{
@@ -193,7 +192,7 @@ var _ = Describe("EnvoyConfig controller", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Wait for the new revision to get published
-				wantRevision = util.Hash(ec.Spec.EnvoyResources)
+				wantRevision = ec.GetEnvoyResourcesVersion()
 				Eventually(func() bool {
 					err := k8sClient.Get(context.Background(), types.NamespacedName{Name: "ec", Namespace: namespace}, ec)
 					Expect(err).ToNot(HaveOccurred())
@@ -251,7 +250,7 @@ var _ = Describe("EnvoyConfig controller", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Wait for the existent revision to get published
-				wantRevision = util.Hash(ec.Spec.EnvoyResources)
+				wantRevision = ec.GetEnvoyResourcesVersion()
 				Eventually(func() bool {
 					err := k8sClient.Get(context.Background(), types.NamespacedName{Name: "ec", Namespace: namespace}, ec)
 					Expect(err).ToNot(HaveOccurred())
@@ -342,7 +341,7 @@ var _ = Describe("EnvoyConfig controller", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Validate the cache for the nodeID
-				wantRevision := util.Hash(ec.Spec.EnvoyResources)
+				wantRevision := ec.GetEnvoyResourcesVersion()
 				wantSnap := xdss_v3.NewSnapshot(&cache_v3.Snapshot{
 					Resources: [6]cache_v3.Resources{
 						{Version: wantRevision, Items: map[string]cache_types.Resource{
@@ -625,7 +624,7 @@ var _ = Describe("EnvoyConfig controller", func() {
 
 				By("checking the v2 xDS server cache")
 				{
-					wantRevision := util.Hash(ec.Spec.EnvoyResources)
+					wantRevision := ec.GetEnvoyResourcesVersion()
 					wantSnap := xdss_v2.NewSnapshot(&cache_v2.Snapshot{
 						Resources: [6]cache_v2.Resources{
 							{Version: wantRevision, Items: map[string]cache_types.Resource{
@@ -643,7 +642,7 @@ var _ = Describe("EnvoyConfig controller", func() {
 
 				By("checking the v3 xDS server cache")
 				{
-					wantRevision := util.Hash(ec.Spec.EnvoyResources)
+					wantRevision := ec.GetEnvoyResourcesVersion()
 					wantSnap := xdss_v3.NewSnapshot(&cache_v3.Snapshot{
 						Resources: [6]cache_v3.Resources{
 							{Version: wantRevision, Items: map[string]cache_types.Resource{
@@ -729,7 +728,7 @@ var _ = Describe("EnvoyConfig controller", func() {
 
 			BeforeEach(func() {
 				OnErrorFn := rollback.OnError(k8sClient)
-				version := util.Hash(ec.Spec.EnvoyResources)
+				version := ec.GetEnvoyResourcesVersion()
 				err := OnErrorFn(nodeID, version, "msg", envoy.APIv2)
 				Expect(err).ToNot(HaveOccurred())
 			})
@@ -19,12 +19,16 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"github.com/3scale/marin3r/pkg/discoveryservice/metrics"
+	"github.com/3scale/marin3r/pkg/discoveryservice/registry"
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	envoy "github.com/3scale/marin3r/pkg/envoy"
 	envoy_resources "github.com/3scale/marin3r/pkg/envoy/resources"
 	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	"github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/filters"
 	envoyconfigrevision "github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfigrevision"
 	"github.com/redhat-cop/operator-utils/pkg/util"
 
@@ -32,16 +36,26 @@ import (
 	"github.com/operator-framework/operator-lib/status"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// replicaCheckInterval is the interval at which the leader checks if the
+// replicas that have reported clients in the status are still alive
+const replicaCheckInterval = 30 * time.Second
+
 // EnvoyConfigRevisionReconciler reconciles a EnvoyConfigRevision object
 type EnvoyConfigRevisionReconciler struct {
 	Client     client.Client
@@ -49,12 +63,46 @@ type EnvoyConfigRevisionReconciler struct {
 	Scheme     *runtime.Scheme
 	XdsCache   xdss.Cache
 	APIVersion envoy.APIVersion
+	// ClientRegistry is used to report the status of the connected clients
+	// in the published revisions. Optional.
+	ClientRegistry *registry.Registry
+	// Elected is closed when this discovery service replica becomes the leader. The
+	// controller runs in all the replicas to load the xDS cache of each one of them, but
+	// only the leader writes to the EnvoyConfigRevisions, except for the status of the
+	// clients connected to each replica. If nil, the controller behaves as the leader.
+	Elected <-chan struct{}
+	// ReplicaName is the name of the discovery service replica, used to tell
+	// apart the clients connected to each replica in the status
+	ReplicaName string
+	// APIReader is used to check if the replicas that have reported clients in
+	// the status are still alive. Optional.
+	APIReader client.Reader
+	// Canaries keeps track of the canary rollouts in progress, so the xDS server
+	// serves the canary revisions to the clients selected as canaries. Optional.
+	Canaries *xdss.Canaries
+	// OnCacheLoad is called with the result of each attempt to load
+	// a revision into the xDS cache. Optional.
+	OnCacheLoad func(ecr types.NamespacedName, err error)
+}
+
+// isLeader returns true if this discovery service replica is the leader
+func (r *EnvoyConfigRevisionReconciler) isLeader() bool {
+	if r.Elected == nil {
+		return true
+	}
+	select {
+	case <-r.Elected:
+		return true
+	default:
+		return false
+	}
 }
 
 // Reconcile progresses EnvoyConfigRevision resources to its desired state
 // +kubebuilder:rbac:groups=marin3r.3scale.net,namespace=placeholder,resources=envoyconfigrevisions,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=marin3r.3scale.net,namespace=placeholder,resources=envoyconfigrevisions/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="core",namespace=placeholder,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="core",namespace=placeholder,resources=pods,verbs=get
 func (r *EnvoyConfigRevisionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("name", req.Name, "namespace", req.Namespace)
 
@@ -73,6 +121,10 @@ func (r *EnvoyConfigRevisionReconciler) Reconcile(ctx context.Context, req ctrl.
 	}
 
 	if ok := envoyconfigrevision.IsInitialized(ecr); !ok {
+		if !r.isLeader() {
+			// The leader will initialize the resource
+			return reconcile.Result{}, nil
+		}
 		if err := r.Client.Update(ctx, ecr); err != nil {
 			log.Error(err, "unable to update EnvoyConfigRevision")
 			return ctrl.Result{}, err
@@ -86,6 +138,12 @@ func (r *EnvoyConfigRevisionReconciler) Reconcile(ctx context.Context, req ctrl.
 			return reconcile.Result{}, nil
 		}
 		envoyconfigrevision.CleanupLogic(ecr, r.XdsCache, log)
+		if r.Canaries != nil && ecr.Status.IsCanary() {
+			r.Canaries.Stop(ecr.Spec.NodeID)
+		}
+		if !r.isLeader() {
+			return reconcile.Result{}, nil
+		}
 		controllerutil.RemoveFinalizer(ecr, marin3rv1alpha1.EnvoyConfigRevisionFinalizer)
 		if err = r.Client.Update(ctx, ecr); err != nil {
 			log.Error(err, "unable to update EnvoyConfigRevision")
@@ -95,9 +153,16 @@ func (r *EnvoyConfigRevisionReconciler) Reconcile(ctx context.Context, req ctrl.
 		return reconcile.Result{}, nil
 	}
 
-	// If this ecr has the RevisionPublishedCondition set to "True" pusblish the resources
-	// to the xds server cache
-	if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) {
+	// Start or stop the canary rollout of this ecr before writing the snapshot,
+	// so the canaries are moved to the canary snapshot once it is written
+	if err := r.reconcileCanary(ecr, log); err != nil {
+		log.Error(err, "unable to reconcile the canary rollout")
+		return ctrl.Result{}, err
+	}
+
+	// If this ecr has the RevisionPublishedCondition or the RevisionCanaryCondition set to "True"
+	// pusblish the resources to the xds server cache, under the key of the canary snapshot for the latter
+	if key, ok := envoyconfigrevision.SnapshotKey(ecr); ok {
 		decoder := envoy_serializer.NewResourceUnmarshaller(ecr.GetSerialization(), r.APIVersion)
 
 		cacheReconciler := envoyconfigrevision.NewCacheReconciler(
@@ -106,7 +171,10 @@ func (r *EnvoyConfigRevisionReconciler) Reconcile(ctx context.Context, req ctrl.
 			envoy_resources.NewGenerator(r.APIVersion),
 		)
 
-		result, err := cacheReconciler.Reconcile(req.NamespacedName, ecr.Spec.EnvoyResources, ecr.Spec.NodeID, ecr.Spec.Version)
+		result, err := cacheReconciler.Reconcile(req.NamespacedName, ecr.Spec.EnvoyResources, key, ecr.Spec.Version)
+		if r.OnCacheLoad != nil {
+			r.OnCacheLoad(req.NamespacedName, err)
+		}
 
 		// If a type errors.StatusError is returned it means that the config in spec.envoyResources is wrong
 		// and cannot be written into the xDS cache. This is true for any error loading all types of resources
@@ -116,16 +184,37 @@ func (r *EnvoyConfigRevisionReconciler) Reconcile(ctx context.Context, req ctrl.
 			switch err.(type) {
 			case *errors.StatusError:
 				log.Error(err, fmt.Sprintf("%v", err))
+				if !r.isLeader() {
+					// All replicas fail to load the same resources, the leader will taint the revision
+					return reconcile.Result{}, nil
+				}
 				if err := r.taintSelf(ctx, ecr, "FailedLoadingResources", err.Error(), log); err != nil {
 					return ctrl.Result{}, err
 				}
 			default:
 				return result, err
 			}
+		} else if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) && !ecr.Status.IsPublished() {
+			// The revision has just been published, keep track of the EnvoyConfig change
+			// that caused it to measure the time it takes for the clients to ACK it
+			metrics.RecordPublication(r.APIVersion, key, ecr.Spec.Version, configChangedAt(ecr))
+		}
+	}
+
+	if !r.isLeader() {
+		// Replicas other than the leader only report the clients connected to them
+		if r.ClientRegistry != nil && !envoyconfigrevision.IsClientsStatusReconciled(ecr, r.ClientRegistry, r.ReplicaName) {
+			if err := r.Client.Status().Update(ctx, ecr); err != nil {
+				log.Error(err, "unable to update EnvoyConfigRevision status")
+				return ctrl.Result{}, err
+			}
+			log.Info("clients status updated for EnvoyConfigRevision resource")
 		}
+		return ctrl.Result{}, nil
 	}
 
-	if ok := envoyconfigrevision.IsStatusReconciled(ecr, r.XdsCache); !ok {
+	pruned := envoyconfigrevision.PruneClientsStatus(ecr, r.isReplicaAlive(ctx, ecr.GetNamespace()))
+	if ok := envoyconfigrevision.IsStatusReconciled(ecr, r.XdsCache, r.ClientRegistry, r.ReplicaName); !ok || pruned {
 		if err := r.Client.Status().Update(ctx, ecr); err != nil {
 			log.Error(err, "unable to update EnvoyConfigRevision status")
 			return ctrl.Result{}, err
@@ -134,9 +223,79 @@ func (r *EnvoyConfigRevisionReconciler) Reconcile(ctx context.Context, req ctrl.
 		return reconcile.Result{}, nil
 	}
 
+	// Periodically check the replicas that have reported clients as they
+	// won't remove them from the status if they die
+	if r.reportsOtherReplicas(ecr) {
+		return ctrl.Result{RequeueAfter: replicaCheckInterval}, nil
+	}
+
 	return ctrl.Result{}, nil
 }
 
+// reconcileCanary starts the canary rollout of the ecr when it has the RevisionCanaryCondition
+// set to "True", and stops it when the condition is removed. The canaries of a promoted revision
+// are moved back to the published snapshot when it is written to the cache, as it then holds the
+// version they already have. The canaries of an aborted rollout are moved back straight away.
+func (r *EnvoyConfigRevisionReconciler) reconcileCanary(ecr *marin3rv1alpha1.EnvoyConfigRevision, log logr.Logger) error {
+	if r.Canaries == nil {
+		return nil
+	}
+
+	published := ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition)
+	if ecr.Status.IsCanary() && !published {
+		selection := xdss.CanarySelection{Version: ecr.Spec.Version, Percentage: ecr.Status.Canary.GetPercentage()}
+		if ecr.Status.Canary.Selector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(ecr.Status.Canary.Selector)
+			if err != nil {
+				return err
+			}
+			selection.Selector = selector
+		}
+		if current, ok := r.Canaries.Get(ecr.Spec.NodeID); !ok || current.Version != selection.Version {
+			log.Info("Starting canary rollout", "Version", selection.Version, "Percentage", selection.Percentage)
+		}
+		r.Canaries.Start(ecr.Spec.NodeID, selection)
+		return nil
+	}
+
+	if current, ok := r.Canaries.Get(ecr.Spec.NodeID); ok && current.Version == ecr.Spec.Version {
+		r.Canaries.Stop(ecr.Spec.NodeID)
+		if published {
+			log.Info("Canary rollout finished, revision promoted", "Version", ecr.Spec.Version)
+			return nil
+		}
+		r.XdsCache.ClearSnapshot(xdss.CanaryKey(ecr.Spec.NodeID))
+		log.Info("Canary rollout aborted", "Version", ecr.Spec.Version)
+	}
+	return nil
+}
+
+// isReplicaAlive returns a function that checks if the Pod of a discovery service replica exists
+func (r *EnvoyConfigRevisionReconciler) isReplicaAlive(ctx context.Context, namespace string) func(string) bool {
+	return func(replica string) bool {
+		if r.APIReader == nil || replica == "" || replica == r.ReplicaName {
+			return true
+		}
+		err := r.APIReader.Get(ctx, types.NamespacedName{Name: replica, Namespace: namespace}, &corev1.Pod{})
+		// Only consider the replica dead if we are sure the Pod no longer exists
+		return !errors.IsNotFound(err)
+	}
+}
+
+// reportsOtherReplicas returns true if the status of the EnvoyConfigRevision
+// holds clients connected to other discovery service replicas that are still being reported
+func (r *EnvoyConfigRevisionReconciler) reportsOtherReplicas(ecr *marin3rv1alpha1.EnvoyConfigRevision) bool {
+	if _, ok := envoyconfigrevision.SnapshotKey(ecr); !ok || r.APIReader == nil || ecr.Status.Clients == nil {
+		return false
+	}
+	for _, client := range ecr.Status.Clients.Details {
+		if client.Replica != r.ReplicaName {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *EnvoyConfigRevisionReconciler) taintSelf(ctx context.Context, ecr *marin3rv1alpha1.EnvoyConfigRevision,
 	reason, msg string, log logr.Logger) error {
 
@@ -159,6 +318,18 @@ func (r *EnvoyConfigRevisionReconciler) taintSelf(ctx context.Context, ecr *mari
 	return nil
 }
 
+// configChangedAt returns the time of the EnvoyConfig change that caused the revision
+// to be published. Revisions are created when the EnvoyConfig changes, so the creation
+// timestamp is used the first time a revision is published. Revisions published again
+// are so because the EnvoyConfig went back to their resources, which is when the
+// EnvoyConfig controller sets the RevisionPublished condition.
+func configChangedAt(ecr *marin3rv1alpha1.EnvoyConfigRevision) time.Time {
+	if ecr.Status.LastPublishedAt == nil {
+		return ecr.GetCreationTimestamp().Time
+	}
+	return ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionPublishedCondition).LastTransitionTime.Time
+}
+
 func filterByAPIVersion(obj runtime.Object, version envoy.APIVersion) bool {
 	switch o := obj.(type) {
 	case *marin3rv1alpha1.EnvoyConfigRevision:
@@ -189,10 +360,94 @@ func filterByAPIVersionPredicate(version envoy.APIVersion,
 	}
 }
 
-// SetupWithManager adds the controller to the manager
+// clientRegistryEvents returns a channel that receives an event for each node whose
+// clients change in the registry. The events hold a placeholder EnvoyConfigRevision
+// with the nodeID and envoy API of the node.
+func (r *EnvoyConfigRevisionReconciler) clientRegistryEvents(mgr ctrl.Manager) (<-chan event.GenericEvent, error) {
+	ch := make(chan event.GenericEvent)
+	changed := r.ClientRegistry.Changed(r.APIVersion)
+
+	err := mgr.Add(nonLeaderElectedRunnable{manager.RunnableFunc(func(ctx context.Context) error {
+		for {
+			select {
+			case <-changed:
+				for _, node := range r.ClientRegistry.PopChanged(r.APIVersion) {
+					select {
+					case ch <- event.GenericEvent{Object: &marin3rv1alpha1.EnvoyConfigRevision{
+						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{NodeID: node.NodeID, EnvoyAPI: pointer.StringPtr(string(node.API))},
+					}}:
+					case <-ctx.Done():
+						return nil
+					}
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})})
+
+	return ch, err
+}
+
+// publishedRevisionsForNode maps the placeholder EnvoyConfigRevisions received from the client
+// registry to the EnvoyConfigRevisions of the same nodeID published to all the clients or to the canaries
+func (r *EnvoyConfigRevisionReconciler) publishedRevisionsForNode(o client.Object) []reconcile.Request {
+	ecr, ok := o.(*marin3rv1alpha1.EnvoyConfigRevision)
+	if !ok {
+		return []reconcile.Request{}
+	}
+
+	// The clients of the canaries are registered under the canary key of the nodeID
+	nodeID := xdss.NodeIDForKey(ecr.Spec.NodeID)
+	list := &marin3rv1alpha1.EnvoyConfigRevisionList{}
+	if err := r.Client.List(context.TODO(), list, client.MatchingLabels{filters.NodeIDTag: nodeID}); err != nil {
+		r.Log.Error(err, "unable to list EnvoyConfigRevisions", "NodeID", nodeID)
+		return []reconcile.Request{}
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range list.Items {
+		if _, ok := envoyconfigrevision.SnapshotKey(&item); ok && item.GetEnvoyAPIVersion() == r.APIVersion {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.GetName(), Namespace: item.GetNamespace()}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager adds the controller to the manager. The controller runs in all
+// the discovery service replicas, regardless of leader election, as each replica needs
+// to load the published revisions into its own xDS cache.
 func (r *EnvoyConfigRevisionReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&marin3rv1alpha1.EnvoyConfigRevision{}).
-		WithEventFilter(filterByAPIVersionPredicate(r.APIVersion, filterByAPIVersion)).
-		Complete(r)
+	c, err := controller.NewUnmanaged(fmt.Sprintf("envoyconfigrevision_%s", r.APIVersion), mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	apiVersionPredicate := filterByAPIVersionPredicate(r.APIVersion, filterByAPIVersion)
+	if err := c.Watch(&source.Kind{Type: &marin3rv1alpha1.EnvoyConfigRevision{}}, &handler.EnqueueRequestForObject{}, apiVersionPredicate); err != nil {
+		return err
+	}
+
+	if r.ClientRegistry != nil {
+		ch, err := r.clientRegistryEvents(mgr)
+		if err != nil {
+			return err
+		}
+		if err := c.Watch(&source.Channel{Source: ch}, handler.EnqueueRequestsFromMapFunc(r.publishedRevisionsForNode), apiVersionPredicate); err != nil {
+			return err
+		}
+	}
+
+	return mgr.Add(nonLeaderElectedRunnable{c})
+}
+
+// nonLeaderElectedRunnable wraps a manager.Runnable so it
+// runs regardless of leader election
+type nonLeaderElectedRunnable struct {
+	manager.Runnable
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (nonLeaderElectedRunnable) NeedLeaderElection() bool {
+	return false
 }
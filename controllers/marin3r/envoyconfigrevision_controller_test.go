@@ -5,9 +5,12 @@ import (
 	"testing"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	xdss_v2 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v2"
 	"github.com/3scale/marin3r/pkg/envoy"
 	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
+	"github.com/operator-framework/operator-lib/status"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -43,6 +46,74 @@ func TestEnvoyConfigRevisionReconciler_taintSelf(t *testing.T) {
 	})
 }
 
+func TestEnvoyConfigRevisionReconciler_reconcileCanary(t *testing.T) {
+	newReconciler := func() *EnvoyConfigRevisionReconciler {
+		return &EnvoyConfigRevisionReconciler{
+			Client:   fake.NewFakeClient(),
+			Scheme:   s,
+			XdsCache: xdss_v2.NewCache(cache_v2.NewSnapshotCache(true, cache_v2.IDHash{}, nil)),
+			Log:      ctrl.Log.WithName("test"),
+			Canaries: xdss.NewCanaries(),
+		}
+	}
+	newRevision := func(conditions ...status.ConditionType) *marin3rv1alpha1.EnvoyConfigRevision {
+		ecr := &marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: "ecr", Namespace: "default"},
+			Spec:       marin3rv1alpha1.EnvoyConfigRevisionSpec{NodeID: "node1", Version: "bbbb"},
+		}
+		for _, c := range conditions {
+			ecr.Status.Conditions.SetCondition(status.Condition{Type: c, Status: corev1.ConditionTrue})
+		}
+		if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionCanaryCondition) {
+			ecr.Status.Canary = &marin3rv1alpha1.CanaryRollout{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}},
+			}
+		}
+		return ecr
+	}
+
+	t.Run("Starts the rollout of a canary revision", func(t *testing.T) {
+		r := newReconciler()
+		if err := r.reconcileCanary(newRevision(marin3rv1alpha1.RevisionCanaryCondition), r.Log); err != nil {
+			t.Fatalf("EnvoyConfigRevisionReconciler.reconcileCanary() error = %v", err)
+		}
+		selection, ok := r.Canaries.Get("node1")
+		if !ok || selection.Version != "bbbb" || selection.Percentage != 100 || selection.Selector == nil {
+			t.Errorf("EnvoyConfigRevisionReconciler.reconcileCanary() selection = %v, %v", selection, ok)
+		}
+	})
+
+	t.Run("Stops the rollout of a promoted revision and keeps the canary snapshot", func(t *testing.T) {
+		r := newReconciler()
+		r.reconcileCanary(newRevision(marin3rv1alpha1.RevisionCanaryCondition), r.Log)
+		r.XdsCache.SetSnapshot(xdss.CanaryKey("node1"), r.XdsCache.NewSnapshot("bbbb"))
+		if err := r.reconcileCanary(newRevision(marin3rv1alpha1.RevisionPublishedCondition), r.Log); err != nil {
+			t.Fatalf("EnvoyConfigRevisionReconciler.reconcileCanary() error = %v", err)
+		}
+		if _, ok := r.Canaries.Get("node1"); ok {
+			t.Errorf("EnvoyConfigRevisionReconciler.reconcileCanary() rollout not stopped")
+		}
+		if _, err := r.XdsCache.GetSnapshot(xdss.CanaryKey("node1")); err != nil {
+			t.Errorf("EnvoyConfigRevisionReconciler.reconcileCanary() cleared the canary snapshot")
+		}
+	})
+
+	t.Run("Stops the rollout of a tainted revision and clears the canary snapshot", func(t *testing.T) {
+		r := newReconciler()
+		r.reconcileCanary(newRevision(marin3rv1alpha1.RevisionCanaryCondition), r.Log)
+		r.XdsCache.SetSnapshot(xdss.CanaryKey("node1"), r.XdsCache.NewSnapshot("bbbb"))
+		if err := r.reconcileCanary(newRevision(marin3rv1alpha1.RevisionTaintedCondition), r.Log); err != nil {
+			t.Fatalf("EnvoyConfigRevisionReconciler.reconcileCanary() error = %v", err)
+		}
+		if _, ok := r.Canaries.Get("node1"); ok {
+			t.Errorf("EnvoyConfigRevisionReconciler.reconcileCanary() rollout not stopped")
+		}
+		if _, err := r.XdsCache.GetSnapshot(xdss.CanaryKey("node1")); err == nil {
+			t.Errorf("EnvoyConfigRevisionReconciler.reconcileCanary() did not clear the canary snapshot")
+		}
+	})
+}
+
 func Test_filterByAPIVersion(t *testing.T) {
 	type args struct {
 		obj     runtime.Object
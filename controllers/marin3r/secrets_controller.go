@@ -60,57 +60,82 @@ func (r *SecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	}
 
 	log := r.Log.WithValues("name", req.Name, "namespace", req.Namespace)
-	log.Info("Reconciling from 'kubernetes.io/tls' Secret")
+	log.Info("Reconciling from Secret")
+
+	return reconcile.Result{}, markRevisionsOutOfSync(ctx, r.Client, log, "SecretChanged",
+		"A secret relevant to this envoyconfigrevision changed",
+		matchSecrets(func(_ string, esr marin3rv1alpha1.EnvoySecretResource) bool {
+			return esr.ConfigMapRef == nil && esr.Ref.Name == req.Name && esr.Ref.Namespace == req.Namespace
+		}),
+	)
+}
+
+// matchSecrets returns a function that matches the EnvoyConfigRevisions that hold a
+// secret resource that matches the given function. The function receives the namespace
+// of the EnvoyConfigRevision and the secret resource.
+func matchSecrets(match func(string, marin3rv1alpha1.EnvoySecretResource) bool) func(*marin3rv1alpha1.EnvoyConfigRevision) bool {
+	return func(ecr *marin3rv1alpha1.EnvoyConfigRevision) bool {
+		for _, secret := range ecr.Spec.EnvoyResources.Secrets {
+			if match(ecr.GetNamespace(), secret) {
+				return true
+			}
+		}
+		return false
+	}
+}
 
-	// Get the list of EnvoyConfigRevisions published and
-	// check which of them contain refs to this secret
+// markRevisionsOutOfSync sets the ResourcesInSync condition to false in the published
+// EnvoyConfigRevisions that match the given function, so the EnvoyConfigRevision controller
+// regenerates the resources that are calculated at runtime from other Kubernetes objects.
+func markRevisionsOutOfSync(ctx context.Context, c client.Client, log logr.Logger, reason, message string,
+	match func(*marin3rv1alpha1.EnvoyConfigRevision) bool) error {
+
+	// Get the list of EnvoyConfigRevisions published, either to all the
+	// clients or to the canaries, and check which of them contain refs to this object
 	list := &marin3rv1alpha1.EnvoyConfigRevisionList{}
-	if err := r.Client.List(ctx, list); err != nil {
-		return reconcile.Result{}, err
+	if err := c.List(ctx, list); err != nil {
+		return err
 	}
 
 	for _, ecr := range list.Items {
 
-		if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) {
-
-			for _, secret := range ecr.Spec.EnvoyResources.Secrets {
-				if secret.Ref.Name == req.Name && secret.Ref.Namespace == req.Namespace {
-					log.Info("Triggered EnvoyConfigRevision reconcile",
-						"EnvoyConfigRevision_Name", ecr.ObjectMeta.Name, "EnvoyConfigRevision_Namespace", ecr.GetNamespace())
-					if err != nil {
-						return reconcile.Result{}, err
-					}
-
-					if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.ResourcesInSyncCondition) {
-						patch := client.MergeFrom(ecr.DeepCopy())
-						ecr.Status.Conditions.SetCondition(status.Condition{
-							Type:    marin3rv1alpha1.ResourcesInSyncCondition,
-							Reason:  "SecretChanged",
-							Message: "A secret relevant to this envoyconfigrevision changed",
-							Status:  corev1.ConditionFalse,
-						})
-						if err := r.Client.Status().Patch(ctx, &ecr, patch); err != nil {
-							return reconcile.Result{}, err
-						}
-						log.V(1).Info("Condition should have been added ...")
-					}
-				}
+		if !ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) && !ecr.Status.IsCanary() {
+			continue
+		}
+
+		if !match(&ecr) {
+			continue
+		}
+		log.Info("Triggered EnvoyConfigRevision reconcile",
+			"EnvoyConfigRevision_Name", ecr.ObjectMeta.Name, "EnvoyConfigRevision_Namespace", ecr.GetNamespace())
+
+		if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.ResourcesInSyncCondition) {
+			patch := client.MergeFrom(ecr.DeepCopy())
+			ecr.Status.Conditions.SetCondition(status.Condition{
+				Type:    marin3rv1alpha1.ResourcesInSyncCondition,
+				Reason:  status.ConditionReason(reason),
+				Message: message,
+				Status:  corev1.ConditionFalse,
+			})
+			if err := c.Status().Patch(ctx, &ecr, patch); err != nil {
+				return err
 			}
+			log.V(1).Info("Condition should have been added ...")
 		}
 	}
 
-	return reconcile.Result{}, nil
+	return nil
 }
 
-func filterTLSTypeCertificatesPredicate() predicate.Predicate {
+// filterEnvoySecretsPredicate filters the Secrets that can be used as
+// envoy secrets: "kubernetes.io/tls" ones and "Opaque" ones, used for generic
+// secrets and session ticket keys
+func filterEnvoySecretsPredicate() predicate.Predicate {
 	return predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
 			switch o := e.Object.(type) {
 			case *corev1.Secret:
-				if o.Type == "kubernetes.io/tls" {
-					return true
-				}
-				return false
+				return isEnvoySecretType(o)
 
 			default:
 				return true
@@ -119,10 +144,7 @@ func filterTLSTypeCertificatesPredicate() predicate.Predicate {
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			switch o := e.ObjectNew.(type) {
 			case *corev1.Secret:
-				if o.Type == "kubernetes.io/tls" {
-					return true
-				}
-				return false
+				return isEnvoySecretType(o)
 			default:
 				return true
 			}
@@ -131,8 +153,12 @@ func filterTLSTypeCertificatesPredicate() predicate.Predicate {
 	}
 }
 
+func isEnvoySecretType(s *corev1.Secret) bool {
+	return s.Type == corev1.SecretTypeTLS || s.Type == corev1.SecretTypeOpaque
+}
+
 func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).For(&corev1.Secret{}).
-		WithEventFilter(filterTLSTypeCertificatesPredicate()).
+		WithEventFilter(filterEnvoySecretsPredicate()).
 		Complete(r)
 }
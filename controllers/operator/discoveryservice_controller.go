@@ -23,7 +23,6 @@ import (
 	operatorv1alpha1 "github.com/3scale/marin3r/apis/operator/v1alpha1"
 	"github.com/3scale/marin3r/pkg/reconcilers/lockedresources"
 	"github.com/3scale/marin3r/pkg/reconcilers/operator/discoveryservice/generators"
-	"github.com/3scale/marin3r/pkg/util"
 	"github.com/go-logr/logr"
 	operatorutil "github.com/redhat-cop/operator-utils/pkg/util"
 	"github.com/redhat-cop/operator-utils/pkg/util/lockedresourcecontroller/lockedpatch"
@@ -59,6 +58,10 @@ type DiscoveryServiceReconciler struct {
 // +kubebuilder:rbac:groups=marin3r.3scale.net,namespace=placeholder,resources=*,verbs=*
 // +kubebuilder:rbac:groups="core",namespace=placeholder,resources=services,verbs=get;list;watch;create;update;patch
 // +kubebuilder:rbac:groups="core",namespace=placeholder,resources=serviceaccounts,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="core",namespace=placeholder,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="core",namespace=placeholder,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="core",namespace=placeholder,resources=pods,verbs=get
+// +kubebuilder:rbac:groups="discovery.k8s.io",namespace=placeholder,resources=endpointslices,verbs=get;list;watch
 // +kubebuilder:rbac:groups="apps",namespace=placeholder,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="rbac.authorization.k8s.io",namespace=placeholder,resources=roles,verbs=get;list;watch;create;patch
 // +kubebuilder:rbac:groups="rbac.authorization.k8s.io",namespace=placeholder,resources=rolebindings,verbs=get;list;watch;create;patch
@@ -104,6 +107,11 @@ func (r *DiscoveryServiceReconciler) Reconcile(ctx context.Context, request ctrl
 		return ctrl.Result{}, nil
 	}
 
+	if err := ds.Validate(); err != nil {
+		log.Error(err, "invalid DiscoveryService spec")
+		return r.ManageError(ctx, ds, err)
+	}
+
 	generate := generators.GeneratorOptions{
 		InstanceName:                      ds.GetName(),
 		Namespace:                         ds.GetNamespace(),
@@ -115,18 +123,21 @@ func (r *DiscoveryServiceReconciler) Reconcile(ctx context.Context, request ctrl
 		ServerCertificateDuration:         func() (d time.Duration) { d, _ = time.ParseDuration("2160h"); return }(), // 90 days,
 		ClientCertificateDuration:         func() (d time.Duration) { d, _ = time.ParseDuration("48h"); return }(),
 		XdsServerPort:                     int32(ds.GetXdsServerPort()),
+		RestServerPort:                    int32(ds.GetRestServerPort()),
 		MetricsServerPort:                 int32(ds.GetMetricsPort()),
+		HealthProbePort:                   int32(operatorv1alpha1.DefaultHealthProbePort),
 		ServiceType:                       operatorv1alpha1.ClusterIPType,
 		DeploymentImage:                   ds.GetImage(),
 		DeploymentResources:               ds.Resources(),
+		Replicas:                          ds.GetReplicas(),
+		NodeIDAuthorization:               *ds.GetNodeIDAuthorization(),
+		NodeHash:                          *ds.GetNodeHash(),
+		GrpcServerOptions:                 ds.Spec.GrpcServerOptions,
+		SnapshotPublishing:                ds.Spec.SnapshotPublishing,
+		DebugServer:                       ds.Spec.DebugServer,
 		Debug:                             ds.Debug(),
 	}
 
-	hash, err := r.calculateServerCertificateHash(ctx, util.ObjectKey(generate.ServerCertificate()()))
-	if err != nil {
-		return r.ManageError(ctx, ds, err)
-	}
-
 	resources, err := r.NewLockedResources(
 		[]lockedresources.LockedResource{
 			{GeneratorFn: generate.RootCertificationAuthority(), ExcludePaths: defaultExcludedPaths},
@@ -135,7 +146,7 @@ func (r *DiscoveryServiceReconciler) Reconcile(ctx context.Context, request ctrl
 			{GeneratorFn: generate.Role(), ExcludePaths: defaultExcludedPaths},
 			{GeneratorFn: generate.RoleBinding(), ExcludePaths: defaultExcludedPaths},
 			{GeneratorFn: generate.Service(), ExcludePaths: append(defaultExcludedPaths, ".spec.clusterIP")},
-			{GeneratorFn: generate.Deployment(hash), ExcludePaths: defaultExcludedPaths},
+			{GeneratorFn: generate.Deployment(), ExcludePaths: defaultExcludedPaths},
 			{GeneratorFn: generate.EnvoyBootstrap(), ExcludePaths: defaultExcludedPaths},
 		},
 		ds,
@@ -150,22 +161,6 @@ func (r *DiscoveryServiceReconciler) Reconcile(ctx context.Context, request ctrl
 	return r.ManageSuccess(ctx, ds)
 }
 
-func (r *DiscoveryServiceReconciler) calculateServerCertificateHash(ctx context.Context, key types.NamespacedName) (string, error) {
-	// Fetch the server certificate to calculate the hash and
-	// populate the deployment's label.
-	// This will trigger rollouts on server certificate changes.
-	serverDSC := &operatorv1alpha1.DiscoveryServiceCertificate{}
-	err := r.GetClient().Get(ctx, key, serverDSC)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// The server certificate hasn't been created yet
-			return "", nil
-		}
-		return "", err
-	}
-	return serverDSC.Status.GetCertificateHash(), nil
-}
-
 // SetupWithManager adds the controller to the manager
 func (r *DiscoveryServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
@@ -142,17 +142,13 @@ var _ = Describe("DiscoveryService controller", func() {
 			By("waiting for the discovery service Deployment to be created")
 			{
 				dep := &appsv1.Deployment{}
-				key := types.NamespacedName{Name: "marin3r-instance", Namespace: namespace}
-				Eventually(func() bool {
-					if err := k8sClient.Get(context.Background(), key, dep); err != nil {
-						return false
-					}
-					hash, ok := dep.Spec.Template.Labels[operatorv1alpha1.DiscoveryServiceCertificateHashLabelKey]
-					if !ok || hash == "" {
-						return false
-					}
-					return true
-				}, 30*time.Second, 5*time.Second).Should(BeTrue())
+				Eventually(func() error {
+					return k8sClient.Get(
+						context.Background(),
+						types.NamespacedName{Name: "marin3r-instance", Namespace: namespace},
+						dep,
+					)
+				}, 30*time.Second, 5*time.Second).ShouldNot(HaveOccurred())
 			}
 
 			By("waiting for the discovery service Service to be created")
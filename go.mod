@@ -7,6 +7,7 @@ require (
 	github.com/cncf/udpa/go v0.0.0-20201001150855-7e6fe0510fb5 // indirect
 	github.com/davecgh/go-spew v1.1.1
 	github.com/envoyproxy/go-control-plane v0.9.7
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/ghodss/yaml v1.0.0
 	github.com/go-logr/logr v0.3.0
 	github.com/golang/protobuf v1.4.3
@@ -15,11 +16,13 @@ require (
 	github.com/onsi/gomega v1.10.2
 	github.com/operator-framework/operator-lib v0.1.0
 	github.com/phayes/freeport v0.0.0-20180830031419-95f893ade6f2
+	github.com/prometheus/client_golang v1.7.1
 	github.com/redhat-cop/operator-utils v1.1.1
 	github.com/spf13/cobra v1.1.1
 	golang.org/x/tools v0.0.0-20201121010211-780cb80bd7fb // indirect
 	google.golang.org/genproto v0.0.0-20200701001935-0939c5918c31
 	google.golang.org/grpc v1.30.0
+	gopkg.in/yaml.v3 v3.0.1
 	k8s.io/api v0.20.0
 	k8s.io/apimachinery v0.20.0
 	k8s.io/client-go v0.20.0
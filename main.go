@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"runtime"
@@ -24,11 +25,13 @@ import (
 
 	"github.com/spf13/cobra"
 	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -37,9 +40,13 @@ import (
 	operatorv1alpha1 "github.com/3scale/marin3r/apis/operator/v1alpha1"
 	marin3rcontroller "github.com/3scale/marin3r/controllers/marin3r"
 	operatorcontroller "github.com/3scale/marin3r/controllers/operator"
+	"github.com/3scale/marin3r/pkg/cli"
 	discoveryservice "github.com/3scale/marin3r/pkg/discoveryservice"
+	"github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	"github.com/3scale/marin3r/pkg/reconcilers/lockedresources"
 	"github.com/3scale/marin3r/pkg/version"
+	"github.com/3scale/marin3r/pkg/webhooks/envoyconfigv1alpha1validator"
 	"github.com/3scale/marin3r/pkg/webhooks/podv1mutator"
 	// +kubebuilder:scaffold:imports
 )
@@ -57,14 +64,35 @@ const (
 var (
 	debug                        bool
 	metricsAddr                  string
+	healthProbeAddr              string
 	enableLeaderElection         bool
 	xdssPort                     int
+	xdssRestPort                 int
 	xdssTLSServerCertificatePath string
 	xdssTLSCACertificatePath     string
 	webhookPort                  int
 	webhookTLSCertDir            string
 	webhookTLSKeyName            string
 	webhookTLSCertName           string
+	nodeIDAuthorization          string
+	nodeIDMappings               []string
+	nodeHash                     string
+	nodeHashMetadataField        string
+	nodeHashRegex                string
+	nodeHashPrefixes             []string
+	grpcServerOptions            discoveryservice.GrpcServerOptions
+	publishOptions               discoveryservice.PublishOptions
+	debugServerPort              int
+	debugAllowedIdentities       []string
+	rollbackNamespace            string
+	rollbackToVersion            string
+	rollbackUnpin                bool
+	untaintNamespace             string
+	untaintVersion               string
+	validateStrict               bool
+	diffNamespace                string
+	diffFrom                     string
+	diffTo                       string
 )
 
 var (
@@ -96,9 +124,41 @@ var (
 	// Webhook subcommand
 	webhookCmd = &cobra.Command{
 		Use:   "webhook",
-		Short: "Run the Pod mutating webhook",
+		Short: "Run the Pod mutating and EnvoyConfig validating webhooks",
 		Run:   runWebhook,
 	}
+
+	// Rollback subcommand
+	rollbackCmd = &cobra.Command{
+		Use:   "rollback ENVOYCONFIG",
+		Short: "Roll back an EnvoyConfig to a previous revision by pinning it",
+		Args:  cobra.ExactArgs(1),
+		Run:   runRollback,
+	}
+
+	// Untaint subcommand
+	untaintCmd = &cobra.Command{
+		Use:   "untaint ENVOYCONFIG",
+		Short: "Remove the taint of a revision of an EnvoyConfig so it can be published again, or retry its aborted canary rollout",
+		Args:  cobra.ExactArgs(1),
+		Run:   runUntaint,
+	}
+
+	// Validate subcommand
+	validateCmd = &cobra.Command{
+		Use:   "validate PATH...",
+		Short: "Validate the EnvoyConfig objects in yaml files or directories, without connecting to a cluster",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   runValidate,
+	}
+
+	// Diff subcommand
+	diffCmd = &cobra.Command{
+		Use:   "diff ENVOYCONFIG",
+		Short: "Show the changes in the envoy resources between two revisions of an EnvoyConfig, or between a revision and the spec",
+		Args:  cobra.ExactArgs(1),
+		Run:   runDiff,
+	}
 )
 
 var (
@@ -116,6 +176,10 @@ func init() {
 	rootCmd.AddCommand(operatorCmd)
 	rootCmd.AddCommand(discoveryServiceCmd)
 	rootCmd.AddCommand(webhookCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(untaintCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(diffCmd)
 
 	// Global flags
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enable debug logs")
@@ -126,11 +190,53 @@ func init() {
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
 
 	// Discovery service flags
+	discoveryServiceCmd.Flags().BoolVar(&enableLeaderElection, "enable-leader-election", false,
+		"Enable leader election between discovery service replicas. Enabling this will ensure there is only one replica writing to the Kubernetes API.")
 	discoveryServiceCmd.Flags().IntVar(&xdssPort, "xdss-port", int(operatorv1alpha1.DefaultXdsServerPort), "The port where the xDS will listen.")
+	discoveryServiceCmd.Flags().IntVar(&xdssRestPort, "xdss-rest-port", 0,
+		"The port where the REST-JSON xDS server will listen. It is disabled when 0.")
 	discoveryServiceCmd.Flags().StringVar(&xdssTLSServerCertificatePath, "server-certificate-path", "/etc/marin3r/tls/server",
 		fmt.Sprintf("The path where the server certificate '%s' and key '%s' files are located", certificateFile, certificateKeyFile))
 	discoveryServiceCmd.Flags().StringVar(&xdssTLSCACertificatePath, "ca-certificate-path", "/etc/marin3r/tls/ca",
 		fmt.Sprintf("The path where the CA certificate '%s' and key '%s' files are located", certificateFile, certificateKeyFile))
+	discoveryServiceCmd.Flags().StringVar(&healthProbeAddr, "health-probe-addr", fmt.Sprintf(":%v", operatorv1alpha1.DefaultHealthProbePort),
+		"The address the /healthz and /readyz endpoints bind to.")
+	discoveryServiceCmd.Flags().StringVar(&nodeIDAuthorization, "node-id-authorization", string(authz.PolicyNone),
+		"The policy used to check that the client certificate of an envoy is allowed to request the config of a node ID. One of None, Exact, Prefix or Mapping.")
+	discoveryServiceCmd.Flags().StringArrayVar(&nodeIDMappings, "node-id-mapping", []string{},
+		"The node IDs a client certificate identity is allowed to request when using the Mapping policy, in the format '<identity>=<nodeID>[,<nodeID>...]'. Can be repeated.")
+	discoveryServiceCmd.Flags().StringVar(&nodeHash, "node-hash", string(xdss.NodeHashID),
+		"The property of the envoy node used to select the EnvoyConfig it gets its config from. One of ID, Cluster, Metadata, Regex or Prefix.")
+	discoveryServiceCmd.Flags().StringVar(&nodeHashMetadataField, "node-hash-metadata-field", "",
+		"The node metadata field used to select the EnvoyConfig when --node-hash=Metadata. Nested fields are separated by dots.")
+	discoveryServiceCmd.Flags().StringVar(&nodeHashRegex, "node-hash-regex", "",
+		"The regular expression matched against the node ID when --node-hash=Regex. The first capturing group, or the whole match, selects the EnvoyConfig.")
+	discoveryServiceCmd.Flags().StringArrayVar(&nodeHashPrefixes, "node-hash-prefix", []string{},
+		"A node ID prefix that selects the EnvoyConfig when --node-hash=Prefix. Can be repeated.")
+	discoveryServiceCmd.Flags().Uint32Var(&grpcServerOptions.MaxConcurrentStreams, "grpc-max-concurrent-streams", operatorv1alpha1.DefaultGrpcMaxConcurrentStreams,
+		"The maximum number of concurrent streams in each client connection to the xDS server.")
+	discoveryServiceCmd.Flags().DurationVar(&grpcServerOptions.KeepaliveMinTime, "grpc-keepalive-min-time", operatorv1alpha1.DefaultGrpcKeepaliveMinTime,
+		"The minimum time a client should wait between keepalive pings. Clients that ping more often get their connection closed.")
+	discoveryServiceCmd.Flags().BoolVar(&grpcServerOptions.KeepalivePermitWithoutStream, "grpc-keepalive-permit-without-stream", false,
+		"Allow clients to send keepalive pings when there are no active streams in the connection.")
+	discoveryServiceCmd.Flags().DurationVar(&grpcServerOptions.KeepaliveTime, "grpc-keepalive-time", operatorv1alpha1.DefaultGrpcKeepaliveTime,
+		"The time after which the xDS server pings a client if there is no activity in the connection.")
+	discoveryServiceCmd.Flags().DurationVar(&grpcServerOptions.KeepaliveTimeout, "grpc-keepalive-timeout", operatorv1alpha1.DefaultGrpcKeepaliveTimeout,
+		"The time the xDS server waits for the response to a keepalive ping before closing the connection.")
+	discoveryServiceCmd.Flags().DurationVar(&grpcServerOptions.MaxConnectionAge, "grpc-max-connection-age", operatorv1alpha1.DefaultGrpcMaxConnectionAge,
+		"The maximum age of a client connection to the xDS server.")
+	discoveryServiceCmd.Flags().DurationVar(&grpcServerOptions.MaxConnectionAgeGrace, "grpc-max-connection-age-grace", operatorv1alpha1.DefaultGrpcMaxConnectionAgeGrace,
+		"The time the connections that reach the maximum age are given to complete the in flight requests before being closed.")
+	discoveryServiceCmd.Flags().DurationVar(&grpcServerOptions.GracefulStopTimeout, "grpc-graceful-stop-timeout", operatorv1alpha1.DefaultGrpcGracefulStopTimeout,
+		"The time the xDS server waits for the streams to finish when stopping before closing them.")
+	discoveryServiceCmd.Flags().DurationVar(&publishOptions.DebounceWindow, "snapshot-debounce-window", 0,
+		"The time a config change waits for further changes of the same node ID before being published to the envoy clients. Changes are published straight away when 0.")
+	discoveryServiceCmd.Flags().DurationVar(&publishOptions.MaxDelay, "snapshot-max-delay", operatorv1alpha1.DefaultSnapshotMaxDelay,
+		"The maximum time the publication of a config change can be delayed by further changes of the same node ID.")
+	discoveryServiceCmd.Flags().IntVar(&debugServerPort, "debug-server-port", 0,
+		"The port where the debug server that dumps the xDS caches listens. The debug server is disabled when 0.")
+	discoveryServiceCmd.Flags().StringArrayVar(&debugAllowedIdentities, "debug-server-allowed-identity", []string{},
+		"A client certificate identity (common name, DNS SAN or URI SAN) allowed to use the debug server. Can be repeated.")
 	discoveryServiceCmd.Flags().IntVar(&webhookPort, "webhook-port", int(operatorv1alpha1.DefaultWebhookPort), "The port where the pod mutator webhook server will listen.")
 
 	// Webhook flags
@@ -139,6 +245,26 @@ func init() {
 	webhookCmd.Flags().StringVar(&webhookTLSCertName, "tls-cert-name", "apiserver.crt", "The file name of the certificate for the webhook.")
 	webhookCmd.Flags().StringVar(&webhookTLSKeyName, "tls-key-name", "apiserver.key", "The file name of the private key for the webhook.")
 
+	// Rollback flags
+	rollbackCmd.Flags().StringVarP(&rollbackNamespace, "namespace", "n", "default", "The namespace of the EnvoyConfig.")
+	rollbackCmd.Flags().StringVar(&rollbackToVersion, "to-version", "",
+		"The version of the revision to pin. Defaults to the last untainted revision published before the current one.")
+	rollbackCmd.Flags().BoolVar(&rollbackUnpin, "unpin", false,
+		"Remove the revision pin, so the revision of the resources in the spec is published again.")
+
+	// Untaint flags
+	untaintCmd.Flags().StringVarP(&untaintNamespace, "namespace", "n", "default", "The namespace of the EnvoyConfig.")
+	untaintCmd.Flags().StringVar(&untaintVersion, "version", "", "The version of the revision to untaint.")
+	untaintCmd.MarkFlagRequired("version")
+
+	// Validate flags
+	validateCmd.Flags().BoolVar(&validateStrict, "strict", false, "Exit with a non-zero code if warnings are found too.")
+
+	// Diff flags
+	diffCmd.Flags().StringVarP(&diffNamespace, "namespace", "n", "default", "The namespace of the EnvoyConfig.")
+	diffCmd.Flags().StringVar(&diffFrom, "from", "", "The version of the revision to diff from. Defaults to the published revision.")
+	diffCmd.Flags().StringVar(&diffTo, "to", "", "The version of the revision to diff to. Defaults to the resources in the EnvoyConfig spec.")
+
 }
 
 func main() {
@@ -232,16 +358,59 @@ func runDiscoveryService(cmd *cobra.Command, args []string) {
 	ctrl.SetLogger(zap.New(zap.UseDevMode(debug)))
 	printVersion()
 
+	policy, err := authz.ParsePolicy(nodeIDAuthorization)
+	if err != nil {
+		setupLog.Error(err, "invalid --node-id-authorization flag")
+		os.Exit(1)
+	}
+	mappings, err := authz.ParseMappings(nodeIDMappings)
+	if err != nil {
+		setupLog.Error(err, "invalid --node-id-mapping flag")
+		os.Exit(1)
+	}
+
+	hash, err := xdss.NewNodeHash(xdss.NodeHashKind(nodeHash), nodeHashMetadataField, nodeHashRegex, nodeHashPrefixes)
+	if err != nil {
+		setupLog.Error(err, "invalid --node-hash flags")
+		os.Exit(1)
+	}
+
+	if err := grpcServerOptions.Validate(); err != nil {
+		setupLog.Error(err, "invalid --grpc-* flags")
+		os.Exit(1)
+	}
+
+	if err := publishOptions.Validate(); err != nil {
+		setupLog.Error(err, "invalid --snapshot-* flags")
+		os.Exit(1)
+	}
+
+	if debugServerPort != 0 && len(debugAllowedIdentities) == 0 {
+		setupLog.Error(fmt.Errorf("at least one --debug-server-allowed-identity is required"), "invalid --debug-server-* flags")
+		os.Exit(1)
+	}
+
 	cfg := ctrl.GetConfigOrDie()
 	ctx := signals.SetupSignalHandler()
 
 	mgr := discoveryservice.Manager{
-		Namespace:             os.Getenv("WATCH_NAMESPACE"),
-		XdsServerPort:         xdssPort,
-		MetricsAddr:           metricsAddr,
-		ServerCertificatePath: xdssTLSServerCertificatePath,
-		CACertificatePath:     xdssTLSCACertificatePath,
-		Cfg:                   cfg,
+		Namespace:                 os.Getenv("WATCH_NAMESPACE"),
+		XdsServerPort:             xdssPort,
+		RestServerPort:            xdssRestPort,
+		MetricsAddr:               metricsAddr,
+		HealthProbeAddr:           healthProbeAddr,
+		GrpcServerOptions:         grpcServerOptions,
+		PublishOptions:            publishOptions,
+		DebugServerPort:           debugServerPort,
+		DebugAllowedIdentities:    debugAllowedIdentities,
+		ServerCertificatePath:     xdssTLSServerCertificatePath,
+		CACertificatePath:         xdssTLSCACertificatePath,
+		Cfg:                       cfg,
+		LeaderElection:            enableLeaderElection,
+		ReplicaName:               os.Getenv("POD_NAME"),
+		NodeIDAuthorizationPolicy: policy,
+		NodeIDMappings:            mappings,
+		NodeHash:                  hash,
 	}
 
 	mgr.Start(ctx)
@@ -274,6 +443,9 @@ func runWebhook(cmd *cobra.Command, args []string) {
 	hookServer.Port = webhookPort
 	ctrl.Log.Info("registering the pod mutating webhook with webhook server")
 	hookServer.Register(podv1mutator.MutatePath, &webhook.Admission{Handler: &podv1mutator.PodMutator{Client: mgr.GetClient()}})
+	ctrl.Log.Info("registering the envoyconfig validating webhook with webhook server")
+	hookServer.Register(envoyconfigv1alpha1validator.ValidatePath,
+		&webhook.Admission{Handler: &envoyconfigv1alpha1validator.EnvoyConfigValidator{}})
 
 	setupLog.Info("starting the webhook")
 	if err := mgr.Start(stopCh); err != nil {
@@ -282,6 +454,92 @@ func runWebhook(cmd *cobra.Command, args []string) {
 	}
 }
 
+func runRollback(cmd *cobra.Command, args []string) {
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(debug)))
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create kubernetes client")
+		os.Exit(1)
+	}
+	key := types.NamespacedName{Name: args[0], Namespace: rollbackNamespace}
+
+	if rollbackUnpin {
+		if err := cli.Unpin(context.Background(), c, key); err != nil {
+			setupLog.Error(err, "unable to unpin the EnvoyConfig revision")
+			os.Exit(1)
+		}
+		fmt.Printf("envoyconfig %s unpinned\n", key)
+		return
+	}
+
+	version, err := cli.Rollback(context.Background(), c, key, rollbackToVersion)
+	if err != nil {
+		setupLog.Error(err, "unable to roll back the EnvoyConfig")
+		os.Exit(1)
+	}
+	fmt.Printf("envoyconfig %s pinned to revision %s\n", key, version)
+}
+
+func runUntaint(cmd *cobra.Command, args []string) {
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(debug)))
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create kubernetes client")
+		os.Exit(1)
+	}
+	key := types.NamespacedName{Name: args[0], Namespace: untaintNamespace}
+
+	name, err := cli.Untaint(context.Background(), c, key, untaintVersion)
+	if err != nil {
+		setupLog.Error(err, "unable to untaint the EnvoyConfig revision")
+		os.Exit(1)
+	}
+	fmt.Printf("envoyconfigrevision %s/%s marked to be untainted\n", key.Namespace, name)
+}
+
+func runValidate(cmd *cobra.Command, args []string) {
+
+	diagnostics, err := cli.ValidateFiles(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to validate the files: %s\n", err)
+		os.Exit(1)
+	}
+
+	for _, d := range diagnostics {
+		fmt.Println(d)
+	}
+	if cli.HasErrors(diagnostics) || (validateStrict && len(diagnostics) > 0) {
+		os.Exit(1)
+	}
+}
+
+func runDiff(cmd *cobra.Command, args []string) {
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(debug)))
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create kubernetes client")
+		os.Exit(1)
+	}
+	key := types.NamespacedName{Name: args[0], Namespace: diffNamespace}
+
+	changes, err := cli.Diff(context.Background(), c, key, diffFrom, diffTo)
+	if err != nil {
+		setupLog.Error(err, "unable to diff the EnvoyConfig revisions")
+		os.Exit(1)
+	}
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+		return
+	}
+	fmt.Print(changes)
+}
+
 // getWatchNamespace returns the Namespace the operator should be watching for changes
 func getWatchNamespace() (string, error) {
 
@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_diff "github.com/3scale/marin3r/pkg/envoy/diff"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	"github.com/3scale/marin3r/pkg/webhooks/envoyconfigv1alpha1validator"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resourcesSource is a set of serialized envoy resources, held
+// either by an EnvoyConfig or by an EnvoyConfigRevision
+type resourcesSource struct {
+	description   string
+	resources     *marin3rv1alpha1.EnvoyResources
+	serialization envoy_serializer.Serialization
+	version       envoy.APIVersion
+}
+
+// Diff returns the changes between the resources of the revision of the EnvoyConfig with version 'from'
+// and the resources of the revision with version 'to'. If 'from' is empty the published revision is used,
+// and if 'to' is empty the resources in the EnvoyConfig spec are used.
+func Diff(ctx context.Context, c client.Client, key types.NamespacedName, from, to string) (envoy_diff.Changes, error) {
+
+	ec := &marin3rv1alpha1.EnvoyConfig{}
+	if err := c.Get(ctx, key, ec); err != nil {
+		return nil, err
+	}
+
+	if from == "" {
+		if ec.Status.PublishedVersion == "" {
+			return nil, fmt.Errorf("EnvoyConfig %s has no published revision", key)
+		}
+		from = ec.Status.PublishedVersion
+	}
+	fromECR, err := getRevision(ctx, c, ec, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if to == "" {
+		return DiffSpec(fromECR, ec)
+	}
+	toECR, err := getRevision(ctx, c, ec, to)
+	if err != nil {
+		return nil, err
+	}
+	return DiffRevisions(fromECR, toECR)
+}
+
+// getRevision returns the revision of the EnvoyConfig with the given version
+func getRevision(ctx context.Context, c client.Client, ec *marin3rv1alpha1.EnvoyConfig, version string) (*marin3rv1alpha1.EnvoyConfigRevision, error) {
+	for _, ref := range ec.Status.ConfigRevisions {
+		if ref.Version == version {
+			ecr := &marin3rv1alpha1.EnvoyConfigRevision{}
+			if err := c.Get(ctx, types.NamespacedName{Name: ref.Ref.Name, Namespace: ref.Ref.Namespace}, ecr); err != nil {
+				return nil, err
+			}
+			return ecr, nil
+		}
+	}
+	return nil, fmt.Errorf("EnvoyConfig %s/%s has no revision with version '%s'", ec.GetNamespace(), ec.GetName(), version)
+}
+
+// DiffRevisions returns the changes between the resources of two EnvoyConfigRevisions. The resources
+// are decoded to protos with the serialization and envoy API version of each revision, so changes in
+// the serialization are not reported. Secrets and the endpoints generated from Services are compared
+// by their references, as their contents are only known at runtime.
+func DiffRevisions(from, to *marin3rv1alpha1.EnvoyConfigRevision) (envoy_diff.Changes, error) {
+	return diffResources(revisionSource(from), revisionSource(to))
+}
+
+// DiffSpec returns the changes between the resources of an EnvoyConfigRevision and the resources in the
+// spec of an EnvoyConfig, which are the ones that the next revision will hold. See DiffRevisions.
+func DiffSpec(from *marin3rv1alpha1.EnvoyConfigRevision, to *marin3rv1alpha1.EnvoyConfig) (envoy_diff.Changes, error) {
+	return diffResources(revisionSource(from), resourcesSource{
+		description:   fmt.Sprintf("EnvoyConfig %s/%s", to.GetNamespace(), to.GetName()),
+		resources:     to.Spec.EnvoyResources,
+		serialization: to.GetSerialization(),
+		version:       to.GetEnvoyAPIVersion(),
+	})
+}
+
+func revisionSource(ecr *marin3rv1alpha1.EnvoyConfigRevision) resourcesSource {
+	return resourcesSource{
+		description:   fmt.Sprintf("EnvoyConfigRevision %s/%s", ecr.GetNamespace(), ecr.GetName()),
+		resources:     ecr.Spec.EnvoyResources,
+		serialization: ecr.GetSerialization(),
+		version:       ecr.GetEnvoyAPIVersion(),
+	}
+}
+
+func diffResources(from, to resourcesSource) (envoy_diff.Changes, error) {
+
+	fromResources, errs := envoyconfigv1alpha1validator.Decode(from.resources, from.serialization, from.version)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("unable to decode the resources of %s: %s", from.description, errs.ToAggregate())
+	}
+	toResources, errs := envoyconfigv1alpha1validator.Decode(to.resources, to.serialization, to.version)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("unable to decode the resources of %s: %s", to.description, errs.ToAggregate())
+	}
+
+	changes, err := envoy_diff.Diff(to.version, fromResources, toResources)
+	if err != nil {
+		return nil, err
+	}
+
+	fromSecrets, fromServiceEndpoints, err := references(from.resources)
+	if err != nil {
+		return nil, err
+	}
+	toSecrets, toServiceEndpoints, err := references(to.resources)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, envoy_diff.DiffObjects(envoy.Secret, fromSecrets, toSecrets)...)
+	changes = append(changes, envoy_diff.DiffObjects(envoy.Endpoint, fromServiceEndpoints, toServiceEndpoints)...)
+	changes.Sort()
+
+	return changes, nil
+}
+
+// references returns the generic json representation of the secrets
+// and the service endpoints of an EnvoyResources struct, indexed by name
+func references(resources *marin3rv1alpha1.EnvoyResources) (map[string]interface{}, map[string]interface{}, error) {
+	secrets := map[string]interface{}{}
+	for _, secret := range resources.Secrets {
+		obj, err := toObject(secret)
+		if err != nil {
+			return nil, nil, err
+		}
+		secrets[secret.Name] = obj
+	}
+
+	serviceEndpoints := map[string]interface{}{}
+	for _, se := range resources.ServiceEndpoints {
+		obj, err := toObject(se)
+		if err != nil {
+			return nil, nil, err
+		}
+		serviceEndpoints[se.Name] = obj
+	}
+
+	return secrets, serviceEndpoints, nil
+}
+
+// toObject returns the generic json representation of a value
+func toObject(v interface{}) (interface{}, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj interface{}
+	err = json.Unmarshal(js, &obj)
+	return obj, err
+}
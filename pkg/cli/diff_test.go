@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_diff "github.com/3scale/marin3r/pkg/envoy/diff"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testRevisionWithResources(name string, serialization envoy_serializer.Serialization, resources *marin3rv1alpha1.EnvoyResources) *marin3rv1alpha1.EnvoyConfigRevision {
+	ecr := testRevision(name, false)
+	ecr.Spec.EnvoyAPI = pointer.StringPtr(envoy.APIv3.String())
+	ecr.Spec.Serialization = pointer.StringPtr(string(serialization))
+	ecr.Spec.EnvoyResources = resources
+	return ecr
+}
+
+func TestDiff(t *testing.T) {
+	key := types.NamespacedName{Name: "ec", Namespace: "default"}
+
+	ec := testEnvoyConfig("1", "1", "2")
+	ec.Spec.EnvoyAPI = pointer.StringPtr(envoy.APIv3.String())
+	ec.Spec.Serialization = pointer.StringPtr(string(envoy_serializer.B64JSON))
+	ec.Spec.EnvoyResources = &marin3rv1alpha1.EnvoyResources{
+		// {"name": "cluster", "connect_timeout": "2s"}
+		Clusters: []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: "eyJuYW1lIjogImNsdXN0ZXIiLCAiY29ubmVjdF90aW1lb3V0IjogIjJzIn0="}},
+	}
+
+	objects := []runtime.Object{
+		ec,
+		testRevisionWithResources("1", envoy_serializer.JSON, &marin3rv1alpha1.EnvoyResources{
+			Clusters: []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: `{"name": "cluster", "connect_timeout": "1s"}`}},
+			Secrets:  []marin3rv1alpha1.EnvoySecretResource{{Name: "cert", Ref: corev1.SecretReference{Name: "cert", Namespace: "default"}}},
+		}),
+		testRevisionWithResources("2", envoy_serializer.YAML, &marin3rv1alpha1.EnvoyResources{
+			Clusters:  []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: "name: cluster\nconnect_timeout: 1s"}},
+			Listeners: []marin3rv1alpha1.EnvoyResource{{Name: "listener", Value: "name: listener"}},
+			Secrets:   []marin3rv1alpha1.EnvoySecretResource{{Name: "cert", Ref: corev1.SecretReference{Name: "other", Namespace: "default"}}},
+		}),
+	}
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		want    envoy_diff.Changes
+		wantErr bool
+	}{
+		{
+			name: "Diffs the published revision and the spec",
+			want: envoy_diff.Changes{
+				{Type: envoy.Cluster, Name: "cluster", Change: envoy_diff.Changed, Fields: []envoy_diff.FieldChange{
+					{Path: "connect_timeout", From: `"1s"`, To: `"2s"`},
+				}},
+				{Type: envoy.Secret, Name: "cert", Change: envoy_diff.Removed},
+			},
+		},
+		{
+			name: "Diffs two revisions ignoring the serialization",
+			from: "1",
+			to:   "2",
+			want: envoy_diff.Changes{
+				{Type: envoy.Listener, Name: "listener", Change: envoy_diff.Added},
+				{Type: envoy.Secret, Name: "cert", Change: envoy_diff.Changed, Fields: []envoy_diff.FieldChange{
+					{Path: "ref.name", From: `"cert"`, To: `"other"`},
+				}},
+			},
+		},
+		{
+			name:    "Fails for versions not listed in the status",
+			from:    "3",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewFakeClientWithScheme(s, objects...)
+			got, err := Diff(context.TODO(), c, key, tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Diff() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffRevisions(t *testing.T) {
+	from := testRevisionWithResources("1", envoy_serializer.JSON, &marin3rv1alpha1.EnvoyResources{
+		Clusters: []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: `{"name": "cluster", "conect_timeout": "1s"}`}},
+	})
+	to := testRevisionWithResources("2", envoy_serializer.JSON, &marin3rv1alpha1.EnvoyResources{})
+
+	if _, err := DiffRevisions(from, to); err == nil {
+		t.Errorf("DiffRevisions() error = nil, want error for resources that cannot be decoded")
+	}
+}
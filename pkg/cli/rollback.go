@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Rollback pins a revision of the EnvoyConfig so it gets published whatever its
+// resources spec says. If version is empty, the revision that was published before
+// the current one is pinned, skipping the tainted ones. Returns the pinned version.
+func Rollback(ctx context.Context, c client.Client, key types.NamespacedName, version string) (string, error) {
+
+	ec := &marin3rv1alpha1.EnvoyConfig{}
+	if err := c.Get(ctx, key, ec); err != nil {
+		return "", err
+	}
+
+	if version == "" {
+		var err error
+		if version, err = previousVersion(ctx, c, ec); err != nil {
+			return "", err
+		}
+	} else if !hasRevision(ec, version) {
+		return "", fmt.Errorf("EnvoyConfig %s has no revision with version '%s'", key, version)
+	}
+
+	patch := client.MergeFrom(ec.DeepCopy())
+	ec.Spec.RevisionPin = &version
+	if err := c.Patch(ctx, ec, patch); err != nil {
+		return "", err
+	}
+
+	return version, nil
+}
+
+// Unpin removes the revision pin of the EnvoyConfig, so the revision
+// for its resources spec gets published again
+func Unpin(ctx context.Context, c client.Client, key types.NamespacedName) error {
+
+	ec := &marin3rv1alpha1.EnvoyConfig{}
+	if err := c.Get(ctx, key, ec); err != nil {
+		return err
+	}
+
+	if ec.Spec.RevisionPin == nil {
+		return nil
+	}
+
+	patch := client.MergeFrom(ec.DeepCopy())
+	ec.Spec.RevisionPin = nil
+	return c.Patch(ctx, ec, patch)
+}
+
+// previousVersion returns the version of the last untainted revision
+// listed in the EnvoyConfig status before the published one
+func previousVersion(ctx context.Context, c client.Client, ec *marin3rv1alpha1.EnvoyConfig) (string, error) {
+
+	published := -1
+	for idx, ref := range ec.Status.ConfigRevisions {
+		if ref.Version == ec.Status.PublishedVersion {
+			published = idx
+		}
+	}
+	if published == -1 {
+		return "", fmt.Errorf("EnvoyConfig %s/%s has no published revision", ec.GetNamespace(), ec.GetName())
+	}
+
+	for idx := published - 1; idx >= 0; idx-- {
+		ref := ec.Status.ConfigRevisions[idx]
+		ecr := &marin3rv1alpha1.EnvoyConfigRevision{}
+		if err := c.Get(ctx, types.NamespacedName{Name: ref.Ref.Name, Namespace: ref.Ref.Namespace}, ecr); err != nil {
+			return "", err
+		}
+		if !ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) {
+			return ref.Version, nil
+		}
+	}
+
+	return "", fmt.Errorf("EnvoyConfig %s/%s has no untainted revision to roll back to", ec.GetNamespace(), ec.GetName())
+}
+
+// hasRevision returns true if the version is listed in the EnvoyConfig status
+func hasRevision(ec *marin3rv1alpha1.EnvoyConfig, version string) bool {
+	for _, ref := range ec.Status.ConfigRevisions {
+		if ref.Version == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"github.com/operator-framework/operator-lib/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var s *runtime.Scheme = scheme.Scheme
+
+func init() {
+	s.AddKnownTypes(marin3rv1alpha1.GroupVersion,
+		&marin3rv1alpha1.EnvoyConfigRevision{},
+		&marin3rv1alpha1.EnvoyConfigRevisionList{},
+		&marin3rv1alpha1.EnvoyConfig{},
+	)
+}
+
+func testRevision(name string, tainted bool) *marin3rv1alpha1.EnvoyConfigRevision {
+	ecr := &marin3rv1alpha1.EnvoyConfigRevision{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: name},
+	}
+	if tainted {
+		ecr.Status.Conditions = status.Conditions{{Type: marin3rv1alpha1.RevisionTaintedCondition, Status: corev1.ConditionTrue}}
+	}
+	return ecr
+}
+
+func testEnvoyConfig(published string, versions ...string) *marin3rv1alpha1.EnvoyConfig {
+	ec := &marin3rv1alpha1.EnvoyConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "default"},
+		Spec:       marin3rv1alpha1.EnvoyConfigSpec{NodeID: "node"},
+		Status:     marin3rv1alpha1.EnvoyConfigStatus{PublishedVersion: published},
+	}
+	for _, v := range versions {
+		ec.Status.ConfigRevisions = append(ec.Status.ConfigRevisions, marin3rv1alpha1.ConfigRevisionRef{
+			Version: v,
+			Ref:     corev1.ObjectReference{Name: v, Namespace: "default"},
+		})
+	}
+	return ec
+}
+
+func TestRollback(t *testing.T) {
+	key := types.NamespacedName{Name: "ec", Namespace: "default"}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		version string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Pins the revision published before the current one",
+			objects: []runtime.Object{
+				testEnvoyConfig("3", "1", "2", "3"),
+				testRevision("1", false), testRevision("2", false), testRevision("3", false),
+			},
+			want: "2",
+		},
+		{
+			name: "Skips the tainted revisions",
+			objects: []runtime.Object{
+				testEnvoyConfig("3", "1", "2", "3"),
+				testRevision("1", false), testRevision("2", true), testRevision("3", false),
+			},
+			want: "1",
+		},
+		{
+			name: "Pins the given version",
+			objects: []runtime.Object{
+				testEnvoyConfig("3", "1", "2", "3"),
+				testRevision("1", false), testRevision("2", false), testRevision("3", false),
+			},
+			version: "1",
+			want:    "1",
+		},
+		{
+			name:    "Fails for versions not listed in the status",
+			objects: []runtime.Object{testEnvoyConfig("1", "1"), testRevision("1", false)},
+			version: "2",
+			wantErr: true,
+		},
+		{
+			name: "Fails if there is no revision to roll back to",
+			objects: []runtime.Object{
+				testEnvoyConfig("2", "1", "2"),
+				testRevision("1", true), testRevision("2", false),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "Fails if the EnvoyConfig does not exist",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewFakeClientWithScheme(s, tt.objects...)
+			got, err := Rollback(context.TODO(), c, key, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Rollback() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Rollback() = %v, want %v", got, tt.want)
+			}
+			ec := &marin3rv1alpha1.EnvoyConfig{}
+			if err := c.Get(context.TODO(), key, ec); err != nil {
+				t.Fatalf("error getting the EnvoyConfig: %v", err)
+			}
+			if ec.Spec.RevisionPin == nil || *ec.Spec.RevisionPin != tt.want {
+				t.Errorf("Rollback() spec.revisionPin = %v, want %v", ec.Spec.RevisionPin, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnpin(t *testing.T) {
+	key := types.NamespacedName{Name: "ec", Namespace: "default"}
+	ec := testEnvoyConfig("1", "1")
+	ec.Spec.RevisionPin = pointer.StringPtr("1")
+	c := fake.NewFakeClientWithScheme(s, ec)
+
+	if err := Unpin(context.TODO(), c, key); err != nil {
+		t.Fatalf("Unpin() error = %v", err)
+	}
+	got := &marin3rv1alpha1.EnvoyConfig{}
+	if err := c.Get(context.TODO(), key, got); err != nil {
+		t.Fatalf("error getting the EnvoyConfig: %v", err)
+	}
+	if got.Spec.RevisionPin != nil {
+		t.Errorf("Unpin() spec.revisionPin = %v, want nil", *got.Spec.RevisionPin)
+	}
+}
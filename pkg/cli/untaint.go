@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Untaint requests the removal of the taint of the revision of the EnvoyConfig with the given
+// version, by setting the UntaintAnnotation in it. The taint is removed by the discovery service.
+// The annotation is also accepted by a revision that is not tainted if its canary rollout was the
+// last one and got aborted, to start the rollout again. Returns the name of the revision.
+func Untaint(ctx context.Context, c client.Client, key types.NamespacedName, version string) (string, error) {
+
+	ec := &marin3rv1alpha1.EnvoyConfig{}
+	if err := c.Get(ctx, key, ec); err != nil {
+		return "", err
+	}
+
+	var ref *marin3rv1alpha1.ConfigRevisionRef
+	for idx := range ec.Status.ConfigRevisions {
+		if ec.Status.ConfigRevisions[idx].Version == version {
+			ref = &ec.Status.ConfigRevisions[idx]
+		}
+	}
+	if ref == nil {
+		return "", fmt.Errorf("EnvoyConfig %s has no revision with version '%s'", key, version)
+	}
+
+	ecr := &marin3rv1alpha1.EnvoyConfigRevision{}
+	if err := c.Get(ctx, types.NamespacedName{Name: ref.Ref.Name, Namespace: ref.Ref.Namespace}, ecr); err != nil {
+		return "", err
+	}
+
+	rollout := ec.Status.Rollout
+	aborted := rollout != nil && rollout.Phase == marin3rv1alpha1.RolloutAbortedPhase && rollout.CanaryVersion == version
+	if !ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) && !aborted {
+		return "", fmt.Errorf("EnvoyConfigRevision %s/%s is not tainted", ecr.GetNamespace(), ecr.GetName())
+	}
+
+	patch := client.MergeFrom(ecr.DeepCopy())
+	annotations := ecr.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[marin3rv1alpha1.UntaintAnnotation] = "true"
+	ecr.SetAnnotations(annotations)
+	if err := c.Patch(ctx, ecr, patch); err != nil {
+		return "", err
+	}
+
+	return ecr.GetName(), nil
+}
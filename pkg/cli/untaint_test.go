@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestUntaint(t *testing.T) {
+	key := types.NamespacedName{Name: "ec", Namespace: "default"}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		version string
+		wantErr bool
+	}{
+		{
+			name:    "Annotates the tainted revision",
+			objects: []runtime.Object{testEnvoyConfig("1", "1", "2"), testRevision("1", false), testRevision("2", true)},
+			version: "2",
+		},
+		{
+			name:    "Fails for revisions that are not tainted",
+			objects: []runtime.Object{testEnvoyConfig("1", "1", "2"), testRevision("1", false), testRevision("2", true)},
+			version: "1",
+			wantErr: true,
+		},
+		{
+			name: "Annotates the untainted revision of an aborted canary rollout",
+			objects: []runtime.Object{
+				func() *marin3rv1alpha1.EnvoyConfig {
+					ec := testEnvoyConfig("1", "1", "2")
+					ec.Status.Rollout = &marin3rv1alpha1.RolloutStatus{Phase: marin3rv1alpha1.RolloutAbortedPhase, CanaryVersion: "2"}
+					return ec
+				}(),
+				testRevision("1", false), testRevision("2", false),
+			},
+			version: "2",
+		},
+		{
+			name:    "Fails for versions not listed in the status",
+			objects: []runtime.Object{testEnvoyConfig("1", "1"), testRevision("1", true)},
+			version: "2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewFakeClientWithScheme(s, tt.objects...)
+			name, err := Untaint(context.TODO(), c, key, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Untaint() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			ecr := &marin3rv1alpha1.EnvoyConfigRevision{}
+			if err := c.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "default"}, ecr); err != nil {
+				t.Fatalf("error getting the EnvoyConfigRevision: %v", err)
+			}
+			if _, ok := ecr.GetAnnotations()[marin3rv1alpha1.UntaintAnnotation]; !ok {
+				t.Errorf("Untaint() did not set the %s annotation", marin3rv1alpha1.UntaintAnnotation)
+			}
+		})
+	}
+}
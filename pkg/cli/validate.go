@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	envoy_lint "github.com/3scale/marin3r/pkg/envoy/lint"
+	"github.com/3scale/marin3r/pkg/webhooks/envoyconfigv1alpha1validator"
+	"gopkg.in/yaml.v3"
+)
+
+// Diagnostic is a problem found when validating the EnvoyConfig manifests
+type Diagnostic struct {
+	File string
+	// Line is the line of the file the problem has been found in,
+	// or 0 if it is not known
+	Line     int
+	Object   string
+	Severity envoy_lint.Severity
+	Message  string
+}
+
+// String returns the string representation of a Diagnostic, in a format editors and CI systems understand
+func (d Diagnostic) String() string {
+	location := d.File
+	if d.Line > 0 {
+		location = fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	if d.Object != "" {
+		return fmt.Sprintf("%s: %s: EnvoyConfig %s: %s", location, strings.ToLower(string(d.Severity)), d.Object, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, strings.ToLower(string(d.Severity)), d.Message)
+}
+
+// HasErrors returns true if any of the diagnostics has SeverityError
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == envoy_lint.SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFiles validates the EnvoyConfig objects in the yaml files of the given paths. Directories
+// are walked recursively looking for files with the .yaml or .yml extensions. Other objects in the
+// files are ignored. Returns an error if the files cannot be read.
+func ValidateFiles(paths []string) ([]Diagnostic, error) {
+	diagnostics := []Diagnostic{}
+
+	for _, path := range paths {
+		files, err := yamlFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			diagnostics = append(diagnostics, ValidateManifest(file, data)...)
+		}
+	}
+
+	return diagnostics, nil
+}
+
+// yamlFiles returns the given path if it is a file, or the yaml files under it if it is a directory
+func yamlFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files := []string{}
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ext := filepath.Ext(file); !info.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ValidateManifest validates the EnvoyConfig objects in a yaml stream, which can hold several
+// documents, with the same code the admission webhook uses, and lints the resources of the valid
+// ones. The lines of the diagnostics are the lines of the fields with the offending values.
+func ValidateManifest(file string, data []byte) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		doc := &yaml.Node{}
+		if err := decoder.Decode(doc); err != nil {
+			if err != io.EOF {
+				diagnostics = append(diagnostics, Diagnostic{
+					File: file, Severity: envoy_lint.SeverityError, Message: fmt.Sprintf("unable to parse yaml: %s", err),
+				})
+			}
+			return diagnostics
+		}
+		diagnostics = append(diagnostics, validateDocument(file, doc)...)
+	}
+}
+
+// validateDocument validates a yaml document, if it holds an EnvoyConfig
+func validateDocument(file string, doc *yaml.Node) []Diagnostic {
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+
+	obj := map[string]interface{}{}
+	if err := root.Decode(&obj); err != nil {
+		return nil
+	}
+	if obj["apiVersion"] != marin3rv1alpha1.GroupVersion.String() || obj["kind"] != "EnvoyConfig" {
+		return nil
+	}
+
+	object := ""
+	diagnostic := func(path string, severity envoy_lint.Severity, msg string) Diagnostic {
+		return Diagnostic{File: file, Line: lineOf(root, path), Object: object, Severity: severity, Message: msg}
+	}
+
+	ec := &marin3rv1alpha1.EnvoyConfig{}
+	js, err := json.Marshal(obj)
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(js))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(ec)
+	}
+	if err != nil {
+		return []Diagnostic{diagnostic("", envoy_lint.SeverityError, fmt.Sprintf("unable to decode EnvoyConfig: %s", err))}
+	}
+
+	object = ec.GetName()
+	if ec.GetNamespace() != "" {
+		object = ec.GetNamespace() + "/" + ec.GetName()
+	}
+
+	diagnostics := []Diagnostic{}
+	if errs := envoyconfigv1alpha1validator.Validate(ec); len(errs) > 0 {
+		for _, err := range errs {
+			msg := err.ErrorBody()
+			if err.Detail != "" {
+				msg = err.Detail
+			}
+			diagnostics = append(diagnostics, diagnostic(err.Field, envoy_lint.SeverityError, fmt.Sprintf("%s: %s", err.Field, msg)))
+		}
+		return diagnostics
+	}
+
+	issues, err := envoyconfigv1alpha1validator.Lint(ec)
+	if err != nil {
+		return []Diagnostic{diagnostic("", envoy_lint.SeverityError, fmt.Sprintf("unable to lint the resources: %s", err))}
+	}
+	for _, issue := range issues {
+		path := envoyconfigv1alpha1validator.ResourcePath(ec, issue.Type, issue.Name)
+		diagnostics = append(diagnostics, diagnostic(path.String(), issue.Severity,
+			fmt.Sprintf("%s %q: %s (%s)", issue.Type, issue.Name, issue.Message, issue.Rule)))
+	}
+
+	return diagnostics
+}
+
+var (
+	// pathSegment matches each of the segments of the string
+	// representation of a field path, like "clusters" or "clusters[3]"
+	pathSegment = regexp.MustCompile(`^([^\[]+)((?:\[\d+\])*)$`)
+	pathIndex   = regexp.MustCompile(`\d+`)
+)
+
+// lineOf returns the line of the field at the given field path, which is the line of its key for
+// the fields of objects, or the line of the deepest field of the path that exists if it cannot be found
+func lineOf(root *yaml.Node, path string) int {
+	node, line := root, root.Line
+	if path == "" {
+		return line
+	}
+
+	for _, segment := range strings.Split(path, ".") {
+		match := pathSegment.FindStringSubmatch(segment)
+		if match == nil {
+			break
+		}
+		key, value := mappingEntry(node, match[1])
+		if key == nil {
+			return line
+		}
+		node, line = value, key.Line
+		for _, idx := range pathIndex.FindAllString(match[2], -1) {
+			i, _ := strconv.Atoi(idx)
+			if node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+				return line
+			}
+			node, line = node.Content[i], node.Content[i].Line
+		}
+	}
+	return line
+}
+
+// mappingEntry returns the key and value nodes of the given key of a
+// yaml mapping node, or nil if the key does not exist
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
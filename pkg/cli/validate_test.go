@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	envoy_lint "github.com/3scale/marin3r/pkg/envoy/lint"
+)
+
+const testManifest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+---
+apiVersion: marin3r.3scale.net/v1alpha1
+kind: EnvoyConfig
+metadata:
+  name: valid
+  namespace: default
+spec:
+  nodeID: test
+  envoyAPI: v3
+  serialization: yaml
+  envoyResources:
+    clusters:
+      - name: cluster
+        value: |
+          name: cluster
+          connect_timeout: 1s
+---
+apiVersion: marin3r.3scale.net/v1alpha1
+kind: EnvoyConfig
+metadata:
+  name: invalid
+spec:
+  nodeID: test
+  envoyAPI: v3
+  envoyResources:
+    clusters:
+      - name: cluster
+        value: '{"name": "cluster", "connect_timeout": "1s"}'
+      - name: other
+        value: '{"name": "other", "conect_timeout": "1s"}'
+---
+apiVersion: marin3r.3scale.net/v1alpha1
+kind: EnvoyConfig
+metadata:
+  name: lint
+spec:
+  nodeID: test
+  envoyResources:
+    clusters:
+      - name: cluster
+        value: '{"name": "other", "connect_timeout": "1s"}'
+`
+
+func TestValidateManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Diagnostic
+	}{
+		{
+			name: "Reports validation and lint issues with their lines",
+			data: testManifest,
+			want: []Diagnostic{
+				{File: "test.yaml", Line: 34, Object: "invalid", Severity: envoy_lint.SeverityError,
+					Message: `spec.envoyResources.clusters[1].value: Invalid envoy resource value: 'Error deserializing resource: 'unknown field "conect_timeout" in envoy.config.cluster.v3.Cluster''`},
+				{File: "test.yaml", Line: 44, Object: "lint", Severity: envoy_lint.SeverityError,
+					Message: `Cluster "cluster": the name field of the resource is "other" (NameMismatch)`},
+			},
+		},
+		{
+			name: "Reports unknown fields",
+			data: "apiVersion: marin3r.3scale.net/v1alpha1\nkind: EnvoyConfig\nmetadata:\n  name: ec\nspec:\n  nodeName: test\n",
+			want: []Diagnostic{
+				{File: "test.yaml", Line: 1, Severity: envoy_lint.SeverityError,
+					Message: `unable to decode EnvoyConfig: json: unknown field "nodeName"`},
+			},
+		},
+		{
+			name: "Reports invalid yaml",
+			data: "apiVersion: [\n",
+			want: []Diagnostic{
+				{File: "test.yaml", Severity: envoy_lint.SeverityError, Message: "unable to parse yaml: yaml: line 1: did not find expected node content"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateManifest("test.yaml", []byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateManifest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marin3r-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "nested", "ec.yml"), []byte(testManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not: [yaml"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ValidateFiles([]string{dir})
+	if err != nil {
+		t.Fatalf("ValidateFiles() error = %v", err)
+	}
+	if len(got) != 2 || got[0].File != filepath.Join(dir, "nested", "ec.yml") || !HasErrors(got) {
+		t.Errorf("ValidateFiles() = %v", got)
+	}
+
+	if _, err := ValidateFiles([]string{filepath.Join(dir, "missing.yaml")}); err == nil {
+		t.Errorf("ValidateFiles() error = nil, want error for missing files")
+	}
+}
+
+func TestValidateFiles_corruptYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marin3r-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// This input used to panic the yaml parser (CVE-2022-28948)
+	file := filepath.Join(dir, "corrupt.yaml")
+	if err := ioutil.WriteFile(file, []byte("0: [:!00 \xef"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ValidateFiles([]string{file})
+	if err != nil {
+		t.Fatalf("ValidateFiles() error = %v", err)
+	}
+	if len(got) != 1 || got[0].File != file || !strings.HasPrefix(got[0].Message, "unable to parse yaml: ") {
+		t.Errorf("ValidateFiles() = %v, want a yaml parse error", got)
+	}
+	// The validate command exits with a non-zero code when there are errors
+	if !HasErrors(got) {
+		t.Errorf("HasErrors() = false, want true")
+	}
+}
+
+func TestDiagnostic_String(t *testing.T) {
+	d := Diagnostic{File: "ec.yaml", Line: 3, Object: "default/ec", Severity: envoy_lint.SeverityWarning, Message: "msg"}
+	if got, want := d.String(), "ec.yaml:3: warning: EnvoyConfig default/ec: msg"; got != want {
+		t.Errorf("Diagnostic.String() = %q, want %q", got, want)
+	}
+}
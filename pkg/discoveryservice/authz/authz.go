@@ -0,0 +1,210 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package authz
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/metrics"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Policy is the policy used to decide if the identity in a client
+// certificate is allowed to request the config of a node ID
+type Policy string
+
+const (
+	// PolicyNone allows any client to request the config of any node ID
+	PolicyNone Policy = "None"
+	// PolicyExact allows a client to request the config of a node
+	// ID only if it matches one of the identities of the client
+	PolicyExact Policy = "Exact"
+	// PolicyPrefix allows a client to request the config of a node ID
+	// only if the node ID starts with one of the identities of the client
+	PolicyPrefix Policy = "Prefix"
+	// PolicyMapping allows a client to request the config of the node
+	// IDs mapped to any of the identities of the client
+	PolicyMapping Policy = "Mapping"
+)
+
+// ParsePolicy returns the Policy for the given string, case insensitive
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range []Policy{PolicyNone, PolicyExact, PolicyPrefix, PolicyMapping} {
+		if strings.EqualFold(s, string(p)) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown node ID authorization policy %q", s)
+}
+
+// ParseMappings parses mappings in the format "<identity>=<nodeID>[,<nodeID>...]"
+func ParseMappings(mappings []string) (map[string][]string, error) {
+	m := map[string][]string{}
+	for _, mapping := range mappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid node ID mapping %q, the format is '<identity>=<nodeID>[,<nodeID>...]'", mapping)
+		}
+		m[parts[0]] = append(m[parts[0]], strings.Split(parts[1], ",")...)
+	}
+	return m, nil
+}
+
+// Authorizer checks that the identities in the client certificates of the
+// envoy clients are allowed to request the config of the node IDs they present.
+// It is safe for concurrent use.
+type Authorizer struct {
+	// Policy is the authorization policy
+	Policy Policy
+	// Mappings holds the node IDs each identity is allowed
+	// to request. Only used with PolicyMapping.
+	Mappings map[string][]string
+	// OnDenied is called whenever a request is denied. Optional.
+	OnDenied func(nodeID string, identities []string, api envoy.APIVersion)
+
+	streams sync.Map
+}
+
+// Authorize returns a PermissionDenied error if none of the given
+// identities is allowed to request the config of nodeID
+func (a *Authorizer) Authorize(identities []string, nodeID string, api envoy.APIVersion) error {
+	if a == nil || a.Policy == PolicyNone || a.Policy == "" {
+		return nil
+	}
+
+	for _, identity := range identities {
+		if a.allowed(identity, nodeID) {
+			return nil
+		}
+	}
+
+	metrics.NodeIDDenied.WithLabelValues(string(api)).Inc()
+	if a.OnDenied != nil {
+		a.OnDenied(nodeID, identities, api)
+	}
+	return status.Errorf(codes.PermissionDenied, "client identities %v are not allowed to request node ID %q", identities, nodeID)
+}
+
+func (a *Authorizer) allowed(identity, nodeID string) bool {
+	switch a.Policy {
+	case PolicyExact:
+		return identity == nodeID
+	case PolicyPrefix:
+		return strings.HasPrefix(nodeID, identity)
+	case PolicyMapping:
+		for _, id := range a.Mappings[identity] {
+			if id == nodeID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamKey identifies a stream of a given envoy API. The
+// delta streams use their own ID sequence so they are told
+// apart using the delta flag.
+type streamKey struct {
+	api   envoy.APIVersion
+	delta bool
+	id    int64
+}
+
+// OpenStream stores the identities of the client of a stream,
+// so the requests received in the stream can be authorized
+func (a *Authorizer) OpenStream(ctx context.Context, api envoy.APIVersion, delta bool, id int64) {
+	if a == nil {
+		return
+	}
+	a.streams.Store(streamKey{api, delta, id}, PeerIdentities(ctx))
+}
+
+// CloseStream removes the identities of the client of a stream
+func (a *Authorizer) CloseStream(api envoy.APIVersion, delta bool, id int64) {
+	if a == nil {
+		return
+	}
+	a.streams.Delete(streamKey{api, delta, id})
+}
+
+// AuthorizeStream authorizes a request received in a stream
+func (a *Authorizer) AuthorizeStream(api envoy.APIVersion, delta bool, id int64, nodeID string) error {
+	if a == nil {
+		return nil
+	}
+	identities, _ := a.streams.Load(streamKey{api, delta, id})
+	ids, _ := identities.([]string)
+	return a.Authorize(ids, nodeID, api)
+}
+
+// AuthorizeContext authorizes a request using the identities of the
+// client certificate in the gRPC peer information of the context
+func (a *Authorizer) AuthorizeContext(ctx context.Context, nodeID string, api envoy.APIVersion) error {
+	if a == nil {
+		return nil
+	}
+	err := a.Authorize(PeerIdentities(ctx), nodeID, api)
+	if denied, ok := ctx.Value(deniedKey{}).(*int32); ok && err != nil {
+		atomic.StoreInt32(denied, 1)
+	}
+	return err
+}
+
+type deniedKey struct{}
+
+// WithDenialTracking returns a copy of the context and a function that reports if
+// AuthorizeContext has denied a request made with it. This allows callers that only
+// get a stringified error back, like the REST-JSON gateway, to answer with the proper code.
+func WithDenialTracking(ctx context.Context) (context.Context, func() bool) {
+	var denied int32
+	return context.WithValue(ctx, deniedKey{}, &denied), func() bool { return atomic.LoadInt32(&denied) == 1 }
+}
+
+// PeerIdentities returns the identities in the certificate presented by the client of a
+// gRPC stream: the subject common name, the DNS SANs and the URI SANs, in that order
+func PeerIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return nil
+	}
+	return CertificateIdentities(info.State.PeerCertificates[0])
+}
+
+// CertificateIdentities returns the identities in a certificate: the
+// subject common name, the DNS SANs and the URI SANs, in that order
+func CertificateIdentities(cert *x509.Certificate) []string {
+	identities := []string{}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
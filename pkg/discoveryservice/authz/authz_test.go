@@ -0,0 +1,246 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package authz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"reflect"
+	"testing"
+
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func peerContext(cert *x509.Certificate) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+}
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorizer *Authorizer
+		identities []string
+		nodeID     string
+		wantErr    bool
+	}{
+		{
+			name:       "Nil authorizer allows everything",
+			authorizer: nil,
+			identities: []string{},
+			nodeID:     "node1",
+			wantErr:    false,
+		},
+		{
+			name:       "None policy allows everything",
+			authorizer: &Authorizer{Policy: PolicyNone},
+			identities: []string{"envoy"},
+			nodeID:     "node1",
+			wantErr:    false,
+		},
+		{
+			name:       "Exact policy allows a matching identity",
+			authorizer: &Authorizer{Policy: PolicyExact},
+			identities: []string{"envoy", "node1"},
+			nodeID:     "node1",
+			wantErr:    false,
+		},
+		{
+			name:       "Exact policy denies other node IDs",
+			authorizer: &Authorizer{Policy: PolicyExact},
+			identities: []string{"node1"},
+			nodeID:     "node10",
+			wantErr:    true,
+		},
+		{
+			name:       "Exact policy denies clients without identities",
+			authorizer: &Authorizer{Policy: PolicyExact},
+			identities: nil,
+			nodeID:     "node1",
+			wantErr:    true,
+		},
+		{
+			name:       "Prefix policy allows node IDs starting with the identity",
+			authorizer: &Authorizer{Policy: PolicyPrefix},
+			identities: []string{"gateway-"},
+			nodeID:     "gateway-1",
+			wantErr:    false,
+		},
+		{
+			name:       "Prefix policy denies other node IDs",
+			authorizer: &Authorizer{Policy: PolicyPrefix},
+			identities: []string{"gateway-"},
+			nodeID:     "sidecar-1",
+			wantErr:    true,
+		},
+		{
+			name:       "Mapping policy allows mapped node IDs",
+			authorizer: &Authorizer{Policy: PolicyMapping, Mappings: map[string][]string{"envoy": {"node1", "node2"}}},
+			identities: []string{"envoy"},
+			nodeID:     "node2",
+			wantErr:    false,
+		},
+		{
+			name:       "Mapping policy denies node IDs not mapped",
+			authorizer: &Authorizer{Policy: PolicyMapping, Mappings: map[string][]string{"envoy": {"node1"}}},
+			identities: []string{"envoy"},
+			nodeID:     "node2",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.authorizer.Authorize(tt.identities, tt.nodeID, envoy.APIv3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authorizer.Authorize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && status.Code(err) != codes.PermissionDenied {
+				t.Errorf("Authorizer.Authorize() code = %v, want %v", status.Code(err), codes.PermissionDenied)
+			}
+		})
+	}
+}
+
+func TestAuthorizer_OnDenied(t *testing.T) {
+	var gotNodeID string
+	a := &Authorizer{
+		Policy:   PolicyExact,
+		OnDenied: func(nodeID string, identities []string, api envoy.APIVersion) { gotNodeID = nodeID },
+	}
+	a.Authorize([]string{"node1"}, "node1", envoy.APIv3)
+	if gotNodeID != "" {
+		t.Errorf("Authorizer.OnDenied called for an allowed request")
+	}
+	a.Authorize([]string{"node1"}, "node2", envoy.APIv3)
+	if gotNodeID != "node2" {
+		t.Errorf("Authorizer.OnDenied called with %q, want %q", gotNodeID, "node2")
+	}
+}
+
+func TestAuthorizer_AuthorizeStream(t *testing.T) {
+	a := &Authorizer{Policy: PolicyExact}
+	a.OpenStream(peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "node1"}}), envoy.APIv3, false, 1)
+
+	if err := a.AuthorizeStream(envoy.APIv3, false, 1, "node1"); err != nil {
+		t.Errorf("Authorizer.AuthorizeStream() error = %v", err)
+	}
+	// Delta streams have their own IDs
+	if err := a.AuthorizeStream(envoy.APIv3, true, 1, "node1"); err == nil {
+		t.Errorf("Authorizer.AuthorizeStream() expected an error for an unknown stream")
+	}
+
+	a.CloseStream(envoy.APIv3, false, 1)
+	if err := a.AuthorizeStream(envoy.APIv3, false, 1, "node1"); err == nil {
+		t.Errorf("Authorizer.AuthorizeStream() expected an error for a closed stream")
+	}
+}
+
+func TestAuthorizer_AuthorizeContext(t *testing.T) {
+	a := &Authorizer{Policy: PolicyExact}
+	ctx, denied := WithDenialTracking(peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "node1"}}))
+
+	if err := a.AuthorizeContext(ctx, "node1", envoy.APIv2); err != nil || denied() {
+		t.Errorf("Authorizer.AuthorizeContext() error = %v, denied = %v", err, denied())
+	}
+	if err := a.AuthorizeContext(ctx, "node2", envoy.APIv2); err == nil || !denied() {
+		t.Errorf("Authorizer.AuthorizeContext() error = %v, denied = %v", err, denied())
+	}
+}
+
+func TestPeerIdentities(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{
+			name: "Returns the CN and the SANs",
+			ctx: peerContext(&x509.Certificate{
+				Subject:  pkix.Name{CommonName: "envoy"},
+				DNSNames: []string{"envoy.default.svc"},
+				URIs:     []*url.URL{{Scheme: "spiffe", Host: "cluster.local", Path: "/ns/default/sa/envoy"}},
+			}),
+			want: []string{"envoy", "envoy.default.svc", "spiffe://cluster.local/ns/default/sa/envoy"},
+		},
+		{
+			name: "Returns nil without peer",
+			ctx:  context.Background(),
+			want: nil,
+		},
+		{
+			name: "Returns nil without TLS",
+			ctx:  peer.NewContext(context.Background(), &peer.Peer{}),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PeerIdentities(tt.ctx); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PeerIdentities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMappings(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings []string
+		want     map[string][]string
+		wantErr  bool
+	}{
+		{
+			name:     "Parses the mappings",
+			mappings: []string{"envoy=node1,node2", "gateway=node3", "envoy=node4"},
+			want:     map[string][]string{"envoy": {"node1", "node2", "node4"}, "gateway": {"node3"}},
+			wantErr:  false,
+		},
+		{
+			name:     "Returns an error if the format is not valid",
+			mappings: []string{"envoy"},
+			want:     nil,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMappings(tt.mappings)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMappings() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMappings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if got, err := ParsePolicy("exact"); err != nil || got != PolicyExact {
+		t.Errorf("ParsePolicy() = %v, %v, want %v", got, err, PolicyExact)
+	}
+	if _, err := ParsePolicy("xxxx"); err == nil {
+		t.Errorf("ParsePolicy() expected an error")
+	}
+}
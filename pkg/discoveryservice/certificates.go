@@ -0,0 +1,174 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+// certificateReloader holds the server certificate and the client CA bundle of the
+// discovery service and reloads them whenever the files in the given directories
+// change. Secrets mounted in a Pod are updated in place by the kubelet, so certificate
+// rotations are picked up without restarting the Pod and dropping the envoy streams.
+type certificateReloader struct {
+	serverCertificatePath string
+	caCertificatePath     string
+	logger                logr.Logger
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// newCertificateReloader returns a certificateReloader with the certificates
+// loaded from the given directories
+func newCertificateReloader(serverCertificatePath, caCertificatePath string, logger logr.Logger) (*certificateReloader, error) {
+	cr := &certificateReloader{
+		serverCertificatePath: serverCertificatePath,
+		caCertificatePath:     caCertificatePath,
+		logger:                logger,
+	}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// load reads the certificates from disk. The certificates in use are
+// only replaced if both the certificate and the CA are successfully loaded.
+func (cr *certificateReloader) load() error {
+	certificate, err := loadCertificate(cr.serverCertificatePath)
+	if err != nil {
+		return err
+	}
+	clientCAs, err := loadCA(cr.caCertificatePath)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.certificate = &certificate
+	cr.clientCAs = clientCAs
+	return nil
+}
+
+// GetCertificate returns the current server certificate. It
+// can be used as the tls.Config GetCertificate function.
+func (cr *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.certificate, nil
+}
+
+// TLSConfig returns a copy of the given tls.Config that uses the current server certificate
+// and client CA bundle for each new handshake. Existing connections are not affected when
+// the certificates are reloaded.
+func (cr *certificateReloader) TLSConfig(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	config.Certificates = nil
+	config.GetCertificate = cr.GetCertificate
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cr.mu.RLock()
+		defer cr.mu.RUnlock()
+
+		c := base.Clone()
+		c.Certificates = []tls.Certificate{*cr.certificate}
+		c.ClientCAs = cr.clientCAs
+		return c, nil
+	}
+	return config
+}
+
+// Start implements manager.Runnable. It watches the certificate
+// directories and reloads the certificates on changes.
+func (cr *certificateReloader) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for _, path := range []string{cr.serverCertificatePath, cr.caCertificatePath} {
+		if err := watcher.Add(path); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Chmod events do not change the contents of the files
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if err := cr.load(); err != nil {
+				// The files might be in the middle of being updated,
+				// keep using the previous certificates until the next event
+				cr.logger.Error(err, "unable to reload certificates", "event", event.String())
+				continue
+			}
+			cr.logger.Info("reloaded certificates", "event", event.String())
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			cr.logger.Error(err, "certificate watcher error")
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (cr *certificateReloader) NeedLeaderElection() bool {
+	return false
+}
+
+func loadCertificate(directory string) (tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(
+		fmt.Sprintf("%s/%s", directory, tlsCertificateFile),
+		fmt.Sprintf("%s/%s", directory, tlsCertificateKeyFile),
+	)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("could not load server certificate: %w", err)
+	}
+	return certificate, nil
+}
+
+func loadCA(directory string) (*x509.CertPool, error) {
+	certPool := x509.NewCertPool()
+	bs, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", directory, tlsCertificateFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client ca cert: %w", err)
+	}
+	if ok := certPool.AppendCertsFromPEM(bs); !ok {
+		return nil, fmt.Errorf("failed to append client certs")
+	}
+	return certPool, nil
+}
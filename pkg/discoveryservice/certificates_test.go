@@ -0,0 +1,131 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/3scale/marin3r/pkg/util/pki"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// writeCertificate writes a new self-signed certificate in the given directory
+func writeCertificate(t *testing.T, dir, commonName string) {
+	crt, key, err := pki.GenerateCertificate(nil, nil, commonName, time.Hour, true, true, "localhost")
+	if err != nil {
+		t.Fatalf("unable to generate certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, tlsCertificateKeyFile), key, 0600); err != nil {
+		t.Fatalf("unable to write key: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, tlsCertificateFile), crt, 0600); err != nil {
+		t.Fatalf("unable to write certificate: %v", err)
+	}
+}
+
+func certificateDER(t *testing.T, cr *certificateReloader) []byte {
+	crt, err := cr.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("certificateReloader.GetCertificate() error = %v", err)
+	}
+	return crt.Certificate[0]
+}
+
+func Test_certificateReloader(t *testing.T) {
+	serverDir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(serverDir)
+	caDir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(caDir)
+
+	writeCertificate(t, serverDir, "server-1")
+	writeCertificate(t, caDir, "ca-1")
+
+	cr, err := newCertificateReloader(serverDir, caDir, ctrl.Log)
+	if err != nil {
+		t.Fatalf("newCertificateReloader() error = %v", err)
+	}
+	initial := certificateDER(t, cr)
+
+	config := cr.TLSConfig(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert})
+	if config.GetCertificate == nil || config.GetConfigForClient == nil || len(config.Certificates) != 0 {
+		t.Errorf("certificateReloader.TLSConfig() does not load the certificates dynamically")
+	}
+	clientCAs := func() [][]byte { c, _ := config.GetConfigForClient(nil); return c.ClientCAs.Subjects() }
+	initialCAs := clientCAs()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cr.Start(ctx)
+	// Give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	writeCertificate(t, serverDir, "server-2")
+	writeCertificate(t, caDir, "ca-2")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for bytes.Equal(certificateDER(t, cr), initial) || reflect.DeepEqual(clientCAs(), initialCAs) {
+		if time.Now().After(deadline) {
+			t.Fatalf("certificateReloader did not reload the certificates")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	c, err := config.GetConfigForClient(nil)
+	if err != nil {
+		t.Fatalf("GetConfigForClient() error = %v", err)
+	}
+	if c.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("GetConfigForClient() did not keep the base config")
+	}
+}
+
+func Test_certificateReloader_KeepsPreviousOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeCertificate(t, dir, "test")
+
+	cr, err := newCertificateReloader(dir, dir, ctrl.Log)
+	if err != nil {
+		t.Fatalf("newCertificateReloader() error = %v", err)
+	}
+	initial := certificateDER(t, cr)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, tlsCertificateFile), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.load(); err == nil {
+		t.Errorf("certificateReloader.load() expected an error")
+	}
+	if !bytes.Equal(certificateDER(t, cr), initial) {
+		t.Errorf("certificateReloader.load() replaced the certificate after an error")
+	}
+}
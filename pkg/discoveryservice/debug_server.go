@@ -0,0 +1,292 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	"github.com/ghodss/yaml"
+	"github.com/go-logr/logr"
+)
+
+const (
+	debugPathPrefix = "/debug/xds"
+	redactedValue   = "[redacted]"
+)
+
+// debugResourceTypes are the resource types dumped by the debug server, in order
+var debugResourceTypes = []envoy.Type{envoy.Listener, envoy.Route, envoy.Cluster, envoy.Endpoint, envoy.Secret, envoy.Runtime,
+	envoy.ExtensionConfig, envoy.ScopedRoute, envoy.VirtualHost}
+
+// debugServer is a manager.Runnable that serves the debug endpoints. Clients
+// authenticate with a certificate signed by the discovery service CA, same
+// as the envoy clients, and only the allowed identities get access.
+type debugServer struct {
+	port      uint
+	tlsConfig *tls.Config
+	handler   *debugHandler
+	logger    logr.Logger
+}
+
+func newDebugServer(port uint, tlsConfig *tls.Config, caches func(envoy.APIVersion) xdss.Cache, allowedIdentities []string, logger logr.Logger) *debugServer {
+	return &debugServer{
+		port:      port,
+		tlsConfig: tlsConfig,
+		handler:   &debugHandler{caches: caches, allowedIdentities: allowedIdentities, logger: logger},
+		logger:    logger,
+	}
+}
+
+// Start implements manager.Runnable
+func (ds *debugServer) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", ds.port),
+		Handler:   ds.handler,
+		TLSConfig: ds.tlsConfig,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		// Certificates are already loaded in the TLS config
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+	ds.logger.Info(fmt.Sprintf("Debug server listening on %d", ds.port))
+
+	select {
+	case <-ctx.Done():
+		return server.Close()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (ds *debugServer) NeedLeaderElection() bool {
+	return false
+}
+
+// debugHandler is an http.Handler that exposes the contents of the xDS caches:
+//
+//	GET /debug/xds                    lists the node IDs in the caches with the version of each resource type
+//	GET /debug/xds/{v2|v3}/{nodeID}   dumps the snapshot of a node ID, as JSON or, with ?format=yaml, as YAML
+//
+// The private keys and other secret values in Secret resources are redacted.
+type debugHandler struct {
+	caches            func(envoy.APIVersion) xdss.Cache
+	allowedIdentities []string
+	logger            logr.Logger
+}
+
+// debugNode is the summary of the snapshot of a node ID
+type debugNode struct {
+	NodeID   string            `json:"nodeID"`
+	Versions map[string]string `json:"versions"`
+}
+
+// debugResources holds the resources of a type in a snapshot
+type debugResources struct {
+	Version   string                     `json:"version"`
+	Resources map[string]json.RawMessage `json:"resources"`
+}
+
+// debugSnapshot is the dump of the snapshot of a node ID
+type debugSnapshot struct {
+	NodeID    string                    `json:"nodeID"`
+	EnvoyAPI  envoy.APIVersion          `json:"envoyAPI"`
+	Resources map[string]debugResources `json:"resources"`
+}
+
+// ServeHTTP implements http.Handler
+func (h *debugHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.authorized(req) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	format := envoy_serializer.Serialization(req.URL.Query().Get("format"))
+	if format == "" {
+		format = envoy_serializer.JSON
+	}
+	if format != envoy_serializer.JSON && format != envoy_serializer.YAML {
+		http.Error(w, fmt.Sprintf("unsupported format %q, use json or yaml", format), http.StatusBadRequest)
+		return
+	}
+
+	p := path.Clean(req.URL.Path)
+	if p == debugPathPrefix {
+		h.write(w, req, format, h.nodes())
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(p, debugPathPrefix+"/"), "/", 2)
+	if !strings.HasPrefix(p, debugPathPrefix+"/") || len(parts) != 2 || parts[1] == "" {
+		http.NotFound(w, req)
+		return
+	}
+	api, err := envoy.ParseAPIVersion(parts[0])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	snap, err := h.caches(api).GetSnapshot(parts[1])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("no %s snapshot for node ID %q", api, parts[1]), http.StatusNotFound)
+		return
+	}
+	dump, err := dumpSnapshot(parts[1], api, snap)
+	if err != nil {
+		h.logger.Error(err, "unable to dump snapshot", "NodeID", parts[1], "EnvoyAPI", api)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.write(w, req, format, dump)
+}
+
+// authorized returns true if any of the identities in the client certificate is allowed
+func (h *debugHandler) authorized(req *http.Request) bool {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	for _, identity := range authz.CertificateIdentities(req.TLS.PeerCertificates[0]) {
+		for _, allowed := range h.allowedIdentities {
+			if identity == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nodes returns the summary of the snapshots in the caches of both API versions
+func (h *debugHandler) nodes() map[envoy.APIVersion][]debugNode {
+	nodes := map[envoy.APIVersion][]debugNode{}
+	for _, api := range []envoy.APIVersion{envoy.APIv2, envoy.APIv3} {
+		cache := h.caches(api)
+		nodes[api] = []debugNode{}
+		for _, nodeID := range cache.NodeIDs() {
+			snap, err := cache.GetSnapshot(nodeID)
+			if err != nil {
+				// The snapshot has been cleared in the meantime
+				continue
+			}
+			node := debugNode{NodeID: nodeID, Versions: map[string]string{}}
+			for _, rType := range debugResourceTypes {
+				node.Versions[string(rType)] = snap.GetVersion(rType)
+			}
+			nodes[api] = append(nodes[api], node)
+		}
+	}
+	return nodes
+}
+
+func (h *debugHandler) write(w http.ResponseWriter, req *http.Request, format envoy_serializer.Serialization, v interface{}) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err == nil && format == envoy_serializer.YAML {
+		body, err = yaml.JSONToYAML(body)
+	}
+	if err != nil {
+		h.logger.Error(err, "unable to encode debug response", "Path", req.URL.Path)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == envoy_serializer.YAML {
+		w.Header().Set("Content-Type", "application/yaml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if _, err := w.Write(body); err != nil {
+		h.logger.Error(err, "Error writing debug response", "Path", req.URL.Path)
+	}
+}
+
+// dumpSnapshot returns the resources of a snapshot serialized as JSON,
+// with the secret values of the Secret resources redacted
+func dumpSnapshot(nodeID string, api envoy.APIVersion, snap xdss.Snapshot) (*debugSnapshot, error) {
+	m := envoy_serializer.NewResourceMarshaller(envoy_serializer.JSON, api)
+	dump := &debugSnapshot{NodeID: nodeID, EnvoyAPI: api, Resources: map[string]debugResources{}}
+
+	for _, rType := range debugResourceTypes {
+		resources := debugResources{Version: snap.GetVersion(rType), Resources: map[string]json.RawMessage{}}
+		for name, res := range snap.GetResources(rType) {
+			s, err := m.Marshal(res)
+			if err != nil {
+				return nil, fmt.Errorf("unable to serialize %s %q: %w", rType, name, err)
+			}
+			raw := json.RawMessage(s)
+			if rType == envoy.Secret {
+				if raw, err = redactSecret(raw); err != nil {
+					return nil, fmt.Errorf("unable to redact secret %q: %w", name, err)
+				}
+			}
+			resources.Resources[name] = raw
+		}
+		dump.Resources[string(rType)] = resources
+	}
+	return dump, nil
+}
+
+// redactSecret replaces the private key and password of TLS certificates, the
+// session ticket keys and the generic secret values of a serialized Secret resource.
+// The field names are the same in both API versions.
+func redactSecret(raw json.RawMessage) (json.RawMessage, error) {
+	secret := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &secret); err != nil {
+		return nil, err
+	}
+
+	redacted := map[string]interface{}{"inline_string": redactedValue}
+	if cert, ok := secret["tls_certificate"].(map[string]interface{}); ok {
+		for _, field := range []string{"private_key", "password"} {
+			if _, ok := cert[field]; ok {
+				cert[field] = redacted
+			}
+		}
+	}
+	if tickets, ok := secret["session_ticket_keys"].(map[string]interface{}); ok {
+		if keys, ok := tickets["keys"].([]interface{}); ok {
+			for idx := range keys {
+				keys[idx] = redacted
+			}
+		}
+	}
+	if generic, ok := secret["generic_secret"].(map[string]interface{}); ok {
+		if _, ok := generic["secret"]; ok {
+			generic["secret"] = redacted
+		}
+	}
+
+	return json.Marshal(secret)
+}
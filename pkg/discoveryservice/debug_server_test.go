@@ -0,0 +1,166 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	xdss_v2 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v2"
+	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func testDebugHandler() *debugHandler {
+	cacheV2 := xdss_v2.NewCache(cache_v2.NewSnapshotCache(true, cache_v2.IDHash{}, nil))
+	cacheV3 := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+
+	snap := cacheV3.NewSnapshot("1")
+	snap.SetResource("cluster1", &envoy_config_cluster_v3.Cluster{Name: "cluster1"})
+	snap.SetResource("cert", &envoy_extensions_transport_sockets_tls_v3.Secret{
+		Name: "cert",
+		Type: &envoy_extensions_transport_sockets_tls_v3.Secret_TlsCertificate{
+			TlsCertificate: &envoy_extensions_transport_sockets_tls_v3.TlsCertificate{
+				CertificateChain: &envoy_config_core_v3.DataSource{
+					Specifier: &envoy_config_core_v3.DataSource_InlineBytes{InlineBytes: []byte("certificate")},
+				},
+				PrivateKey: &envoy_config_core_v3.DataSource{
+					Specifier: &envoy_config_core_v3.DataSource_InlineBytes{InlineBytes: []byte("private-key")},
+				},
+			},
+		},
+	})
+	cacheV3.SetSnapshot("node1", snap)
+
+	return &debugHandler{
+		caches: func(api envoy.APIVersion) xdss.Cache {
+			if api == envoy.APIv2 {
+				return cacheV2
+			}
+			return cacheV3
+		},
+		allowedIdentities: []string{"admin"},
+		logger:            ctrl.Log,
+	}
+}
+
+func debugRequest(path, identity string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if identity != "" {
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: identity}}},
+		}
+	}
+	return req
+}
+
+func Test_debugHandler_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		req          *http.Request
+		wantCode     int
+		wantContains []string
+		wantExcludes []string
+	}{
+		{
+			name:         "Lists the node IDs with their versions",
+			req:          debugRequest("/debug/xds", "admin"),
+			wantCode:     http.StatusOK,
+			wantContains: []string{`"v2": []`, `"nodeID": "node1"`, `"Cluster": "1"`},
+		},
+		{
+			name:         "Dumps a snapshot as JSON with the private keys redacted",
+			req:          debugRequest("/debug/xds/v3/node1", "admin"),
+			wantCode:     http.StatusOK,
+			wantContains: []string{`"name": "cluster1"`, `"inline_bytes": "Y2VydGlmaWNhdGU="`, `"inline_string": "[redacted]"`},
+			wantExcludes: []string{"cHJpdmF0ZS1rZXk="},
+		},
+		{
+			name:         "Dumps a snapshot as YAML",
+			req:          debugRequest("/debug/xds/v3/node1?format=yaml", "admin"),
+			wantCode:     http.StatusOK,
+			wantContains: []string{"nodeID: node1", "envoyAPI: v3", "inline_string: '[redacted]'"},
+			wantExcludes: []string{"cHJpdmF0ZS1rZXk="},
+		},
+		{
+			name:     "Returns 404 for unknown node IDs",
+			req:      debugRequest("/debug/xds/v2/node1", "admin"),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Returns 404 for unknown API versions",
+			req:      debugRequest("/debug/xds/v4/node1", "admin"),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Returns 400 for unsupported formats",
+			req:      debugRequest("/debug/xds/v3/node1?format=b64json", "admin"),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Returns 403 for identities not allowed",
+			req:      debugRequest("/debug/xds", "node1"),
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Returns 403 without client certificate",
+			req:      debugRequest("/debug/xds", ""),
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			testDebugHandler().ServeHTTP(rec, tt.req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("debugHandler.ServeHTTP() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			for _, s := range tt.wantContains {
+				if !strings.Contains(rec.Body.String(), s) {
+					t.Errorf("debugHandler.ServeHTTP() body = %v, want it to contain %v", rec.Body.String(), s)
+				}
+			}
+			for _, s := range tt.wantExcludes {
+				if strings.Contains(rec.Body.String(), s) {
+					t.Errorf("debugHandler.ServeHTTP() body = %v, want it not to contain %v", rec.Body.String(), s)
+				}
+			}
+		})
+	}
+}
+
+func Test_redactSecret(t *testing.T) {
+	got, err := redactSecret([]byte(`{"name":"s","session_ticket_keys":{"keys":[{"inline_string":"k1"},{"filename":"/k2"}]},"generic_secret":{"secret":{"inline_string":"s"}}}`))
+	if err != nil {
+		t.Fatalf("redactSecret() error = %v", err)
+	}
+	want := `{"generic_secret":{"secret":{"inline_string":"[redacted]"}},"name":"s","session_ticket_keys":{"keys":[{"inline_string":"[redacted]"},{"inline_string":"[redacted]"}]}}`
+	if string(got) != want {
+		t.Errorf("redactSecret() = %s, want %s", got, want)
+	}
+}
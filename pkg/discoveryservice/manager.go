@@ -3,9 +3,7 @@ package discoveryservice
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"sync"
@@ -13,14 +11,19 @@ import (
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
 	marin3rcontroller "github.com/3scale/marin3r/controllers/marin3r"
+	"github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	xdss_metrics "github.com/3scale/marin3r/pkg/discoveryservice/metrics"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	envoy "github.com/3scale/marin3r/pkg/envoy"
 	rollback "github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/rollback"
-	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	util_runtime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 const (
@@ -45,25 +48,58 @@ type Manager struct {
 	Namespace string
 	// The xDS server port
 	XdsServerPort int
+	// The REST-JSON xDS server port. The REST server is disabled when 0.
+	RestServerPort int
 	// The mutating webhook server port
 	MetricsAddr string
+	// The address where the /healthz and /readyz endpoints are served
+	HealthProbeAddr string
+	// GrpcServerOptions are the settings of the xDS gRPC server
+	GrpcServerOptions GrpcServerOptions
+	// PublishOptions configure the debouncing of the snapshots published to the envoy clients
+	PublishOptions PublishOptions
 	// The directory where server certificate and key are located
 	ServerCertificatePath string
 	// The directory where the CA used to authenticate clients with the xDS server is
 	CACertificatePath string
 	// Cfg is the config to connect to the k8s API server
 	Cfg *rest.Config
+	// LeaderElection enables leader election between the discovery service replicas
+	LeaderElection bool
+	// ReplicaName is the name of this discovery service replica
+	ReplicaName string
+	// NodeIDAuthorizationPolicy is the policy used to check that the client
+	// certificate of an envoy is allowed to request the config of a node ID
+	NodeIDAuthorizationPolicy authz.Policy
+	// NodeIDMappings holds the node IDs each client certificate identity is
+	// allowed to request when the policy is authz.PolicyMapping
+	NodeIDMappings map[string][]string
+	// NodeHash computes the key of the snapshot served to each envoy, so
+	// several node IDs can share an EnvoyConfig. Snapshots are keyed by node ID when nil.
+	NodeHash *xdss.NodeHash
+	// The port of the debug server that dumps the xDS caches. The debug server is disabled when 0.
+	DebugServerPort int
+	// DebugAllowedIdentities are the client certificate identities allowed to use the debug server
+	DebugAllowedIdentities []string
 }
 
 // Start runs the DiscoveryServiceManager, which runs the EnvoyConfig and
-// EnvoyConfigRevision controller, the xDS server and the mutating webhook server
+// EnvoyConfigRevision controller, the xDS server and the mutating webhook server.
+// When several replicas run, all of them serve the xDS protocol but only the leader
+// runs the EnvoyConfig and Secret controllers.
+// +kubebuilder:rbac:groups="core",namespace=placeholder,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="core",namespace=placeholder,resources=events,verbs=create;patch
 func (dsm *Manager) Start(ctx context.Context) {
 
 	mgr, err := ctrl.NewManager(dsm.Cfg, ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: dsm.MetricsAddr,
-		LeaderElection:     false,
-		Namespace:          dsm.Namespace,
+		Scheme:                     scheme,
+		MetricsBindAddress:         dsm.MetricsAddr,
+		HealthProbeBindAddress:     dsm.HealthProbeAddr,
+		LeaderElection:             dsm.LeaderElection,
+		LeaderElectionID:           "discoveryservice.marin3r.3scale.net",
+		LeaderElectionNamespace:    dsm.Namespace,
+		LeaderElectionResourceLock: "configmaps",
+		Namespace:                  dsm.Namespace,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -75,30 +111,104 @@ func (dsm *Manager) Start(ctx context.Context) {
 
 	var wait sync.WaitGroup
 
+	// Load the certificates and watch them for changes
+	certificates, err := newCertificateReloader(dsm.ServerCertificatePath, dsm.CACertificatePath, setupLog.WithName("certificates"))
+	if err != nil {
+		setupLog.Error(err, "unable to load certificates")
+		os.Exit(1)
+	}
+	if err := mgr.Add(certificates); err != nil {
+		setupLog.Error(err, "unable to add the certificate reloader to the manager")
+		os.Exit(1)
+	}
+
+	// Reject the requests for node IDs the client certificate is not allowed to
+	// request. Denials are recorded as events of the discovery service replica.
+	recorder := mgr.GetEventRecorderFor("marin3r-discoveryservice")
+	authorizer := &authz.Authorizer{
+		Policy:   dsm.NodeIDAuthorizationPolicy,
+		Mappings: dsm.NodeIDMappings,
+		OnDenied: func(nodeID string, identities []string, api envoy.APIVersion) {
+			if dsm.ReplicaName == "" {
+				return
+			}
+			recorder.Eventf(
+				&corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: dsm.Namespace, Name: dsm.ReplicaName},
+				corev1.EventTypeWarning, "NodeIDNotAuthorized",
+				"Client with identities %v is not allowed to request the %s config of node ID %q", identities, api, nodeID,
+			)
+		},
+	}
+
+	// TLS config shared by the xDS and the debug servers. Clients
+	// need to present a certificate signed by the CA.
+	tlsConfig := certificates.TLSConfig(&tls.Config{
+		MinVersion:               tls.VersionTLS12,
+		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
+		PreferServerCipherSuites: true,
+		CipherSuites: []uint16{
+			// Sadly, these 2 non 256 are required to use http2 in go
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		// The config returned for each client is used as is for the handshake,
+		// so the protocols negotiated by the gRPC and REST servers need to be set
+		NextProtos: []string{"h2", "http/1.1"},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	})
+
 	// Start envoy's aggregated discovery service
 	xdss := NewDualXdsServer(
 		ctx,
 		uint(dsm.XdsServerPort),
-		&tls.Config{
-			MinVersion:               tls.VersionTLS12,
-			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-			PreferServerCipherSuites: true,
-			CipherSuites: []uint16{
-				// Sadly, these 2 non 256 are required to use http2 in go
-				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			},
-			Certificates: []tls.Certificate{loadCertificate(dsm.ServerCertificatePath, setupLog)},
-			ClientAuth:   tls.RequireAndVerifyClientCert,
-			ClientCAs:    loadCA(dsm.CACertificatePath, setupLog),
-		},
+		uint(dsm.RestServerPort),
+		tlsConfig,
+		dsm.GrpcServerOptions,
+		dsm.PublishOptions,
+		authorizer,
+		dsm.NodeHash,
 		rollback.OnError(mgr.GetClient()),
 		setupLog,
 	)
 
+	// Report the open xDS streams in the metrics endpoint
+	ctrlmetrics.Registry.MustRegister(xdss_metrics.StreamsCollector{Registry: xdss.GetClientRegistry()})
+
+	// Do not serve the xDS protocol until all the published
+	// revisions have been loaded into the xDS cache
+	warmer := newCacheWarmer(mgr.GetClient(), dsm.Namespace, xdss.GetCache, setupLog.WithName("warmup"))
+	if err := mgr.Add(warmer); err != nil {
+		setupLog.Error(err, "unable to add the cache warmer to the manager")
+		os.Exit(1)
+	}
+
+	// Serve the contents of the xDS caches to the allowed client identities
+	if dsm.DebugServerPort != 0 {
+		debug := newDebugServer(uint(dsm.DebugServerPort), tlsConfig, xdss.GetCache, dsm.DebugAllowedIdentities, setupLog.WithName("debug"))
+		if err := mgr.Add(debug); err != nil {
+			setupLog.Error(err, "unable to add the debug server to the manager")
+			os.Exit(1)
+		}
+	}
+
+	// The replica is ready once the xDS cache has been loaded
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("xds-cache", warmer.Check); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
 	wait.Add(1)
 	go func() {
 		defer wait.Done()
+		select {
+		case <-warmer.Ready():
+		case <-stopCh:
+			return
+		}
 		if err := xdss.Start(stopCh); err != nil {
 			setupLog.Error(err, "xDS server returned an unrecoverable error, shutting down")
 			os.Exit(1)
@@ -107,31 +217,44 @@ func (dsm *Manager) Start(ctx context.Context) {
 
 	// Start controllers
 	if err := (&marin3rcontroller.EnvoyConfigReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("envoyconfig"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("envoyconfig"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("envoyconfig"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "envoyconfig")
 		os.Exit(1)
 	}
 
 	if err := (&marin3rcontroller.EnvoyConfigRevisionReconciler{
-		Client:     mgr.GetClient(),
-		Log:        ctrl.Log.WithName("controllers").WithName(fmt.Sprintf("envoyconfigrevision_%s", string(envoy.APIv2))),
-		Scheme:     mgr.GetScheme(),
-		XdsCache:   xdss.GetCache(envoy.APIv2),
-		APIVersion: envoy.APIv2,
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName(fmt.Sprintf("envoyconfigrevision_%s", string(envoy.APIv2))),
+		Scheme:         mgr.GetScheme(),
+		XdsCache:       xdss.GetCache(envoy.APIv2),
+		APIVersion:     envoy.APIv2,
+		ClientRegistry: xdss.GetClientRegistry(),
+		Elected:        mgr.Elected(),
+		ReplicaName:    dsm.ReplicaName,
+		APIReader:      mgr.GetAPIReader(),
+		Canaries:       xdss.GetCanaries(envoy.APIv2),
+		OnCacheLoad:    warmer.RecordLoad,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", fmt.Sprintf("envoyconfigrevision_%s", string(envoy.APIv2)))
 		os.Exit(1)
 	}
 
 	if err := (&marin3rcontroller.EnvoyConfigRevisionReconciler{
-		Client:     mgr.GetClient(),
-		Log:        ctrl.Log.WithName("controllers").WithName(fmt.Sprintf("envoyconfigrevision_%s", string(envoy.APIv3))),
-		Scheme:     mgr.GetScheme(),
-		XdsCache:   xdss.GetCache(envoy.APIv3),
-		APIVersion: envoy.APIv3,
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName(fmt.Sprintf("envoyconfigrevision_%s", string(envoy.APIv3))),
+		Scheme:         mgr.GetScheme(),
+		XdsCache:       xdss.GetCache(envoy.APIv3),
+		APIVersion:     envoy.APIv3,
+		ClientRegistry: xdss.GetClientRegistry(),
+		Elected:        mgr.Elected(),
+		ReplicaName:    dsm.ReplicaName,
+		APIReader:      mgr.GetAPIReader(),
+		Canaries:       xdss.GetCanaries(envoy.APIv3),
+		OnCacheLoad:    warmer.RecordLoad,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", fmt.Sprintf("envoyconfigrevision_%s", string(envoy.APIv3)))
 		os.Exit(1)
@@ -146,6 +269,24 @@ func (dsm *Manager) Start(ctx context.Context) {
 		os.Exit(1)
 	}
 
+	if err := (&marin3rcontroller.ConfigMapReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("configmap"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "configmap")
+		os.Exit(1)
+	}
+
+	if err := (&marin3rcontroller.EndpointSliceReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("endpointslice"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "endpointslice")
+		os.Exit(1)
+	}
+
 	// Start the controllers
 	wait.Add(1)
 	go func() {
@@ -161,33 +302,6 @@ func (dsm *Manager) Start(ctx context.Context) {
 	setupLog.Info("Controller has shut down")
 }
 
-func loadCertificate(directory string, logger logr.Logger) tls.Certificate {
-	certificate, err := tls.LoadX509KeyPair(
-		fmt.Sprintf("%s/%s", directory, tlsCertificateFile),
-		fmt.Sprintf("%s/%s", directory, tlsCertificateKeyFile),
-	)
-	if err != nil {
-		logger.Error(err, "Could not load server certificate")
-		os.Exit(1)
-	}
-	return certificate
-}
-
-func loadCA(directory string, logger logr.Logger) *x509.CertPool {
-	certPool := x509.NewCertPool()
-	if bs, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", directory, tlsCertificateFile)); err != nil {
-		logger.Error(err, "Failed to read client ca cert")
-		os.Exit(1)
-	} else {
-		ok := certPool.AppendCertsFromPEM(bs)
-		if !ok {
-			logger.Error(err, "Failed to append client certs")
-			os.Exit(1)
-		}
-	}
-	return certPool
-}
-
 var onlyOneSignalHandler = make(chan struct{})
 
 // SetupSignalHandler registers for SIGTERM and SIGINT. A stop channel is returned
@@ -0,0 +1,252 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/registry"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "marin3r"
+	subsystem = "xdss"
+)
+
+var (
+	// Requests counts the discovery requests received, by node ID and type URL
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "requests_total",
+		Help:      "Total number of discovery requests received",
+	}, []string{"envoy_api", "node_id", "type_url"})
+
+	// Responses counts the discovery responses sent, by node ID and type URL
+	Responses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "responses_total",
+		Help:      "Total number of discovery responses sent",
+	}, []string{"envoy_api", "node_id", "type_url"})
+
+	// NACKs counts the discovery requests received that reject
+	// the last response, by node ID and type URL
+	NACKs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "nacks_total",
+		Help:      "Total number of NACKs received",
+	}, []string{"envoy_api", "node_id", "type_url"})
+
+	// SnapshotSetDuration observes the time it takes to write a
+	// snapshot into the xDS cache, which includes answering the
+	// open watches of the clients
+	SnapshotSetDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "snapshot_set_duration_seconds",
+		Help:      "Time it takes to write a snapshot into the xDS cache",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"envoy_api"})
+
+	// SnapshotResources holds the number of resources of each
+	// type in the last snapshot written for each node ID
+	SnapshotResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "snapshot_resources",
+		Help:      "Number of resources in the last snapshot written to the xDS cache",
+	}, []string{"envoy_api", "node_id", "resource_type"})
+
+	// ConfigACKDuration observes the time since an EnvoyConfig change is
+	// published until it is ACKed by a client
+	ConfigACKDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "config_ack_duration_seconds",
+		Help:      "Time from an EnvoyConfig change being published until a client ACKs it",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"envoy_api", "type_url"})
+
+	// NodeIDDenied counts the discovery requests rejected because the
+	// client certificate is not allowed to request the node ID. The node
+	// ID is not used as a label because it is chosen by the client.
+	NodeIDDenied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "node_id_denied_total",
+		Help:      "Total number of discovery requests rejected by the node ID authorization policy",
+	}, []string{"envoy_api"})
+
+	// SnapshotUpdatesMerged counts the snapshots that replaced a snapshot
+	// of the same node ID that was still waiting to be published
+	SnapshotUpdatesMerged = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "snapshot_updates_merged_total",
+		Help:      "Total number of snapshot updates merged into a pending publication",
+	}, []string{"envoy_api", "node_id"})
+
+	// SnapshotPublishDelay observes the time a snapshot waits
+	// in the publish queue before being written to the xDS cache
+	SnapshotPublishDelay = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "snapshot_publish_delay_seconds",
+		Help:      "Time a snapshot waits in the publish queue before being written to the xDS cache",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"envoy_api"})
+
+	openStreamsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "open_streams"),
+		"Number of open xDS streams",
+		[]string{"envoy_api", "node_id", "type_url"}, nil,
+	)
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		Requests,
+		Responses,
+		NACKs,
+		SnapshotSetDuration,
+		SnapshotResources,
+		ConfigACKDuration,
+		NodeIDDenied,
+		SnapshotUpdatesMerged,
+		SnapshotPublishDelay,
+	)
+}
+
+var snapshotTypes = []envoy.Type{envoy.Endpoint, envoy.Cluster, envoy.Route, envoy.Listener, envoy.Secret, envoy.Runtime,
+	envoy.ExtensionConfig, envoy.ScopedRoute, envoy.VirtualHost}
+
+// ObserveSnapshotSet records the time it took to write a snapshot for a
+// node ID into the xDS cache and the number of resources it holds
+func ObserveSnapshotSet(api envoy.APIVersion, nodeID string, snap xdss.Snapshot, elapsed time.Duration) {
+	SnapshotSetDuration.WithLabelValues(string(api)).Observe(elapsed.Seconds())
+	for _, rType := range snapshotTypes {
+		SnapshotResources.WithLabelValues(string(api), nodeID, string(rType)).Set(float64(len(snap.GetResources(rType))))
+	}
+}
+
+// ObserveSnapshotPublish records the time a snapshot
+// waited in the publish queue
+func ObserveSnapshotPublish(api envoy.APIVersion, delay time.Duration) {
+	SnapshotPublishDelay.WithLabelValues(string(api)).Observe(delay.Seconds())
+}
+
+// ForgetSnapshot removes the metrics of the snapshot of a node ID
+func ForgetSnapshot(api envoy.APIVersion, nodeID string) {
+	for _, rType := range snapshotTypes {
+		SnapshotResources.DeleteLabelValues(string(api), nodeID, string(rType))
+	}
+	SnapshotUpdatesMerged.DeleteLabelValues(string(api), nodeID)
+	ForgetPublication(api, nodeID)
+}
+
+type publication struct {
+	version   string
+	changedAt time.Time
+	// acked holds the streams and type URLs
+	// that have already ACKed the version
+	acked map[ack]struct{}
+}
+
+type ack struct {
+	stream  registry.StreamKey
+	typeURL string
+}
+
+var (
+	publicationsMu sync.Mutex
+	publications   = map[registry.NodeKey]publication{}
+)
+
+// RecordPublication records the time of the EnvoyConfig change that caused the given
+// version to be published for a snapshot key. It is a no-op if the version is already recorded.
+func RecordPublication(api envoy.APIVersion, key, version string, changedAt time.Time) {
+	publicationsMu.Lock()
+	defer publicationsMu.Unlock()
+
+	nodeKey := registry.NodeKey{NodeID: key, API: api}
+	if p, ok := publications[nodeKey]; ok && p.version == version {
+		return
+	}
+	publications[nodeKey] = publication{version: version, changedAt: changedAt, acked: map[ack]struct{}{}}
+}
+
+// ForgetPublication removes the publication record of a snapshot key
+func ForgetPublication(api envoy.APIVersion, key string) {
+	publicationsMu.Lock()
+	defer publicationsMu.Unlock()
+
+	delete(publications, registry.NodeKey{NodeID: key, API: api})
+}
+
+// ObserveACK observes the time since the publication of the given version if it is the
+// last version published for the snapshot key. Only the first ACK of the version received
+// in each stream is observed, as clients repeat the version in the requests that follow.
+// The hash suffix of the Secret and Endpoint versions is ignored.
+func ObserveACK(api envoy.APIVersion, key string, stream registry.StreamKey, typeURL, version string) {
+	publicationsMu.Lock()
+	defer publicationsMu.Unlock()
+
+	p, ok := publications[registry.NodeKey{NodeID: key, API: api}]
+	if !ok || p.version != xdss.ConfigVersion(typeURL, version) {
+		return
+	}
+	a := ack{stream: stream, typeURL: typeURL}
+	if _, ok := p.acked[a]; ok {
+		return
+	}
+	p.acked[a] = struct{}{}
+	ConfigACKDuration.WithLabelValues(string(api), typeURL).Observe(time.Since(p.changedAt).Seconds())
+}
+
+// StreamsCollector is a prometheus.Collector that reports the number
+// of open xDS streams using the information in a client registry
+type StreamsCollector struct {
+	Registry *registry.Registry
+}
+
+// Describe implements prometheus.Collector
+func (c StreamsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- openStreamsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c StreamsCollector) Collect(ch chan<- prometheus.Metric) {
+	type labels struct {
+		api, nodeID, typeURL string
+	}
+
+	counts := map[labels]int{}
+	for _, client := range c.Registry.AllClients() {
+		for _, ts := range client.Types {
+			counts[labels{string(client.Key.API), client.NodeID, ts.TypeURL}]++
+		}
+	}
+
+	for l, count := range counts {
+		ch <- prometheus.MustNewConstMetric(openStreamsDesc, prometheus.GaugeValue, float64(count), l.api, l.nodeID, l.typeURL)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/registry"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeSnapshot implements xdss.Snapshot. The xdss/v3 package
+// cannot be used as it imports this package.
+type fakeSnapshot struct {
+	xdss.Snapshot
+	resources map[envoy.Type]map[string]envoy.Resource
+}
+
+func (s fakeSnapshot) GetResources(rType envoy.Type) map[string]envoy.Resource {
+	return s.resources[rType]
+}
+
+func TestObserveSnapshotSet(t *testing.T) {
+	snap := fakeSnapshot{resources: map[envoy.Type]map[string]envoy.Resource{
+		envoy.Cluster: {
+			"cluster1": &envoy_config_cluster_v3.Cluster{Name: "cluster1"},
+			"cluster2": &envoy_config_cluster_v3.Cluster{Name: "cluster2"},
+		},
+	}}
+
+	ObserveSnapshotSet(envoy.APIv3, "snapshot-node", snap, time.Millisecond)
+
+	if got := testutil.ToFloat64(SnapshotResources.WithLabelValues("v3", "snapshot-node", string(envoy.Cluster))); got != 2 {
+		t.Errorf("ObserveSnapshotSet() clusters = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(SnapshotResources.WithLabelValues("v3", "snapshot-node", string(envoy.Listener))); got != 0 {
+		t.Errorf("ObserveSnapshotSet() listeners = %v, want 0", got)
+	}
+
+	ForgetSnapshot(envoy.APIv3, "snapshot-node")
+	if got := testutil.CollectAndCount(SnapshotResources); got != 0 {
+		t.Errorf("ForgetSnapshot() left %v series", got)
+	}
+}
+
+func TestObserveACK(t *testing.T) {
+	stream1 := registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: 1}
+	stream2 := registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: 2}
+	endpointsURL := "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+
+	type ack struct {
+		stream  registry.StreamKey
+		typeURL string
+		version string
+	}
+	tests := []struct {
+		name      string
+		published string
+		acks      []ack
+		want      uint64
+	}{
+		{"Observes ACKs of the published version", "1", []ack{{stream1, "type", "1"}}, 1},
+		{"Ignores ACKs of other versions", "1", []ack{{stream1, "type", "2"}}, 0},
+		{"Observes the first ACK of each stream", "1", []ack{{stream1, "type", "1"}, {stream1, "type", "1"}, {stream2, "type", "1"}}, 2},
+		{"Observes the first ACK of each type URL", "1", []ack{{stream1, "type", "1"}, {stream1, "other", "1"}}, 2},
+		{"Ignores the hash suffix of the endpoints version", "1", []ack{{stream1, endpointsURL, "1-6f8c2d"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ConfigACKDuration.Reset()
+			RecordPublication(envoy.APIv3, "ack-node", tt.published, time.Now().Add(-time.Second))
+			defer ForgetPublication(envoy.APIv3, "ack-node")
+
+			for _, a := range tt.acks {
+				ObserveACK(envoy.APIv3, "ack-node", a.stream, a.typeURL, a.version)
+			}
+			if got := ackObservations(t); got != tt.want {
+				t.Errorf("ObserveACK() made %v observations, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// ackObservations returns the number of observations of ConfigACKDuration
+func ackObservations(t *testing.T) uint64 {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ConfigACKDuration)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %v", err)
+	}
+	var count uint64
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			count += m.GetHistogram().GetSampleCount()
+		}
+	}
+	return count
+}
+
+func TestStreamsCollector(t *testing.T) {
+	r := registry.NewRegistry()
+	for id := int64(1); id <= 2; id++ {
+		key := registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id}
+		r.OpenStream(key, "")
+		r.RequestReceived(key, "node1", "type", "", nil)
+	}
+	// A stream that has not sent any request yet is not reported
+	r.OpenStream(registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: 1}, "")
+
+	expected := `
+# HELP marin3r_xdss_open_streams Number of open xDS streams
+# TYPE marin3r_xdss_open_streams gauge
+marin3r_xdss_open_streams{envoy_api="v3",node_id="node1",type_url="type"} 2
+`
+	if err := testutil.CollectAndCompare(StreamsCollector{Registry: r}, strings.NewReader(expected)); err != nil {
+		t.Errorf("StreamsCollector.Collect() %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/metrics"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	"github.com/go-logr/logr"
+)
+
+// PublishOptions configures the debouncing of the snapshots
+// written to the xDS caches
+type PublishOptions struct {
+	// DebounceWindow is the time a snapshot waits for newer snapshots of the same
+	// node ID before it is published. Each new snapshot restarts the wait. Snapshots
+	// are published straight away when it is 0.
+	DebounceWindow time.Duration
+	// MaxDelay is the maximum time a snapshot can be delayed by a continuous
+	// stream of newer snapshots of the same node ID
+	MaxDelay time.Duration
+}
+
+// Validate returns an error if any of the options holds an invalid value
+func (o PublishOptions) Validate() error {
+	if o.DebounceWindow < 0 {
+		return fmt.Errorf("debounce window cannot be negative")
+	}
+	if o.DebounceWindow > 0 && o.MaxDelay < o.DebounceWindow {
+		return fmt.Errorf("max delay must be greater than or equal to the debounce window")
+	}
+	return nil
+}
+
+// pendingSnapshot is a snapshot waiting to be published
+type pendingSnapshot struct {
+	snap   xdss.Snapshot
+	queued time.Time
+	merged int
+	timer  *time.Timer
+}
+
+// debouncedCache is an xdss.Cache that delays the publication of snapshots, so a burst of
+// changes for a node ID, like several edits of an EnvoyConfig or the rotation of the secrets
+// it uses, is merged into a single push to the envoy clients. GetSnapshot and NodeIDs only
+// see the published snapshots, the queued ones are looked up with GetPendingSnapshot.
+type debouncedCache struct {
+	xdss.Cache
+	api     envoy.APIVersion
+	options PublishOptions
+	logger  logr.Logger
+
+	mu      sync.Mutex
+	pending map[string]*pendingSnapshot
+}
+
+// newDebouncedCache wraps the given cache with a debouncedCache. The
+// cache is returned as is if the debounce window is 0.
+func newDebouncedCache(cache xdss.Cache, api envoy.APIVersion, options PublishOptions, logger logr.Logger) xdss.Cache {
+	if options.DebounceWindow <= 0 {
+		return cache
+	}
+	return &debouncedCache{
+		Cache:   cache,
+		api:     api,
+		options: options,
+		logger:  logger,
+		pending: map[string]*pendingSnapshot{},
+	}
+}
+
+// SetSnapshot queues the snapshot for publication. If there is already a snapshot
+// queued for the node ID it is replaced and the debounce window restarts, up to the
+// maximum delay since the first of the queued snapshots.
+func (dc *debouncedCache) SetSnapshot(nodeID string, snap xdss.Snapshot) error {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	p, ok := dc.pending[nodeID]
+	if !ok {
+		p = &pendingSnapshot{snap: snap, queued: time.Now()}
+		p.timer = time.AfterFunc(dc.options.DebounceWindow, func() { dc.publish(nodeID, p) })
+		dc.pending[nodeID] = p
+		return nil
+	}
+
+	p.snap = snap
+	p.merged++
+	metrics.SnapshotUpdatesMerged.WithLabelValues(string(dc.api), nodeID).Inc()
+
+	wait := dc.options.DebounceWindow
+	if remaining := dc.options.MaxDelay - time.Since(p.queued); remaining < wait {
+		wait = remaining
+	}
+	// If the timer has already fired the publication is waiting for the lock
+	// and will pick the new snapshot, so there is no need to reset it
+	if p.timer.Stop() {
+		if wait < 0 {
+			wait = 0
+		}
+		p.timer.Reset(wait)
+	}
+	return nil
+}
+
+// GetPendingSnapshot returns the snapshot queued for the node ID, if any. GetSnapshot
+// is not overridden, so it returns the published snapshot like for any other cache.
+func (dc *debouncedCache) GetPendingSnapshot(nodeID string) (xdss.Snapshot, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	p, ok := dc.pending[nodeID]
+	if !ok {
+		return nil, false
+	}
+	return p.snap, true
+}
+
+// Flush publishes the snapshot queued for the node ID straight away, if any. The
+// snapshot is kept queued if it cannot be published, and retried as usual.
+func (dc *debouncedCache) Flush(nodeID string) error {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	p, ok := dc.pending[nodeID]
+	if !ok {
+		return nil
+	}
+	if err := dc.Cache.SetSnapshot(nodeID, p.snap); err != nil {
+		return err
+	}
+	// The timer might have already fired, in which case the
+	// publication finds the snapshot is no longer queued
+	p.timer.Stop()
+	delete(dc.pending, nodeID)
+	metrics.ObserveSnapshotPublish(dc.api, time.Since(p.queued))
+	dc.logger.V(1).Info("Published snapshot", "NodeID", nodeID, "EnvoyAPI", dc.api, "MergedUpdates", p.merged)
+	return nil
+}
+
+// ClearSnapshot discards the snapshot queued for the node
+// ID and clears the published one
+func (dc *debouncedCache) ClearSnapshot(nodeID string) {
+	dc.mu.Lock()
+	if p, ok := dc.pending[nodeID]; ok {
+		p.timer.Stop()
+		delete(dc.pending, nodeID)
+	}
+	dc.mu.Unlock()
+
+	dc.Cache.ClearSnapshot(nodeID)
+}
+
+// publish writes a queued snapshot to the underlying cache
+func (dc *debouncedCache) publish(nodeID string, p *pendingSnapshot) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	// The snapshot might have been cleared while waiting for the lock
+	if dc.pending[nodeID] != p {
+		return
+	}
+
+	// Keep the snapshot queued and try again after the debounce
+	// window, unless a newer snapshot replaces it in the meantime
+	if err := dc.Cache.SetSnapshot(nodeID, p.snap); err != nil {
+		dc.logger.Error(err, "unable to publish snapshot, retrying", "NodeID", nodeID, "EnvoyAPI", dc.api)
+		p.timer = time.AfterFunc(dc.options.DebounceWindow, func() { dc.publish(nodeID, p) })
+		return
+	}
+	delete(dc.pending, nodeID)
+	metrics.ObserveSnapshotPublish(dc.api, time.Since(p.queued))
+	dc.logger.V(1).Info("Published snapshot", "NodeID", nodeID, "EnvoyAPI", dc.api, "MergedUpdates", p.merged)
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/metrics"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func testSnapshot(cache xdss.Cache, version string) xdss.Snapshot {
+	snap := cache.NewSnapshot(version)
+	snap.SetVersion(envoy.Cluster, version)
+	return snap
+}
+
+// waitForVersion waits until the snapshot published in the
+// cache for the node ID has the given cluster version
+func waitForVersion(cache xdss.Cache, nodeID, version string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if snap, err := cache.GetSnapshot(nodeID); err == nil && snap.GetVersion(envoy.Cluster) == version {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestPublishOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options PublishOptions
+		wantErr bool
+	}{
+		{"Disabled", PublishOptions{}, false},
+		{"Valid options", PublishOptions{DebounceWindow: 100 * time.Millisecond, MaxDelay: time.Second}, false},
+		{"Negative debounce window", PublishOptions{DebounceWindow: -time.Second}, true},
+		{"Max delay lower than the debounce window", PublishOptions{DebounceWindow: time.Second, MaxDelay: 100 * time.Millisecond}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("PublishOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newDebouncedCache_Disabled(t *testing.T) {
+	cache := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	if got := newDebouncedCache(cache, envoy.APIv3, PublishOptions{}, ctrl.Log); got != cache {
+		t.Errorf("newDebouncedCache() = %v, want the given cache", got)
+	}
+}
+
+func Test_debouncedCache_SetSnapshot(t *testing.T) {
+	published := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	cache := newDebouncedCache(published, envoy.APIv3, PublishOptions{DebounceWindow: 50 * time.Millisecond, MaxDelay: time.Second}, ctrl.Log)
+
+	for _, version := range []string{"1", "2", "3"} {
+		if err := cache.SetSnapshot("merge-node", testSnapshot(cache, version)); err != nil {
+			t.Fatalf("debouncedCache.SetSnapshot() error = %v", err)
+		}
+	}
+
+	if _, err := published.GetSnapshot("merge-node"); err == nil {
+		t.Errorf("debouncedCache.SetSnapshot() published the snapshot before the debounce window")
+	}
+	if _, err := cache.GetSnapshot("merge-node"); err == nil {
+		t.Errorf("debouncedCache.GetSnapshot() returned a snapshot that has not been published")
+	}
+	if snap, _ := xdss.GetLatestSnapshot(cache, "merge-node"); snap.GetVersion(envoy.Cluster) != "3" {
+		t.Errorf("xdss.GetLatestSnapshot() = %v, want the pending snapshot", snap.GetVersion(envoy.Cluster))
+	}
+	if !waitForVersion(published, "merge-node", "3", time.Second) {
+		t.Errorf("debouncedCache.SetSnapshot() did not publish the last snapshot")
+	}
+	if got := testutil.ToFloat64(metrics.SnapshotUpdatesMerged.WithLabelValues(string(envoy.APIv3), "merge-node")); got != 2 {
+		t.Errorf("debouncedCache.SetSnapshot() merged %v updates, want 2", got)
+	}
+}
+
+func Test_debouncedCache_MaxDelay(t *testing.T) {
+	published := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	cache := newDebouncedCache(published, envoy.APIv3, PublishOptions{DebounceWindow: 100 * time.Millisecond, MaxDelay: 200 * time.Millisecond}, ctrl.Log)
+
+	// Keep writing snapshots more often than the debounce window, so
+	// only the max delay can cause the snapshot to be published
+	start := time.Now()
+	for i := 0; time.Since(start) < 500*time.Millisecond; i++ {
+		cache.SetSnapshot("delay-node", testSnapshot(cache, "1"))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, err := published.GetSnapshot("delay-node"); err != nil {
+		t.Errorf("debouncedCache.SetSnapshot() did not publish the snapshot after the max delay")
+	}
+}
+
+func Test_debouncedCache_ClearSnapshot(t *testing.T) {
+	published := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	cache := newDebouncedCache(published, envoy.APIv3, PublishOptions{DebounceWindow: 50 * time.Millisecond, MaxDelay: time.Second}, ctrl.Log)
+
+	cache.SetSnapshot("clear-node", testSnapshot(cache, "1"))
+	cache.ClearSnapshot("clear-node")
+
+	if _, err := xdss.GetLatestSnapshot(cache, "clear-node"); err == nil {
+		t.Errorf("xdss.GetLatestSnapshot() returned a cleared snapshot")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := published.GetSnapshot("clear-node"); err == nil {
+		t.Errorf("debouncedCache.ClearSnapshot() did not discard the pending snapshot")
+	}
+}
+
+// failingCache is an xdss.Cache that fails to
+// set the first 'failures' snapshots written to it
+type failingCache struct {
+	xdss.Cache
+	failures int
+}
+
+func (fc *failingCache) SetSnapshot(nodeID string, snap xdss.Snapshot) error {
+	if fc.failures > 0 {
+		fc.failures--
+		return fmt.Errorf("failed to set snapshot")
+	}
+	return fc.Cache.SetSnapshot(nodeID, snap)
+}
+
+func Test_debouncedCache_PublishRetry(t *testing.T) {
+	published := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	cache := newDebouncedCache(&failingCache{Cache: published, failures: 2}, envoy.APIv3,
+		PublishOptions{DebounceWindow: 20 * time.Millisecond, MaxDelay: time.Second}, ctrl.Log)
+
+	cache.SetSnapshot("retry-node", testSnapshot(cache, "1"))
+
+	if !waitForVersion(published, "retry-node", "1", time.Second) {
+		t.Errorf("debouncedCache.SetSnapshot() did not retry the publication of the snapshot")
+	}
+	if _, ok := cache.(xdss.PendingCache).GetPendingSnapshot("retry-node"); ok {
+		t.Errorf("debouncedCache.GetPendingSnapshot() returned a published snapshot")
+	}
+}
+
+func Test_debouncedCache_Flush(t *testing.T) {
+	published := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	cache := newDebouncedCache(published, envoy.APIv3, PublishOptions{DebounceWindow: time.Hour, MaxDelay: time.Hour}, ctrl.Log)
+
+	if err := cache.(xdss.PendingCache).Flush("flush-node"); err != nil {
+		t.Errorf("debouncedCache.Flush() error = %v without a queued snapshot", err)
+	}
+
+	cache.SetSnapshot("flush-node", testSnapshot(cache, "1"))
+	if err := cache.(xdss.PendingCache).Flush("flush-node"); err != nil {
+		t.Fatalf("debouncedCache.Flush() error = %v", err)
+	}
+	if snap, err := published.GetSnapshot("flush-node"); err != nil || snap.GetVersion(envoy.Cluster) != "1" {
+		t.Errorf("debouncedCache.Flush() did not publish the snapshot")
+	}
+	if _, ok := cache.(xdss.PendingCache).GetPendingSnapshot("flush-node"); ok {
+		t.Errorf("debouncedCache.GetPendingSnapshot() returned a flushed snapshot")
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	"google.golang.org/grpc/peer"
+)
+
+// StreamKind identifies the variant of the xDS protocol used in a stream
+type StreamKind string
+
+const (
+	// SotW is the state of the world variant of the xDS protocol
+	SotW StreamKind = "sotw"
+	// Delta is the incremental variant of the xDS protocol
+	Delta StreamKind = "delta"
+)
+
+// StreamKey uniquely identifies an xDS stream. Stream IDs are only unique
+// within each of the xDS servers, so the API version and the protocol variant
+// are required to tell streams apart.
+type StreamKey struct {
+	API  envoy.APIVersion
+	Kind StreamKind
+	ID   int64
+}
+
+// String returns the string representation of a StreamKey
+func (k StreamKey) String() string {
+	return fmt.Sprintf("%s/%s/%d", k.API, k.Kind, k.ID)
+}
+
+// NodeKey identifies the clients of a given node ID and envoy API version
+type NodeKey struct {
+	NodeID string
+	API    envoy.APIVersion
+}
+
+// TypeStatus holds the last observations for a resource type in a stream
+type TypeStatus struct {
+	// TypeURL is the type URL of the resource type
+	TypeURL string
+	// AckedVersion is the last version ACKed by the client
+	AckedVersion string
+	// NackedVersion is the last version NACKed by the client. It is cleared
+	// as soon as a newer version is ACKed.
+	NackedVersion string
+	// NackMessage is the error detail sent by the client in the last NACK
+	NackMessage string
+	// NackedAt is the time the client first NACKed NackedVersion
+	NackedAt time.Time
+	// LastUpdate is the time of the last ACK or NACK
+	LastUpdate time.Time
+}
+
+// Client holds the information of an envoy client connected to the
+// discovery service
+type Client struct {
+	// Key identifies the stream the client is connected through
+	Key StreamKey
+	// NodeID is the node ID the client presented in its requests
+	NodeID string
+	// PeerAddress is the address of the client
+	PeerAddress string
+	// ConnectedAt is the time the stream was opened
+	ConnectedAt time.Time
+	// Types holds the status of each resource type the client has
+	// requested, sorted by type URL
+	Types []TypeStatus
+}
+
+// AckedVersion returns the version ACKed by the client if it is the same for all
+// the resource types it has requested, or the empty string otherwise. Secret
+// versions are suffixed with the hash of the secrets, so the versionFn is used
+// to normalize the versions of each type before comparing them.
+func (c Client) AckedVersion(versionFn func(typeURL, version string) string) string {
+	acked := ""
+	for idx, ts := range c.Types {
+		version := versionFn(ts.TypeURL, ts.AckedVersion)
+		if version == "" || (idx > 0 && version != acked) {
+			return ""
+		}
+		acked = version
+	}
+	return acked
+}
+
+// NackedVersion returns the last version NACKed by the client for any of the resource
+// types, normalized with versionFn, along with the error message and the NACK time
+func (c Client) NackedVersion(versionFn func(typeURL, version string) string) (string, string, time.Time) {
+	var last TypeStatus
+	for _, ts := range c.Types {
+		if ts.NackedVersion != "" && ts.LastUpdate.After(last.LastUpdate) {
+			last = ts
+		}
+	}
+	if last.NackedVersion == "" {
+		return "", "", time.Time{}
+	}
+	return versionFn(last.TypeURL, last.NackedVersion), last.NackMessage, last.NackedAt
+}
+
+type stream struct {
+	client Client
+	types  map[string]*TypeStatus
+	// pending holds the version sent in each response
+	// that has not been ACKed/NACKed yet, by nonce
+	pending map[string]string
+}
+
+// changeSet holds the nodes of an envoy API version whose clients have changed
+type changeSet struct {
+	nodes  map[NodeKey]struct{}
+	notify chan struct{}
+}
+
+// Registry keeps track of the envoy clients connected to the
+// discovery service and the versions they have ACKed/NACKed. It is
+// safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	streams map[StreamKey]*stream
+	// changed is kept per envoy API version so the consumers of
+	// each API don't drain the changes of the other one
+	changed map[envoy.APIVersion]*changeSet
+}
+
+// NewRegistry returns a new empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		streams: map[StreamKey]*stream{},
+		changed: map[envoy.APIVersion]*changeSet{},
+	}
+}
+
+// OpenStream registers a new stream
+func (r *Registry) OpenStream(key StreamKey, peerAddress string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.streams[key] = &stream{
+		client:  Client{Key: key, PeerAddress: peerAddress, ConnectedAt: time.Now()},
+		types:   map[string]*TypeStatus{},
+		pending: map[string]string{},
+	}
+}
+
+// CloseStream removes a stream from the registry
+func (r *Registry) CloseStream(key StreamKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.streams[key]
+	if !ok {
+		return
+	}
+	delete(r.streams, key)
+	if s.client.NodeID != "" {
+		r.markChanged(NodeKey{NodeID: s.client.NodeID, API: key.API})
+	}
+}
+
+// ResponseSent records the version sent to the client in the response with the
+// given nonce, so it can be matched with the ACK/NACK that the client sends back
+func (r *Registry) ResponseSent(key StreamKey, typeURL, nonce, version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.streams[key]
+	if !ok || nonce == "" {
+		return
+	}
+	s.pending[nonce] = version
+}
+
+// RequestReceived records a request received from the client. When the request
+// carries the nonce of a previous response it is either an ACK or, if nackMsg is
+// not nil, a NACK of the version sent in that response. The ACKed version is
+// returned when the request is an ACK.
+func (r *Registry) RequestReceived(key StreamKey, nodeID, typeURL, nonce string, nackMsg *string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.streams[key]
+	if !ok {
+		return ""
+	}
+
+	changed := false
+	if s.client.NodeID != nodeID && nodeID != "" {
+		if s.client.NodeID != "" {
+			r.markChanged(NodeKey{NodeID: s.client.NodeID, API: key.API})
+		}
+		s.client.NodeID = nodeID
+		changed = true
+	}
+
+	ts, ok := s.types[typeURL]
+	if !ok {
+		ts = &TypeStatus{TypeURL: typeURL}
+		s.types[typeURL] = ts
+		changed = true
+	}
+
+	acked := ""
+	if version, ok := s.pending[nonce]; ok {
+		delete(s.pending, nonce)
+		ts.LastUpdate = time.Now()
+		if nackMsg != nil {
+			changed = changed || ts.NackedVersion != version || ts.NackMessage != *nackMsg
+			if ts.NackedVersion != version {
+				ts.NackedAt = ts.LastUpdate
+			}
+			ts.NackedVersion = version
+			ts.NackMessage = *nackMsg
+		} else {
+			changed = changed || ts.AckedVersion != version || ts.NackedVersion != ""
+			ts.AckedVersion = version
+			ts.NackedVersion = ""
+			ts.NackMessage = ""
+			ts.NackedAt = time.Time{}
+			acked = version
+		}
+	}
+
+	if changed && s.client.NodeID != "" {
+		r.markChanged(NodeKey{NodeID: s.client.NodeID, API: key.API})
+	}
+	return acked
+}
+
+// Clients returns the clients connected with the given node ID and
+// envoy API version, sorted by stream
+func (r *Registry) Clients(nodeID string, api envoy.APIVersion) []Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clients := []Client{}
+	for key, s := range r.streams {
+		if s.client.NodeID != nodeID || key.API != api {
+			continue
+		}
+		clients = append(clients, s.copyClient())
+	}
+
+	sortClients(clients)
+	return clients
+}
+
+// AllClients returns all the clients that have already
+// sent their node ID, sorted by stream
+func (r *Registry) AllClients() []Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clients := []Client{}
+	for _, s := range r.streams {
+		if s.client.NodeID == "" {
+			continue
+		}
+		clients = append(clients, s.copyClient())
+	}
+	sortClients(clients)
+	return clients
+}
+
+// Changed returns a channel that receives a value whenever there are nodes of the
+// given envoy API version whose clients have changed. Use PopChanged to retrieve them.
+func (r *Registry) Changed(api envoy.APIVersion) <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.changeSet(api).notify
+}
+
+// PopChanged returns the nodes of the given envoy API version whose
+// clients have changed since the last call to PopChanged for that version
+func (r *Registry) PopChanged(api envoy.APIVersion) []NodeKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cs := r.changeSet(api)
+	nodes := make([]NodeKey, 0, len(cs.nodes))
+	for node := range cs.nodes {
+		nodes = append(nodes, node)
+	}
+	cs.nodes = map[NodeKey]struct{}{}
+	return nodes
+}
+
+func (r *Registry) markChanged(node NodeKey) {
+	cs := r.changeSet(node.API)
+	cs.nodes[node] = struct{}{}
+	select {
+	case cs.notify <- struct{}{}:
+	default:
+	}
+}
+
+// changeSet returns the changeSet of an envoy API version,
+// creating it if needed. The lock must be held by the caller.
+func (r *Registry) changeSet(api envoy.APIVersion) *changeSet {
+	cs, ok := r.changed[api]
+	if !ok {
+		cs = &changeSet{nodes: map[NodeKey]struct{}{}, notify: make(chan struct{}, 1)}
+		r.changed[api] = cs
+	}
+	return cs
+}
+
+func (s *stream) copyClient() Client {
+	c := s.client
+	c.Types = make([]TypeStatus, 0, len(s.types))
+	for _, ts := range s.types {
+		c.Types = append(c.Types, *ts)
+	}
+	sort.Slice(c.Types, func(i, j int) bool { return c.Types[i].TypeURL < c.Types[j].TypeURL })
+	return c
+}
+
+// PeerAddress returns the address of the client of a gRPC
+// stream, or the empty string if it cannot be determined
+func PeerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+func sortClients(clients []Client) {
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Key.API != clients[j].Key.API {
+			return clients[i].Key.API < clients[j].Key.API
+		}
+		if clients[i].Key.Kind != clients[j].Key.Kind {
+			return clients[i].Key.Kind < clients[j].Key.Kind
+		}
+		return clients[i].Key.ID < clients[j].Key.ID
+	})
+}
@@ -0,0 +1,302 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	"google.golang.org/grpc/peer"
+)
+
+const clusterType = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+
+func stringPtr(s string) *string { return &s }
+
+func TestRegistry(t *testing.T) {
+	key := StreamKey{API: envoy.APIv3, Kind: SotW, ID: 1}
+
+	tests := []struct {
+		name        string
+		run         func(r *Registry)
+		wantTypes   []TypeStatus
+		wantChanged []NodeKey
+	}{
+		{
+			name: "Registers the node and the requested types",
+			run: func(r *Registry) {
+				r.RequestReceived(key, "node1", clusterType, "", nil)
+			},
+			wantTypes:   []TypeStatus{{TypeURL: clusterType}},
+			wantChanged: []NodeKey{{NodeID: "node1", API: envoy.APIv3}},
+		},
+		{
+			name: "Records ACKs",
+			run: func(r *Registry) {
+				r.RequestReceived(key, "node1", clusterType, "", nil)
+				r.ResponseSent(key, clusterType, "1", "aaaa")
+				r.RequestReceived(key, "node1", clusterType, "1", nil)
+			},
+			wantTypes:   []TypeStatus{{TypeURL: clusterType, AckedVersion: "aaaa"}},
+			wantChanged: []NodeKey{{NodeID: "node1", API: envoy.APIv3}},
+		},
+		{
+			name: "Records NACKs",
+			run: func(r *Registry) {
+				r.RequestReceived(key, "node1", clusterType, "", nil)
+				r.ResponseSent(key, clusterType, "1", "aaaa")
+				r.RequestReceived(key, "node1", clusterType, "1", nil)
+				r.ResponseSent(key, clusterType, "2", "bbbb")
+				r.RequestReceived(key, "node1", clusterType, "2", stringPtr("error"))
+			},
+			wantTypes:   []TypeStatus{{TypeURL: clusterType, AckedVersion: "aaaa", NackedVersion: "bbbb", NackMessage: "error"}},
+			wantChanged: []NodeKey{{NodeID: "node1", API: envoy.APIv3}},
+		},
+		{
+			name: "Ignores unknown nonces",
+			run: func(r *Registry) {
+				r.RequestReceived(key, "node1", clusterType, "", nil)
+				r.PopChanged(envoy.APIv3)
+				r.RequestReceived(key, "node1", clusterType, "7", nil)
+			},
+			wantTypes:   []TypeStatus{{TypeURL: clusterType}},
+			wantChanged: []NodeKey{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.OpenStream(key, "10.0.0.1:5000")
+			tt.run(r)
+
+			clients := r.Clients("node1", envoy.APIv3)
+			if len(clients) != 1 {
+				t.Fatalf("Registry.Clients() returned %v clients, want 1", len(clients))
+			}
+			types := clients[0].Types
+			for idx := range types {
+				if types[idx].NackedAt.IsZero() != (types[idx].NackedVersion == "") {
+					t.Errorf("Registry.Clients() NackedAt = %v for NackedVersion %q", types[idx].NackedAt, types[idx].NackedVersion)
+				}
+				types[idx].LastUpdate = tt.wantTypes[idx].LastUpdate
+				types[idx].NackedAt = tt.wantTypes[idx].NackedAt
+			}
+			if !reflect.DeepEqual(types, tt.wantTypes) {
+				t.Errorf("Registry.Clients() types = %v, want %v", types, tt.wantTypes)
+			}
+			if got := r.PopChanged(envoy.APIv3); !reflect.DeepEqual(got, tt.wantChanged) {
+				t.Errorf("Registry.PopChanged() = %v, want %v", got, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestRegistry_CloseStream(t *testing.T) {
+	r := NewRegistry()
+	keys := []StreamKey{
+		{API: envoy.APIv3, Kind: SotW, ID: 1},
+		{API: envoy.APIv3, Kind: Delta, ID: 1},
+		{API: envoy.APIv2, Kind: SotW, ID: 1},
+	}
+	for _, key := range keys {
+		r.OpenStream(key, "")
+		r.RequestReceived(key, "node1", clusterType, "", nil)
+	}
+	r.PopChanged(envoy.APIv3)
+
+	if got := len(r.Clients("node1", envoy.APIv3)); got != 2 {
+		t.Errorf("Registry.Clients() returned %v clients, want 2", got)
+	}
+
+	r.CloseStream(keys[0])
+	select {
+	case <-r.Changed(envoy.APIv3):
+	default:
+		t.Errorf("Registry.Changed() expected a notification")
+	}
+	if got := r.PopChanged(envoy.APIv3); !reflect.DeepEqual(got, []NodeKey{{NodeID: "node1", API: envoy.APIv3}}) {
+		t.Errorf("Registry.PopChanged() = %v", got)
+	}
+	if got := r.Clients("node1", envoy.APIv3); len(got) != 1 || got[0].Key != keys[1] {
+		t.Errorf("Registry.Clients() = %v", got)
+	}
+}
+
+func TestRegistry_PopChanged(t *testing.T) {
+	r := NewRegistry()
+	keys := []StreamKey{
+		{API: envoy.APIv2, Kind: SotW, ID: 1},
+		{API: envoy.APIv3, Kind: SotW, ID: 1},
+	}
+
+	// each consumer collects the changes of its own API version,
+	// as the reconcilers of EnvoyConfigRevisions do
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := map[envoy.APIVersion]chan []NodeKey{}
+	for _, api := range []envoy.APIVersion{envoy.APIv2, envoy.APIv3} {
+		results[api] = make(chan []NodeKey)
+		go func(api envoy.APIVersion, changed <-chan struct{}, out chan<- []NodeKey) {
+			for {
+				select {
+				case <-changed:
+					if nodes := r.PopChanged(api); len(nodes) > 0 {
+						out <- nodes
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(api, r.Changed(api), results[api])
+	}
+
+	for _, key := range keys {
+		r.OpenStream(key, "")
+		r.RequestReceived(key, "node1", clusterType, "", nil)
+	}
+
+	for _, api := range []envoy.APIVersion{envoy.APIv2, envoy.APIv3} {
+		select {
+		case got := <-results[api]:
+			if want := []NodeKey{{NodeID: "node1", API: api}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Registry.PopChanged(%s) = %v, want %v", api, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Registry.Changed(%s) expected a notification", api)
+		}
+	}
+}
+
+func TestClient_AckedVersion(t *testing.T) {
+	identity := func(typeURL, version string) string { return version }
+	tests := []struct {
+		name   string
+		client Client
+		want   string
+	}{
+		{
+			name: "Returns the version if all types have ACKed the same one",
+			client: Client{Types: []TypeStatus{
+				{TypeURL: "a", AckedVersion: "1"}, {TypeURL: "b", AckedVersion: "1"},
+			}},
+			want: "1",
+		},
+		{
+			name: "Returns empty if types have ACKed different versions",
+			client: Client{Types: []TypeStatus{
+				{TypeURL: "a", AckedVersion: "1"}, {TypeURL: "b", AckedVersion: "2"},
+			}},
+			want: "",
+		},
+		{
+			name: "Returns empty if a type has not ACKed any version yet",
+			client: Client{Types: []TypeStatus{
+				{TypeURL: "a", AckedVersion: "1"}, {TypeURL: "b"},
+			}},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.AckedVersion(identity); got != tt.want {
+				t.Errorf("Client.AckedVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_NackedVersion(t *testing.T) {
+	identity := func(typeURL, version string) string { return version }
+	t1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	tests := []struct {
+		name        string
+		client      Client
+		wantVersion string
+		wantMsg     string
+		wantAt      time.Time
+	}{
+		{
+			name: "Returns the most recent NACK of any type",
+			client: Client{Types: []TypeStatus{
+				{TypeURL: "a", NackedVersion: "1", NackMessage: "old", NackedAt: t1, LastUpdate: t1},
+				{TypeURL: "b", NackedVersion: "2", NackMessage: "new", NackedAt: t2, LastUpdate: t2},
+			}},
+			wantVersion: "2", wantMsg: "new", wantAt: t2,
+		},
+		{
+			name: "Returns empty if no type has been NACKed",
+			client: Client{Types: []TypeStatus{
+				{TypeURL: "a", AckedVersion: "1", LastUpdate: t1},
+			}},
+			wantVersion: "", wantMsg: "", wantAt: time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, msg, at := tt.client.NackedVersion(identity)
+			if version != tt.wantVersion || msg != tt.wantMsg || !at.Equal(tt.wantAt) {
+				t.Errorf("Client.NackedVersion() = %v, %v, %v, want %v, %v, %v", version, msg, at, tt.wantVersion, tt.wantMsg, tt.wantAt)
+			}
+		})
+	}
+}
+
+func TestRegistry_NackedAt(t *testing.T) {
+	key := StreamKey{API: envoy.APIv3, Kind: SotW, ID: 1}
+	r := NewRegistry()
+	r.OpenStream(key, "10.0.0.1:5000")
+	r.RequestReceived(key, "node1", clusterType, "", nil)
+	r.ResponseSent(key, clusterType, "1", "aaaa")
+	r.RequestReceived(key, "node1", clusterType, "1", stringPtr("error"))
+	first := r.Clients("node1", envoy.APIv3)[0].Types[0].NackedAt
+
+	// The same version is NACKed again
+	r.ResponseSent(key, clusterType, "2", "aaaa")
+	r.RequestReceived(key, "node1", clusterType, "2", stringPtr("error"))
+	if got := r.Clients("node1", envoy.APIv3)[0].Types[0].NackedAt; !got.Equal(first) {
+		t.Errorf("Registry.Clients() NackedAt = %v, want %v", got, first)
+	}
+
+	// A newer version is ACKed
+	r.ResponseSent(key, clusterType, "3", "bbbb")
+	r.RequestReceived(key, "node1", clusterType, "3", nil)
+	if got := r.Clients("node1", envoy.APIv3)[0].Types[0].NackedAt; !got.IsZero() {
+		t.Errorf("Registry.Clients() NackedAt = %v, want zero", got)
+	}
+}
+
+func TestPeerAddress(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"Returns the peer address", peer.NewContext(context.Background(), &peer.Peer{Addr: addr}), "10.0.0.1:5000"},
+		{"Returns empty without peer", context.Background(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PeerAddress(tt.ctx); got != tt.want {
+				t.Errorf("PeerAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
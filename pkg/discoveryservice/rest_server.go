@@ -0,0 +1,101 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	server_v2 "github.com/envoyproxy/go-control-plane/pkg/server/v2"
+	server_v3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// restHandler is an http.Handler that serves the REST-JSON variant of the
+// xDS protocol for both envoy API versions. Requests are routed to the
+// gateway of the API version in the path (/v2/discovery:clusters,
+// /v3/discovery:listeners ...) and resolved using the snapshot caches.
+type restHandler struct {
+	gatewayV2 *server_v2.HTTPGateway
+	gatewayV3 *server_v3.HTTPGateway
+	logger    logr.Logger
+}
+
+func newRestHandler(srvV2 server_v2.Server, srvV3 server_v3.Server, logger logr.Logger) *restHandler {
+	return &restHandler{
+		gatewayV2: &server_v2.HTTPGateway{Server: srvV2, Log: clogger{Logger: logger}},
+		gatewayV3: &server_v3.HTTPGateway{Server: srvV3, Log: clogger{Logger: logger}},
+		logger:    logger,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *restHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Expose the client certificate the same way the gRPC server
+	// does, so the fetch requests can be authorized by the callbacks
+	ctx := req.Context()
+	if req.TLS != nil {
+		addr, _ := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr, AuthInfo: credentials.TLSInfo{State: *req.TLS}})
+	}
+	ctx, denied := authz.WithDenialTracking(ctx)
+	req = req.WithContext(ctx)
+
+	var body []byte
+	var code int
+	var err error
+
+	switch p := path.Clean(req.URL.Path); {
+	case strings.HasPrefix(p, "/v2/"):
+		body, code, err = h.gatewayV2.ServeHTTP(req)
+	case strings.HasPrefix(p, "/v3/"):
+		body, code, err = h.gatewayV3.ServeHTTP(req)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	if err != nil {
+		if denied() {
+			code = http.StatusForbidden
+		}
+		h.logger.V(1).Info("Error serving fetch request", "Path", req.URL.Path, "Code", code, "Error", err.Error())
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if code == http.StatusNotModified {
+		w.WriteHeader(code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(body); err != nil {
+		h.logger.Error(err, "Error writing fetch response", "Path", req.URL.Path)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authz "github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	xdss_v2 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v2"
+	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	server_v2 "github.com/envoyproxy/go-control-plane/pkg/server/v2"
+	server_v3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func testRestHandler(onError onErrorFn, authorizer *authz.Authorizer) *restHandler {
+	cacheV2 := cache_v2.NewSnapshotCache(true, cache_v2.IDHash{}, nil)
+	cacheV2.SetSnapshot("node1", cache_v2.NewSnapshot("1", nil,
+		[]cache_types.Resource{&envoy_api_v2.Cluster{Name: "cluster1"}}, nil, nil, nil, nil))
+	cacheV3 := cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)
+	cacheV3.SetSnapshot("node1", cache_v3.NewSnapshot("1", nil,
+		[]cache_types.Resource{&envoy_config_cluster_v3.Cluster{Name: "cluster1"}}, nil, nil, nil, nil))
+
+	return newRestHandler(
+		server_v2.NewServer(context.Background(), cacheV2, &xdss_v2.Callbacks{OnError: onError, SnapshotCache: &cacheV2, Logger: ctrl.Log, Authorizer: authorizer}),
+		server_v3.NewServer(context.Background(), cacheV3, &xdss_v3.Callbacks{OnError: onError, SnapshotCache: &cacheV3, Logger: ctrl.Log, Authorizer: authorizer}),
+		ctrl.Log,
+	)
+}
+
+func Test_restHandler_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Returns the v3 clusters",
+			method:   http.MethodPost,
+			path:     "/v3/discovery:clusters",
+			body:     `{"node":{"id":"node1"}}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"version_info":"1","resources":[{"@type":"type.googleapis.com/envoy.config.cluster.v3.Cluster","name":"cluster1"}],"type_url":"type.googleapis.com/envoy.config.cluster.v3.Cluster"}`,
+		},
+		{
+			name:     "Returns the v2 clusters",
+			method:   http.MethodPost,
+			path:     "/v2/discovery:clusters",
+			body:     `{"node":{"id":"node1"}}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"version_info":"1","resources":[{"@type":"type.googleapis.com/envoy.api.v2.Cluster","name":"cluster1"}],"type_url":"type.googleapis.com/envoy.api.v2.Cluster"}`,
+		},
+		{
+			name:     "Returns 304 if the client already has the current version",
+			method:   http.MethodPost,
+			path:     "/v3/discovery:clusters",
+			body:     `{"node":{"id":"node1"},"version_info":"1"}`,
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "Returns 500 if the node has no snapshot",
+			method:   http.MethodPost,
+			path:     "/v3/discovery:clusters",
+			body:     `{"node":{"id":"node2"}}`,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "Returns 500 if the node is missing",
+			method:   http.MethodPost,
+			path:     "/v3/discovery:clusters",
+			body:     `{}`,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "Returns 400 if the body is not valid",
+			method:   http.MethodPost,
+			path:     "/v3/discovery:clusters",
+			body:     `xxxx`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Returns 404 for unknown resource types",
+			method:   http.MethodPost,
+			path:     "/v3/discovery:xxxx",
+			body:     `{"node":{"id":"node1"}}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Returns 404 for unknown API versions",
+			method:   http.MethodPost,
+			path:     "/v4/discovery:clusters",
+			body:     `{"node":{"id":"node1"}}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Returns 405 for methods other than POST",
+			method:   http.MethodGet,
+			path:     "/v3/discovery:clusters",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testRestHandler(fn, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Errorf("restHandler.ServeHTTP() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("restHandler.ServeHTTP() body = %v, want %v", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_restHandler_ServeHTTP_NACK(t *testing.T) {
+	var gotNodeID, gotVersion string
+	var gotAPI envoy.APIVersion
+	h := testRestHandler(func(nodeID, version, msg string, envoyAPI envoy.APIVersion) error {
+		gotNodeID, gotVersion, gotAPI = nodeID, version, envoyAPI
+		return nil
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v3/discovery:listeners",
+		strings.NewReader(`{"node":{"id":"node1"},"version_info":"0","error_detail":{"code":3,"message":"error"}}`)))
+
+	if gotNodeID != "node1" || gotVersion != "1" || gotAPI != envoy.APIv3 {
+		t.Errorf("restHandler.ServeHTTP() OnError called with (%q, %q, %q), want (%q, %q, %q)",
+			gotNodeID, gotVersion, gotAPI, "node1", "1", envoy.APIv3)
+	}
+}
+
+func Test_restHandler_ServeHTTP_NodeIDAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		commonName string
+		path       string
+		wantCode   int
+	}{
+		{
+			name:       "Allows a client to request its own node ID",
+			commonName: "node1",
+			path:       "/v3/discovery:clusters",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "Returns 403 if the client requests another node ID",
+			commonName: "node2",
+			path:       "/v3/discovery:clusters",
+			wantCode:   http.StatusForbidden,
+		},
+		{
+			name:       "Returns 403 if the client requests another node ID (v2)",
+			commonName: "node2",
+			path:       "/v2/discovery:clusters",
+			wantCode:   http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testRestHandler(fn, &authz.Authorizer{Policy: authz.PolicyExact})
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"node":{"id":"node1"}}`))
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: tt.commonName}}}}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("restHandler.ServeHTTP() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
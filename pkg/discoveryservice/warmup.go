@@ -0,0 +1,192 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const cacheWarmupInterval = time.Second
+
+// cacheWarmer is a manager.Runnable that waits until all the published EnvoyConfigRevisions
+// have been loaded into the xDS caches and then closes the ready channel. This avoids a new
+// discovery service replica serving empty configurations to the envoy clients that connect
+// to it before it has caught up with the rest of the replicas. Revisions that have failed
+// to load, like the ones that reference a Secret that does not exist, do not block the
+// warm up, as they would otherwise keep the replica from serving any node ID.
+type cacheWarmer struct {
+	client    client.Reader
+	namespace string
+	caches    func(envoy.APIVersion) xdss.Cache
+	ready     chan struct{}
+	logger    logr.Logger
+
+	mu sync.Mutex
+	// loadErrors holds the error of the last attempt to load
+	// each revision, for the revisions that failed to load
+	loadErrors map[types.NamespacedName]string
+	// pending holds the revisions the cache is waiting for
+	pending []string
+}
+
+func newCacheWarmer(cl client.Reader, namespace string, caches func(envoy.APIVersion) xdss.Cache, logger logr.Logger) *cacheWarmer {
+	return &cacheWarmer{
+		client:     cl,
+		namespace:  namespace,
+		caches:     caches,
+		ready:      make(chan struct{}),
+		logger:     logger,
+		loadErrors: map[types.NamespacedName]string{},
+	}
+}
+
+// Start implements manager.Runnable
+func (cw *cacheWarmer) Start(ctx context.Context) error {
+	err := wait.PollImmediateUntil(cacheWarmupInterval, func() (bool, error) {
+		warm, err := cw.isCacheWarm(ctx)
+		if err != nil {
+			// Keep polling, the error might be transient
+			cw.logger.Error(err, "unable to check the xDS cache")
+			return false, nil
+		}
+		return warm, nil
+	}, ctx.Done())
+	if err != nil {
+		// The context has been cancelled
+		return nil
+	}
+
+	if failed := cw.failedRevisions(); len(failed) > 0 {
+		cw.logger.Info("xDS cache loaded, some published revisions failed to load", "Revisions", failed)
+	} else {
+		cw.logger.Info("xDS cache loaded with all the published revisions")
+	}
+	close(cw.ready)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (cw *cacheWarmer) NeedLeaderElection() bool {
+	return false
+}
+
+// Ready returns a channel that is closed once the xDS cache is warm
+func (cw *cacheWarmer) Ready() <-chan struct{} {
+	return cw.ready
+}
+
+// Check is a healthz.Checker that fails until the xDS cache is warm. The error
+// reports the revisions still being waited for and the ones that failed to load.
+func (cw *cacheWarmer) Check(_ *http.Request) error {
+	select {
+	case <-cw.ready:
+		return nil
+	default:
+	}
+
+	cw.mu.Lock()
+	pending := cw.pending
+	cw.mu.Unlock()
+
+	msg := "xDS cache not loaded yet"
+	if len(pending) > 0 {
+		msg = fmt.Sprintf("%s, waiting for revisions %v", msg, pending)
+	}
+	if failed := cw.failedRevisions(); len(failed) > 0 {
+		msg = fmt.Sprintf("%s, failed to load revisions %v", msg, failed)
+	}
+	return fmt.Errorf(msg)
+}
+
+// RecordLoad records the result of an attempt to load a
+// published revision into the xDS cache. Meant to be used
+// as the OnCacheLoad hook of the EnvoyConfigRevision controller.
+func (cw *cacheWarmer) RecordLoad(ecr types.NamespacedName, err error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if err == nil {
+		delete(cw.loadErrors, ecr)
+		return
+	}
+	cw.loadErrors[ecr] = err.Error()
+}
+
+// failedRevisions returns the revisions that failed to load, with the error
+func (cw *cacheWarmer) failedRevisions() []string {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	failed := make([]string, 0, len(cw.loadErrors))
+	for ecr, msg := range cw.loadErrors {
+		failed = append(failed, fmt.Sprintf("%s: %s", ecr, msg))
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// isCacheWarm returns true if the xDS caches have published the resources of all the
+// published revisions. Tainted revisions are skipped, as they might have failed to load,
+// and so are the revisions whose last load attempt failed.
+func (cw *cacheWarmer) isCacheWarm(ctx context.Context) (bool, error) {
+	list := &marin3rv1alpha1.EnvoyConfigRevisionList{}
+	if err := cw.client.List(ctx, list, client.InNamespace(cw.namespace)); err != nil {
+		return false, err
+	}
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	pending := []string{}
+	for _, ecr := range list.Items {
+		if !ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) ||
+			ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) ||
+			ecr.GetDeletionTimestamp() != nil {
+			continue
+		}
+		if _, ok := cw.loadErrors[types.NamespacedName{Name: ecr.GetName(), Namespace: ecr.GetNamespace()}]; ok {
+			continue
+		}
+
+		cache := cw.caches(ecr.GetEnvoyAPIVersion())
+		// A debounced cache might still hold the snapshot queued, so it is published
+		// straight away rather than getting ready before the clients are served it
+		if pc, ok := cache.(xdss.PendingCache); ok {
+			if err := pc.Flush(ecr.Spec.NodeID); err != nil {
+				cw.logger.Error(err, "unable to publish the snapshot", "NodeID", ecr.Spec.NodeID)
+			}
+		}
+		snap, err := cache.GetSnapshot(ecr.Spec.NodeID)
+		if err != nil || snap.GetVersion(envoy.Cluster) != ecr.Spec.Version {
+			pending = append(pending, ecr.GetName())
+		}
+	}
+	cw.pending = pending
+
+	return len(pending) == 0, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/operator-framework/operator-lib/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testRevision(name, nodeID, version string, conditions ...status.Condition) runtime.Object {
+	return &marin3rv1alpha1.EnvoyConfigRevision{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{
+			NodeID:   nodeID,
+			Version:  version,
+			EnvoyAPI: pointer.StringPtr(string(envoy.APIv3)),
+		},
+		Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{Conditions: conditions},
+	}
+}
+
+func Test_isCacheWarm(t *testing.T) {
+	published := status.Condition{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue}
+	tainted := status.Condition{Type: marin3rv1alpha1.RevisionTaintedCondition, Status: corev1.ConditionTrue}
+
+	cache := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	if err := cache.SetSnapshot("node1", cache.NewSnapshot("aaaa")); err != nil {
+		t.Fatalf("unable to set snapshot: %v", err)
+	}
+	caches := func(envoy.APIVersion) xdss.Cache { return cache }
+
+	tests := []struct {
+		name       string
+		objects    []runtime.Object
+		loadErrors map[types.NamespacedName]string
+		want       bool
+	}{
+		{
+			name:    "Warm if the published revisions are loaded",
+			objects: []runtime.Object{testRevision("ecr1", "node1", "aaaa", published), testRevision("ecr2", "node2", "bbbb")},
+			want:    true,
+		},
+		{
+			name:    "Not warm if a published revision is not loaded",
+			objects: []runtime.Object{testRevision("ecr1", "node1", "aaaa", published), testRevision("ecr2", "node2", "bbbb", published)},
+			want:    false,
+		},
+		{
+			name:    "Not warm if the cache holds another version",
+			objects: []runtime.Object{testRevision("ecr1", "node1", "cccc", published)},
+			want:    false,
+		},
+		{
+			name:    "Skips tainted revisions",
+			objects: []runtime.Object{testRevision("ecr2", "node2", "bbbb", published, tainted)},
+			want:    true,
+		},
+		{
+			name:       "Skips revisions that failed to load",
+			objects:    []runtime.Object{testRevision("ecr2", "node2", "bbbb", published)},
+			loadErrors: map[types.NamespacedName]string{{Name: "ecr2", Namespace: "default"}: "secret not found"},
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw := newCacheWarmer(fake.NewFakeClientWithScheme(scheme, tt.objects...), "default", caches, ctrl.Log)
+			for ecr, msg := range tt.loadErrors {
+				cw.RecordLoad(ecr, errors.New(msg))
+			}
+			got, err := cw.isCacheWarm(context.Background())
+			if err != nil {
+				t.Fatalf("isCacheWarm() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isCacheWarm() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isCacheWarm_debouncedCache(t *testing.T) {
+	published := status.Condition{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue}
+	cache := newDebouncedCache(xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)),
+		envoy.APIv3, PublishOptions{DebounceWindow: time.Hour, MaxDelay: time.Hour}, ctrl.Log)
+	caches := func(envoy.APIVersion) xdss.Cache { return cache }
+	if err := cache.SetSnapshot("node1", cache.NewSnapshot("aaaa")); err != nil {
+		t.Fatalf("unable to set snapshot: %v", err)
+	}
+
+	cw := newCacheWarmer(fake.NewFakeClientWithScheme(scheme, testRevision("ecr1", "node1", "aaaa", published)), "default", caches, ctrl.Log)
+	got, err := cw.isCacheWarm(context.Background())
+	if err != nil {
+		t.Fatalf("isCacheWarm() error = %v", err)
+	}
+	if !got {
+		t.Errorf("isCacheWarm() = false, want true once the queued snapshot is published")
+	}
+	// The snapshot is served before the replica gets ready
+	if snap, err := cache.GetSnapshot("node1"); err != nil || snap.GetVersion(envoy.Cluster) != "aaaa" {
+		t.Errorf("isCacheWarm() did not publish the queued snapshot")
+	}
+}
+
+func Test_cacheWarmer_RecordLoad(t *testing.T) {
+	cache := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	caches := func(envoy.APIVersion) xdss.Cache { return cache }
+	published := status.Condition{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue}
+	cl := fake.NewFakeClientWithScheme(scheme,
+		testRevision("ecr1", "node1", "aaaa", published), testRevision("ecr2", "node2", "bbbb", published))
+	cw := newCacheWarmer(cl, "default", caches, ctrl.Log)
+
+	cw.RecordLoad(types.NamespacedName{Name: "ecr1", Namespace: "default"}, errors.New("secret not found"))
+	if warm, _ := cw.isCacheWarm(context.Background()); warm {
+		t.Fatalf("isCacheWarm() = true, want false while ecr2 is not loaded")
+	}
+	want := "xDS cache not loaded yet, waiting for revisions [ecr2], failed to load revisions [default/ecr1: secret not found]"
+	if err := cw.Check(nil); err == nil || err.Error() != want {
+		t.Errorf("cacheWarmer.Check() = %v, want %q", err, want)
+	}
+
+	// A successful load clears the error
+	cw.RecordLoad(types.NamespacedName{Name: "ecr1", Namespace: "default"}, nil)
+	if got := cw.failedRevisions(); len(got) != 0 {
+		t.Errorf("cacheWarmer.failedRevisions() = %v, want none", got)
+	}
+}
+
+func Test_cacheWarmer_Check(t *testing.T) {
+	cw := newCacheWarmer(fake.NewFakeClientWithScheme(scheme), "default", nil, ctrl.Log)
+	if err := cw.Check(nil); err == nil {
+		t.Errorf("cacheWarmer.Check() = nil, want error before the cache is warm")
+	}
+
+	if err := cw.Start(context.Background()); err != nil {
+		t.Fatalf("cacheWarmer.Start() error = %v", err)
+	}
+	if err := cw.Check(nil); err != nil {
+		t.Errorf("cacheWarmer.Check() = %v, want nil once the cache is warm", err)
+	}
+}
@@ -19,38 +19,117 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
 	"time"
 
+	authz "github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	registry "github.com/3scale/marin3r/pkg/discoveryservice/registry"
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	xdss_v2 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v2"
 	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
 	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
 	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
 	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	server_v2 "github.com/envoyproxy/go-control-plane/pkg/server/v2"
 	server_v3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	"github.com/go-logr/logr"
 
+	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	envoy_service_discovery_v2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+
+	envoy_service_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	envoy_service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	envoy_service_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	envoy_service_extension_v3 "github.com/envoyproxy/go-control-plane/envoy/service/extension/v3"
+	envoy_service_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	envoy_service_route_v3 "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	envoy_service_runtime_v3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	envoy_service_secret_v3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 )
 
-const (
-	grpcMaxConcurrentStreams                          = 1000000
-	grpcMaxConnectionAge                              = 43200 // 12 hours
-	grpcMaxConnectionAgeGrace                         = 300   // 5 min
-	grpcKeepaliveEnforcementPolicyMinTime             = 50
-	grpcKeepaliveEnforcementPolicyPermitWithoutStream = false
-)
+// GrpcServerOptions holds the settings of the gRPC server
+// that serves the xDS protocol
+type GrpcServerOptions struct {
+	// MaxConcurrentStreams is the maximum number of concurrent
+	// streams in each client connection
+	MaxConcurrentStreams uint32
+	// KeepaliveMinTime is the minimum time a client should wait between keepalive pings
+	KeepaliveMinTime time.Duration
+	// KeepalivePermitWithoutStream allows clients to send keepalive
+	// pings when there are no active streams in the connection
+	KeepalivePermitWithoutStream bool
+	// KeepaliveTime is the time after which the server pings a
+	// client if there is no activity in the connection
+	KeepaliveTime time.Duration
+	// KeepaliveTimeout is the time the server waits for the response
+	// to a keepalive ping before closing the connection
+	KeepaliveTimeout time.Duration
+	// MaxConnectionAge is the maximum age of a client connection
+	MaxConnectionAge time.Duration
+	// MaxConnectionAgeGrace is the time the connections that reach the maximum
+	// age are given to complete the in flight requests before being closed
+	MaxConnectionAgeGrace time.Duration
+	// GracefulStopTimeout is the time the server waits for the
+	// streams to finish when stopping before closing them
+	GracefulStopTimeout time.Duration
+}
+
+// Validate returns an error if any of the options holds an invalid value
+func (o GrpcServerOptions) Validate() error {
+	if o.MaxConcurrentStreams == 0 {
+		return fmt.Errorf("max concurrent streams must be greater than 0")
+	}
+	if o.KeepaliveTime < time.Second {
+		return fmt.Errorf("keepalive time must be at least 1s")
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"keepalive min time", o.KeepaliveMinTime},
+		{"keepalive timeout", o.KeepaliveTimeout},
+		{"max connection age", o.MaxConnectionAge},
+		{"max connection age grace", o.MaxConnectionAgeGrace},
+		{"graceful stop timeout", o.GracefulStopTimeout},
+	} {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be greater than 0", d.name)
+		}
+	}
+	return nil
+}
+
+// serverOptions returns the grpc.ServerOptions for the given settings
+func (o GrpcServerOptions) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.MaxConcurrentStreams(o.MaxConcurrentStreams),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             o.KeepaliveMinTime,
+			PermitWithoutStream: o.KeepalivePermitWithoutStream,
+		}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  o.KeepaliveTime,
+			Timeout:               o.KeepaliveTimeout,
+			MaxConnectionAge:      o.MaxConnectionAge,
+			MaxConnectionAgeGrace: o.MaxConnectionAgeGrace,
+		}),
+	}
+}
 
 // XdsServer in an interface that any xDS server should implement
 type XdsServer interface {
 	Start(<-chan struct{}) error
 	GetCache(envoy.APIVersion) xdss.Cache
+	GetClientRegistry() *registry.Registry
+	GetCanaries(envoy.APIVersion) *xdss.Canaries
 }
 
 type onErrorFn func(nodeID, previousVersion, msg string, envoyAPI envoy.APIVersion) error
@@ -60,55 +139,109 @@ type onErrorFn func(nodeID, previousVersion, msg string, envoyAPI envoy.APIVersi
 type DualXdsServer struct {
 	ctx             context.Context
 	xDSPort         uint
+	restPort        uint
 	tlsConfig       *tls.Config
+	grpcOptions     GrpcServerOptions
 	serverV2        server_v2.Server
 	serverV3        server_v3.Server
+	deltaServerV3   *xdss_v3.DeltaServer
 	snapshotCacheV2 cache_v2.SnapshotCache
 	snapshotCacheV3 cache_v3.SnapshotCache
+	cacheV2         xdss.Cache
+	cacheV3         xdss.Cache
 	callbacksV2     *xdss_v2.Callbacks
 	callbacksV3     *xdss_v3.Callbacks
+	clientRegistry  *registry.Registry
+	canariesV2      *xdss.Canaries
+	canariesV3      *xdss.Canaries
+	healthServer    *health.Server
 }
 
-// NewDualXdsServer creates a new DualXdsServer object fron the given params
-func NewDualXdsServer(ctx context.Context, xDSPort uint, tlsConfig *tls.Config, fn onErrorFn, logger logr.Logger) *DualXdsServer {
+// NewDualXdsServer creates a new DualXdsServer object fron the given params. The
+// REST-JSON xDS server is disabled when restPort is 0. The authorizer is optional,
+// any client can request the config of any node ID when it is nil. The nodeHash is
+// also optional, snapshots are keyed by node ID when it is nil. The publishOptions
+// configure the debouncing of the snapshots written to the caches returned by GetCache.
+func NewDualXdsServer(ctx context.Context, xDSPort, restPort uint, tlsConfig *tls.Config, grpcOptions GrpcServerOptions, publishOptions PublishOptions,
+	authorizer *authz.Authorizer, nodeHash *xdss.NodeHash, fn onErrorFn, logger logr.Logger) *DualXdsServer {
 
 	xdsLogger := logger.WithName("xds")
-
-	snapshotCacheV2 := cache_v2.NewSnapshotCache(
-		true,
-		cache_v2.IDHash{},
-		clogger{Logger: xdsLogger.WithName("cache").WithName("v2")},
+	canariesV2 := xdss.NewCanaries()
+	canariesV3 := xdss.NewCanaries()
+	nodeHashV2 := xdss_v2.NewNodeHash(nodeHash, canariesV2)
+	nodeHashV3 := xdss_v3.NewNodeHash(nodeHash, canariesV3)
+
+	// The WatchRouters move the open watches of the clients
+	// that get selected as canaries to the canary snapshots
+	var snapshotCacheV2 cache_v2.SnapshotCache = xdss_v2.NewWatchRouter(
+		cache_v2.NewSnapshotCache(
+			true,
+			nodeHashV2,
+			clogger{Logger: xdsLogger.WithName("cache").WithName("v2")},
+		),
+		nodeHashV2,
 	)
-	snapshotCacheV3 := cache_v3.NewSnapshotCache(
-		true,
-		cache_v3.IDHash{},
-		clogger{Logger: xdsLogger.WithName("cache").WithName("v3")},
+	var snapshotCacheV3 cache_v3.SnapshotCache = xdss_v3.NewWatchRouter(
+		cache_v3.NewSnapshotCache(
+			true,
+			nodeHashV3,
+			clogger{Logger: xdsLogger.WithName("cache").WithName("v3")},
+		),
+		nodeHashV3,
 	)
 
+	// The NACKs of the canaries must taint the canary revision
+	// of the nodeID, which is the version of the canary snapshot
+	onError := func(key, previousVersion, msg string, envoyAPI envoy.APIVersion) error {
+		return fn(xdss.NodeIDForKey(key), previousVersion, msg, envoyAPI)
+	}
+
+	clientRegistry := registry.NewRegistry()
+
 	callbacksV2 := &xdss_v2.Callbacks{
-		OnError:       fn,
+		OnError:       onError,
 		SnapshotCache: &snapshotCacheV2,
 		Logger:        xdsLogger.WithName("server").WithName("v2"),
+		Registry:      clientRegistry,
+		Authorizer:    authorizer,
+		NodeHash:      nodeHashV2,
 	}
 	callbacksV3 := &xdss_v3.Callbacks{
-		OnError:       fn,
+		OnError:       onError,
 		SnapshotCache: &snapshotCacheV3,
 		Logger:        xdsLogger.WithName("server").WithName("v3"),
+		Registry:      clientRegistry,
+		Authorizer:    authorizer,
+		NodeHash:      nodeHashV3,
 	}
 
 	srvV2 := server_v2.NewServer(ctx, snapshotCacheV2, callbacksV2)
 	srvV3 := server_v3.NewServer(ctx, snapshotCacheV3, callbacksV3)
+	// The DeltaServer reads from the same Cache the snapshots are published to, as
+	// it also holds the resource types not supported by the go-control-plane cache
+	cacheV3 := xdss_v3.NewCache(snapshotCacheV3)
+	callbacksV3.Cache = &cacheV3
+	deltaSrvV3 := xdss_v3.NewDeltaServer(ctx, cacheV3, nodeHashV3, callbacksV3)
 
 	return &DualXdsServer{
 		ctx:             ctx,
 		xDSPort:         xDSPort,
+		restPort:        restPort,
 		tlsConfig:       tlsConfig,
+		grpcOptions:     grpcOptions,
 		serverV2:        srvV2,
 		serverV3:        srvV3,
+		deltaServerV3:   deltaSrvV3,
 		snapshotCacheV2: snapshotCacheV2,
 		snapshotCacheV3: snapshotCacheV3,
+		cacheV2:         newDebouncedCache(xdss_v2.NewCache(snapshotCacheV2), envoy.APIv2, publishOptions, xdsLogger.WithName("publisher").WithName("v2")),
+		cacheV3:         newDebouncedCache(cacheV3, envoy.APIv3, publishOptions, xdsLogger.WithName("publisher").WithName("v3")),
 		callbacksV2:     callbacksV2,
 		callbacksV3:     callbacksV3,
+		clientRegistry:  clientRegistry,
+		canariesV2:      canariesV2,
+		canariesV3:      canariesV3,
+		healthServer:    health.NewServer(),
 	}
 }
 
@@ -118,18 +251,9 @@ func (xdss *DualXdsServer) Start(stopCh <-chan struct{}) error {
 	// gRPC golang library sets a very small upper bound for the number gRPC/h2
 	// streams over a single TCP connection. If a proxy multiplexes requests over
 	// a single connection to the management server, then it might lead to
-	// availability problems.
+	// availability problems, so the limit is configurable and defaults to a high value.
 	grpcServer := grpc.NewServer(
-		grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams),
-		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
-			MinTime:             grpcKeepaliveEnforcementPolicyMinTime * time.Second,
-			PermitWithoutStream: grpcKeepaliveEnforcementPolicyPermitWithoutStream,
-		}),
-		grpc.Creds(credentials.NewTLS(xdss.tlsConfig)),
-		grpc.KeepaliveParams(keepalive.ServerParameters{
-			MaxConnectionAge:      grpcMaxConnectionAge * time.Second,
-			MaxConnectionAgeGrace: grpcMaxConnectionAgeGrace * time.Second,
-		}),
+		append(xdss.grpcOptions.serverOptions(), grpc.Creds(credentials.NewTLS(xdss.tlsConfig)))...,
 	)
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", xdss.xDSPort))
 	if err != nil {
@@ -138,25 +262,48 @@ func (xdss *DualXdsServer) Start(stopCh <-chan struct{}) error {
 	}
 
 	// channel to receive errors from the gorutine running the server
-	errCh := make(chan error)
+	errCh := make(chan error, 2)
 
-	// goroutine to run server
-	envoy_service_discovery_v2.RegisterAggregatedDiscoveryServiceServer(grpcServer, xdss.serverV2)
-	envoy_service_discovery_v3.RegisterAggregatedDiscoveryServiceServer(grpcServer, xdss.serverV3)
+	xdss.registerServices(grpcServer)
 
+	// goroutine to run server
 	go func() {
 		if err = grpcServer.Serve(lis); err != nil {
 			errCh <- err
 		}
 	}()
 
-	setupLog.Info(fmt.Sprintf("Aggregated discovery service listening on %d\n", xdss.xDSPort))
+	// The xDS server is only started once the cache is loaded,
+	// so it can report itself as serving straight away
+	xdss.healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	setupLog.Info(fmt.Sprintf("Discovery service listening on %d\n", xdss.xDSPort))
+
+	// goroutine to run the REST-JSON server
+	var restServer *http.Server
+	if xdss.restPort != 0 {
+		restServer = &http.Server{
+			Addr:      fmt.Sprintf(":%d", xdss.restPort),
+			Handler:   newRestHandler(xdss.serverV2, xdss.serverV3, setupLog.WithName("rest")),
+			TLSConfig: xdss.tlsConfig,
+		}
+		go func() {
+			// Certificates are already loaded in the TLS config
+			if err := restServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				errCh <- err
+			}
+		}()
+		setupLog.Info(fmt.Sprintf("REST discovery service listening on %d\n", xdss.restPort))
+	}
 
 	// wait until channel stopCh closed or an error is received
 	select {
 
 	case <-stopCh:
 		setupLog.Info("shutting down xds server")
+		// Let the clients checking the health service know that
+		// they should move to another discovery service replica
+		xdss.healthServer.Shutdown()
 		stopped := make(chan struct{})
 		go func() {
 			grpcServer.GracefulStop()
@@ -164,13 +311,21 @@ func (xdss *DualXdsServer) Start(stopCh <-chan struct{}) error {
 		}()
 
 		// Timeout on graceful stop
-		t := time.NewTimer(10 * time.Second)
+		t := time.NewTimer(xdss.grpcOptions.GracefulStopTimeout)
 		select {
 		case <-t.C:
 			grpcServer.Stop()
 		case <-stopped:
 			t.Stop()
 		}
+
+		if restServer != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), xdss.grpcOptions.GracefulStopTimeout)
+			defer cancel()
+			if err := restServer.Shutdown(ctx); err != nil {
+				restServer.Close()
+			}
+		}
 		return nil
 
 	case err := <-errCh:
@@ -180,12 +335,112 @@ func (xdss *DualXdsServer) Start(stopCh <-chan struct{}) error {
 
 }
 
-// GetCache returns the Cache
+// GetCache returns the Cache for the given envoy API version
 func (xdss *DualXdsServer) GetCache(version envoy.APIVersion) xdss.Cache {
 	if version == envoy.APIv2 {
-		return xdss_v2.NewCache(xdss.snapshotCacheV2)
+		return xdss.cacheV2
 	}
-	return xdss_v3.NewCache(xdss.snapshotCacheV3)
+	return xdss.cacheV3
+}
+
+// GetClientRegistry returns the registry of the clients
+// connected to the xDS server
+func (xdss *DualXdsServer) GetClientRegistry() *registry.Registry {
+	return xdss.clientRegistry
+}
+
+// GetCanaries returns the canary rollouts in progress
+// for the given envoy API version
+func (xdss *DualXdsServer) GetCanaries(version envoy.APIVersion) *xdss.Canaries {
+	if version == envoy.APIv2 {
+		return xdss.canariesV2
+	}
+	return xdss.canariesV3
+}
+
+// registerServices registers in the given gRPC server the aggregated discovery
+// service and the per type discovery services (CDS, LDS, EDS, RDS, SDS and RTDS)
+// for both envoy API versions. All of them are served from the same caches, so clients
+// can choose to use either ADS or separate streams per resource type. The ECDS, SRDS
+// and VHDS services are also registered for envoy API v3, but only serve the incremental
+// variant of the protocol. The standard grpc.health.v1 health service is also registered.
+func (xdss *DualXdsServer) registerServices(grpcServer *grpc.Server) {
+
+	healthpb.RegisterHealthServer(grpcServer, xdss.healthServer)
+
+	// envoy API v2
+	envoy_service_discovery_v2.RegisterAggregatedDiscoveryServiceServer(grpcServer, xdss.serverV2)
+	envoy_api_v2.RegisterClusterDiscoveryServiceServer(grpcServer, xdss.serverV2)
+	envoy_api_v2.RegisterEndpointDiscoveryServiceServer(grpcServer, xdss.serverV2)
+	envoy_api_v2.RegisterListenerDiscoveryServiceServer(grpcServer, xdss.serverV2)
+	envoy_api_v2.RegisterRouteDiscoveryServiceServer(grpcServer, xdss.serverV2)
+	envoy_service_discovery_v2.RegisterSecretDiscoveryServiceServer(grpcServer, xdss.serverV2)
+	envoy_service_discovery_v2.RegisterRuntimeDiscoveryServiceServer(grpcServer, xdss.serverV2)
+
+	// envoy API v3
+	srvV3 := &discoveryServiceV3{Server: xdss.serverV3, delta: xdss.deltaServerV3}
+	envoy_service_discovery_v3.RegisterAggregatedDiscoveryServiceServer(grpcServer, srvV3)
+	envoy_service_cluster_v3.RegisterClusterDiscoveryServiceServer(grpcServer, srvV3)
+	envoy_service_endpoint_v3.RegisterEndpointDiscoveryServiceServer(grpcServer, srvV3)
+	envoy_service_listener_v3.RegisterListenerDiscoveryServiceServer(grpcServer, srvV3)
+	envoy_service_route_v3.RegisterRouteDiscoveryServiceServer(grpcServer, srvV3)
+	envoy_service_secret_v3.RegisterSecretDiscoveryServiceServer(grpcServer, srvV3)
+	envoy_service_runtime_v3.RegisterRuntimeDiscoveryServiceServer(grpcServer, srvV3)
+
+	extSrvV3 := &extensionDiscoveryServiceV3{delta: xdss.deltaServerV3}
+	envoy_service_extension_v3.RegisterExtensionConfigDiscoveryServiceServer(grpcServer, extSrvV3)
+	envoy_service_route_v3.RegisterScopedRoutesDiscoveryServiceServer(grpcServer, extSrvV3)
+	envoy_service_route_v3.RegisterVirtualHostDiscoveryServiceServer(grpcServer, extSrvV3)
+}
+
+// discoveryServiceV3 serves the state-of-the-world variant of the v3
+// discovery services using the go-control-plane server and the
+// incremental variant using the DeltaServer
+type discoveryServiceV3 struct {
+	server_v3.Server
+	delta *xdss_v3.DeltaServer
+}
+
+// DeltaAggregatedResources implements the DeltaAggregatedResources method of the
+// envoy API v3 AggregatedDiscoveryService gRPC service.
+func (ds *discoveryServiceV3) DeltaAggregatedResources(stream envoy_service_discovery_v3.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	return ds.delta.DeltaAggregatedResources(stream)
+}
+
+// DeltaEndpoints implements the DeltaEndpoints method of the
+// envoy API v3 EndpointDiscoveryService gRPC service.
+func (ds *discoveryServiceV3) DeltaEndpoints(stream envoy_service_endpoint_v3.EndpointDiscoveryService_DeltaEndpointsServer) error {
+	return ds.delta.DeltaStreamHandler(stream, resource_v3.EndpointType)
+}
+
+// DeltaClusters implements the DeltaClusters method of the
+// envoy API v3 ClusterDiscoveryService gRPC service.
+func (ds *discoveryServiceV3) DeltaClusters(stream envoy_service_cluster_v3.ClusterDiscoveryService_DeltaClustersServer) error {
+	return ds.delta.DeltaStreamHandler(stream, resource_v3.ClusterType)
+}
+
+// DeltaRoutes implements the DeltaRoutes method of the
+// envoy API v3 RouteDiscoveryService gRPC service.
+func (ds *discoveryServiceV3) DeltaRoutes(stream envoy_service_route_v3.RouteDiscoveryService_DeltaRoutesServer) error {
+	return ds.delta.DeltaStreamHandler(stream, resource_v3.RouteType)
+}
+
+// DeltaListeners implements the DeltaListeners method of the
+// envoy API v3 ListenerDiscoveryService gRPC service.
+func (ds *discoveryServiceV3) DeltaListeners(stream envoy_service_listener_v3.ListenerDiscoveryService_DeltaListenersServer) error {
+	return ds.delta.DeltaStreamHandler(stream, resource_v3.ListenerType)
+}
+
+// DeltaSecrets implements the DeltaSecrets method of the
+// envoy API v3 SecretDiscoveryService gRPC service.
+func (ds *discoveryServiceV3) DeltaSecrets(stream envoy_service_secret_v3.SecretDiscoveryService_DeltaSecretsServer) error {
+	return ds.delta.DeltaStreamHandler(stream, resource_v3.SecretType)
+}
+
+// DeltaRuntime implements the DeltaRuntime method of the
+// envoy API v3 RuntimeDiscoveryService gRPC service.
+func (ds *discoveryServiceV3) DeltaRuntime(stream envoy_service_runtime_v3.RuntimeDiscoveryService_DeltaRuntimeServer) error {
+	return ds.delta.DeltaStreamHandler(stream, resource_v3.RuntimeType)
 }
 
 type clogger struct {
@@ -207,3 +462,30 @@ func (cl clogger) Warnf(format string, args ...interface{}) {
 func (cl clogger) Errorf(format string, args ...interface{}) {
 	cl.Logger.Error(fmt.Errorf("xds cache error"), fmt.Sprintf(format, args...))
 }
+
+// extensionDiscoveryServiceV3 serves the ECDS, SRDS and VHDS discovery
+// services. The go-control-plane server does not support these resource
+// types, so only the incremental variant is implemented, using the DeltaServer.
+type extensionDiscoveryServiceV3 struct {
+	envoy_service_extension_v3.UnimplementedExtensionConfigDiscoveryServiceServer
+	envoy_service_route_v3.UnimplementedScopedRoutesDiscoveryServiceServer
+	delta *xdss_v3.DeltaServer
+}
+
+// DeltaExtensionConfigs implements the DeltaExtensionConfigs method of the
+// envoy API v3 ExtensionConfigDiscoveryService gRPC service.
+func (ds *extensionDiscoveryServiceV3) DeltaExtensionConfigs(stream envoy_service_extension_v3.ExtensionConfigDiscoveryService_DeltaExtensionConfigsServer) error {
+	return ds.delta.DeltaStreamHandler(stream, envoy_resources_v3.Mappings()[envoy.ExtensionConfig])
+}
+
+// DeltaScopedRoutes implements the DeltaScopedRoutes method of the
+// envoy API v3 ScopedRoutesDiscoveryService gRPC service.
+func (ds *extensionDiscoveryServiceV3) DeltaScopedRoutes(stream envoy_service_route_v3.ScopedRoutesDiscoveryService_DeltaScopedRoutesServer) error {
+	return ds.delta.DeltaStreamHandler(stream, envoy_resources_v3.Mappings()[envoy.ScopedRoute])
+}
+
+// DeltaVirtualHosts implements the DeltaVirtualHosts method of the
+// envoy API v3 VirtualHostDiscoveryService gRPC service.
+func (ds *extensionDiscoveryServiceV3) DeltaVirtualHosts(stream envoy_service_route_v3.VirtualHostDiscoveryService_DeltaVirtualHostsServer) error {
+	return ds.delta.DeltaStreamHandler(stream, envoy_resources_v3.Mappings()[envoy.VirtualHost])
+}
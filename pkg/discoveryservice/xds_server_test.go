@@ -20,7 +20,9 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
+	authz "github.com/3scale/marin3r/pkg/discoveryservice/authz"
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	xdss_v2 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v2"
 	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
@@ -30,6 +32,8 @@ import (
 	server_v2 "github.com/envoyproxy/go-control-plane/pkg/server/v2"
 	server_v3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -42,11 +46,16 @@ var (
 func TestNewDualXdsServer(t *testing.T) {
 
 	type args struct {
-		ctx       context.Context
-		adsPort   uint
-		tlsConfig *tls.Config
-		fn        onErrorFn
-		logger    logr.Logger
+		ctx         context.Context
+		adsPort     uint
+		restPort    uint
+		tlsConfig   *tls.Config
+		grpcOptions GrpcServerOptions
+		publish     PublishOptions
+		authorizer  *authz.Authorizer
+		nodeHash    *xdss.NodeHash
+		fn          onErrorFn
+		logger      logr.Logger
 	}
 	tests := []struct {
 		name string
@@ -54,15 +63,15 @@ func TestNewDualXdsServer(t *testing.T) {
 	}{
 		{
 			"Returns a new DualXdsServer from the given params",
-			args{context.Background(), 10000, &tls.Config{}, fn, ctrl.Log},
+			args{context.Background(), 10000, 10001, &tls.Config{}, GrpcServerOptions{}, PublishOptions{DebounceWindow: time.Second, MaxDelay: 5 * time.Second}, &authz.Authorizer{Policy: authz.PolicyExact}, &xdss.NodeHash{Kind: xdss.NodeHashCluster}, fn, ctrl.Log},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := NewDualXdsServer(tt.args.ctx, tt.args.adsPort, tt.args.tlsConfig, tt.args.fn, tt.args.logger)
-			if got.snapshotCacheV2 == nil || got.snapshotCacheV3 == nil ||
-				got.serverV2 == nil || got.serverV3 == nil ||
-				got.callbacksV2 == nil || got.callbacksV3 == nil {
+			got := NewDualXdsServer(tt.args.ctx, tt.args.adsPort, tt.args.restPort, tt.args.tlsConfig, tt.args.grpcOptions, tt.args.publish, tt.args.authorizer, tt.args.nodeHash, tt.args.fn, tt.args.logger)
+			if got.snapshotCacheV2 == nil || got.snapshotCacheV3 == nil || got.cacheV2 == nil || got.cacheV3 == nil ||
+				got.serverV2 == nil || got.serverV3 == nil || got.deltaServerV3 == nil ||
+				got.callbacksV2 == nil || got.callbacksV3 == nil || got.clientRegistry == nil || got.healthServer == nil {
 				t.Errorf("TestNewDualXdsServer = expected non-empty caches")
 			}
 		})
@@ -78,15 +87,17 @@ func TestDualXdsServer_Start(t *testing.T) {
 		{
 			"Runs the ads server",
 			&DualXdsServer{
-				context.Background(),
-				10000,
-				&tls.Config{},
-				server_v2.NewServer(context.Background(), snapshotCacheV2, &xdss_v2.Callbacks{Logger: ctrl.Log}),
-				server_v3.NewServer(context.Background(), snapshotCacheV3, &xdss_v3.Callbacks{Logger: ctrl.Log}),
-				snapshotCacheV2,
-				snapshotCacheV3,
-				&xdss_v2.Callbacks{Logger: ctrl.Log},
-				&xdss_v3.Callbacks{Logger: ctrl.Log},
+				ctx:             context.Background(),
+				xDSPort:         10000,
+				tlsConfig:       &tls.Config{},
+				serverV2:        server_v2.NewServer(context.Background(), snapshotCacheV2, &xdss_v2.Callbacks{Logger: ctrl.Log}),
+				serverV3:        server_v3.NewServer(context.Background(), snapshotCacheV3, &xdss_v3.Callbacks{Logger: ctrl.Log}),
+				deltaServerV3:   xdss_v3.NewDeltaServer(context.Background(), xdss_v3.NewCache(snapshotCacheV3), cache_v3.IDHash{}, &xdss_v3.Callbacks{Logger: ctrl.Log}),
+				snapshotCacheV2: snapshotCacheV2,
+				snapshotCacheV3: snapshotCacheV3,
+				callbacksV2:     &xdss_v2.Callbacks{Logger: ctrl.Log},
+				callbacksV3:     &xdss_v3.Callbacks{Logger: ctrl.Log},
+				healthServer:    health.NewServer(),
 			},
 		},
 	}
@@ -117,15 +128,18 @@ func TestDualXdsServer_GetCache(t *testing.T) {
 		{
 			"Gets the server's Cache",
 			&DualXdsServer{
-				context.Background(),
-				10000,
-				&tls.Config{},
-				server_v2.NewServer(context.Background(), snapshotCacheV2, &xdss_v2.Callbacks{Logger: ctrl.Log}),
-				server_v3.NewServer(context.Background(), snapshotCacheV3, &xdss_v3.Callbacks{Logger: ctrl.Log}),
-				snapshotCacheV2,
-				snapshotCacheV3,
-				&xdss_v2.Callbacks{Logger: ctrl.Log},
-				&xdss_v3.Callbacks{Logger: ctrl.Log},
+				ctx:             context.Background(),
+				xDSPort:         10000,
+				tlsConfig:       &tls.Config{},
+				serverV2:        server_v2.NewServer(context.Background(), snapshotCacheV2, &xdss_v2.Callbacks{Logger: ctrl.Log}),
+				serverV3:        server_v3.NewServer(context.Background(), snapshotCacheV3, &xdss_v3.Callbacks{Logger: ctrl.Log}),
+				deltaServerV3:   xdss_v3.NewDeltaServer(context.Background(), xdss_v3.NewCache(snapshotCacheV3), cache_v3.IDHash{}, &xdss_v3.Callbacks{Logger: ctrl.Log}),
+				snapshotCacheV2: snapshotCacheV2,
+				snapshotCacheV3: snapshotCacheV3,
+				cacheV2:         xdss_v2.NewCache(snapshotCacheV2),
+				cacheV3:         xdss_v3.NewCache(snapshotCacheV3),
+				callbacksV2:     &xdss_v2.Callbacks{Logger: ctrl.Log},
+				callbacksV3:     &xdss_v3.Callbacks{Logger: ctrl.Log},
 			},
 			xdss_v2.NewCache(snapshotCacheV2),
 			envoy.APIv2,
@@ -133,15 +147,18 @@ func TestDualXdsServer_GetCache(t *testing.T) {
 		{
 			"Gets the server's Cache",
 			&DualXdsServer{
-				context.Background(),
-				10000,
-				&tls.Config{},
-				server_v2.NewServer(context.Background(), snapshotCacheV2, &xdss_v2.Callbacks{Logger: ctrl.Log}),
-				server_v3.NewServer(context.Background(), snapshotCacheV3, &xdss_v3.Callbacks{Logger: ctrl.Log}),
-				snapshotCacheV2,
-				snapshotCacheV3,
-				&xdss_v2.Callbacks{Logger: ctrl.Log},
-				&xdss_v3.Callbacks{Logger: ctrl.Log},
+				ctx:             context.Background(),
+				xDSPort:         10000,
+				tlsConfig:       &tls.Config{},
+				serverV2:        server_v2.NewServer(context.Background(), snapshotCacheV2, &xdss_v2.Callbacks{Logger: ctrl.Log}),
+				serverV3:        server_v3.NewServer(context.Background(), snapshotCacheV3, &xdss_v3.Callbacks{Logger: ctrl.Log}),
+				deltaServerV3:   xdss_v3.NewDeltaServer(context.Background(), xdss_v3.NewCache(snapshotCacheV3), cache_v3.IDHash{}, &xdss_v3.Callbacks{Logger: ctrl.Log}),
+				snapshotCacheV2: snapshotCacheV2,
+				snapshotCacheV3: snapshotCacheV3,
+				cacheV2:         xdss_v2.NewCache(snapshotCacheV2),
+				cacheV3:         xdss_v3.NewCache(snapshotCacheV3),
+				callbacksV2:     &xdss_v2.Callbacks{Logger: ctrl.Log},
+				callbacksV3:     &xdss_v3.Callbacks{Logger: ctrl.Log},
 			},
 			xdss_v3.NewCache(snapshotCacheV3),
 			envoy.APIv3,
@@ -155,3 +172,74 @@ func TestDualXdsServer_GetCache(t *testing.T) {
 		})
 	}
 }
+
+func TestDualXdsServer_registerServices(t *testing.T) {
+	xdss := &DualXdsServer{
+		serverV2:      server_v2.NewServer(context.Background(), snapshotCacheV2, &xdss_v2.Callbacks{Logger: ctrl.Log}),
+		serverV3:      server_v3.NewServer(context.Background(), snapshotCacheV3, &xdss_v3.Callbacks{Logger: ctrl.Log}),
+		deltaServerV3: xdss_v3.NewDeltaServer(context.Background(), xdss_v3.NewCache(snapshotCacheV3), cache_v3.IDHash{}, &xdss_v3.Callbacks{Logger: ctrl.Log}),
+		healthServer:  health.NewServer(),
+	}
+	grpcServer := grpc.NewServer()
+	xdss.registerServices(grpcServer)
+
+	tests := []struct {
+		name    string
+		service string
+	}{
+		{"Registers v2 ADS", "envoy.service.discovery.v2.AggregatedDiscoveryService"},
+		{"Registers v2 CDS", "envoy.api.v2.ClusterDiscoveryService"},
+		{"Registers v2 EDS", "envoy.api.v2.EndpointDiscoveryService"},
+		{"Registers v2 LDS", "envoy.api.v2.ListenerDiscoveryService"},
+		{"Registers v2 RDS", "envoy.api.v2.RouteDiscoveryService"},
+		{"Registers v2 SDS", "envoy.service.discovery.v2.SecretDiscoveryService"},
+		{"Registers v2 RTDS", "envoy.service.discovery.v2.RuntimeDiscoveryService"},
+		{"Registers v3 ADS", "envoy.service.discovery.v3.AggregatedDiscoveryService"},
+		{"Registers v3 CDS", "envoy.service.cluster.v3.ClusterDiscoveryService"},
+		{"Registers v3 EDS", "envoy.service.endpoint.v3.EndpointDiscoveryService"},
+		{"Registers v3 LDS", "envoy.service.listener.v3.ListenerDiscoveryService"},
+		{"Registers v3 RDS", "envoy.service.route.v3.RouteDiscoveryService"},
+		{"Registers v3 SDS", "envoy.service.secret.v3.SecretDiscoveryService"},
+		{"Registers v3 RTDS", "envoy.service.runtime.v3.RuntimeDiscoveryService"},
+		{"Registers the health service", "grpc.health.v1.Health"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := grpcServer.GetServiceInfo()[tt.service]; !ok {
+				t.Errorf("DualXdsServer.registerServices() service %s not registered", tt.service)
+			}
+		})
+	}
+}
+
+func TestGrpcServerOptions_Validate(t *testing.T) {
+	valid := GrpcServerOptions{
+		MaxConcurrentStreams:  1000,
+		KeepaliveMinTime:      time.Minute,
+		KeepaliveTime:         time.Minute,
+		KeepaliveTimeout:      time.Second,
+		MaxConnectionAge:      time.Hour,
+		MaxConnectionAgeGrace: time.Minute,
+		GracefulStopTimeout:   time.Second,
+	}
+	tests := []struct {
+		name    string
+		modify  func(o *GrpcServerOptions)
+		wantErr bool
+	}{
+		{"Valid options", func(o *GrpcServerOptions) {}, false},
+		{"Zero max concurrent streams", func(o *GrpcServerOptions) { o.MaxConcurrentStreams = 0 }, true},
+		{"Keepalive time lower than 1s", func(o *GrpcServerOptions) { o.KeepaliveTime = 500 * time.Millisecond }, true},
+		{"Negative max connection age", func(o *GrpcServerOptions) { o.MaxConnectionAge = -time.Hour }, true},
+		{"Zero graceful stop timeout", func(o *GrpcServerOptions) { o.GracefulStopTimeout = 0 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := valid
+			tt.modify(&o)
+			if err := o.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("GrpcServerOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
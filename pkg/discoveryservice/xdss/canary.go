@@ -0,0 +1,152 @@
+package discoveryservice
+
+import (
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	_struct "github.com/golang/protobuf/ptypes/struct"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// canarySuffix is appended to the snapshot key of a nodeID
+// to get the key of the snapshot served to its canary clients
+const canarySuffix = "/canary"
+
+// CanaryKey returns the key of the snapshot that holds the canary
+// revision of a nodeID, served to the clients selected as canaries
+func CanaryKey(nodeID string) string {
+	return nodeID + canarySuffix
+}
+
+// NodeIDForKey returns the nodeID a snapshot key belongs to, which
+// is the key itself unless it is the key of a canary snapshot
+func NodeIDForKey(key string) string {
+	return strings.TrimSuffix(key, canarySuffix)
+}
+
+// IsCanaryKey returns true if the key is the key of a canary snapshot
+func IsCanaryKey(key string) bool {
+	return strings.HasSuffix(key, canarySuffix)
+}
+
+// ClientAddressField is the field of the envoy node metadata the discovery service sets
+// to the IP address of the client. All the clients of an EnvoyConfig usually share the same
+// node ID, so the address is what tells them apart when the canaries are picked.
+const ClientAddressField = "marin3r.3scale.net/client-address"
+
+// ClientMetadata returns a copy of the envoy node metadata with the ClientAddressField set to
+// the IP of the given peer address, or nil if the metadata already holds it or the peer address
+// is unknown. The node of a stream is shared by all its requests, so it is only replaced when it
+// doesn't hold the address yet, before any watch uses it.
+func ClientMetadata(metadata *_struct.Struct, peerAddress string) *_struct.Struct {
+	if peerAddress == "" {
+		return nil
+	}
+	ip := peerAddress
+	if host, _, err := net.SplitHostPort(peerAddress); err == nil {
+		ip = host
+	}
+	if metadata.GetFields()[ClientAddressField].GetStringValue() == ip {
+		return nil
+	}
+
+	out := &_struct.Struct{}
+	if metadata != nil {
+		out = proto.Clone(metadata).(*_struct.Struct)
+	}
+	if out.Fields == nil {
+		out.Fields = map[string]*_struct.Value{}
+	}
+	out.Fields[ClientAddressField] = &_struct.Value{Kind: &_struct.Value_StringValue{StringValue: ip}}
+	return out
+}
+
+// CanarySelection determines which of the clients of a nodeID are canaries
+type CanarySelection struct {
+	// Version is the version being rolled out to the canaries
+	Version string
+	// Percentage is the percentage of the clients, among the ones matched
+	// by the selector, that are canaries. The clients are picked by a hash
+	// of their envoy node ID and address, so each client is consistently
+	// picked or not.
+	Percentage int32
+	// Selector is matched against the string fields at the top level of the
+	// envoy node metadata. A nil selector matches all the clients.
+	Selector labels.Selector
+}
+
+// selects returns true if the client with the given envoy
+// node ID and metadata is selected as a canary
+func (cs CanarySelection) selects(id string, metadata *_struct.Struct) bool {
+	if cs.Selector != nil && !cs.Selector.Matches(metadataLabels(metadata)) {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	if address := metadata.GetFields()[ClientAddressField].GetStringValue(); address != "" {
+		h.Write([]byte("/" + address))
+	}
+	return int32(h.Sum32()%100) < cs.Percentage
+}
+
+// Canaries keeps track of the canary rollouts in progress for each nodeID. It
+// is safe for concurrent use.
+type Canaries struct {
+	mu         sync.RWMutex
+	selections map[string]CanarySelection
+}
+
+// NewCanaries returns a new Canaries object
+func NewCanaries() *Canaries {
+	return &Canaries{selections: map[string]CanarySelection{}}
+}
+
+// Start starts, or updates, the canary rollout of a nodeID
+func (c *Canaries) Start(nodeID string, selection CanarySelection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.selections[nodeID] = selection
+}
+
+// Stop stops the canary rollout of a nodeID, if any
+func (c *Canaries) Stop(nodeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.selections, nodeID)
+}
+
+// Get returns the canary rollout in progress for a nodeID
+func (c *Canaries) Get(nodeID string) (CanarySelection, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	selection, ok := c.selections[nodeID]
+	return selection, ok
+}
+
+// Key returns the key of the snapshot served to a client, given the key computed
+// by the NodeHash and the envoy node ID and metadata of the client. It is the canary
+// key of the nodeID for the clients selected as canaries and the given key otherwise.
+func (c *Canaries) Key(key, id string, metadata *_struct.Struct) string {
+	if c == nil {
+		return key
+	}
+	if selection, ok := c.Get(key); ok && selection.selects(id, metadata) {
+		return CanaryKey(key)
+	}
+	return key
+}
+
+// metadataLabels returns the string fields at the
+// top level of the envoy node metadata as a labels.Set
+func metadataLabels(metadata *_struct.Struct) labels.Set {
+	set := labels.Set{}
+	for k, v := range metadata.GetFields() {
+		if s, ok := v.GetKind().(*_struct.Value_StringValue); ok {
+			set[k] = s.StringValue
+		}
+	}
+	return set
+}
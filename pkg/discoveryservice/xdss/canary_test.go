@@ -0,0 +1,168 @@
+package discoveryservice
+
+import (
+	"fmt"
+	"testing"
+
+	_struct "github.com/golang/protobuf/ptypes/struct"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestNodeIDForKey(t *testing.T) {
+	tests := []struct {
+		key        string
+		wantNodeID string
+		wantCanary bool
+	}{
+		{"gateway", "gateway", false},
+		{CanaryKey("gateway"), "gateway", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := NodeIDForKey(tt.key); got != tt.wantNodeID {
+				t.Errorf("NodeIDForKey() = %v, want %v", got, tt.wantNodeID)
+			}
+			if got := IsCanaryKey(tt.key); got != tt.wantCanary {
+				t.Errorf("IsCanaryKey() = %v, want %v", got, tt.wantCanary)
+			}
+		})
+	}
+}
+
+func TestCanaries_Key(t *testing.T) {
+	metadata := func(zone string) *_struct.Struct {
+		return &_struct.Struct{Fields: map[string]*_struct.Value{
+			"zone":    {Kind: &_struct.Value_StringValue{StringValue: zone}},
+			"version": {Kind: &_struct.Value_NumberValue{NumberValue: 2}},
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		selection *CanarySelection
+		id        string
+		metadata  *_struct.Struct
+		want      string
+	}{
+		{
+			name: "Returns the key if there is no rollout",
+			id:   "gateway-1", metadata: metadata("a"), want: "gateway",
+		},
+		{
+			name:      "Returns the canary key for all clients with 100%",
+			selection: &CanarySelection{Percentage: 100},
+			id:        "gateway-1", metadata: metadata("a"), want: CanaryKey("gateway"),
+		},
+		{
+			name:      "Returns the key for all clients with 0%",
+			selection: &CanarySelection{Percentage: 0},
+			id:        "gateway-1", metadata: metadata("a"), want: "gateway",
+		},
+		{
+			name:      "Returns the canary key for the clients matched by the selector",
+			selection: &CanarySelection{Percentage: 100, Selector: labels.SelectorFromSet(labels.Set{"zone": "a"})},
+			id:        "gateway-1", metadata: metadata("a"), want: CanaryKey("gateway"),
+		},
+		{
+			name:      "Returns the key for the clients not matched by the selector",
+			selection: &CanarySelection{Percentage: 100, Selector: labels.SelectorFromSet(labels.Set{"zone": "a"})},
+			id:        "gateway-1", metadata: metadata("b"), want: "gateway",
+		},
+		{
+			name:      "Ignores the metadata fields that are not strings",
+			selection: &CanarySelection{Percentage: 100, Selector: labels.SelectorFromSet(labels.Set{"version": "2"})},
+			id:        "gateway-1", metadata: metadata("a"), want: "gateway",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCanaries()
+			if tt.selection != nil {
+				c.Start("gateway", *tt.selection)
+			}
+			if got := c.Key("gateway", tt.id, tt.metadata); got != tt.want {
+				t.Errorf("Canaries.Key() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Nil Canaries returns the key", func(t *testing.T) {
+		var c *Canaries
+		if got := c.Key("gateway", "gateway-1", nil); got != "gateway" {
+			t.Errorf("Canaries.Key() = %v, want %v", got, "gateway")
+		}
+	})
+
+	t.Run("Selects a share of the clients close to the percentage", func(t *testing.T) {
+		c := NewCanaries()
+		c.Start("gateway", CanarySelection{Percentage: 20})
+		selected := 0
+		for i := 0; i < 1000; i++ {
+			if c.Key("gateway", fmt.Sprintf("gateway-%d", i), nil) == CanaryKey("gateway") {
+				selected++
+			}
+		}
+		if selected < 150 || selected > 250 {
+			t.Errorf("Canaries.Key() selected %d out of 1000 clients, want about 200", selected)
+		}
+	})
+
+	t.Run("Selects a share of the clients that share the node ID", func(t *testing.T) {
+		c := NewCanaries()
+		c.Start("gateway", CanarySelection{Percentage: 20})
+		selected := 0
+		for i := 0; i < 1000; i++ {
+			md := ClientMetadata(nil, fmt.Sprintf("10.0.%d.%d:45678", i/250, i%250))
+			if c.Key("gateway", "gateway", md) == CanaryKey("gateway") {
+				selected++
+			}
+		}
+		if selected < 150 || selected > 250 {
+			t.Errorf("Canaries.Key() selected %d out of 1000 clients, want about 200", selected)
+		}
+	})
+
+	t.Run("Stop ends the rollout", func(t *testing.T) {
+		c := NewCanaries()
+		c.Start("gateway", CanarySelection{Percentage: 100})
+		c.Stop("gateway")
+		if got := c.Key("gateway", "gateway-1", nil); got != "gateway" {
+			t.Errorf("Canaries.Key() = %v, want %v", got, "gateway")
+		}
+	})
+}
+
+func TestClientMetadata(t *testing.T) {
+	address := func(md *_struct.Struct) string {
+		return md.GetFields()[ClientAddressField].GetStringValue()
+	}
+
+	t.Run("Sets the IP of the client", func(t *testing.T) {
+		in := &_struct.Struct{Fields: map[string]*_struct.Value{"zone": {Kind: &_struct.Value_StringValue{StringValue: "a"}}}}
+		got := ClientMetadata(in, "10.0.0.1:45678")
+		if address(got) != "10.0.0.1" || got.GetFields()["zone"].GetStringValue() != "a" {
+			t.Errorf("ClientMetadata() = %v", got)
+		}
+		if address(in) != "" {
+			t.Errorf("ClientMetadata() modified the given metadata")
+		}
+	})
+
+	t.Run("Sets the IP if there is no metadata", func(t *testing.T) {
+		if got := ClientMetadata(nil, "[fd00::1]:45678"); address(got) != "fd00::1" {
+			t.Errorf("ClientMetadata() = %v", got)
+		}
+	})
+
+	t.Run("Returns nil if the IP is already set", func(t *testing.T) {
+		if got := ClientMetadata(ClientMetadata(nil, "10.0.0.1:45678"), "10.0.0.1:5678"); got != nil {
+			t.Errorf("ClientMetadata() = %v, want nil", got)
+		}
+	})
+
+	t.Run("Returns nil if the address is unknown", func(t *testing.T) {
+		if got := ClientMetadata(nil, ""); got != nil {
+			t.Errorf("ClientMetadata() = %v, want nil", got)
+		}
+	})
+}
@@ -16,6 +16,7 @@ type Cache interface {
 	SetSnapshot(string, Snapshot) error
 	GetSnapshot(string) (Snapshot, error)
 	ClearSnapshot(string)
+	NodeIDs() []string
 	NewSnapshot(string) Snapshot
 }
 
@@ -28,4 +29,23 @@ type Snapshot interface {
 	GetResources(envoy.Type) map[string]envoy.Resource
 	GetVersion(envoy.Type) string
 	SetVersion(envoy.Type, string)
+	GetResourceVersions(envoy.Type) (map[string]string, error)
+}
+
+// PendingCache is implemented by the caches that delay the publication of snapshots,
+// so the snapshots waiting to be published can be looked up and published straight away
+type PendingCache interface {
+	GetPendingSnapshot(string) (Snapshot, bool)
+	Flush(string) error
+}
+
+// GetLatestSnapshot returns the last snapshot written to the cache for a node ID,
+// the one waiting to be published if there is any or the published one otherwise
+func GetLatestSnapshot(cache Cache, nodeID string) (Snapshot, error) {
+	if pc, ok := cache.(PendingCache); ok {
+		if snap, ok := pc.GetPendingSnapshot(nodeID); ok {
+			return snap, nil
+		}
+	}
+	return cache.GetSnapshot(nodeID)
 }
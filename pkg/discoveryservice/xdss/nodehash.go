@@ -0,0 +1,149 @@
+package discoveryservice
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+// NodeHashKind is the property of the envoy node used
+// to select the snapshot that is served to it
+type NodeHashKind string
+
+const (
+	// NodeHashID keys the snapshots by node ID
+	NodeHashID NodeHashKind = "ID"
+	// NodeHashCluster keys the snapshots by node cluster
+	NodeHashCluster NodeHashKind = "Cluster"
+	// NodeHashMetadata keys the snapshots by the value of a node metadata field
+	NodeHashMetadata NodeHashKind = "Metadata"
+	// NodeHashRegex keys the snapshots by the part of the node ID that
+	// matches a regular expression. If the expression has capturing groups,
+	// the first group is used instead of the whole match.
+	NodeHashRegex NodeHashKind = "Regex"
+	// NodeHashPrefix keys the snapshots by the longest of a list of
+	// prefixes that the node ID starts with
+	NodeHashPrefix NodeHashKind = "Prefix"
+)
+
+// NodeHash computes the key of the snapshot served to an envoy node, which is the
+// nodeID of the EnvoyConfig the node gets its config from. It allows a single EnvoyConfig
+// to serve a fleet of envoys with different node IDs, like the Pods of a Deployment.
+// The node ID is used when the property used for grouping is missing in the node.
+type NodeHash struct {
+	// Kind is the property of the node used to compute the key
+	Kind NodeHashKind
+	// MetadataField is the path of the node metadata field, with
+	// nested fields separated by dots. Used with NodeHashMetadata.
+	MetadataField string
+	// Pattern is the regular expression matched against
+	// the node ID. Used with NodeHashRegex.
+	Pattern *regexp.Regexp
+	// Prefixes are the node ID prefixes. Used with NodeHashPrefix.
+	Prefixes []string
+}
+
+// NewNodeHash returns a NodeHash of the given kind, validating that the
+// arguments it requires are set. An empty kind defaults to NodeHashID.
+func NewNodeHash(kind NodeHashKind, metadataField, pattern string, prefixes []string) (*NodeHash, error) {
+	switch kind {
+	case NodeHashID, "":
+		return &NodeHash{Kind: NodeHashID}, nil
+
+	case NodeHashCluster:
+		return &NodeHash{Kind: kind}, nil
+
+	case NodeHashMetadata:
+		if metadataField == "" {
+			return nil, fmt.Errorf("a metadata field is required to group nodes by metadata")
+		}
+		return &NodeHash{Kind: kind, MetadataField: metadataField}, nil
+
+	case NodeHashRegex:
+		if pattern == "" {
+			return nil, fmt.Errorf("a regular expression is required to group nodes by regex")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node ID regular expression: %w", err)
+		}
+		return &NodeHash{Kind: kind, Pattern: re}, nil
+
+	case NodeHashPrefix:
+		if len(prefixes) == 0 {
+			return nil, fmt.Errorf("at least one prefix is required to group nodes by prefix")
+		}
+		return &NodeHash{Kind: kind, Prefixes: prefixes}, nil
+	}
+
+	return nil, fmt.Errorf("unknown node hash kind %q", kind)
+}
+
+// Key returns the key of the snapshot for the node with the given properties
+func (h *NodeHash) Key(id, cluster string, metadata *_struct.Struct) string {
+	if h == nil {
+		return id
+	}
+
+	var key string
+	switch h.Kind {
+	case NodeHashCluster:
+		key = cluster
+
+	case NodeHashMetadata:
+		key = metadataValue(metadata, h.MetadataField)
+
+	case NodeHashRegex:
+		if match := h.Pattern.FindStringSubmatch(id); match != nil {
+			key = match[0]
+			if len(match) > 1 {
+				key = match[1]
+			}
+		}
+
+	case NodeHashPrefix:
+		for _, prefix := range h.Prefixes {
+			if strings.HasPrefix(id, prefix) && len(prefix) > len(key) {
+				key = prefix
+			}
+		}
+	}
+
+	if key == "" {
+		return id
+	}
+	return key
+}
+
+// metadataValue returns the string representation of the value of a
+// metadata field, or the empty string if the field does not exist or holds
+// a list or a struct
+func metadataValue(metadata *_struct.Struct, path string) string {
+	fields := strings.Split(path, ".")
+	for idx, field := range fields {
+		if metadata == nil {
+			return ""
+		}
+		value, ok := metadata.GetFields()[field]
+		if !ok {
+			return ""
+		}
+		if idx < len(fields)-1 {
+			metadata = value.GetStructValue()
+			continue
+		}
+
+		switch v := value.GetKind().(type) {
+		case *_struct.Value_StringValue:
+			return v.StringValue
+		case *_struct.Value_NumberValue:
+			return strconv.FormatFloat(v.NumberValue, 'f', -1, 64)
+		case *_struct.Value_BoolValue:
+			return strconv.FormatBool(v.BoolValue)
+		}
+	}
+	return ""
+}
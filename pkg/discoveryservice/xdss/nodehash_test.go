@@ -0,0 +1,81 @@
+package discoveryservice
+
+import (
+	"testing"
+
+	_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+func TestNewNodeHash(t *testing.T) {
+	tests := []struct {
+		name          string
+		kind          NodeHashKind
+		metadataField string
+		pattern       string
+		prefixes      []string
+		wantErr       bool
+	}{
+		{"Defaults to ID", "", "", "", nil, false},
+		{"Cluster", NodeHashCluster, "", "", nil, false},
+		{"Metadata requires a field", NodeHashMetadata, "", "", nil, true},
+		{"Regex requires a pattern", NodeHashRegex, "", "", nil, true},
+		{"Regex fails with an invalid pattern", NodeHashRegex, "", "(", nil, true},
+		{"Prefix requires prefixes", NodeHashPrefix, "", "", nil, true},
+		{"Unknown kind", "xxxx", "", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewNodeHash(tt.kind, tt.metadataField, tt.pattern, tt.prefixes); (err != nil) != tt.wantErr {
+				t.Errorf("NewNodeHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNodeHash_Key(t *testing.T) {
+	mustNodeHash := func(kind NodeHashKind, metadataField, pattern string, prefixes []string) *NodeHash {
+		h, err := NewNodeHash(kind, metadataField, pattern, prefixes)
+		if err != nil {
+			t.Fatalf("NewNodeHash() error = %v", err)
+		}
+		return h
+	}
+	metadata := &_struct.Struct{Fields: map[string]*_struct.Value{
+		"app":     {Kind: &_struct.Value_StringValue{StringValue: "gateway"}},
+		"version": {Kind: &_struct.Value_NumberValue{NumberValue: 2}},
+		"labels": {Kind: &_struct.Value_StructValue{StructValue: &_struct.Struct{Fields: map[string]*_struct.Value{
+			"tier": {Kind: &_struct.Value_StringValue{StringValue: "edge"}},
+		}}}},
+	}}
+
+	tests := []struct {
+		name     string
+		hash     *NodeHash
+		id       string
+		cluster  string
+		metadata *_struct.Struct
+		want     string
+	}{
+		{"Nil hash returns the node ID", nil, "gateway-7d9f-x2k", "gateway", nil, "gateway-7d9f-x2k"},
+		{"ID", mustNodeHash(NodeHashID, "", "", nil), "gateway-7d9f-x2k", "gateway", nil, "gateway-7d9f-x2k"},
+		{"Cluster", mustNodeHash(NodeHashCluster, "", "", nil), "gateway-7d9f-x2k", "gateway", nil, "gateway"},
+		{"Cluster falls back to the node ID", mustNodeHash(NodeHashCluster, "", "", nil), "gateway-7d9f-x2k", "", nil, "gateway-7d9f-x2k"},
+		{"Metadata", mustNodeHash(NodeHashMetadata, "app", "", nil), "gateway-7d9f-x2k", "", metadata, "gateway"},
+		{"Metadata number", mustNodeHash(NodeHashMetadata, "version", "", nil), "gateway-7d9f-x2k", "", metadata, "2"},
+		{"Metadata nested field", mustNodeHash(NodeHashMetadata, "labels.tier", "", nil), "gateway-7d9f-x2k", "", metadata, "edge"},
+		{"Metadata falls back to the node ID", mustNodeHash(NodeHashMetadata, "labels.xxxx", "", nil), "gateway-7d9f-x2k", "", metadata, "gateway-7d9f-x2k"},
+		{"Metadata falls back to the node ID without metadata", mustNodeHash(NodeHashMetadata, "app", "", nil), "gateway-7d9f-x2k", "", nil, "gateway-7d9f-x2k"},
+		{"Regex uses the first group", mustNodeHash(NodeHashRegex, "", "^(.*)-[a-z0-9]+-[a-z0-9]+$", nil), "gateway-7d9f-x2k", "", nil, "gateway"},
+		{"Regex uses the whole match", mustNodeHash(NodeHashRegex, "", "^[a-z]+", nil), "gateway-7d9f-x2k", "", nil, "gateway"},
+		{"Regex falls back to the node ID", mustNodeHash(NodeHashRegex, "", "^sidecar", nil), "gateway-7d9f-x2k", "", nil, "gateway-7d9f-x2k"},
+		{"Prefix uses the longest prefix", mustNodeHash(NodeHashPrefix, "", "", []string{"gate", "gateway-"}), "gateway-7d9f-x2k", "", nil, "gateway-"},
+		{"Prefix falls back to the node ID", mustNodeHash(NodeHashPrefix, "", "", []string{"sidecar-"}), "gateway-7d9f-x2k", "", nil, "gateway-7d9f-x2k"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hash.Key(tt.id, tt.cluster, tt.metadata); got != tt.want {
+				t.Errorf("NodeHash.Key() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
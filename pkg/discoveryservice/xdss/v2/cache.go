@@ -1,25 +1,38 @@
 package discoveryservice
 
 import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/metrics"
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
 	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
 )
 
 // Cache implements "github.com/3scale/marin3r/pkg/discoveryservice/xdss".Cache for envoy API v2.
 type Cache struct {
-	v2 cache_v2.SnapshotCache
+	v2    cache_v2.SnapshotCache
+	nodes *sync.Map
 }
 
 // NewCache returns a Cache object.
 func NewCache(v2 cache_v2.SnapshotCache) Cache {
-	return Cache{v2: v2}
+	return Cache{v2: v2, nodes: &sync.Map{}}
 }
 
 // SetSnapshot updates a snapshot for a node.
 func (c Cache) SetSnapshot(nodeID string, snap xdss.Snapshot) error {
 
-	return c.v2.SetSnapshot(nodeID, *snap.(Snapshot).v2)
+	start := time.Now()
+	if err := c.v2.SetSnapshot(nodeID, *snap.(Snapshot).v2); err != nil {
+		return err
+	}
+	c.nodes.Store(nodeID, struct{}{})
+	metrics.ObserveSnapshotSet(envoy.APIv2, nodeID, snap, time.Since(start))
+	return nil
 }
 
 // GetSnapshot gets the snapshot for a node, and returns an error if not found.
@@ -36,6 +49,20 @@ func (c Cache) GetSnapshot(nodeID string) (xdss.Snapshot, error) {
 func (c Cache) ClearSnapshot(nodeID string) {
 
 	c.v2.ClearSnapshot(nodeID)
+	c.nodes.Delete(nodeID)
+	metrics.ForgetSnapshot(envoy.APIv2, nodeID)
+}
+
+// NodeIDs returns the node IDs that have a snapshot in the cache, sorted.
+func (c Cache) NodeIDs() []string {
+
+	nodeIDs := []string{}
+	c.nodes.Range(func(key, value interface{}) bool {
+		nodeIDs = append(nodeIDs, key.(string))
+		return true
+	})
+	sort.Strings(nodeIDs)
+	return nodeIDs
 }
 
 // NewSnapshot returns a Snapshot object
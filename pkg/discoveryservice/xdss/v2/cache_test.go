@@ -1,6 +1,8 @@
 package discoveryservice
 
 import (
+	"reflect"
+	"sync"
 	"testing"
 
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
@@ -54,7 +56,8 @@ func TestCache_SetSnapshot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Cache{
-				v2: tt.fields.v2,
+				v2:    tt.fields.v2,
+				nodes: &sync.Map{},
 			}
 			if err := c.SetSnapshot(tt.args.nodeID, tt.args.snap); (err != nil) != tt.wantErr {
 				t.Errorf("Cache.SetSnapshot() error = %v, wantErr %v", err, tt.wantErr)
@@ -138,7 +141,8 @@ func TestCache_GetSnapshot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Cache{
-				v2: tt.fields.v2,
+				v2:    tt.fields.v2,
+				nodes: &sync.Map{},
 			}
 			got, err := c.GetSnapshot(tt.args.nodeID)
 			if (err != nil) != tt.wantErr {
@@ -188,7 +192,8 @@ func TestCache_ClearSnapshot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Cache{
-				v2: tt.fields.v2,
+				v2:    tt.fields.v2,
+				nodes: &sync.Map{},
 			}
 			c.ClearSnapshot(tt.args.nodeID)
 			if _, err := c.GetSnapshot("node"); err == nil {
@@ -229,7 +234,8 @@ func TestCache_NewSnapshot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Cache{
-				v2: tt.fields.v2,
+				v2:    tt.fields.v2,
+				nodes: &sync.Map{},
 			}
 			if got := c.NewSnapshot(tt.args.resourcesVersion); !testutil.SnapshotsAreEqual(got, tt.want) {
 				t.Errorf("Cache.NewSnapshot() = %v, want %v", got, tt.want)
@@ -237,3 +243,15 @@ func TestCache_NewSnapshot(t *testing.T) {
 		})
 	}
 }
+
+func TestCache_NodeIDs(t *testing.T) {
+	c := NewCache(cache_v2.NewSnapshotCache(true, cache_v2.IDHash{}, nil))
+	c.SetSnapshot("node2", c.NewSnapshot("xxxx"))
+	c.SetSnapshot("node1", c.NewSnapshot("xxxx"))
+	c.SetSnapshot("node3", c.NewSnapshot("xxxx"))
+	c.ClearSnapshot("node3")
+
+	if got := c.NodeIDs(); !reflect.DeepEqual(got, []string{"node1", "node2"}) {
+		t.Errorf("Cache.NodeIDs() = %v, want %v", got, []string{"node1", "node2"})
+	}
+}
@@ -16,12 +16,19 @@ package discoveryservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
+	"github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	"github.com/3scale/marin3r/pkg/discoveryservice/metrics"
+	"github.com/3scale/marin3r/pkg/discoveryservice/registry"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	"github.com/3scale/marin3r/pkg/envoy"
 	envoy_resources_v2 "github.com/3scale/marin3r/pkg/envoy/resources/v2"
 	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
 	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	envoy_api_v2_core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
 	"github.com/go-logr/logr"
 )
@@ -31,12 +38,78 @@ type Callbacks struct {
 	OnError       func(nodeID, previousVersion, msg string, envoyAPI envoy.APIVersion) error
 	SnapshotCache *cache_v2.SnapshotCache
 	Logger        logr.Logger
+	// Registry keeps track of the connected clients. Optional.
+	Registry *registry.Registry
+	// Authorizer checks that the client certificate is allowed to request the
+	// config of the node ID the snapshot served to the client belongs to. Optional.
+	Authorizer *authz.Authorizer
+	// NodeHash computes the key of the snapshot served to a node, which
+	// might be shared by several node IDs. Defaults to the node ID.
+	NodeHash cache_v2.NodeHash
+
+	// addresses holds the peer address of the client of each open stream
+	addresses sync.Map
+}
+
+// setClientAddress sets the address of the client in the metadata of the
+// node, so each client is picked as canary or not on its own
+func setClientAddress(node *envoy_api_v2_core.Node, peerAddress string) {
+	if md := xdss.ClientMetadata(node.GetMetadata(), peerAddress); md != nil {
+		node.Metadata = md
+	}
+}
+
+// streamAddress returns the peer address of the client of a stream
+func (cb *Callbacks) streamAddress(key registry.StreamKey) string {
+	if address, ok := cb.addresses.Load(key); ok {
+		return address.(string)
+	}
+	return ""
+}
+
+// snapshotKey returns the key of the snapshot served to the node
+func (cb *Callbacks) snapshotKey(node *envoy_api_v2_core.Node) string {
+	if cb.NodeHash == nil {
+		return node.GetId()
+	}
+	return cb.NodeHash.ID(node)
+}
+
+// snapshotVersion returns the version of the given type URL in the snapshot of a key
+func (cb *Callbacks) snapshotVersion(key, typeURL string) (string, error) {
+	snap, err := (*cb.SnapshotCache).GetSnapshot(key)
+	if err != nil {
+		return "", err
+	}
+	return snap.GetVersion(typeURL), nil
+}
+
+// reportNACK reports the version of the snapshot of a key a client has rejected for
+// the given type URL through OnError. The keysAndValues are added to the logs.
+func (cb *Callbacks) reportNACK(key, typeURL, msg string, keysAndValues ...interface{}) error {
+	metrics.NACKs.WithLabelValues(string(envoy.APIv2), key, typeURL).Inc()
+	version, err := cb.snapshotVersion(key, typeURL)
+	if err != nil {
+		return err
+	}
+	failingVersion := xdss.ConfigVersion(typeURL, version)
+	cb.Logger.Error(errors.New(msg), "A gateway reported an error", append([]interface{}{"FailingVersion", failingVersion}, keysAndValues...)...)
+	if err := cb.OnError(key, failingVersion, msg, envoy.APIv2); err != nil {
+		cb.Logger.Error(err, "Error calling OnErrorFn", keysAndValues...)
+		return err
+	}
+	return nil
 }
 
 // OnStreamOpen implements go-control-plane/pkg/server/Callbacks.OnStreamOpen
 // Returning an error will end processing and close the stream. OnStreamClosed will still be called.
 func (cb *Callbacks) OnStreamOpen(ctx context.Context, id int64, typ string) error {
 	cb.Logger.V(1).Info("Stream opened", "StreamId", id)
+	cb.addresses.Store(registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: id}, registry.PeerAddress(ctx))
+	if cb.Registry != nil {
+		cb.Registry.OpenStream(registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: id}, registry.PeerAddress(ctx))
+	}
+	cb.Authorizer.OpenStream(ctx, envoy.APIv2, false, id)
 	return nil
 }
 
@@ -44,6 +117,11 @@ func (cb *Callbacks) OnStreamOpen(ctx context.Context, id int64, typ string) err
 // OnStreamClosed is called immediately prior to closing an xDS stream with a stream ID.
 func (cb *Callbacks) OnStreamClosed(id int64) {
 	cb.Logger.V(1).Info("Stream closed", "StreamID", id)
+	cb.addresses.Delete(registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: id})
+	if cb.Registry != nil {
+		cb.Registry.CloseStream(registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: id})
+	}
+	cb.Authorizer.CloseStream(envoy.APIv2, false, id)
 }
 
 // OnStreamRequest implements go-control-plane/pkg/server/Callbacks.OnStreamRequest
@@ -51,19 +129,32 @@ func (cb *Callbacks) OnStreamClosed(id int64) {
 // Returning an error will end processing and close the stream. OnStreamClosed will still be called.
 func (cb *Callbacks) OnStreamRequest(id int64, req *envoy_api_v2.DiscoveryRequest) error {
 	cb.Logger.V(1).Info("Received request", "ResourceNames", req.ResourceNames, "Version", req.VersionInfo, "TypeURL", req.TypeUrl, "NodeID", req.Node.Id, "StreamID", id)
+	setClientAddress(req.Node, cb.streamAddress(registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: id}))
+	key := cb.snapshotKey(req.Node)
 
-	if req.ErrorDetail != nil {
-		snap, err := (*cb.SnapshotCache).GetSnapshot(req.Node.Id)
-		if err != nil {
-			return err
-		}
-		// All resource types are always kept at the same version
-		failingVersion := snap.GetVersion(req.TypeUrl)
-		cb.Logger.Error(fmt.Errorf(req.ErrorDetail.Message), "A gateway reported an error", "CurrentVersion", req.VersionInfo, "FailingVersion", failingVersion, "NodeID", req.Node.Id, "StreamID", id)
-		if err := cb.OnError(req.Node.Id, failingVersion, req.ErrorDetail.Message, envoy.APIv2); err != nil {
-			cb.Logger.Error(err, "Error calling OnErrorFn", "NodeID", req.Node.Id, "StreamID", id)
-			return err
+	if err := cb.Authorizer.AuthorizeStream(envoy.APIv2, false, id, xdss.NodeIDForKey(key)); err != nil {
+		cb.Logger.Error(err, "Client not allowed to request the node ID", "NodeID", req.Node.Id, "SnapshotKey", key, "StreamID", id)
+		return err
+	}
+	metrics.Requests.WithLabelValues(string(envoy.APIv2), key, req.TypeUrl).Inc()
+
+	if req.ResponseNonce != "" && req.ErrorDetail == nil {
+		// Clients send the version they have applied
+		// in the version_info field when they ACK
+		metrics.ObserveACK(envoy.APIv2, key, registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: id}, req.TypeUrl, req.VersionInfo)
+	}
+
+	if cb.Registry != nil {
+		var nackMsg *string
+		if req.ErrorDetail != nil {
+			nackMsg = &req.ErrorDetail.Message
 		}
+		cb.Registry.RequestReceived(registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: id},
+			key, req.TypeUrl, req.ResponseNonce, nackMsg)
+	}
+
+	if req.ErrorDetail != nil {
+		return cb.reportNACK(key, req.TypeUrl, req.ErrorDetail.Message, "CurrentVersion", req.VersionInfo, "NodeID", req.Node.Id, "StreamID", id)
 	}
 	return nil
 }
@@ -71,6 +162,12 @@ func (cb *Callbacks) OnStreamRequest(id int64, req *envoy_api_v2.DiscoveryReques
 // OnStreamResponse implements go-control-plane/pkgserver/Callbacks.OnStreamResponse
 // OnStreamResponse is called immediately prior to sending a response on a stream.
 func (cb *Callbacks) OnStreamResponse(id int64, req *envoy_api_v2.DiscoveryRequest, rsp *envoy_api_v2.DiscoveryResponse) {
+	metrics.Responses.WithLabelValues(string(envoy.APIv2), cb.snapshotKey(req.Node), rsp.TypeUrl).Inc()
+
+	if cb.Registry != nil {
+		cb.Registry.ResponseSent(registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: id}, rsp.TypeUrl, rsp.Nonce, rsp.VersionInfo)
+	}
+
 	resources := []string{}
 	for _, r := range rsp.Resources {
 		j, _ := envoy_serializer.NewResourceMarshaller(envoy_serializer.JSON, envoy.APIv2).Marshal(r)
@@ -89,10 +186,31 @@ func (cb *Callbacks) OnStreamResponse(id int64, req *envoy_api_v2.DiscoveryReque
 // OnFetchRequest is called for each Fetch request. Returning an error will end processing of the
 // request and respond with an error.
 func (cb *Callbacks) OnFetchRequest(ctx context.Context, req *envoy_api_v2.DiscoveryRequest) error {
+	if req.Node == nil {
+		return fmt.Errorf("missing node identifier")
+	}
+	cb.Logger.V(1).Info("Received fetch request", "ResourceNames", req.ResourceNames, "Version", req.VersionInfo, "TypeURL", req.TypeUrl, "NodeID", req.Node.Id)
+	setClientAddress(req.Node, registry.PeerAddress(ctx))
+	key := cb.snapshotKey(req.Node)
+
+	if err := cb.Authorizer.AuthorizeContext(ctx, xdss.NodeIDForKey(key), envoy.APIv2); err != nil {
+		cb.Logger.Error(err, "Client not allowed to request the node ID", "NodeID", req.Node.Id, "SnapshotKey", key)
+		return err
+	}
+	metrics.Requests.WithLabelValues(string(envoy.APIv2), key, req.TypeUrl).Inc()
+
+	// REST clients report errors in the next request they send to the
+	// server, so NACKs are handled the same way as in gRPC streams
+	if req.ErrorDetail != nil {
+		return cb.reportNACK(key, req.TypeUrl, req.ErrorDetail.Message, "CurrentVersion", req.VersionInfo, "NodeID", req.Node.Id)
+	}
 	return nil
 }
 
 // OnFetchResponse implements go-control-plane/pkg/server/Callbacks.OnFetchRequest
 // OnFetchResponse is called immediately prior to sending a response.
 func (cb *Callbacks) OnFetchResponse(req *envoy_api_v2.DiscoveryRequest, resp *envoy_api_v2.DiscoveryResponse) {
+	metrics.Responses.WithLabelValues(string(envoy.APIv2), cb.snapshotKey(req.GetNode()), resp.TypeUrl).Inc()
+	cb.Logger.V(1).Info("Fetch response sent to gateway",
+		"ResourcesNames", req.ResourceNames, "TypeURL", req.TypeUrl, "NodeID", req.GetNode().GetId(), "Version", resp.GetVersionInfo())
 }
@@ -16,10 +16,16 @@ package discoveryservice
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"testing"
 
+	"github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources_v2 "github.com/3scale/marin3r/pkg/envoy/resources/v2"
 	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	envoy_api_v2_core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
@@ -27,6 +33,8 @@ import (
 
 	"github.com/golang/protobuf/ptypes/any"
 	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -36,7 +44,7 @@ func fakeTestCache() *cache_v2.SnapshotCache {
 
 	snapshotCache.SetSnapshot("node1", cache_v2.Snapshot{
 		Resources: [6]cache_v2.Resources{
-			{Version: "1", Items: map[string]cache_types.Resource{
+			{Version: "1-6f8c2d", Items: map[string]cache_types.Resource{
 				"endpoint1": &envoy_api_v2.ClusterLoadAssignment{ClusterName: "endpoint1"},
 			}},
 			{Version: "1", Items: map[string]cache_types.Resource{
@@ -52,6 +60,24 @@ func fakeTestCache() *cache_v2.SnapshotCache {
 	return &snapshotCache
 }
 
+// peerContext returns a context with the gRPC peer information
+// of a client that presents a certificate for the given identity
+func peerContext(identity string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: identity}}},
+		}},
+	})
+}
+
+// streamAuthorizer returns an Authorizer with the Exact policy and an
+// open stream whose client presents a certificate for the given identity
+func streamAuthorizer(id int64, identity string) *authz.Authorizer {
+	a := &authz.Authorizer{Policy: authz.PolicyExact}
+	a.OpenStream(peerContext(identity), envoy.APIv2, false, id)
+	return a
+}
+
 func TestCallbacks_OnStreamOpen(t *testing.T) {
 	type args struct {
 		ctx context.Context
@@ -168,6 +194,63 @@ func TestCallbacks_OnStreamRequest(t *testing.T) {
 			}},
 			true,
 		},
+		{
+			"OnStreamRequest() node ID not authorized",
+			&Callbacks{
+				Logger:     ctrl.Log,
+				Authorizer: &authz.Authorizer{Policy: authz.PolicyExact},
+			},
+			args{1, &envoy_api_v2.DiscoveryRequest{
+				Node:    &envoy_api_v2_core.Node{Id: "node1", Cluster: "cluster1"},
+				TypeUrl: "some-type",
+			}},
+			true,
+		},
+		{
+			"OnStreamRequest() node ID authorized for the snapshot key",
+			&Callbacks{
+				Logger:     ctrl.Log,
+				Authorizer: streamAuthorizer(1, "node1"),
+				NodeHash:   NewNodeHash(&xdss.NodeHash{Kind: xdss.NodeHashCluster}, nil),
+			},
+			args{1, &envoy_api_v2.DiscoveryRequest{
+				Node:    &envoy_api_v2_core.Node{Id: "gateway-7d9f-x2k", Cluster: "node1"},
+				TypeUrl: "some-type",
+			}},
+			false,
+		},
+		{
+			"OnStreamRequest() node ID not authorized for the snapshot key",
+			&Callbacks{
+				Logger:     ctrl.Log,
+				Authorizer: streamAuthorizer(1, "node1"),
+				NodeHash:   NewNodeHash(&xdss.NodeHash{Kind: xdss.NodeHashCluster}, nil),
+			},
+			args{1, &envoy_api_v2.DiscoveryRequest{
+				Node:    &envoy_api_v2_core.Node{Id: "node1", Cluster: "node2"},
+				TypeUrl: "some-type",
+			}},
+			true,
+		},
+		{
+			"OnStreamRequest() NACK received for endpoints",
+			&Callbacks{
+				OnError: func(nodeID, version, c string, d envoy.APIVersion) error {
+					if version != "1" {
+						return fmt.Errorf("unexpected version %q", version)
+					}
+					return nil
+				},
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+			},
+			args{1, &envoy_api_v2.DiscoveryRequest{
+				Node:        &envoy_api_v2_core.Node{Id: "node1", Cluster: "cluster1"},
+				TypeUrl:     envoy_resources_v2.Mappings()[envoy.Endpoint],
+				ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+			}},
+			false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -247,10 +330,94 @@ func TestCallbacks_OnFetchRequest(t *testing.T) {
 		{
 			"OnFetchRequest()",
 			&Callbacks{Logger: ctrl.Log},
+			args{
+				context.Background(),
+				&envoy_api_v2.DiscoveryRequest{
+					Node:    &envoy_api_v2_core.Node{Id: "node1", Cluster: "cluster1"},
+					TypeUrl: "some-type",
+				},
+			},
+			false,
+		},
+		{
+			"OnFetchRequest() error, missing node",
+			&Callbacks{Logger: ctrl.Log},
 			args{
 				context.Background(),
 				&envoy_api_v2.DiscoveryRequest{},
 			},
+			true,
+		},
+		{
+			"OnFetchRequest() NACK received",
+			&Callbacks{
+				OnError:       func(a, b, c string, d envoy.APIVersion) error { return nil },
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+			},
+			args{
+				context.Background(),
+				&envoy_api_v2.DiscoveryRequest{
+					Node:        &envoy_api_v2_core.Node{Id: "node1", Cluster: "cluster1"},
+					TypeUrl:     "some-type",
+					ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+				},
+			},
+			false,
+		},
+		{
+			"OnFetchRequest() error calling OnErrorFn",
+			&Callbacks{
+				OnError:       func(a, b, c string, d envoy.APIVersion) error { return fmt.Errorf("err") },
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+			},
+			args{
+				context.Background(),
+				&envoy_api_v2.DiscoveryRequest{
+					Node:        &envoy_api_v2_core.Node{Id: "node1", Cluster: "cluster1"},
+					TypeUrl:     "some-type",
+					ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+				},
+			},
+			true,
+		},
+		{
+			"OnFetchRequest() node ID not authorized for the snapshot key",
+			&Callbacks{
+				Logger:     ctrl.Log,
+				Authorizer: &authz.Authorizer{Policy: authz.PolicyExact},
+				NodeHash:   NewNodeHash(&xdss.NodeHash{Kind: xdss.NodeHashCluster}, nil),
+			},
+			args{
+				peerContext("node1"),
+				&envoy_api_v2.DiscoveryRequest{
+					Node:    &envoy_api_v2_core.Node{Id: "node1", Cluster: "node2"},
+					TypeUrl: "some-type",
+				},
+			},
+			true,
+		},
+		{
+			"OnFetchRequest() NACK received for endpoints",
+			&Callbacks{
+				OnError: func(nodeID, version, c string, d envoy.APIVersion) error {
+					if version != "1" {
+						return fmt.Errorf("unexpected version %q", version)
+					}
+					return nil
+				},
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+			},
+			args{
+				context.Background(),
+				&envoy_api_v2.DiscoveryRequest{
+					Node:        &envoy_api_v2_core.Node{Id: "node1", Cluster: "cluster1"},
+					TypeUrl:     envoy_resources_v2.Mappings()[envoy.Endpoint],
+					ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+				},
+			},
 			false,
 		},
 	}
@@ -0,0 +1,31 @@
+package discoveryservice
+
+import (
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy_api_v2_core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
+)
+
+// NodeHash implements "github.com/envoyproxy/go-control-plane/pkg/cache/v2".NodeHash
+// for envoy API v2 using a "github.com/3scale/marin3r/pkg/discoveryservice/xdss".NodeHash
+type NodeHash struct {
+	hash     *xdss.NodeHash
+	canaries *xdss.Canaries
+}
+
+var _ cache_v2.NodeHash = NodeHash{}
+
+// NewNodeHash returns a NodeHash object. A nil hash keys the snapshots by node ID. The
+// canaries are optional, the clients selected as canaries of a canary rollout in progress
+// are served the canary snapshot of the nodeID.
+func NewNodeHash(hash *xdss.NodeHash, canaries *xdss.Canaries) NodeHash {
+	return NodeHash{hash: hash, canaries: canaries}
+}
+
+// ID returns the key of the snapshot served to the node
+func (h NodeHash) ID(node *envoy_api_v2_core.Node) string {
+	if node == nil {
+		return ""
+	}
+	return h.canaries.Key(h.hash.Key(node.Id, node.Cluster, node.Metadata), node.Id, node.Metadata)
+}
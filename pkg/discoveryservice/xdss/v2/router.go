@@ -0,0 +1,126 @@
+package discoveryservice
+
+import (
+	"sync"
+
+	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
+)
+
+// WatchRouter wraps a "github.com/envoyproxy/go-control-plane/pkg/cache/v2".SnapshotCache
+// to move the open watches to a different snapshot when the key the NodeHash computes for
+// them changes, like when a client is selected as canary of a rollout. The go-control-plane
+// cache computes the key only when a watch is created, so without it a client would not get
+// the snapshot of its new key until it sends a new request. The open watches are rerouted
+// every time a snapshot is set or cleared.
+type WatchRouter struct {
+	cache_v2.SnapshotCache
+	hash cache_v2.NodeHash
+
+	mu      sync.Mutex
+	nextID  int64
+	watches map[int64]*routedWatch
+}
+
+// routedWatch is an open watch of the WatchRouter
+type routedWatch struct {
+	request *cache_v2.Request
+	value   chan cache_v2.Response
+	key     string
+	cancel  func()
+	stop    chan struct{}
+}
+
+var _ cache_v2.SnapshotCache = &WatchRouter{}
+
+// NewWatchRouter returns a WatchRouter for the given cache, which must
+// use the same NodeHash to key the snapshots
+func NewWatchRouter(cache cache_v2.SnapshotCache, hash cache_v2.NodeHash) *WatchRouter {
+	return &WatchRouter{SnapshotCache: cache, hash: hash, watches: map[int64]*routedWatch{}}
+}
+
+// CreateWatch implements "github.com/envoyproxy/go-control-plane/pkg/cache/v2".ConfigWatcher
+func (wr *WatchRouter) CreateWatch(request *cache_v2.Request) (chan cache_v2.Response, func()) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	wr.nextID++
+	id := wr.nextID
+	// Only one response is ever sent to the channel, so
+	// a buffer of 1 never blocks the sender
+	w := &routedWatch{request: request, value: make(chan cache_v2.Response, 1)}
+	wr.watches[id] = w
+	wr.open(id, w)
+
+	return w.value, func() {
+		wr.mu.Lock()
+		defer wr.mu.Unlock()
+		if _, ok := wr.watches[id]; ok {
+			wr.close(w)
+			delete(wr.watches, id)
+		}
+	}
+}
+
+// SetSnapshot implements "github.com/envoyproxy/go-control-plane/pkg/cache/v2".SnapshotCache
+func (wr *WatchRouter) SetSnapshot(node string, snapshot cache_v2.Snapshot) error {
+	if err := wr.SnapshotCache.SetSnapshot(node, snapshot); err != nil {
+		return err
+	}
+	wr.Reroute()
+	return nil
+}
+
+// ClearSnapshot implements "github.com/envoyproxy/go-control-plane/pkg/cache/v2".SnapshotCache
+func (wr *WatchRouter) ClearSnapshot(node string) {
+	wr.SnapshotCache.ClearSnapshot(node)
+	wr.Reroute()
+}
+
+// Reroute moves the open watches whose key has changed to the snapshot of their new key
+func (wr *WatchRouter) Reroute() {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	for id, w := range wr.watches {
+		if wr.hash.ID(w.request.Node) != w.key {
+			wr.close(w)
+			wr.open(id, w)
+		}
+	}
+}
+
+// open opens the watch in the wrapped cache under its current key, and forwards
+// the response to the watch's channel. Must be called with the lock held.
+func (wr *WatchRouter) open(id int64, w *routedWatch) {
+	w.key = wr.hash.ID(w.request.Node)
+	value, cancel := wr.SnapshotCache.CreateWatch(w.request)
+	w.cancel = cancel
+	stop := make(chan struct{})
+	w.stop = stop
+
+	go func() {
+		select {
+		case rsp := <-value:
+			wr.mu.Lock()
+			select {
+			case <-stop:
+				// The watch has been rerouted or cancelled meanwhile
+				wr.mu.Unlock()
+				return
+			default:
+			}
+			delete(wr.watches, id)
+			wr.mu.Unlock()
+			w.value <- rsp
+		case <-stop:
+		}
+	}()
+}
+
+// close cancels the watch in the wrapped cache. Must be called with the lock held.
+func (wr *WatchRouter) close(w *routedWatch) {
+	close(w.stop)
+	if w.cancel != nil {
+		w.cancel()
+	}
+}
@@ -1,6 +1,10 @@
 package discoveryservice
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
 	envoy "github.com/3scale/marin3r/pkg/envoy"
 	envoy_resources_v2 "github.com/3scale/marin3r/pkg/envoy/resources/v2"
 	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
@@ -76,9 +80,38 @@ func (s Snapshot) GetVersion(rType envoy.Type) string {
 
 // SetVersion sets the version for a resource type.
 func (s Snapshot) SetVersion(rType envoy.Type, version string) {
+	if _, ok := envoy_resources_v2.Mappings()[rType]; !ok {
+		return
+	}
 	s.v2.Resources[v2CacheResources(rType)].Version = version
 }
 
+// GetResourceVersions returns the version of each one of the resources
+// of the given type, indexed by resource name. The version of a resource
+// is calculated from its serialized representation so it only changes
+// when the resource itself changes, which allows incremental xDS clients to
+// receive only the resources that have been modified.
+func (s Snapshot) GetResourceVersions(rType envoy.Type) (map[string]string, error) {
+	versions := map[string]string{}
+	for name, res := range s.GetResources(rType) {
+		version, err := resourceVersion(res)
+		if err != nil {
+			return nil, fmt.Errorf("unable to calculate the version of resource %q: %w", name, err)
+		}
+		versions[name] = version
+	}
+	return versions, nil
+}
+
+func resourceVersion(res envoy.Resource) (string, error) {
+	b, err := cache_v2.MarshalResource(res)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
 func v2CacheResources(rType envoy.Type) int {
 	types := map[envoy.Type]int{
 		envoy.Endpoint: 0,
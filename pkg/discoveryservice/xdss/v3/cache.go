@@ -1,25 +1,52 @@
 package discoveryservice
 
 import (
+	"time"
+
+	"github.com/3scale/marin3r/pkg/discoveryservice/metrics"
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
 	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 )
 
 // Cache implements "github.com/3scale/marin3r/pkg/discoveryservice/xdss".Cache for envoy API v3.
+// The resources of the extension types (ECDS, SRDS and VHDS), not supported by
+// the go-control-plane snapshot cache, are kept in a separate store.
 type Cache struct {
-	v3 cache_v3.SnapshotCache
+	v3         cache_v3.SnapshotCache
+	extensions *extensionStore
 }
 
 // NewCache returns a Cache object.
 func NewCache(v3 cache_v3.SnapshotCache) Cache {
-	return Cache{v3: v3}
+	return Cache{v3: v3, extensions: newExtensionStore()}
 }
 
-// SetSnapshot updates a snapshot for a node.
+// SetSnapshot updates a snapshot for a node. The versions of the resources of the
+// snapshot are calculated here and stored along with it, so they are reused in every
+// response of the incremental xDS protocol.
 func (c Cache) SetSnapshot(nodeID string, snap xdss.Snapshot) error {
 
-	return c.v3.SetSnapshot(nodeID, *snap.(Snapshot).v3)
+	start := time.Now()
+	s := snap.(Snapshot)
+	versions, err := s.allResourceVersions()
+	if err != nil {
+		return err
+	}
+	ext := newExtensionResources("")
+	if s.ext != nil {
+		e := *s.ext
+		ext = &e
+	}
+	ext.versions = versions
+
+	if err := c.v3.SetSnapshot(nodeID, *s.v3); err != nil {
+		return err
+	}
+	c.extensions.set(nodeID, ext)
+	metrics.ObserveSnapshotSet(envoy.APIv3, nodeID, snap, time.Since(start))
+	return nil
 }
 
 // GetSnapshot gets the snapshot for a node, and returns an error if not found.
@@ -29,13 +56,25 @@ func (c Cache) GetSnapshot(nodeID string) (xdss.Snapshot, error) {
 	if err != nil {
 		return &Snapshot{}, err
 	}
-	return &Snapshot{v3: &snap}, nil
+	ext, ok := c.extensions.get(nodeID)
+	if !ok {
+		ext = newExtensionResources("")
+	}
+	return &Snapshot{v3: &snap, ext: ext}, nil
 }
 
 // ClearSnapshot clears snapshot and info for a node.
 func (c Cache) ClearSnapshot(nodeID string) {
 
 	c.v3.ClearSnapshot(nodeID)
+	c.extensions.clear(nodeID)
+	metrics.ForgetSnapshot(envoy.APIv3, nodeID)
+}
+
+// NodeIDs returns the node IDs that have a snapshot in the cache, sorted.
+func (c Cache) NodeIDs() []string {
+
+	return c.extensions.nodeIDs()
 }
 
 // NewSnapshot returns a Snapshot object
@@ -49,5 +88,5 @@ func (c Cache) NewSnapshot(resourcesVersion string) xdss.Snapshot {
 	snap.Resources[cache_types.Secret] = cache_v3.NewResources(resourcesVersion, []cache_types.Resource{})
 	snap.Resources[cache_types.Runtime] = cache_v3.NewResources(resourcesVersion, []cache_types.Resource{})
 
-	return Snapshot{v3: snap}
+	return Snapshot{v3: snap, ext: newExtensionResources(resourcesVersion)}
 }
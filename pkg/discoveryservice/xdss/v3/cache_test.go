@@ -1,6 +1,7 @@
 package discoveryservice
 
 import (
+	"reflect"
 	"testing"
 
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
@@ -54,7 +55,8 @@ func TestCache_SetSnapshot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Cache{
-				v3: tt.fields.v3,
+				v3:         tt.fields.v3,
+				extensions: newExtensionStore(),
 			}
 			if err := c.SetSnapshot(tt.args.nodeID, tt.args.snap); (err != nil) != tt.wantErr {
 				t.Errorf("Cache.SetSnapshot() error = %v, wantErr %v", err, tt.wantErr)
@@ -138,7 +140,8 @@ func TestCache_GetSnapshot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Cache{
-				v3: tt.fields.v3,
+				v3:         tt.fields.v3,
+				extensions: newExtensionStore(),
 			}
 			got, err := c.GetSnapshot(tt.args.nodeID)
 			if (err != nil) != tt.wantErr {
@@ -188,7 +191,8 @@ func TestCache_ClearSnapshot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Cache{
-				v3: tt.fields.v3,
+				v3:         tt.fields.v3,
+				extensions: newExtensionStore(),
 			}
 			c.ClearSnapshot(tt.args.nodeID)
 			if _, err := c.GetSnapshot("node"); err == nil {
@@ -229,7 +233,8 @@ func TestCache_NewSnapshot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := Cache{
-				v3: tt.fields.v3,
+				v3:         tt.fields.v3,
+				extensions: newExtensionStore(),
 			}
 			if got := c.NewSnapshot(tt.args.resourcesVersion); !testutil.SnapshotsAreEqual(got, tt.want) {
 				t.Errorf("Cache.NewSnapshot() = %v, want %v", got, tt.want)
@@ -237,3 +242,15 @@ func TestCache_NewSnapshot(t *testing.T) {
 		})
 	}
 }
+
+func TestCache_NodeIDs(t *testing.T) {
+	c := NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+	c.SetSnapshot("node2", c.NewSnapshot("xxxx"))
+	c.SetSnapshot("node1", c.NewSnapshot("xxxx"))
+	c.SetSnapshot("node3", c.NewSnapshot("xxxx"))
+	c.ClearSnapshot("node3")
+
+	if got := c.NodeIDs(); !reflect.DeepEqual(got, []string{"node1", "node2"}) {
+		t.Errorf("Cache.NodeIDs() = %v, want %v", got, []string{"node1", "node2"})
+	}
+}
@@ -16,11 +16,18 @@ package discoveryservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
+	"github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	"github.com/3scale/marin3r/pkg/discoveryservice/metrics"
+	"github.com/3scale/marin3r/pkg/discoveryservice/registry"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	"github.com/3scale/marin3r/pkg/envoy"
 	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
 	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/go-logr/logr"
@@ -34,12 +41,92 @@ type Callbacks struct {
 	OnError       func(nodeID, previousVersion, msg string, envoyAPI envoy.APIVersion) error
 	SnapshotCache *cache_v3.SnapshotCache
 	Logger        logr.Logger
+	// Registry keeps track of the connected clients. Optional.
+	Registry *registry.Registry
+	// Authorizer checks that the client certificate is allowed to request the
+	// config of the node ID the snapshot served to the client belongs to. Optional.
+	Authorizer *authz.Authorizer
+	// NodeHash computes the key of the snapshot served to a node, which
+	// might be shared by several node IDs. Defaults to the node ID.
+	NodeHash cache_v3.NodeHash
+	// Cache holds the resources of the types not supported by SnapshotCache, which
+	// are needed to know the version of the ECDS, SRDS and VHDS resources clients NACK
+	Cache *Cache
+
+	// addresses holds the peer address of the client of each open stream
+	addresses sync.Map
+}
+
+// setClientAddress sets the address of the client in the metadata of the
+// node, so each client is picked as canary or not on its own
+func setClientAddress(node *envoy_config_core_v3.Node, peerAddress string) {
+	if md := xdss.ClientMetadata(node.GetMetadata(), peerAddress); md != nil {
+		node.Metadata = md
+	}
+}
+
+// streamAddress returns the peer address of the client of a stream
+func (cb *Callbacks) streamAddress(key registry.StreamKey) string {
+	if address, ok := cb.addresses.Load(key); ok {
+		return address.(string)
+	}
+	return ""
+}
+
+// snapshotKey returns the key of the snapshot served to the node
+func (cb *Callbacks) snapshotKey(node *envoy_config_core_v3.Node) string {
+	if cb.NodeHash == nil {
+		return node.GetId()
+	}
+	return cb.NodeHash.ID(node)
+}
+
+// snapshotVersion returns the version of the given type URL in the snapshot of a key
+func (cb *Callbacks) snapshotVersion(key, typeURL string) (string, error) {
+	if rType, ok := resourceTypeForURL(typeURL); ok && isExtensionType(rType) {
+		if cb.Cache == nil {
+			return "", fmt.Errorf("no cache to look up the version of %q", typeURL)
+		}
+		snap, err := cb.Cache.GetSnapshot(key)
+		if err != nil {
+			return "", err
+		}
+		return snap.GetVersion(rType), nil
+	}
+
+	snap, err := (*cb.SnapshotCache).GetSnapshot(key)
+	if err != nil {
+		return "", err
+	}
+	return snap.GetVersion(typeURL), nil
+}
+
+// reportNACK reports the version of the snapshot of a key a client has rejected for
+// the given type URL through OnError. The keysAndValues are added to the logs.
+func (cb *Callbacks) reportNACK(key, typeURL, msg string, keysAndValues ...interface{}) error {
+	metrics.NACKs.WithLabelValues(string(envoy.APIv3), key, typeURL).Inc()
+	version, err := cb.snapshotVersion(key, typeURL)
+	if err != nil {
+		return err
+	}
+	failingVersion := xdss.ConfigVersion(typeURL, version)
+	cb.Logger.Error(errors.New(msg), "A gateway reported an error", append([]interface{}{"FailingVersion", failingVersion}, keysAndValues...)...)
+	if err := cb.OnError(key, failingVersion, msg, envoy.APIv3); err != nil {
+		cb.Logger.Error(err, "Error calling OnErrorFn", keysAndValues...)
+		return err
+	}
+	return nil
 }
 
 // OnStreamOpen implements go-control-plane/pkg/server/Callbacks.OnStreamOpen
 // Returning an error will end processing and close the stream. OnStreamClosed will still be called.
 func (cb *Callbacks) OnStreamOpen(ctx context.Context, id int64, typ string) error {
 	cb.Logger.V(1).Info("Stream opened", "StreamId", id)
+	cb.addresses.Store(registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id}, registry.PeerAddress(ctx))
+	if cb.Registry != nil {
+		cb.Registry.OpenStream(registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id}, registry.PeerAddress(ctx))
+	}
+	cb.Authorizer.OpenStream(ctx, envoy.APIv3, false, id)
 	return nil
 }
 
@@ -47,6 +134,11 @@ func (cb *Callbacks) OnStreamOpen(ctx context.Context, id int64, typ string) err
 // OnStreamClosed is called immediately prior to closing an xDS stream with a stream ID.
 func (cb *Callbacks) OnStreamClosed(id int64) {
 	cb.Logger.V(1).Info("Stream closed", "StreamID", id)
+	cb.addresses.Delete(registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id})
+	if cb.Registry != nil {
+		cb.Registry.CloseStream(registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id})
+	}
+	cb.Authorizer.CloseStream(envoy.APIv3, false, id)
 }
 
 // OnStreamRequest implements go-control-plane/pkg/server/Callbacks.OnStreamRequest
@@ -54,19 +146,32 @@ func (cb *Callbacks) OnStreamClosed(id int64) {
 // Returning an error will end processing and close the stream. OnStreamClosed will still be called.
 func (cb *Callbacks) OnStreamRequest(id int64, req *envoy_service_discovery_v3.DiscoveryRequest) error {
 	cb.Logger.V(1).Info("Received request", "ResourceNames", req.ResourceNames, "Version", req.VersionInfo, "TypeURL", req.TypeUrl, "NodeID", req.Node.Id, "StreamID", id)
+	setClientAddress(req.Node, cb.streamAddress(registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id}))
+	key := cb.snapshotKey(req.Node)
 
-	if req.ErrorDetail != nil {
-		snap, err := (*cb.SnapshotCache).GetSnapshot(req.Node.Id)
-		if err != nil {
-			return err
-		}
-		// All resource types are always kept at the same version
-		failingVersion := snap.GetVersion(req.TypeUrl)
-		cb.Logger.Error(fmt.Errorf(req.ErrorDetail.Message), "A gateway reported an error", "CurrentVersion", req.VersionInfo, "FailingVersion", failingVersion, "NodeID", req.Node.Id, "StreamID", id)
-		if err := cb.OnError(req.Node.Id, failingVersion, req.ErrorDetail.Message, envoy.APIv3); err != nil {
-			cb.Logger.Error(err, "Error calling OnErrorFn", "NodeID", req.Node.Id, "StreamID", id)
-			return err
+	if err := cb.Authorizer.AuthorizeStream(envoy.APIv3, false, id, xdss.NodeIDForKey(key)); err != nil {
+		cb.Logger.Error(err, "Client not allowed to request the node ID", "NodeID", req.Node.Id, "SnapshotKey", key, "StreamID", id)
+		return err
+	}
+	metrics.Requests.WithLabelValues(string(envoy.APIv3), key, req.TypeUrl).Inc()
+
+	if req.ResponseNonce != "" && req.ErrorDetail == nil {
+		// Clients send the version they have applied
+		// in the version_info field when they ACK
+		metrics.ObserveACK(envoy.APIv3, key, registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id}, req.TypeUrl, req.VersionInfo)
+	}
+
+	if cb.Registry != nil {
+		var nackMsg *string
+		if req.ErrorDetail != nil {
+			nackMsg = &req.ErrorDetail.Message
 		}
+		cb.Registry.RequestReceived(registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id},
+			key, req.TypeUrl, req.ResponseNonce, nackMsg)
+	}
+
+	if req.ErrorDetail != nil {
+		return cb.reportNACK(key, req.TypeUrl, req.ErrorDetail.Message, "CurrentVersion", req.VersionInfo, "NodeID", req.Node.Id, "StreamID", id)
 	}
 	return nil
 }
@@ -74,6 +179,12 @@ func (cb *Callbacks) OnStreamRequest(id int64, req *envoy_service_discovery_v3.D
 // OnStreamResponse implements go-control-plane/pkgserver/Callbacks.OnStreamResponse
 // OnStreamResponse is called immediately prior to sending a response on a stream.
 func (cb *Callbacks) OnStreamResponse(id int64, req *envoy_service_discovery_v3.DiscoveryRequest, rsp *envoy_service_discovery_v3.DiscoveryResponse) {
+	metrics.Responses.WithLabelValues(string(envoy.APIv3), cb.snapshotKey(req.Node), rsp.TypeUrl).Inc()
+
+	if cb.Registry != nil {
+		cb.Registry.ResponseSent(registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: id}, rsp.TypeUrl, rsp.Nonce, rsp.VersionInfo)
+	}
+
 	resources := []string{}
 	for _, r := range rsp.Resources {
 		j, _ := envoy_serializer.NewResourceMarshaller(envoy_serializer.JSON, envoy.APIv3).Marshal(r)
@@ -92,10 +203,107 @@ func (cb *Callbacks) OnStreamResponse(id int64, req *envoy_service_discovery_v3.
 // OnFetchRequest is called for each Fetch request. Returning an error will end processing of the
 // request and respond with an error.
 func (cb *Callbacks) OnFetchRequest(ctx context.Context, req *envoy_service_discovery_v3.DiscoveryRequest) error {
+	if req.Node == nil {
+		return fmt.Errorf("missing node identifier")
+	}
+	cb.Logger.V(1).Info("Received fetch request", "ResourceNames", req.ResourceNames, "Version", req.VersionInfo, "TypeURL", req.TypeUrl, "NodeID", req.Node.Id)
+	setClientAddress(req.Node, registry.PeerAddress(ctx))
+	key := cb.snapshotKey(req.Node)
+
+	if err := cb.Authorizer.AuthorizeContext(ctx, xdss.NodeIDForKey(key), envoy.APIv3); err != nil {
+		cb.Logger.Error(err, "Client not allowed to request the node ID", "NodeID", req.Node.Id, "SnapshotKey", key)
+		return err
+	}
+	metrics.Requests.WithLabelValues(string(envoy.APIv3), key, req.TypeUrl).Inc()
+
+	// REST clients report errors in the next request they send to the
+	// server, so NACKs are handled the same way as in gRPC streams
+	if req.ErrorDetail != nil {
+		return cb.reportNACK(key, req.TypeUrl, req.ErrorDetail.Message, "CurrentVersion", req.VersionInfo, "NodeID", req.Node.Id)
+	}
 	return nil
 }
 
 // OnFetchResponse implements go-control-plane/pkg/server/Callbacks.OnFetchRequest
 // OnFetchResponse is called immediately prior to sending a response.
 func (cb *Callbacks) OnFetchResponse(req *envoy_service_discovery_v3.DiscoveryRequest, resp *envoy_service_discovery_v3.DiscoveryResponse) {
+	metrics.Responses.WithLabelValues(string(envoy.APIv3), cb.snapshotKey(req.GetNode()), resp.TypeUrl).Inc()
+	cb.Logger.V(1).Info("Fetch response sent to gateway",
+		"ResourcesNames", req.ResourceNames, "TypeURL", req.TypeUrl, "NodeID", req.GetNode().GetId(), "Version", resp.GetVersionInfo())
+}
+
+// OnDeltaStreamOpen implements "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3".DeltaCallbacks.OnDeltaStreamOpen
+// Returning an error will end processing and close the stream. OnDeltaStreamClosed will still be called.
+func (cb *Callbacks) OnDeltaStreamOpen(ctx context.Context, id int64, typ string) error {
+	cb.Logger.V(1).Info("Delta stream opened", "StreamId", id)
+	cb.addresses.Store(registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: id}, registry.PeerAddress(ctx))
+	if cb.Registry != nil {
+		cb.Registry.OpenStream(registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: id}, registry.PeerAddress(ctx))
+	}
+	cb.Authorizer.OpenStream(ctx, envoy.APIv3, true, id)
+	return nil
+}
+
+// OnDeltaStreamClosed implements "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3".DeltaCallbacks.OnDeltaStreamClosed
+// OnDeltaStreamClosed is called immediately prior to closing an incremental xDS stream with a stream ID.
+func (cb *Callbacks) OnDeltaStreamClosed(id int64) {
+	cb.Logger.V(1).Info("Delta stream closed", "StreamID", id)
+	cb.addresses.Delete(registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: id})
+	if cb.Registry != nil {
+		cb.Registry.CloseStream(registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: id})
+	}
+	cb.Authorizer.CloseStream(envoy.APIv3, true, id)
+}
+
+// OnDeltaStreamRequest implements "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3".DeltaCallbacks.OnDeltaStreamRequest
+// OnDeltaStreamRequest is called once a request is received on an incremental xDS stream.
+// Returning an error will end processing and close the stream. OnDeltaStreamClosed will still be called.
+func (cb *Callbacks) OnDeltaStreamRequest(id int64, req *envoy_service_discovery_v3.DeltaDiscoveryRequest) error {
+	cb.Logger.V(1).Info("Received delta request", "Subscribe", req.ResourceNamesSubscribe, "Unsubscribe", req.ResourceNamesUnsubscribe,
+		"Nonce", req.ResponseNonce, "TypeURL", req.TypeUrl, "NodeID", req.Node.Id, "StreamID", id)
+	setClientAddress(req.Node, cb.streamAddress(registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: id}))
+	key := cb.snapshotKey(req.Node)
+
+	if err := cb.Authorizer.AuthorizeStream(envoy.APIv3, true, id, xdss.NodeIDForKey(key)); err != nil {
+		cb.Logger.Error(err, "Client not allowed to request the node ID", "NodeID", req.Node.Id, "SnapshotKey", key, "StreamID", id)
+		return err
+	}
+	metrics.Requests.WithLabelValues(string(envoy.APIv3), key, req.TypeUrl).Inc()
+
+	// Incremental xDS requests don't carry a version, so the ACKed
+	// version can only be known from the registry
+	if cb.Registry != nil {
+		var nackMsg *string
+		if req.ErrorDetail != nil {
+			nackMsg = &req.ErrorDetail.Message
+		}
+		acked := cb.Registry.RequestReceived(registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: id},
+			key, req.TypeUrl, req.ResponseNonce, nackMsg)
+		if acked != "" {
+			metrics.ObserveACK(envoy.APIv3, key, registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: id}, req.TypeUrl, acked)
+		}
+	}
+
+	if req.ErrorDetail != nil {
+		return cb.reportNACK(key, req.TypeUrl, req.ErrorDetail.Message, "NodeID", req.Node.Id, "StreamID", id)
+	}
+	return nil
+}
+
+// OnDeltaStreamResponse implements "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3".DeltaCallbacks.OnDeltaStreamResponse
+// OnDeltaStreamResponse is called immediately prior to sending a response on an incremental xDS stream.
+func (cb *Callbacks) OnDeltaStreamResponse(id int64, req *envoy_service_discovery_v3.DeltaDiscoveryRequest, rsp *envoy_service_discovery_v3.DeltaDiscoveryResponse) {
+	metrics.Responses.WithLabelValues(string(envoy.APIv3), cb.snapshotKey(req.Node), rsp.TypeUrl).Inc()
+
+	if cb.Registry != nil {
+		cb.Registry.ResponseSent(registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: id}, rsp.TypeUrl, rsp.Nonce, rsp.SystemVersionInfo)
+	}
+
+	names := make([]string, 0, len(rsp.Resources))
+	for _, r := range rsp.Resources {
+		names = append(names, r.Name)
+	}
+	cb.Logger.V(1).Info("Delta response sent to gateway",
+		"ResourcesNames", names, "RemovedResources", rsp.RemovedResources, "TypeURL", rsp.TypeUrl, "NodeID", req.Node.Id,
+		"StreamID", id, "Version", rsp.GetSystemVersionInfo())
 }
@@ -16,10 +16,17 @@ package discoveryservice
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"net"
 	"testing"
 
+	"github.com/3scale/marin3r/pkg/discoveryservice/authz"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
 	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
@@ -28,6 +35,8 @@ import (
 
 	"github.com/golang/protobuf/ptypes/any"
 	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -37,7 +46,7 @@ func fakeTestCache() *cache_v3.SnapshotCache {
 
 	snapshotCache.SetSnapshot("node1", cache_v3.Snapshot{
 		Resources: [6]cache_v3.Resources{
-			{Version: "1", Items: map[string]cache_types.Resource{
+			{Version: "1-6f8c2d", Items: map[string]cache_types.Resource{
 				"endpoint1": &envoy_api_v2.ClusterLoadAssignment{ClusterName: "endpoint1"},
 			}},
 			{Version: "1", Items: map[string]cache_types.Resource{
@@ -53,6 +62,24 @@ func fakeTestCache() *cache_v3.SnapshotCache {
 	return &snapshotCache
 }
 
+// peerContext returns a context with the gRPC peer information
+// of a client that presents a certificate for the given identity
+func peerContext(identity string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: identity}}},
+		}},
+	})
+}
+
+// streamAuthorizer returns an Authorizer with the Exact policy and an
+// open stream whose client presents a certificate for the given identity
+func streamAuthorizer(id int64, identity string) *authz.Authorizer {
+	a := &authz.Authorizer{Policy: authz.PolicyExact}
+	a.OpenStream(peerContext(identity), envoy.APIv3, false, id)
+	return a
+}
+
 func TestCallbacks_OnStreamOpen(t *testing.T) {
 	type args struct {
 		ctx context.Context
@@ -169,6 +196,83 @@ func TestCallbacks_OnStreamRequest(t *testing.T) {
 			}},
 			true,
 		},
+		{
+			"OnStreamRequest() node ID not authorized",
+			&Callbacks{
+				Logger:     ctrl.Log,
+				Authorizer: &authz.Authorizer{Policy: authz.PolicyExact},
+			},
+			args{1, &envoy_service_discovery_v3.DiscoveryRequest{
+				Node:    &envoy_config_core_v3.Node{Id: "node1", Cluster: "cluster1"},
+				TypeUrl: "some-type",
+			}},
+			true,
+		},
+		{
+			"OnStreamRequest() NACK received from a grouped node",
+			&Callbacks{
+				OnError: func(nodeID, b, c string, d envoy.APIVersion) error {
+					if nodeID != "node1" {
+						return fmt.Errorf("unexpected node ID %q", nodeID)
+					}
+					return nil
+				},
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+				NodeHash:      NewNodeHash(&xdss.NodeHash{Kind: xdss.NodeHashCluster}, nil),
+			},
+			args{1, &envoy_service_discovery_v3.DiscoveryRequest{
+				Node:        &envoy_config_core_v3.Node{Id: "gateway-7d9f-x2k", Cluster: "node1"},
+				TypeUrl:     "some-type",
+				ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+			}},
+			false,
+		},
+		{
+			"OnStreamRequest() node ID authorized for the snapshot key",
+			&Callbacks{
+				Logger:     ctrl.Log,
+				Authorizer: streamAuthorizer(1, "node1"),
+				NodeHash:   NewNodeHash(&xdss.NodeHash{Kind: xdss.NodeHashCluster}, nil),
+			},
+			args{1, &envoy_service_discovery_v3.DiscoveryRequest{
+				Node:    &envoy_config_core_v3.Node{Id: "gateway-7d9f-x2k", Cluster: "node1"},
+				TypeUrl: "some-type",
+			}},
+			false,
+		},
+		{
+			"OnStreamRequest() node ID not authorized for the snapshot key",
+			&Callbacks{
+				Logger:     ctrl.Log,
+				Authorizer: streamAuthorizer(1, "node1"),
+				NodeHash:   NewNodeHash(&xdss.NodeHash{Kind: xdss.NodeHashCluster}, nil),
+			},
+			args{1, &envoy_service_discovery_v3.DiscoveryRequest{
+				Node:    &envoy_config_core_v3.Node{Id: "node1", Cluster: "node2"},
+				TypeUrl: "some-type",
+			}},
+			true,
+		},
+		{
+			"OnStreamRequest() NACK received for endpoints",
+			&Callbacks{
+				OnError: func(nodeID, version, c string, d envoy.APIVersion) error {
+					if version != "1" {
+						return fmt.Errorf("unexpected version %q", version)
+					}
+					return nil
+				},
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+			},
+			args{1, &envoy_service_discovery_v3.DiscoveryRequest{
+				Node:        &envoy_config_core_v3.Node{Id: "node1", Cluster: "cluster1"},
+				TypeUrl:     envoy_resources_v3.Mappings()[envoy.Endpoint],
+				ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+			}},
+			false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -179,6 +283,26 @@ func TestCallbacks_OnStreamRequest(t *testing.T) {
 	}
 }
 
+func TestCallbacks_OnStreamRequest_clientAddress(t *testing.T) {
+	cb := &Callbacks{SnapshotCache: fakeTestCache(), Logger: ctrl.Log}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 45678}})
+	if err := cb.OnStreamOpen(ctx, 1, ""); err != nil {
+		t.Fatalf("Callbacks.OnStreamOpen() error = %v", err)
+	}
+
+	req := &envoy_service_discovery_v3.DiscoveryRequest{
+		Node:    &envoy_config_core_v3.Node{Id: "node1"},
+		TypeUrl: envoy_resources_v3.Mappings()[envoy.Cluster],
+	}
+	if err := cb.OnStreamRequest(1, req); err != nil {
+		t.Fatalf("Callbacks.OnStreamRequest() error = %v", err)
+	}
+	if got := req.Node.GetMetadata().GetFields()[xdss.ClientAddressField].GetStringValue(); got != "10.0.0.1" {
+		t.Errorf("Callbacks.OnStreamRequest() client address = %q, want %q", got, "10.0.0.1")
+	}
+	cb.OnStreamClosed(1)
+}
+
 func TestCallbacks_OnStreamResponse(t *testing.T) {
 	type args struct {
 		id       int64
@@ -248,10 +372,94 @@ func TestCallbacks_OnFetchRequest(t *testing.T) {
 		{
 			"OnFetchRequest()",
 			&Callbacks{Logger: ctrl.Log},
+			args{
+				context.Background(),
+				&envoy_service_discovery_v3.DiscoveryRequest{
+					Node:    &envoy_config_core_v3.Node{Id: "node1", Cluster: "cluster1"},
+					TypeUrl: "some-type",
+				},
+			},
+			false,
+		},
+		{
+			"OnFetchRequest() error, missing node",
+			&Callbacks{Logger: ctrl.Log},
 			args{
 				context.Background(),
 				&envoy_service_discovery_v3.DiscoveryRequest{},
 			},
+			true,
+		},
+		{
+			"OnFetchRequest() NACK received",
+			&Callbacks{
+				OnError:       func(a, b, c string, d envoy.APIVersion) error { return nil },
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+			},
+			args{
+				context.Background(),
+				&envoy_service_discovery_v3.DiscoveryRequest{
+					Node:        &envoy_config_core_v3.Node{Id: "node1", Cluster: "cluster1"},
+					TypeUrl:     "some-type",
+					ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+				},
+			},
+			false,
+		},
+		{
+			"OnFetchRequest() error calling OnErrorFn",
+			&Callbacks{
+				OnError:       func(a, b, c string, d envoy.APIVersion) error { return fmt.Errorf("err") },
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+			},
+			args{
+				context.Background(),
+				&envoy_service_discovery_v3.DiscoveryRequest{
+					Node:        &envoy_config_core_v3.Node{Id: "node1", Cluster: "cluster1"},
+					TypeUrl:     "some-type",
+					ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+				},
+			},
+			true,
+		},
+		{
+			"OnFetchRequest() node ID not authorized for the snapshot key",
+			&Callbacks{
+				Logger:     ctrl.Log,
+				Authorizer: &authz.Authorizer{Policy: authz.PolicyExact},
+				NodeHash:   NewNodeHash(&xdss.NodeHash{Kind: xdss.NodeHashCluster}, nil),
+			},
+			args{
+				peerContext("node1"),
+				&envoy_service_discovery_v3.DiscoveryRequest{
+					Node:    &envoy_config_core_v3.Node{Id: "node1", Cluster: "node2"},
+					TypeUrl: "some-type",
+				},
+			},
+			true,
+		},
+		{
+			"OnFetchRequest() NACK received for endpoints",
+			&Callbacks{
+				OnError: func(nodeID, version, c string, d envoy.APIVersion) error {
+					if version != "1" {
+						return fmt.Errorf("unexpected version %q", version)
+					}
+					return nil
+				},
+				SnapshotCache: fakeTestCache(),
+				Logger:        ctrl.Log,
+			},
+			args{
+				context.Background(),
+				&envoy_service_discovery_v3.DiscoveryRequest{
+					Node:        &envoy_config_core_v3.Node{Id: "node1", Cluster: "cluster1"},
+					TypeUrl:     envoy_resources_v3.Mappings()[envoy.Endpoint],
+					ErrorDetail: &status.Status{Code: 0, Message: "xxxx"},
+				},
+			},
 			false,
 		},
 	}
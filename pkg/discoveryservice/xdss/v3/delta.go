@@ -0,0 +1,452 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/golang/protobuf/ptypes/any"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// wildcardResourceName is the resource name that clients use
+	// to explicitly subscribe to all the resources of a given type
+	wildcardResourceName = "*"
+)
+
+// DeltaCallbacks is the set of callbacks the DeltaServer invokes
+// along the lifecycle of an incremental xDS stream
+type DeltaCallbacks interface {
+	// OnDeltaStreamOpen is called once an incremental xDS stream is open with a stream ID and
+	// the type URL (or "" for ADS). Returning an error will end processing and close the stream.
+	OnDeltaStreamOpen(context.Context, int64, string) error
+	// OnDeltaStreamClosed is called immediately prior to closing an incremental xDS stream.
+	OnDeltaStreamClosed(int64)
+	// OnDeltaStreamRequest is called once a request is received on a stream. Returning an
+	// error will end processing and close the stream.
+	OnDeltaStreamRequest(int64, *envoy_service_discovery_v3.DeltaDiscoveryRequest) error
+	// OnDeltaStreamResponse is called immediately prior to sending a response on a stream.
+	OnDeltaStreamResponse(int64, *envoy_service_discovery_v3.DeltaDiscoveryRequest, *envoy_service_discovery_v3.DeltaDiscoveryResponse)
+}
+
+// deltaStream is the interface shared by the ADS and the per type
+// incremental xDS gRPC streams
+type deltaStream interface {
+	grpc.ServerStream
+
+	Send(*envoy_service_discovery_v3.DeltaDiscoveryResponse) error
+	Recv() (*envoy_service_discovery_v3.DeltaDiscoveryRequest, error)
+}
+
+// DeltaServer implements the incremental variant of the xDS protocol for
+// envoy API v3. It is driven by the same snapshot cache that serves the
+// state-of-the-world protocol: snapshot changes are detected using the cache
+// watches and only resources whose per resource version have changed are sent
+// to the clients. The extension types (ECDS, SRDS and VHDS), that the snapshot
+// cache does not support, are watched in the extension store of the Cache.
+type DeltaServer struct {
+	ctx         context.Context
+	cache       Cache
+	hash        cache_v3.NodeHash
+	callbacks   DeltaCallbacks
+	streamCount int64
+}
+
+// NewDeltaServer returns a DeltaServer that serves the resources
+// held in the given cache
+func NewDeltaServer(ctx context.Context, cache Cache, hash cache_v3.NodeHash, callbacks DeltaCallbacks) *DeltaServer {
+	return &DeltaServer{ctx: ctx, cache: cache, hash: hash, callbacks: callbacks}
+}
+
+// DeltaAggregatedResources implements the DeltaAggregatedResources method of the
+// envoy API v3 AggregatedDiscoveryService gRPC service.
+func (s *DeltaServer) DeltaAggregatedResources(stream envoy_service_discovery_v3.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	return s.process(stream, "")
+}
+
+// DeltaStreamHandler serves an incremental xDS stream for the given type URL.
+// It can be used to implement the Delta methods of the per type discovery services.
+func (s *DeltaServer) DeltaStreamHandler(stream deltaStream, typeURL string) error {
+	return s.process(stream, typeURL)
+}
+
+// deltaSubscription holds the state of the subscription of a client
+// to a given resource type within an incremental xDS stream
+type deltaSubscription struct {
+	// wildcard is true when the client is subscribed to all
+	// the resources of the type
+	wildcard bool
+	// names holds the resource names the client has explicitly subscribed to
+	names map[string]struct{}
+	// sent holds the versions of the resources the client is known to have
+	sent map[string]string
+	// version is the version of the resource type in the
+	// snapshot the last time the subscription was synced
+	version string
+	// initialized is true once the first response has been sent
+	initialized bool
+	// request is the last request received for this subscription
+	request *envoy_service_discovery_v3.DeltaDiscoveryRequest
+	// cancel stops the cache watch for this subscription
+	cancel func()
+}
+
+func newDeltaSubscription(req *envoy_service_discovery_v3.DeltaDiscoveryRequest) *deltaSubscription {
+	sub := &deltaSubscription{
+		// Per the xDS protocol spec, an initial request without
+		// resource names is a wildcard subscription
+		wildcard: len(req.ResourceNamesSubscribe) == 0,
+		names:    map[string]struct{}{},
+		sent:     map[string]string{},
+	}
+	for name, version := range req.InitialResourceVersions {
+		sub.sent[name] = version
+	}
+	return sub
+}
+
+// update applies the subscription changes of a request. Returns true
+// if the set of resources the client is interested in has changed.
+func (sub *deltaSubscription) update(req *envoy_service_discovery_v3.DeltaDiscoveryRequest) bool {
+	changed := false
+	for _, name := range req.ResourceNamesSubscribe {
+		if name == wildcardResourceName {
+			changed = changed || !sub.wildcard
+			sub.wildcard = true
+			continue
+		}
+		if _, ok := sub.names[name]; !ok {
+			sub.names[name] = struct{}{}
+			changed = true
+		}
+	}
+	for _, name := range req.ResourceNamesUnsubscribe {
+		if name == wildcardResourceName {
+			// The resources the client only got through the wildcard
+			// subscription are listed as removed in the next response
+			changed = changed || sub.wildcard
+			sub.wildcard = false
+			continue
+		}
+		delete(sub.names, name)
+		// The client forgets about unsubscribed resources, so a
+		// later subscription needs to send them again
+		delete(sub.sent, name)
+	}
+	return changed
+}
+
+func (sub *deltaSubscription) isSubscribed(name string) bool {
+	if sub.wildcard {
+		return true
+	}
+	_, ok := sub.names[name]
+	return ok
+}
+
+// deltaStreamState holds the state of an incremental xDS stream. It
+// is only accessed from the goroutine that processes the stream.
+type deltaStreamState struct {
+	id            int64
+	node          *envoy_config_core_v3.Node
+	nonce         int64
+	subscriptions map[string]*deltaSubscription
+}
+
+func (s *DeltaServer) process(stream deltaStream, defaultTypeURL string) error {
+
+	streamID := atomic.AddInt64(&s.streamCount, 1)
+	ctx := stream.Context()
+
+	if s.callbacks != nil {
+		if err := s.callbacks.OnDeltaStreamOpen(ctx, streamID, defaultTypeURL); err != nil {
+			return err
+		}
+		defer s.callbacks.OnDeltaStreamClosed(streamID)
+	}
+
+	state := &deltaStreamState{id: streamID, subscriptions: map[string]*deltaSubscription{}}
+	defer func() {
+		for _, sub := range state.subscriptions {
+			if sub.cancel != nil {
+				sub.cancel()
+			}
+		}
+	}()
+
+	// done is closed when the stream finishes so all
+	// the goroutines spawned for the stream return
+	done := make(chan struct{})
+	defer close(done)
+
+	reqCh := make(chan *envoy_service_discovery_v3.DeltaDiscoveryRequest)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			select {
+			case reqCh <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// notifyCh receives the type URLs of the
+	// resources that have changed in the cache
+	notifyCh := make(chan string)
+
+	for {
+		select {
+
+		case <-s.ctx.Done():
+			return nil
+
+		case <-ctx.Done():
+			return nil
+
+		case err := <-errCh:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+
+		case req := <-reqCh:
+			if err := s.handleRequest(stream, state, req, defaultTypeURL, notifyCh, done); err != nil {
+				return err
+			}
+
+		case typeURL := <-notifyCh:
+			sub := state.subscriptions[typeURL]
+			if err := s.respond(stream, state, typeURL, sub); err != nil {
+				return err
+			}
+			s.watch(state, typeURL, sub, notifyCh, done)
+		}
+	}
+}
+
+func (s *DeltaServer) handleRequest(stream deltaStream, state *deltaStreamState, req *envoy_service_discovery_v3.DeltaDiscoveryRequest,
+	defaultTypeURL string, notifyCh chan string, done chan struct{}) error {
+
+	// The node only needs to be sent in the first request of the stream
+	if req.Node != nil {
+		state.node = req.Node
+	} else if state.node != nil {
+		req.Node = state.node
+	} else {
+		return status.Errorf(codes.InvalidArgument, "missing node identifier")
+	}
+
+	if defaultTypeURL != "" {
+		if req.TypeUrl == "" {
+			req.TypeUrl = defaultTypeURL
+		} else if req.TypeUrl != defaultTypeURL {
+			return status.Errorf(codes.InvalidArgument, "type URL %q not supported by this stream", req.TypeUrl)
+		}
+	} else if req.TypeUrl == "" {
+		return status.Errorf(codes.InvalidArgument, "type URL is required for ADS")
+	}
+
+	if s.callbacks != nil {
+		if err := s.callbacks.OnDeltaStreamRequest(state.id, req); err != nil {
+			return err
+		}
+	}
+
+	sub, ok := state.subscriptions[req.TypeUrl]
+	if !ok {
+		sub = newDeltaSubscription(req)
+		state.subscriptions[req.TypeUrl] = sub
+	}
+	changed := sub.update(req)
+	sub.request = req
+
+	// NACKs are handled by the callbacks. Nothing is sent back to the
+	// client until the resources change in the cache again.
+	if req.ErrorDetail != nil {
+		return nil
+	}
+
+	if !sub.initialized || changed {
+		if err := s.respond(stream, state, req.TypeUrl, sub); err != nil {
+			return err
+		}
+	}
+
+	if sub.cancel == nil {
+		s.watch(state, req.TypeUrl, sub, notifyCh, done)
+	}
+
+	return nil
+}
+
+// respond sends to the client the resources that have changed since the last time the
+// subscription was synced with the snapshot cache, and the names of the resources that
+// have been removed or the client is no longer subscribed to.
+func (s *DeltaServer) respond(stream deltaStream, state *deltaStreamState, typeURL string, sub *deltaSubscription) error {
+
+	rType, ok := resourceTypeForURL(typeURL)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown type URL %q", typeURL)
+	}
+
+	snap, err := s.cache.GetSnapshot(s.hash.ID(state.node))
+	if err != nil {
+		// There is no snapshot for this node yet, the cache
+		// watch will trigger a response when there is one
+		return nil
+	}
+
+	previousVersion := sub.version
+	sub.version = snap.GetVersion(rType)
+	resources := snap.GetResources(rType)
+	versions, err := snap.GetResourceVersions(rType)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to calculate the resource versions: %v", err)
+	}
+
+	rsp := &envoy_service_discovery_v3.DeltaDiscoveryResponse{
+		SystemVersionInfo: sub.version,
+		TypeUrl:           typeURL,
+		Resources:         []*envoy_service_discovery_v3.Resource{},
+		RemovedResources:  []string{},
+	}
+
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !sub.isSubscribed(name) || sub.sent[name] == versions[name] {
+			continue
+		}
+		b, err := cache_v3.MarshalResource(resources[name])
+		if err != nil {
+			return status.Errorf(codes.Internal, "unable to marshal resource %q: %v", name, err)
+		}
+		rsp.Resources = append(rsp.Resources, &envoy_service_discovery_v3.Resource{
+			Name:     name,
+			Version:  versions[name],
+			Resource: &any.Any{TypeUrl: typeURL, Value: b},
+		})
+		sub.sent[name] = versions[name]
+	}
+
+	for name := range sub.sent {
+		if _, ok := resources[name]; !ok || !sub.isSubscribed(name) {
+			rsp.RemovedResources = append(rsp.RemovedResources, name)
+			delete(sub.sent, name)
+		}
+	}
+	sort.Strings(rsp.RemovedResources)
+
+	// An empty response is still sent when the version changes so the
+	// client can report that it is running the new version
+	if sub.initialized && len(rsp.Resources) == 0 && len(rsp.RemovedResources) == 0 && sub.version == previousVersion {
+		return nil
+	}
+	sub.initialized = true
+
+	state.nonce++
+	rsp.Nonce = strconv.FormatInt(state.nonce, 10)
+
+	if s.callbacks != nil {
+		s.callbacks.OnDeltaStreamResponse(state.id, sub.request, rsp)
+	}
+	return stream.Send(rsp)
+}
+
+// watch opens a watch in the snapshot cache that notifies the stream through notifyCh
+// when a version different from the one last synced for the type is available.
+func (s *DeltaServer) watch(state *deltaStreamState, typeURL string, sub *deltaSubscription,
+	notifyCh chan string, done chan struct{}) {
+
+	if sub.cancel != nil {
+		sub.cancel()
+	}
+
+	// Only one of the channels is set, depending on where
+	// the resources of the type are stored
+	var value chan cache_v3.Response
+	var changed <-chan struct{}
+	var cancel func()
+	if rType, ok := resourceTypeForURL(typeURL); ok && isExtensionType(rType) {
+		changed = s.cache.extensions.watch(s.hash.ID(state.node), rType, sub.version)
+	} else {
+		value, cancel = s.cache.v3.CreateWatch(&envoy_service_discovery_v3.DiscoveryRequest{
+			Node:        state.node,
+			TypeUrl:     typeURL,
+			VersionInfo: sub.version,
+		})
+	}
+
+	stop := make(chan struct{})
+	sub.cancel = func() {
+		select {
+		case <-stop:
+		default:
+			close(stop)
+		}
+		if cancel != nil {
+			cancel()
+		}
+	}
+
+	go func() {
+		select {
+		case <-value:
+		case <-changed:
+		case <-stop:
+			return
+		case <-done:
+			return
+		}
+		select {
+		case notifyCh <- typeURL:
+		case <-stop:
+		case <-done:
+		}
+	}()
+}
+
+// resourceTypeForURL returns the envoy.Type that
+// corresponds to the given v3 type URL
+func resourceTypeForURL(typeURL string) (envoy.Type, bool) {
+	for rType, url := range envoy_resources_v3.Mappings() {
+		if url == typeURL {
+			return rType, true
+		}
+	}
+	return "", false
+}
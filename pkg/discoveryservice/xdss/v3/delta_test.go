@@ -0,0 +1,400 @@
+// Copyright 2020 rvazquez@redhat.com
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package discoveryservice
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+type fakeDeltaStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	requests  chan *envoy_service_discovery_v3.DeltaDiscoveryRequest
+	responses chan *envoy_service_discovery_v3.DeltaDiscoveryResponse
+}
+
+func newFakeDeltaStream(ctx context.Context) *fakeDeltaStream {
+	return &fakeDeltaStream{
+		ctx:       ctx,
+		requests:  make(chan *envoy_service_discovery_v3.DeltaDiscoveryRequest, 10),
+		responses: make(chan *envoy_service_discovery_v3.DeltaDiscoveryResponse, 10),
+	}
+}
+
+func (s *fakeDeltaStream) Context() context.Context { return s.ctx }
+
+func (s *fakeDeltaStream) Send(rsp *envoy_service_discovery_v3.DeltaDiscoveryResponse) error {
+	s.responses <- rsp
+	return nil
+}
+
+func (s *fakeDeltaStream) Recv() (*envoy_service_discovery_v3.DeltaDiscoveryRequest, error) {
+	select {
+	case req, ok := <-s.requests:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-s.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+func (s *fakeDeltaStream) expectResponse(t *testing.T) *envoy_service_discovery_v3.DeltaDiscoveryResponse {
+	t.Helper()
+	select {
+	case rsp := <-s.responses:
+		return rsp
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a delta response")
+	}
+	return nil
+}
+
+func (s *fakeDeltaStream) expectNoResponse(t *testing.T) {
+	t.Helper()
+	select {
+	case rsp := <-s.responses:
+		t.Fatalf("unexpected delta response: %v", rsp)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func newClustersSnapshot(version string, clusters ...string) cache_v3.Snapshot {
+	items := []cache_types.Resource{}
+	for _, name := range clusters {
+		items = append(items, &envoy_config_cluster_v3.Cluster{Name: name, AltStatName: version})
+	}
+	return cache_v3.NewSnapshot(version, nil, items, nil, nil, nil, nil)
+}
+
+func responseNames(rsp *envoy_service_discovery_v3.DeltaDiscoveryResponse) []string {
+	names := []string{}
+	for _, r := range rsp.Resources {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func TestDeltaServer_DeltaAggregatedResources(t *testing.T) {
+
+	t.Run("Pushes only the resources that changed", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cache := cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)
+		cache.SetSnapshot("node1", newClustersSnapshot("1", "cluster1", "cluster2"))
+
+		stream := newFakeDeltaStream(ctx)
+		srv := NewDeltaServer(ctx, NewCache(cache), cache_v3.IDHash{}, &Callbacks{Logger: ctrl.Log})
+		go srv.DeltaAggregatedResources(stream)
+
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			Node:    &envoy_config_core_v3.Node{Id: "node1"},
+			TypeUrl: resource_v3.ClusterType,
+		}
+		rsp := stream.expectResponse(t)
+		if got := responseNames(rsp); !reflect.DeepEqual(got, []string{"cluster1", "cluster2"}) {
+			t.Errorf("initial response resources = %v", got)
+		}
+		if rsp.SystemVersionInfo != "1" {
+			t.Errorf("initial response version = %v", rsp.SystemVersionInfo)
+		}
+
+		// ACK
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			TypeUrl: resource_v3.ClusterType, ResponseNonce: rsp.Nonce,
+		}
+		stream.expectNoResponse(t)
+
+		// cluster1 is unchanged, cluster2 changes and cluster3 is added
+		snap := newClustersSnapshot("2", "cluster2", "cluster3")
+		snap.Resources[cache_types.Cluster].Items["cluster1"] = &envoy_config_cluster_v3.Cluster{Name: "cluster1", AltStatName: "1"}
+		cache.SetSnapshot("node1", snap)
+
+		rsp = stream.expectResponse(t)
+		if got := responseNames(rsp); !reflect.DeepEqual(got, []string{"cluster2", "cluster3"}) {
+			t.Errorf("update response resources = %v", got)
+		}
+		if len(rsp.RemovedResources) != 0 {
+			t.Errorf("update response removed resources = %v", rsp.RemovedResources)
+		}
+
+		// cluster1 is removed, the rest are unchanged
+		cache.SetSnapshot("node1", cache_v3.NewSnapshot("3", nil, []cache_types.Resource{
+			snap.Resources[cache_types.Cluster].Items["cluster2"],
+			snap.Resources[cache_types.Cluster].Items["cluster3"],
+		}, nil, nil, nil, nil))
+		rsp = stream.expectResponse(t)
+		if len(rsp.Resources) != 0 || !reflect.DeepEqual(rsp.RemovedResources, []string{"cluster1"}) {
+			t.Errorf("removal response = %v", rsp)
+		}
+	})
+
+	t.Run("Sends only the subscribed resources", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cache := cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)
+		cache.SetSnapshot("node1", newClustersSnapshot("1", "cluster1", "cluster2"))
+
+		version, err := resourceVersion(&envoy_config_cluster_v3.Cluster{Name: "cluster1", AltStatName: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stream := newFakeDeltaStream(ctx)
+		srv := NewDeltaServer(ctx, NewCache(cache), cache_v3.IDHash{}, &Callbacks{Logger: ctrl.Log})
+		go srv.DeltaAggregatedResources(stream)
+
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			Node:                    &envoy_config_core_v3.Node{Id: "node1"},
+			TypeUrl:                 resource_v3.ClusterType,
+			ResourceNamesSubscribe:  []string{"cluster1", "cluster2"},
+			InitialResourceVersions: map[string]string{"cluster1": version},
+		}
+		rsp := stream.expectResponse(t)
+		if got := responseNames(rsp); !reflect.DeepEqual(got, []string{"cluster2"}) {
+			t.Errorf("initial response resources = %v", got)
+		}
+
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			TypeUrl: resource_v3.ClusterType, ResponseNonce: rsp.Nonce, ResourceNamesUnsubscribe: []string{"cluster2"},
+		}
+		stream.expectNoResponse(t)
+
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			TypeUrl: resource_v3.ClusterType, ResourceNamesSubscribe: []string{"cluster2"},
+		}
+		rsp = stream.expectResponse(t)
+		if got := responseNames(rsp); !reflect.DeepEqual(got, []string{"cluster2"}) {
+			t.Errorf("subscription response resources = %v", got)
+		}
+	})
+
+	t.Run("Removes the wildcard resources on wildcard unsubscribe", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cache := cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)
+		cache.SetSnapshot("node1", newClustersSnapshot("1", "cluster1", "cluster2"))
+
+		stream := newFakeDeltaStream(ctx)
+		srv := NewDeltaServer(ctx, NewCache(cache), cache_v3.IDHash{}, &Callbacks{Logger: ctrl.Log})
+		go srv.DeltaAggregatedResources(stream)
+
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			Node:                   &envoy_config_core_v3.Node{Id: "node1"},
+			TypeUrl:                resource_v3.ClusterType,
+			ResourceNamesSubscribe: []string{"*", "cluster1"},
+		}
+		rsp := stream.expectResponse(t)
+		if got := responseNames(rsp); !reflect.DeepEqual(got, []string{"cluster1", "cluster2"}) {
+			t.Errorf("initial response resources = %v", got)
+		}
+
+		// cluster1 is still explicitly subscribed
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			TypeUrl: resource_v3.ClusterType, ResponseNonce: rsp.Nonce, ResourceNamesUnsubscribe: []string{"*"},
+		}
+		rsp = stream.expectResponse(t)
+		if len(rsp.Resources) != 0 || !reflect.DeepEqual(rsp.RemovedResources, []string{"cluster2"}) {
+			t.Errorf("unsubscribe response = %v", rsp)
+		}
+
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			TypeUrl: resource_v3.ClusterType, ResponseNonce: rsp.Nonce, ResourceNamesSubscribe: []string{"*"},
+		}
+		rsp = stream.expectResponse(t)
+		if got := responseNames(rsp); !reflect.DeepEqual(got, []string{"cluster2"}) {
+			t.Errorf("subscribe response resources = %v", got)
+		}
+	})
+
+	t.Run("Calls OnError on NACK", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cache := cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)
+		cache.SetSnapshot("node1", newClustersSnapshot("1", "cluster1"))
+
+		nacked := make(chan string, 1)
+		stream := newFakeDeltaStream(ctx)
+		srv := NewDeltaServer(ctx, NewCache(cache), cache_v3.IDHash{}, &Callbacks{
+			Logger:        ctrl.Log,
+			SnapshotCache: &cache,
+			OnError: func(nodeID, previousVersion, msg string, envoyAPI envoy.APIVersion) error {
+				nacked <- previousVersion
+				return nil
+			},
+		})
+		go srv.DeltaAggregatedResources(stream)
+
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			Node:    &envoy_config_core_v3.Node{Id: "node1"},
+			TypeUrl: resource_v3.ClusterType,
+		}
+		rsp := stream.expectResponse(t)
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			TypeUrl: resource_v3.ClusterType, ResponseNonce: rsp.Nonce, ErrorDetail: &status.Status{Code: 3, Message: "error"},
+		}
+
+		select {
+		case version := <-nacked:
+			if version != "1" {
+				t.Errorf("OnError() called with version %q, want %q", version, "1")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnError() was not called")
+		}
+		stream.expectNoResponse(t)
+	})
+
+	t.Run("Calls OnError on NACK of an extension type", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cache := NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+		snap := cache.NewSnapshot("1")
+		snap.SetResource("ext1", &envoy_config_core_v3.TypedExtensionConfig{Name: "ext1"})
+		cache.SetSnapshot("node1", snap)
+
+		nacked := make(chan string, 1)
+		stream := newFakeDeltaStream(ctx)
+		srv := NewDeltaServer(ctx, cache, cache_v3.IDHash{}, &Callbacks{
+			Logger:        ctrl.Log,
+			SnapshotCache: &cache.v3,
+			Cache:         &cache,
+			OnError: func(nodeID, previousVersion, msg string, envoyAPI envoy.APIVersion) error {
+				nacked <- previousVersion
+				return nil
+			},
+		})
+		go srv.DeltaAggregatedResources(stream)
+
+		typeURL := envoy_resources_v3.Mappings()[envoy.ExtensionConfig]
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			Node:    &envoy_config_core_v3.Node{Id: "node1"},
+			TypeUrl: typeURL,
+		}
+		rsp := stream.expectResponse(t)
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			TypeUrl: typeURL, ResponseNonce: rsp.Nonce, ErrorDetail: &status.Status{Code: 3, Message: "error"},
+		}
+
+		select {
+		case version := <-nacked:
+			if version != "1" {
+				t.Errorf("OnError() called with version %q, want %q", version, "1")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnError() was not called")
+		}
+		stream.expectNoResponse(t)
+	})
+
+	t.Run("Pushes the resources of the extension types", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cache := NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+		snap := cache.NewSnapshot("1")
+		snap.SetResource("vhost1", &envoy_config_route_v3.VirtualHost{Name: "vhost1", Domains: []string{"example.com"}})
+		cache.SetSnapshot("node1", snap)
+
+		stream := newFakeDeltaStream(ctx)
+		srv := NewDeltaServer(ctx, cache, cache_v3.IDHash{}, &Callbacks{Logger: ctrl.Log})
+		go srv.DeltaAggregatedResources(stream)
+
+		typeURL := envoy_resources_v3.Mappings()[envoy.VirtualHost]
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			Node:    &envoy_config_core_v3.Node{Id: "node1"},
+			TypeUrl: typeURL,
+		}
+		rsp := stream.expectResponse(t)
+		if got := responseNames(rsp); !reflect.DeepEqual(got, []string{"vhost1"}) {
+			t.Errorf("initial response resources = %v", got)
+		}
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{
+			TypeUrl: typeURL, ResponseNonce: rsp.Nonce,
+		}
+		stream.expectNoResponse(t)
+
+		// vhost1 is removed and vhost2 is added
+		snap = cache.NewSnapshot("2")
+		snap.SetResource("vhost2", &envoy_config_route_v3.VirtualHost{Name: "vhost2", Domains: []string{"example.net"}})
+		cache.SetSnapshot("node1", snap)
+
+		rsp = stream.expectResponse(t)
+		if got := responseNames(rsp); !reflect.DeepEqual(got, []string{"vhost2"}) || !reflect.DeepEqual(rsp.RemovedResources, []string{"vhost1"}) {
+			t.Errorf("update response = %v", rsp)
+		}
+		if rsp.SystemVersionInfo != "2" {
+			t.Errorf("update response version = %v", rsp.SystemVersionInfo)
+		}
+	})
+
+	t.Run("Fails when the node is missing", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cache := cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)
+		stream := newFakeDeltaStream(ctx)
+		srv := NewDeltaServer(ctx, NewCache(cache), cache_v3.IDHash{}, nil)
+
+		stream.requests <- &envoy_service_discovery_v3.DeltaDiscoveryRequest{TypeUrl: resource_v3.ClusterType}
+		if err := srv.DeltaAggregatedResources(stream); err == nil {
+			t.Errorf("DeltaServer.DeltaAggregatedResources() expected an error")
+		}
+	})
+}
+
+func Test_resourceTypeForURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		typeURL string
+		want    envoy.Type
+		wantOk  bool
+	}{
+		{"Returns the type for a known type URL", resource_v3.ListenerType, envoy.Listener, true},
+		{"Returns the type for an extension type URL", "type.googleapis.com/envoy.config.route.v3.VirtualHost", envoy.VirtualHost, true},
+		{"Returns false for an unknown type URL", "xxxx", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := resourceTypeForURL(tt.typeURL)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("resourceTypeForURL() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
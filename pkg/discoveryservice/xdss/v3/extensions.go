@@ -0,0 +1,97 @@
+package discoveryservice
+
+import (
+	"sort"
+	"sync"
+
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+)
+
+// extensionStore keeps the node IDs with a snapshot in the cache together with
+// the resources of the extension types of their snapshots, which the go-control-plane
+// snapshot cache cannot hold. It also allows to watch for changes in the extension
+// resources of a node ID.
+type extensionStore struct {
+	mu      sync.Mutex
+	nodes   map[string]*extensionResources
+	watches map[string]chan struct{}
+}
+
+func newExtensionStore() *extensionStore {
+	return &extensionStore{
+		nodes:   map[string]*extensionResources{},
+		watches: map[string]chan struct{}{},
+	}
+}
+
+// set stores the extension resources of the snapshot of a node ID
+func (es *extensionStore) set(nodeID string, ext *extensionResources) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if ext == nil {
+		ext = newExtensionResources("")
+	}
+	es.nodes[nodeID] = ext
+	es.notify(nodeID)
+}
+
+// get returns the extension resources of the snapshot of a node ID
+func (es *extensionStore) get(nodeID string) (*extensionResources, bool) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	ext, ok := es.nodes[nodeID]
+	return ext, ok
+}
+
+// clear removes a node ID from the store
+func (es *extensionStore) clear(nodeID string) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	delete(es.nodes, nodeID)
+	es.notify(nodeID)
+}
+
+// nodeIDs returns the node IDs in the store, sorted
+func (es *extensionStore) nodeIDs() []string {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	nodeIDs := make([]string, 0, len(es.nodes))
+	for nodeID := range es.nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs
+}
+
+// watch returns a channel that is closed when the version of the given extension
+// type for the node ID differs from the given one. The channel is returned already
+// closed if the version differs at the time of the call.
+func (es *extensionStore) watch(nodeID string, rType envoy.Type, version string) <-chan struct{} {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if ext, ok := es.nodes[nodeID]; ok && ext.versionOf(rType) != version {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+
+	ch, ok := es.watches[nodeID]
+	if !ok {
+		ch = make(chan struct{})
+		es.watches[nodeID] = ch
+	}
+	return ch
+}
+
+// notify wakes up the watches of a node ID. Must be called with the lock held.
+func (es *extensionStore) notify(nodeID string) {
+	if ch, ok := es.watches[nodeID]; ok {
+		close(ch)
+		delete(es.watches, nodeID)
+	}
+}
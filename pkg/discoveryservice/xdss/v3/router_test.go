@@ -0,0 +1,83 @@
+package discoveryservice
+
+import (
+	"testing"
+	"time"
+
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_discovery_v3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func TestWatchRouter(t *testing.T) {
+	canaries := xdss.NewCanaries()
+	hash := NewNodeHash(nil, canaries)
+	wr := NewWatchRouter(cache_v3.NewSnapshotCache(true, hash, nil), hash)
+
+	if err := wr.SetSnapshot("node", cache_v3.NewSnapshot("v1", nil, nil, nil, nil, nil, nil)); err != nil {
+		t.Fatalf("WatchRouter.SetSnapshot() error = %v", err)
+	}
+
+	request := &envoy_service_discovery_v3.DiscoveryRequest{
+		Node:        &envoy_config_core_v3.Node{Id: "node"},
+		TypeUrl:     resource_v3.ClusterType,
+		VersionInfo: "v1",
+	}
+	receive := func(value chan cache_v3.Response) (string, bool) {
+		select {
+		case rsp := <-value:
+			version, _ := rsp.GetVersion()
+			return version, true
+		case <-time.After(100 * time.Millisecond):
+			return "", false
+		}
+	}
+
+	t.Run("Moves the open watches to the canary snapshot", func(t *testing.T) {
+		value, cancel := wr.CreateWatch(request)
+		defer cancel()
+		if _, ok := receive(value); ok {
+			t.Fatalf("WatchRouter.CreateWatch() responded to an up to date request")
+		}
+
+		canaries.Start("node", xdss.CanarySelection{Version: "v2", Percentage: 100})
+		if err := wr.SetSnapshot(xdss.CanaryKey("node"), cache_v3.NewSnapshot("v2", nil, nil, nil, nil, nil, nil)); err != nil {
+			t.Fatalf("WatchRouter.SetSnapshot() error = %v", err)
+		}
+		if version, ok := receive(value); !ok || version != "v2" {
+			t.Errorf("WatchRouter.Reroute() got version = %q, want %q", version, "v2")
+		}
+	})
+
+	t.Run("Moves the open watches back when the canary snapshot is cleared", func(t *testing.T) {
+		value, cancel := wr.CreateWatch(&envoy_service_discovery_v3.DiscoveryRequest{
+			Node: request.Node, TypeUrl: request.TypeUrl, VersionInfo: "v2",
+		})
+		defer cancel()
+		if _, ok := receive(value); ok {
+			t.Fatalf("WatchRouter.CreateWatch() responded to an up to date request")
+		}
+
+		canaries.Stop("node")
+		wr.ClearSnapshot(xdss.CanaryKey("node"))
+		if version, ok := receive(value); !ok || version != "v1" {
+			t.Errorf("WatchRouter.Reroute() got version = %q, want %q", version, "v1")
+		}
+	})
+
+	t.Run("Does not respond to cancelled watches", func(t *testing.T) {
+		value, cancel := wr.CreateWatch(request)
+		cancel()
+		if err := wr.SetSnapshot("node", cache_v3.NewSnapshot("v3", nil, nil, nil, nil, nil, nil)); err != nil {
+			t.Fatalf("WatchRouter.SetSnapshot() error = %v", err)
+		}
+		if _, ok := receive(value); ok {
+			t.Errorf("WatchRouter.CreateWatch() responded to a cancelled watch")
+		}
+		if len(wr.watches) != 0 {
+			t.Errorf("WatchRouter has %d open watches, want 0", len(wr.watches))
+		}
+	})
+}
@@ -1,25 +1,74 @@
 package discoveryservice
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
 	"github.com/3scale/marin3r/pkg/envoy"
 	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
 	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	envoy_service_runtime_v3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 )
 
+// extensionTypes are the resource types that the go-control-plane snapshot
+// cache does not support. They are stored alongside the cache_v3.Snapshot
+// and are only served by the DeltaServer.
+var extensionTypes = map[envoy.Type]struct{}{
+	envoy.ExtensionConfig: {},
+	envoy.ScopedRoute:     {},
+	envoy.VirtualHost:     {},
+}
+
+func isExtensionType(rType envoy.Type) bool {
+	_, ok := extensionTypes[rType]
+	return ok
+}
+
+// extensionResources holds the resources of the extension types of a snapshot
+type extensionResources struct {
+	// version is the version given to an extension type
+	// when the first resource of that type is added
+	version   string
+	resources map[envoy.Type]*cache_v3.Resources
+	// versions holds the version of each resource of the snapshot, of all the
+	// types, indexed by type and name. It is calculated when the snapshot is set
+	// in the cache, so it is not calculated again for every response.
+	versions map[envoy.Type]map[string]string
+}
+
+func newExtensionResources(version string) *extensionResources {
+	return &extensionResources{version: version, resources: map[envoy.Type]*cache_v3.Resources{}}
+}
+
+// versionOf returns the version of an extension type, or an
+// empty string if there are no resources of that type
+func (e *extensionResources) versionOf(rType envoy.Type) string {
+	if e == nil {
+		return ""
+	}
+	if r, ok := e.resources[rType]; ok {
+		return r.Version
+	}
+	return ""
+}
+
 // Snapshot implements "github.com/3scale/marin3r/pkg/discoveryservice/xdss".Snapshot for envoy API v3.
 type Snapshot struct {
-	v3 *cache_v3.Snapshot
+	v3  *cache_v3.Snapshot
+	ext *extensionResources
 }
 
 // NewSnapshot returns a Snapshot object.
 func NewSnapshot(v3 *cache_v3.Snapshot) Snapshot {
-	return Snapshot{v3: v3}
+	return Snapshot{v3: v3, ext: newExtensionResources("")}
 }
 
 // Consistent check verifies that the dependent resources are exactly listed in the
@@ -56,14 +105,47 @@ func (s Snapshot) SetResource(name string, res envoy.Resource) {
 
 	case *envoy_service_runtime_v3.Runtime:
 		s.v3.Resources[v3CacheResources(envoy.Runtime)].Items[name] = o
+
+	case *envoy_config_core_v3.TypedExtensionConfig:
+		s.extension(envoy.ExtensionConfig).Items[name] = o
+
+	case *envoy_config_route_v3.ScopedRouteConfiguration:
+		s.extension(envoy.ScopedRoute).Items[name] = o
+
+	case *envoy_config_route_v3.VirtualHost:
+		s.extension(envoy.VirtualHost).Items[name] = o
+	}
+}
+
+// extension returns the resources of the given extension type, creating them
+// with the snapshot version if the snapshot holds none of that type yet. Extension
+// types without resources have an empty version, so snapshots that do not use them
+// look the same as before these types were supported.
+func (s Snapshot) extension(rType envoy.Type) *cache_v3.Resources {
+	if r, ok := s.ext.resources[rType]; ok {
+		return r
 	}
+	r := &cache_v3.Resources{Version: s.ext.version, Items: map[string]cache_types.Resource{}}
+	s.ext.resources[rType] = r
+	return r
 }
 
 // GetResources selects snapshot resources by type.
 func (s Snapshot) GetResources(rType envoy.Type) map[string]envoy.Resource {
 
-	typeURLs := envoy_resources_v3.Mappings()
 	resources := map[string]envoy.Resource{}
+	if isExtensionType(rType) {
+		if s.ext != nil {
+			if r, ok := s.ext.resources[rType]; ok {
+				for k, v := range r.Items {
+					resources[k] = v.(envoy.Resource)
+				}
+			}
+		}
+		return resources
+	}
+
+	typeURLs := envoy_resources_v3.Mappings()
 	for k, v := range s.v3.GetResources(typeURLs[rType]) {
 		resources[k] = v.(envoy.Resource)
 	}
@@ -72,15 +154,74 @@ func (s Snapshot) GetResources(rType envoy.Type) map[string]envoy.Resource {
 
 // GetVersion returns the version for a resource type.
 func (s Snapshot) GetVersion(rType envoy.Type) string {
+	if isExtensionType(rType) {
+		return s.ext.versionOf(rType)
+	}
 	typeURLs := envoy_resources_v3.Mappings()
 	return s.v3.GetVersion(typeURLs[rType])
 }
 
 // SetVersion sets the version for a resource type.
 func (s Snapshot) SetVersion(rType envoy.Type, version string) {
+	if isExtensionType(rType) {
+		s.extension(rType).Version = version
+		return
+	}
+	if _, ok := envoy_resources_v3.Mappings()[rType]; !ok {
+		return
+	}
 	s.v3.Resources[v3CacheResources(rType)].Version = version
 }
 
+// GetResourceVersions returns the version of each one of the resources
+// of the given type, indexed by resource name. The version of a resource
+// is calculated from its serialized representation so it only changes
+// when the resource itself changes, which allows incremental xDS clients to
+// receive only the resources that have been modified. The versions of the
+// snapshots in the cache are calculated once, when they are set, and the
+// returned map must not be modified.
+func (s Snapshot) GetResourceVersions(rType envoy.Type) (map[string]string, error) {
+	if s.ext != nil && s.ext.versions != nil {
+		return s.ext.versions[rType], nil
+	}
+	return resourceVersions(s.GetResources(rType))
+}
+
+// allResourceVersions returns the version of each resource of
+// the snapshot, indexed by resource type and name
+func (s Snapshot) allResourceVersions() (map[envoy.Type]map[string]string, error) {
+	all := map[envoy.Type]map[string]string{}
+	for rType := range envoy_resources_v3.Mappings() {
+		versions, err := resourceVersions(s.GetResources(rType))
+		if err != nil {
+			return nil, err
+		}
+		all[rType] = versions
+	}
+	return all, nil
+}
+
+func resourceVersions(resources map[string]envoy.Resource) (map[string]string, error) {
+	versions := make(map[string]string, len(resources))
+	for name, res := range resources {
+		version, err := resourceVersion(res)
+		if err != nil {
+			return nil, fmt.Errorf("unable to calculate the version of resource %q: %w", name, err)
+		}
+		versions[name] = version
+	}
+	return versions, nil
+}
+
+func resourceVersion(res envoy.Resource) (string, error) {
+	b, err := cache_v3.MarshalResource(res)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
 func v3CacheResources(rType envoy.Type) int {
 	types := map[envoy.Type]int{
 		envoy.Endpoint: 0,
@@ -1,6 +1,7 @@
 package discoveryservice
 
 import (
+	"reflect"
 	"testing"
 
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
@@ -234,6 +235,69 @@ func TestSnapshot_SetVersion(t *testing.T) {
 	}
 }
 
+func TestSnapshot_GetResourceVersions(t *testing.T) {
+	snap := &cache_v3.Snapshot{
+		Resources: [6]cache_v3.Resources{
+			{Version: "1", Items: map[string]cache_types.Resource{
+				"endpoint1": &envoy_config_endpoint_v3.ClusterLoadAssignment{ClusterName: "endpoint1"},
+				"endpoint2": &envoy_config_endpoint_v3.ClusterLoadAssignment{ClusterName: "endpoint2"},
+			}},
+			{Version: "2", Items: map[string]cache_types.Resource{}},
+			{Version: "3", Items: map[string]cache_types.Resource{}},
+			{Version: "4", Items: map[string]cache_types.Resource{}},
+			{Version: "5", Items: map[string]cache_types.Resource{}},
+			{Version: "6", Items: map[string]cache_types.Resource{}},
+		}}
+
+	t.Run("Returns a version for each resource of the given type", func(t *testing.T) {
+		got, err := Snapshot{v3: snap}.GetResourceVersions(envoy.Endpoint)
+		if err != nil || len(got) != 2 || got["endpoint1"] == "" || got["endpoint2"] == "" || got["endpoint1"] == got["endpoint2"] {
+			t.Errorf("Snapshot.GetResourceVersions() = %v, %v", got, err)
+		}
+	})
+
+	t.Run("Resource versions don't depend on the snapshot's version", func(t *testing.T) {
+		before, _ := Snapshot{v3: snap}.GetResourceVersions(envoy.Endpoint)
+		s := Snapshot{v3: snap}
+		s.SetVersion(envoy.Endpoint, "xxxx")
+		if got, _ := s.GetResourceVersions(envoy.Endpoint); got["endpoint1"] != before["endpoint1"] {
+			t.Errorf("Snapshot.GetResourceVersions() = %v, want %v", got, before)
+		}
+	})
+
+	t.Run("Returns the versions calculated when the snapshot is set in the cache", func(t *testing.T) {
+		want, _ := Snapshot{v3: snap}.GetResourceVersions(envoy.Endpoint)
+		c := NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+		if err := c.SetSnapshot("node", NewSnapshot(snap)); err != nil {
+			t.Fatalf("Cache.SetSnapshot() error = %v", err)
+		}
+		s, _ := c.GetSnapshot("node")
+		if s.(*Snapshot).ext.versions == nil {
+			t.Fatalf("Cache.SetSnapshot() did not calculate the resource versions")
+		}
+		if got, err := s.GetResourceVersions(envoy.Endpoint); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Snapshot.GetResourceVersions() = %v, %v, want %v", got, err, want)
+		}
+	})
+
+	t.Run("Returns an error if a resource can't be serialized", func(t *testing.T) {
+		s := NewSnapshot(&cache_v3.Snapshot{Resources: [6]cache_v3.Resources{
+			{Items: map[string]cache_types.Resource{
+				// Strings must be valid UTF-8 to be serialized
+				"endpoint1": &envoy_config_endpoint_v3.ClusterLoadAssignment{ClusterName: "\xff"},
+			}},
+			{}, {}, {}, {}, {},
+		}})
+		if got, err := s.GetResourceVersions(envoy.Endpoint); err == nil {
+			t.Errorf("Snapshot.GetResourceVersions() = %v, want an error", got)
+		}
+		c := NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+		if err := c.SetSnapshot("node", s); err == nil {
+			t.Errorf("Cache.SetSnapshot() error = nil, want an error")
+		}
+	})
+}
+
 func Test_v3CacheResources(t *testing.T) {
 	type args struct {
 		rType envoy.Type
@@ -0,0 +1,22 @@
+package discoveryservice
+
+import (
+	"strings"
+
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources_v2 "github.com/3scale/marin3r/pkg/envoy/resources/v2"
+	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
+)
+
+// ConfigVersion returns the config version for the given type URL and snapshot version. The
+// Secret and Endpoint versions are suffixed with the hash of the resources, which is removed.
+func ConfigVersion(typeURL, version string) string {
+	switch typeURL {
+	case envoy_resources_v2.Mappings()[envoy.Secret], envoy_resources_v3.Mappings()[envoy.Secret],
+		envoy_resources_v2.Mappings()[envoy.Endpoint], envoy_resources_v3.Mappings()[envoy.Endpoint]:
+		if idx := strings.LastIndex(version, "-"); idx != -1 {
+			return version[:idx]
+		}
+	}
+	return version
+}
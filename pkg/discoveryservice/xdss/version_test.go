@@ -0,0 +1,30 @@
+package discoveryservice
+
+import (
+	"testing"
+
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources_v2 "github.com/3scale/marin3r/pkg/envoy/resources/v2"
+	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
+)
+
+func TestConfigVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		typeURL string
+		version string
+		want    string
+	}{
+		{"Removes the hash from v3 endpoints", envoy_resources_v3.Mappings()[envoy.Endpoint], "845f965864-6f8c2d", "845f965864"},
+		{"Removes the hash from v2 secrets", envoy_resources_v2.Mappings()[envoy.Secret], "845f965864-6f8c2d", "845f965864"},
+		{"Keeps endpoint versions without hash", envoy_resources_v3.Mappings()[envoy.Endpoint], "845f965864", "845f965864"},
+		{"Keeps the version of other types", envoy_resources_v3.Mappings()[envoy.Cluster], "845f965864", "845f965864"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ConfigVersion(tt.typeURL, tt.version); got != tt.want {
+				t.Errorf("ConfigVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,19 @@ const (
 	XdsClusterName                  string = "xds_cluster"
 )
 
+// XdsConfigSourceType selects the way envoy clients
+// subscribe to the resources of the discovery service
+type XdsConfigSourceType string
+
+const (
+	// AdsConfigSource makes envoy subscribe to all resource types using a
+	// single aggregated discovery service stream. This is the default.
+	AdsConfigSource XdsConfigSourceType = "ADS"
+	// GrpcConfigSource makes envoy subscribe to each resource type
+	// using a separate gRPC stream (CDS, LDS, RTDS ...)
+	GrpcConfigSource XdsConfigSourceType = "GRPC"
+)
+
 // ConfigOptions has options to configure the way the bootstrap config is generated
 type ConfigOptions struct {
 	XdsHost                     string
@@ -16,4 +29,5 @@ type ConfigOptions struct {
 	AdminAddress                string
 	AdminPort                   uint32
 	AdminAccessLogPath          string
+	XdsConfigSource             XdsConfigSourceType
 }
@@ -29,6 +29,50 @@ func (c *Config) getAdminAccessLogPath() string {
 	return stringOrDefault(c.Options.AdminAccessLogPath, "/dev/null")
 }
 
+// getAdsConfig returns the config of the aggregated discovery service, or nil
+// if the envoy client is configured to use a separate stream per resource type
+func (c *Config) getAdsConfig() *envoy_api_v2_core.ApiConfigSource {
+	if c.Options.XdsConfigSource == envoy_bootstrap_options.GrpcConfigSource {
+		return nil
+	}
+	return c.getApiConfigSource()
+}
+
+// getConfigSource returns the config source for the resources
+// the envoy client receives from the discovery service
+func (c *Config) getConfigSource() *envoy_api_v2_core.ConfigSource {
+	if c.Options.XdsConfigSource == envoy_bootstrap_options.GrpcConfigSource {
+		return &envoy_api_v2_core.ConfigSource{
+			ResourceApiVersion: envoy_api_v2_core.ApiVersion_V2,
+			ConfigSourceSpecifier: &envoy_api_v2_core.ConfigSource_ApiConfigSource{
+				ApiConfigSource: c.getApiConfigSource(),
+			},
+		}
+	}
+	return &envoy_api_v2_core.ConfigSource{
+		ResourceApiVersion: envoy_api_v2_core.ApiVersion_V2,
+		ConfigSourceSpecifier: &envoy_api_v2_core.ConfigSource_Ads{
+			Ads: &envoy_api_v2_core.AggregatedConfigSource{},
+		},
+	}
+}
+
+func (c *Config) getApiConfigSource() *envoy_api_v2_core.ApiConfigSource {
+	return &envoy_api_v2_core.ApiConfigSource{
+		ApiType:             envoy_api_v2_core.ApiConfigSource_GRPC,
+		TransportApiVersion: envoy_api_v2_core.ApiVersion_V2,
+		GrpcServices: []*envoy_api_v2_core.GrpcService{
+			{
+				TargetSpecifier: &envoy_api_v2_core.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &envoy_api_v2_core.GrpcService_EnvoyGrpc{
+						ClusterName: envoy_bootstrap_options.XdsClusterName,
+					},
+				},
+			},
+		},
+	}
+}
+
 // GenerateStatic returns the json serialized representation of an envoy
 // bootstrap object that can be passed as the configuration file to an envoy proxy
 // so it can connect to the discovery service.
@@ -68,31 +112,9 @@ func (c *Config) GenerateStatic() (string, error) {
 			},
 		},
 		DynamicResources: &envoy_config_bootstrap_v2.Bootstrap_DynamicResources{
-			AdsConfig: &envoy_api_v2_core.ApiConfigSource{
-				ApiType:             envoy_api_v2_core.ApiConfigSource_GRPC,
-				TransportApiVersion: envoy_api_v2_core.ApiVersion_V2,
-				GrpcServices: []*envoy_api_v2_core.GrpcService{
-					{
-						TargetSpecifier: &envoy_api_v2_core.GrpcService_EnvoyGrpc_{
-							EnvoyGrpc: &envoy_api_v2_core.GrpcService_EnvoyGrpc{
-								ClusterName: envoy_bootstrap_options.XdsClusterName,
-							},
-						},
-					},
-				},
-			},
-			CdsConfig: &envoy_api_v2_core.ConfigSource{
-				ResourceApiVersion: envoy_api_v2_core.ApiVersion_V2,
-				ConfigSourceSpecifier: &envoy_api_v2_core.ConfigSource_Ads{
-					Ads: &envoy_api_v2_core.AggregatedConfigSource{},
-				},
-			},
-			LdsConfig: &envoy_api_v2_core.ConfigSource{
-				ResourceApiVersion: envoy_api_v2_core.ApiVersion_V2,
-				ConfigSourceSpecifier: &envoy_api_v2_core.ConfigSource_Ads{
-					Ads: &envoy_api_v2_core.AggregatedConfigSource{},
-				},
-			},
+			AdsConfig: c.getAdsConfig(),
+			CdsConfig: c.getConfigSource(),
+			LdsConfig: c.getConfigSource(),
 		},
 		StaticResources: &envoy_config_bootstrap_v2.Bootstrap_StaticResources{
 			Clusters: []*envoy_api_v2.Cluster{
@@ -142,13 +164,8 @@ func (c *Config) GenerateStatic() (string, error) {
 				Name: c.Options.RtdsLayerResourceName,
 				LayerSpecifier: &envoy_config_bootstrap_v2.RuntimeLayer_RtdsLayer_{
 					RtdsLayer: &envoy_config_bootstrap_v2.RuntimeLayer_RtdsLayer{
-						Name: c.Options.RtdsLayerResourceName,
-						RtdsConfig: &envoy_api_v2_core.ConfigSource{
-							ResourceApiVersion: envoy_api_v2_core.ApiVersion_V2,
-							ConfigSourceSpecifier: &envoy_api_v2_core.ConfigSource_Ads{
-								Ads: &envoy_api_v2_core.AggregatedConfigSource{},
-							},
-						},
+						Name:       c.Options.RtdsLayerResourceName,
+						RtdsConfig: c.getConfigSource(),
 					},
 				},
 			}},
@@ -29,6 +29,22 @@ func TestConfig_GenerateStatic(t *testing.T) {
 			want:    `{"static_resources":{"clusters":[{"name":"xds_cluster","type":"STRICT_DNS","connect_timeout":"1s","load_assignment":{"cluster_name":"xds_cluster","endpoints":[{"lb_endpoints":[{"endpoint":{"address":{"socket_address":{"address":"localhost","port_value":10000}}}}]}]},"http2_protocol_options":{},"transport_socket":{"name":"envoy.transport_sockets.tls","typed_config":{"@type":"type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext","common_tls_context":{"tls_certificate_sds_secret_configs":[{"sds_config":{"path":"/sds-config-source.json"}}]}}}}]},"dynamic_resources":{"lds_config":{"ads":{},"resource_api_version":"V2"},"cds_config":{"ads":{},"resource_api_version":"V2"},"ads_config":{"api_type":"GRPC","transport_api_version":"V2","grpc_services":[{"envoy_grpc":{"cluster_name":"xds_cluster"}}]}},"layered_runtime":{"layers":[{"name":"runtime","rtds_layer":{"name":"runtime","rtds_config":{"ads":{},"resource_api_version":"V2"}}}]},"admin":{"access_log_path":"/dev/null","address":{"socket_address":{"address":"0.0.0.0","port_value":9001}}}}`,
 			wantErr: false,
 		},
+		{
+			name: "Returns a static configuration that uses a separate gRPC stream per resource type",
+			c: &Config{
+				Options: envoy_bootstrap_options.ConfigOptions{
+					XdsHost:                     "localhost",
+					XdsPort:                     10000,
+					XdsClientCertificatePath:    "/tls.crt",
+					XdsClientCertificateKeyPath: "/tls.key",
+					SdsConfigSourcePath:         "/sds-config-source.json",
+					RtdsLayerResourceName:       "runtime",
+					XdsConfigSource:             envoy_bootstrap_options.GrpcConfigSource,
+				},
+			},
+			want:    `{"static_resources":{"clusters":[{"name":"xds_cluster","type":"STRICT_DNS","connect_timeout":"1s","load_assignment":{"cluster_name":"xds_cluster","endpoints":[{"lb_endpoints":[{"endpoint":{"address":{"socket_address":{"address":"localhost","port_value":10000}}}}]}]},"http2_protocol_options":{},"transport_socket":{"name":"envoy.transport_sockets.tls","typed_config":{"@type":"type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext","common_tls_context":{"tls_certificate_sds_secret_configs":[{"sds_config":{"path":"/sds-config-source.json"}}]}}}}]},"dynamic_resources":{"lds_config":{"api_config_source":{"api_type":"GRPC","transport_api_version":"V2","grpc_services":[{"envoy_grpc":{"cluster_name":"xds_cluster"}}]},"resource_api_version":"V2"},"cds_config":{"api_config_source":{"api_type":"GRPC","transport_api_version":"V2","grpc_services":[{"envoy_grpc":{"cluster_name":"xds_cluster"}}]},"resource_api_version":"V2"}},"layered_runtime":{"layers":[{"name":"runtime","rtds_layer":{"name":"runtime","rtds_config":{"api_config_source":{"api_type":"GRPC","transport_api_version":"V2","grpc_services":[{"envoy_grpc":{"cluster_name":"xds_cluster"}}]},"resource_api_version":"V2"}}}]},"admin":{"access_log_path":"/dev/null","address":{"socket_address":{"address":"0.0.0.0","port_value":9001}}}}`,
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
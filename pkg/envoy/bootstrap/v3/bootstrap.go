@@ -30,6 +30,50 @@ func (c *Config) getAdminAccessLogPath() string {
 	return stringOrDefault(c.Options.AdminAccessLogPath, "/dev/null")
 }
 
+// getAdsConfig returns the config of the aggregated discovery service, or nil
+// if the envoy client is configured to use a separate stream per resource type
+func (c *Config) getAdsConfig() *envoy_config_core_v3.ApiConfigSource {
+	if c.Options.XdsConfigSource == envoy_bootstrap_options.GrpcConfigSource {
+		return nil
+	}
+	return c.getApiConfigSource()
+}
+
+// getConfigSource returns the config source for the resources
+// the envoy client receives from the discovery service
+func (c *Config) getConfigSource() *envoy_config_core_v3.ConfigSource {
+	if c.Options.XdsConfigSource == envoy_bootstrap_options.GrpcConfigSource {
+		return &envoy_config_core_v3.ConfigSource{
+			ResourceApiVersion: envoy_config_core_v3.ApiVersion_V3,
+			ConfigSourceSpecifier: &envoy_config_core_v3.ConfigSource_ApiConfigSource{
+				ApiConfigSource: c.getApiConfigSource(),
+			},
+		}
+	}
+	return &envoy_config_core_v3.ConfigSource{
+		ResourceApiVersion: envoy_config_core_v3.ApiVersion_V3,
+		ConfigSourceSpecifier: &envoy_config_core_v3.ConfigSource_Ads{
+			Ads: &envoy_config_core_v3.AggregatedConfigSource{},
+		},
+	}
+}
+
+func (c *Config) getApiConfigSource() *envoy_config_core_v3.ApiConfigSource {
+	return &envoy_config_core_v3.ApiConfigSource{
+		ApiType:             envoy_config_core_v3.ApiConfigSource_GRPC,
+		TransportApiVersion: envoy_config_core_v3.ApiVersion_V3,
+		GrpcServices: []*envoy_config_core_v3.GrpcService{
+			{
+				TargetSpecifier: &envoy_config_core_v3.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &envoy_config_core_v3.GrpcService_EnvoyGrpc{
+						ClusterName: envoy_bootstrap_options.XdsClusterName,
+					},
+				},
+			},
+		},
+	}
+}
+
 // GenerateStatic returns the json serialized representation of an envoy
 // bootstrap object that can be passed as the configuration file to an envoy proxy
 // so it can connect to the discovery service.
@@ -69,31 +113,9 @@ func (c *Config) GenerateStatic() (string, error) {
 			},
 		},
 		DynamicResources: &envoy_config_bootstrap_v3.Bootstrap_DynamicResources{
-			AdsConfig: &envoy_config_core_v3.ApiConfigSource{
-				ApiType:             envoy_config_core_v3.ApiConfigSource_GRPC,
-				TransportApiVersion: envoy_config_core_v3.ApiVersion_V3,
-				GrpcServices: []*envoy_config_core_v3.GrpcService{
-					{
-						TargetSpecifier: &envoy_config_core_v3.GrpcService_EnvoyGrpc_{
-							EnvoyGrpc: &envoy_config_core_v3.GrpcService_EnvoyGrpc{
-								ClusterName: envoy_bootstrap_options.XdsClusterName,
-							},
-						},
-					},
-				},
-			},
-			CdsConfig: &envoy_config_core_v3.ConfigSource{
-				ResourceApiVersion: envoy_config_core_v3.ApiVersion_V3,
-				ConfigSourceSpecifier: &envoy_config_core_v3.ConfigSource_Ads{
-					Ads: &envoy_config_core_v3.AggregatedConfigSource{},
-				},
-			},
-			LdsConfig: &envoy_config_core_v3.ConfigSource{
-				ResourceApiVersion: envoy_config_core_v3.ApiVersion_V3,
-				ConfigSourceSpecifier: &envoy_config_core_v3.ConfigSource_Ads{
-					Ads: &envoy_config_core_v3.AggregatedConfigSource{},
-				},
-			},
+			AdsConfig: c.getAdsConfig(),
+			CdsConfig: c.getConfigSource(),
+			LdsConfig: c.getConfigSource(),
 		},
 		StaticResources: &envoy_config_bootstrap_v3.Bootstrap_StaticResources{
 			Clusters: []*envoy_config_cluster_v3.Cluster{
@@ -143,13 +165,8 @@ func (c *Config) GenerateStatic() (string, error) {
 				Name: c.Options.RtdsLayerResourceName,
 				LayerSpecifier: &envoy_config_bootstrap_v3.RuntimeLayer_RtdsLayer_{
 					RtdsLayer: &envoy_config_bootstrap_v3.RuntimeLayer_RtdsLayer{
-						Name: c.Options.RtdsLayerResourceName,
-						RtdsConfig: &envoy_config_core_v3.ConfigSource{
-							ResourceApiVersion: envoy_config_core_v3.ApiVersion_V3,
-							ConfigSourceSpecifier: &envoy_config_core_v3.ConfigSource_Ads{
-								Ads: &envoy_config_core_v3.AggregatedConfigSource{},
-							},
-						},
+						Name:       c.Options.RtdsLayerResourceName,
+						RtdsConfig: c.getConfigSource(),
 					},
 				},
 			}},
@@ -0,0 +1,248 @@
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	"github.com/golang/protobuf/proto"
+)
+
+// ChangeType is the type of change of a resource
+type ChangeType string
+
+const (
+	// Added is used for resources that only exist in the new set of resources
+	Added ChangeType = "Added"
+	// Removed is used for resources that only exist in the old set of resources
+	Removed ChangeType = "Removed"
+	// Changed is used for resources that exist in both sets of resources with different values
+	Changed ChangeType = "Changed"
+)
+
+// FieldChange is a change in a field of a resource
+type FieldChange struct {
+	// Path is the path to the field in the json representation of the resource,
+	// like "load_assignment.endpoints[0].lb_endpoints"
+	Path string
+	// From is the json representation of the old value, empty if the field has been added
+	From string
+	// To is the json representation of the new value, empty if the field has been removed
+	To string
+}
+
+// String returns the string representation of a FieldChange
+func (fc FieldChange) String() string {
+	switch {
+	case fc.From == "":
+		return fmt.Sprintf("+ %s: %s", fc.Path, fc.To)
+	case fc.To == "":
+		return fmt.Sprintf("- %s: %s", fc.Path, fc.From)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", fc.Path, fc.From, fc.To)
+	}
+}
+
+// ResourceChange is a change in a resource
+type ResourceChange struct {
+	Type   envoy.Type
+	Name   string
+	Change ChangeType
+	// Fields holds the changes in the fields of the resource, only for Changed resources
+	Fields []FieldChange
+}
+
+// Changes is a list of changes in resources
+type Changes []ResourceChange
+
+// String returns the string representation of the changes, one line per resource and field
+func (c Changes) String() string {
+	var b strings.Builder
+	for _, rc := range c {
+		fmt.Fprintf(&b, "%s %s %q\n", rc.Change, rc.Type, rc.Name)
+		for _, fc := range rc.Fields {
+			fmt.Fprintf(&b, "    %s\n", fc)
+		}
+	}
+	return b.String()
+}
+
+// Sort sorts the changes by resource type and name
+func (c Changes) Sort() {
+	order := map[envoy.Type]int{}
+	for i, rType := range diffedTypes {
+		order[rType] = i
+	}
+	sort.SliceStable(c, func(i, j int) bool {
+		if c[i].Type != c[j].Type {
+			return order[c[i].Type] < order[c[j].Type]
+		}
+		return c[i].Name < c[j].Name
+	})
+}
+
+// diffedTypes is the order in which the resource types are diffed
+var diffedTypes = []envoy.Type{
+	envoy.Endpoint, envoy.Cluster, envoy.Route, envoy.ScopedRoute, envoy.VirtualHost,
+	envoy.Listener, envoy.Secret, envoy.Runtime, envoy.ExtensionConfig,
+}
+
+// Diff returns the changes between two sets of envoy resources, indexed by type and name. The resources
+// are compared as protos, so the serialization they were decoded from makes no difference, and the field
+// changes are calculated over the json representation of the resources that the serializer produces. The
+// changes are sorted by resource type and name.
+func Diff(version envoy.APIVersion, from, to map[envoy.Type]map[string]envoy.Resource) (Changes, error) {
+	marshaller := envoy_serializer.NewResourceMarshaller(envoy_serializer.JSON, version)
+	changes := Changes{}
+
+	for _, rType := range diffedTypes {
+		oldObjs := map[string]interface{}{}
+		newObjs := map[string]interface{}{}
+
+		// Only the resources that are not equal need to be serialized
+		for name, oldRes := range from[rType] {
+			if newRes, ok := to[rType][name]; ok && proto.Equal(oldRes, newRes) {
+				continue
+			}
+			obj, err := toObject(marshaller, oldRes)
+			if err != nil {
+				return nil, fmt.Errorf("unable to serialize %s %q: '%s'", rType, name, err)
+			}
+			oldObjs[name] = obj
+		}
+		for name, newRes := range to[rType] {
+			if oldRes, ok := from[rType][name]; ok && proto.Equal(oldRes, newRes) {
+				continue
+			}
+			obj, err := toObject(marshaller, newRes)
+			if err != nil {
+				return nil, fmt.Errorf("unable to serialize %s %q: '%s'", rType, name, err)
+			}
+			newObjs[name] = obj
+		}
+
+		changes = append(changes, DiffObjects(rType, oldObjs, newObjs)...)
+	}
+
+	return changes, nil
+}
+
+// DiffObjects returns the changes between two sets of resources of the given type, indexed by name,
+// which are already decoded from json into interface{}. It can be used to diff the objects that hold
+// references to resources whose contents are not known, like the secrets. The changes are sorted by name.
+func DiffObjects(rType envoy.Type, from, to map[string]interface{}) Changes {
+	changes := Changes{}
+	for _, name := range unionOfKeys(from, to) {
+		oldObj, inFrom := from[name]
+		newObj, inTo := to[name]
+
+		switch {
+		case !inTo:
+			changes = append(changes, ResourceChange{Type: rType, Name: name, Change: Removed})
+		case !inFrom:
+			changes = append(changes, ResourceChange{Type: rType, Name: name, Change: Added})
+		default:
+			if fields := Fields(oldObj, newObj); len(fields) > 0 {
+				changes = append(changes, ResourceChange{Type: rType, Name: name, Change: Changed, Fields: fields})
+			}
+		}
+	}
+	return changes
+}
+
+// unionOfKeys returns the sorted list of the keys of both maps
+func unionOfKeys(from, to map[string]interface{}) []string {
+	keys := []string{}
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// toObject returns the generic json representation of an envoy resource
+func toObject(marshaller envoy_serializer.ResourceMarshaller, res envoy.Resource) (interface{}, error) {
+	js, err := marshaller.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	var obj interface{}
+	if err := json.Unmarshal([]byte(js), &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Fields returns the changes between two values decoded from json into interface{}. Objects
+// are compared field by field and lists item by item, so the changes point to the innermost
+// fields that differ. The changes are sorted by path.
+func Fields(from, to interface{}) []FieldChange {
+	changes := []FieldChange{}
+	compare("", from, to, &changes)
+	return changes
+}
+
+func compare(path string, from, to interface{}, changes *[]FieldChange) {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		if t, ok := to.(map[string]interface{}); ok {
+			keys := []string{}
+			for k := range f {
+				keys = append(keys, k)
+			}
+			for k := range t {
+				if _, ok := f[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				child := k
+				if path != "" {
+					child = path + "." + k
+				}
+				compare(child, f[k], t[k], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if t, ok := to.([]interface{}); ok {
+			for i := 0; i < len(f) || i < len(t); i++ {
+				var fi, ti interface{}
+				if i < len(f) {
+					fi = f[i]
+				}
+				if i < len(t) {
+					ti = t[i]
+				}
+				compare(fmt.Sprintf("%s[%d]", path, i), fi, ti, changes)
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		*changes = append(*changes, FieldChange{Path: path, From: encode(from), To: encode(to)})
+	}
+}
+
+// encode returns the json representation of a value, or an empty string for nil values
+func encode(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(js)
+}
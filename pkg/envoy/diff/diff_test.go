@@ -0,0 +1,154 @@
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources "github.com/3scale/marin3r/pkg/envoy/resources"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+)
+
+// testResources decodes the given resources, indexed by name, with the given serialization
+func testResources(t *testing.T, encoding envoy_serializer.Serialization, resources map[envoy.Type]map[string]string) map[envoy.Type]map[string]envoy.Resource {
+	generator := envoy_resources.NewGenerator(envoy.APIv3)
+	decoder := envoy_serializer.NewResourceUnmarshaller(encoding, envoy.APIv3)
+
+	r := map[envoy.Type]map[string]envoy.Resource{}
+	for rType, values := range resources {
+		r[rType] = map[string]envoy.Resource{}
+		for name, value := range values {
+			res := generator.New(rType)
+			if err := decoder.Unmarshal(value, res); err != nil {
+				t.Fatalf("unable to decode test resource %s %q: %s", rType, name, err)
+			}
+			r[rType][name] = res
+		}
+	}
+	return r
+}
+
+func TestDiff(t *testing.T) {
+	from := testResources(t, envoy_serializer.JSON, map[envoy.Type]map[string]string{
+		envoy.Cluster: {
+			"same":    `{"name": "same", "connect_timeout": "1s"}`,
+			"changed": `{"name": "changed", "connect_timeout": "1s", "dns_lookup_family": "V4_ONLY"}`,
+			"removed": `{"name": "removed"}`,
+		},
+	})
+	to := testResources(t, envoy_serializer.YAML, map[envoy.Type]map[string]string{
+		envoy.Cluster: {
+			"same":    "name: same\nconnect_timeout: 1s",
+			"changed": "name: changed\nconnect_timeout: 2s\ntype: STRICT_DNS",
+		},
+		envoy.Listener: {
+			"added": "name: added",
+		},
+	})
+
+	got, err := Diff(envoy.APIv3, from, to)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	want := Changes{
+		{Type: envoy.Cluster, Name: "changed", Change: Changed, Fields: []FieldChange{
+			{Path: "connect_timeout", From: `"1s"`, To: `"2s"`},
+			{Path: "dns_lookup_family", From: `"V4_ONLY"`},
+			{Path: "type", To: `"STRICT_DNS"`},
+		}},
+		{Type: envoy.Cluster, Name: "removed", Change: Removed},
+		{Type: envoy.Listener, Name: "added", Change: Added},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+}
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		name string
+		from interface{}
+		to   interface{}
+		want []FieldChange
+	}{
+		{
+			name: "Equal values",
+			from: map[string]interface{}{"a": []interface{}{"x"}},
+			to:   map[string]interface{}{"a": []interface{}{"x"}},
+			want: []FieldChange{},
+		},
+		{
+			name: "Nested fields and list items",
+			from: map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"x", "y"}}},
+			to:   map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"x", "z", "w"}}},
+			want: []FieldChange{
+				{Path: "a.b[1]", From: `"y"`, To: `"z"`},
+				{Path: "a.b[2]", To: `"w"`},
+			},
+		},
+		{
+			name: "Values of different types",
+			from: map[string]interface{}{"a": map[string]interface{}{"b": 1.0}},
+			to:   map[string]interface{}{"a": "b"},
+			want: []FieldChange{{Path: "a", From: `{"b":1}`, To: `"b"`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fields(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChanges_String(t *testing.T) {
+	changes := Changes{
+		{Type: envoy.Cluster, Name: "a", Change: Changed, Fields: []FieldChange{
+			{Path: "x", From: "1", To: "2"}, {Path: "y", To: "3"}, {Path: "z", From: "4"},
+		}},
+		{Type: envoy.Listener, Name: "b", Change: Added},
+	}
+	want := "Changed Cluster \"a\"\n    ~ x: 1 -> 2\n    + y: 3\n    - z: 4\nAdded Listener \"b\"\n"
+	if got := changes.String(); got != want {
+		t.Errorf("Changes.String() = %q, want %q", got, want)
+	}
+}
+
+func TestDiffObjects(t *testing.T) {
+	from := map[string]interface{}{
+		"a": map[string]interface{}{"ref": "x"},
+		"b": map[string]interface{}{"ref": "y"},
+	}
+	to := map[string]interface{}{
+		"a": map[string]interface{}{"ref": "z"},
+		"c": map[string]interface{}{"ref": "y"},
+	}
+	want := Changes{
+		{Type: envoy.Secret, Name: "a", Change: Changed, Fields: []FieldChange{{Path: "ref", From: `"x"`, To: `"z"`}}},
+		{Type: envoy.Secret, Name: "b", Change: Removed},
+		{Type: envoy.Secret, Name: "c", Change: Added},
+	}
+	if got := DiffObjects(envoy.Secret, from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffObjects() = %v, want %v", got, want)
+	}
+}
+
+func TestChanges_Sort(t *testing.T) {
+	changes := Changes{
+		{Type: envoy.Secret, Name: "a"},
+		{Type: envoy.Cluster, Name: "b"},
+		{Type: envoy.Endpoint, Name: "c"},
+		{Type: envoy.Cluster, Name: "a"},
+	}
+	changes.Sort()
+	want := Changes{
+		{Type: envoy.Endpoint, Name: "c"},
+		{Type: envoy.Cluster, Name: "a"},
+		{Type: envoy.Cluster, Name: "b"},
+		{Type: envoy.Secret, Name: "a"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Changes.Sort() = %v, want %v", changes, want)
+	}
+}
@@ -0,0 +1,458 @@
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+)
+
+// Severity is the severity of a lint issue
+type Severity string
+
+const (
+	// SeverityError is used for issues that make envoy reject
+	// the resources or fail to serve the traffic as configured
+	SeverityError Severity = "Error"
+	// SeverityWarning is used for issues that envoy accepts
+	// but are most probably a mistake
+	SeverityWarning Severity = "Warning"
+)
+
+// Rule identifies each of the checks of the linter
+type Rule string
+
+const (
+	// UnknownClusterRule checks that the clusters that
+	// routes and tcp proxies send traffic to exist
+	UnknownClusterRule Rule = "UnknownCluster"
+	// UnknownSecretRule checks that the secrets requested via
+	// SDS by clusters and listeners exist
+	UnknownSecretRule Rule = "UnknownSecret"
+	// DuplicateListenerAddressRule checks that no two
+	// listeners bind to the same address and port
+	DuplicateListenerAddressRule Rule = "DuplicateListenerAddress"
+	// NameMismatchRule checks that the name of each resource
+	// matches the name inside the resource
+	NameMismatchRule Rule = "NameMismatch"
+	// ShadowedRouteRule checks that no route of a virtual host
+	// is unreachable because a previous route matches all its requests
+	ShadowedRouteRule Rule = "ShadowedRoute"
+	// DeltaConfigSourceRule checks that the extension configs, scoped routes and
+	// virtual hosts are requested using the incremental variant of the xDS protocol,
+	// the only one the discovery service serves them over
+	DeltaConfigSourceRule Rule = "DeltaConfigSource"
+)
+
+// Issue is a problem found by the linter in an envoy resource
+type Issue struct {
+	Severity Severity
+	Rule     Rule
+	// Type and Name identify the resource the issue has been found in
+	Type    envoy.Type
+	Name    string
+	Message string
+}
+
+// String returns the string representation of an Issue
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s %q: %s (%s)", i.Severity, i.Type, i.Name, i.Message, i.Rule)
+}
+
+// Issues is a list of lint issues
+type Issues []Issue
+
+// Errors returns the issues with SeverityError
+func (is Issues) Errors() Issues {
+	return is.filter(SeverityError)
+}
+
+// Warnings returns the issues with SeverityWarning
+func (is Issues) Warnings() Issues {
+	return is.filter(SeverityWarning)
+}
+
+func (is Issues) filter(severity Severity) Issues {
+	filtered := Issues{}
+	for _, issue := range is {
+		if issue.Severity == severity {
+			filtered = append(filtered, issue)
+		}
+	}
+	return filtered
+}
+
+// Resources holds envoy resources by type and name, the same
+// way they are stored in the snapshots of the xDS server cache
+type Resources map[envoy.Type]map[string]envoy.Resource
+
+// lintedTypes is the order in which the resource types are linted
+var lintedTypes = []envoy.Type{
+	envoy.Endpoint, envoy.Cluster, envoy.Route, envoy.ScopedRoute, envoy.VirtualHost,
+	envoy.Listener, envoy.Secret, envoy.Runtime, envoy.ExtensionConfig,
+}
+
+// object is the generic representation of a decoded envoy resource, or of any of its fields
+type object = map[string]interface{}
+
+// document is an envoy resource, decoded into its generic representation
+type document struct {
+	rType envoy.Type
+	name  string
+	obj   object
+}
+
+// Lint checks the cross references between envoy resources, which the proto validation rules and
+// the snapshot consistency check don't cover. The resources are serialized to json with the same
+// serializer the controllers use, so the rules are applied the same way to all the envoy API versions.
+// The issues found are returned sorted by resource type and name.
+func Lint(version envoy.APIVersion, resources Resources) (Issues, error) {
+	marshaller := envoy_serializer.NewResourceMarshaller(envoy_serializer.JSON, version)
+	docs := []document{}
+
+	for _, rType := range lintedTypes {
+		names := make([]string, 0, len(resources[rType]))
+		for name := range resources[rType] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			js, err := marshaller.Marshal(resources[rType][name])
+			if err != nil {
+				return nil, fmt.Errorf("unable to serialize %s %q: '%s'", rType, name, err)
+			}
+			obj := object{}
+			if err := json.Unmarshal([]byte(js), &obj); err != nil {
+				return nil, fmt.Errorf("unable to decode %s %q: '%s'", rType, name, err)
+			}
+			docs = append(docs, document{rType, name, obj})
+		}
+	}
+
+	issues := Issues{}
+	for _, doc := range docs {
+		issues = append(issues, lintName(doc)...)
+		issues = append(issues, lintClusterReferences(doc, resources[envoy.Cluster])...)
+		issues = append(issues, lintSecretReferences(doc, resources[envoy.Secret])...)
+		issues = append(issues, lintShadowedRoutes(doc)...)
+		issues = append(issues, lintDeltaConfigSources(doc)...)
+	}
+	issues = append(issues, lintListenerAddresses(docs)...)
+
+	order := map[envoy.Type]int{}
+	for i, rType := range lintedTypes {
+		order[rType] = i
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Type != issues[j].Type {
+			return order[issues[i].Type] < order[issues[j].Type]
+		}
+		return issues[i].Name < issues[j].Name
+	})
+
+	return issues, nil
+}
+
+// lintName checks that the name of the resource matches the name inside the resource
+func lintName(doc document) Issues {
+	field := "name"
+	if doc.rType == envoy.Endpoint {
+		field = "cluster_name"
+	}
+	if name, _ := doc.obj[field].(string); name != doc.name {
+		return Issues{{
+			Severity: SeverityError, Rule: NameMismatchRule, Type: doc.rType, Name: doc.name,
+			Message: fmt.Sprintf("the %s field of the resource is %q", field, name),
+		}}
+	}
+	return nil
+}
+
+// lintClusterReferences checks that the clusters referenced by routes, weighted
+// clusters, request mirror policies and tcp proxies exist
+func lintClusterReferences(doc document, clusters map[string]envoy.Resource) Issues {
+	if doc.rType != envoy.Route && doc.rType != envoy.VirtualHost && doc.rType != envoy.Listener {
+		return nil
+	}
+
+	refs := []string{}
+	walk(doc.obj, "", func(key string, obj object) {
+		switch {
+		case key == "route" || key == "request_mirror_policies" || key == "request_mirror_policy" || isTCPProxy(obj):
+			if cluster, ok := obj["cluster"].(string); ok {
+				refs = append(refs, cluster)
+			}
+		case key == "weighted_clusters":
+			for _, wc := range objects(obj["clusters"]) {
+				if cluster, ok := wc["name"].(string); ok {
+					refs = append(refs, cluster)
+				}
+			}
+		}
+	})
+
+	issues := Issues{}
+	for _, ref := range unique(refs) {
+		if _, ok := clusters[ref]; !ok {
+			issues = append(issues, Issue{
+				Severity: SeverityError, Rule: UnknownClusterRule, Type: doc.rType, Name: doc.name,
+				Message: fmt.Sprintf("cluster %q is not defined", ref),
+			})
+		}
+	}
+	return issues
+}
+
+// isTCPProxy returns true if the object is the typed config of a tcp proxy network filter
+func isTCPProxy(obj object) bool {
+	t, _ := obj["@type"].(string)
+	return strings.HasSuffix(t, ".TcpProxy")
+}
+
+// lintSecretReferences checks that the secrets that clusters and listeners request via ADS exist. Secrets
+// requested from other config sources, or without config source, which are static secrets defined in the
+// bootstrap, are not checked.
+func lintSecretReferences(doc document, secrets map[string]envoy.Resource) Issues {
+	if doc.rType != envoy.Cluster && doc.rType != envoy.Listener {
+		return nil
+	}
+
+	refs := []string{}
+	walk(doc.obj, "", func(key string, obj object) {
+		switch key {
+		case "tls_certificate_sds_secret_configs", "validation_context_sds_secret_config", "session_ticket_keys_sds_secret_config":
+			source, _ := obj["sds_config"].(object)
+			if _, ads := source["ads"]; !ads {
+				return
+			}
+			if secret, ok := obj["name"].(string); ok {
+				refs = append(refs, secret)
+			}
+		}
+	})
+
+	issues := Issues{}
+	for _, ref := range unique(refs) {
+		if _, ok := secrets[ref]; !ok {
+			issues = append(issues, Issue{
+				Severity: SeverityError, Rule: UnknownSecretRule, Type: doc.rType, Name: doc.name,
+				Message: fmt.Sprintf("secret %q is not defined", ref),
+			})
+		}
+	}
+	return issues
+}
+
+// deltaConfigSources maps the fields that request the resource types only served over the incremental
+// variant of the xDS protocol to the field of their config source and the name of the discovery service
+var deltaConfigSources = map[string]struct{ source, service string }{
+	"config_discovery": {"config_source", "extension config discovery service (ECDS)"},
+	"scoped_rds":       {"scoped_rds_config_source", "scoped route discovery service (SRDS)"},
+	"vhds":             {"config_source", "virtual host discovery service (VHDS)"},
+}
+
+// lintDeltaConfigSources checks that the config sources used to request extension configs, scoped routes
+// and virtual hosts have the DELTA_GRPC api type. The ADS config sources are reported too, as the envoy
+// bootstraps generated by marin3r configure ADS with the state of the world variant of the protocol.
+func lintDeltaConfigSources(doc document) Issues {
+	services := []string{}
+	walk(doc.obj, "", func(key string, obj object) {
+		ds, ok := deltaConfigSources[key]
+		if !ok {
+			return
+		}
+		source, _ := obj[ds.source].(object)
+		api, _ := source["api_config_source"].(object)
+		if apiType, _ := api["api_type"].(string); apiType != "DELTA_GRPC" {
+			services = append(services, ds.service)
+		}
+	})
+
+	issues := Issues{}
+	for _, service := range unique(services) {
+		issues = append(issues, Issue{
+			Severity: SeverityError, Rule: DeltaConfigSourceRule, Type: doc.rType, Name: doc.name,
+			Message: fmt.Sprintf("the config source of the %s must have the DELTA_GRPC api type", service),
+		})
+	}
+	return issues
+}
+
+// listenerAddress is the address a listener binds to
+type listenerAddress struct {
+	protocol string
+	address  string
+	port     float64
+}
+
+// overlaps returns true if both addresses bind to the same port and protocol and either
+// they have the same IP address or one of them is the wildcard address
+func (la listenerAddress) overlaps(other listenerAddress) bool {
+	wildcard := func(address string) bool { return address == "0.0.0.0" || address == "::" }
+	return la.protocol == other.protocol && la.port == other.port &&
+		(la.address == other.address || wildcard(la.address) || wildcard(other.address))
+}
+
+// lintListenerAddresses checks that no two listeners bind to the same address and port.
+// The issue is reported in the listener that comes last in alphabetical order.
+func lintListenerAddresses(docs []document) Issues {
+	issues := Issues{}
+	seen := map[string]listenerAddress{}
+	names := []string{}
+
+	for _, doc := range docs {
+		if doc.rType != envoy.Listener {
+			continue
+		}
+		address, _ := doc.obj["address"].(object)
+		socket, ok := address["socket_address"].(object)
+		if !ok {
+			continue
+		}
+		la := listenerAddress{}
+		la.protocol, _ = socket["protocol"].(string)
+		la.address, _ = socket["address"].(string)
+		la.port, _ = socket["port_value"].(float64)
+
+		for _, name := range names {
+			if seen[name].overlaps(la) {
+				issues = append(issues, Issue{
+					Severity: SeverityError, Rule: DuplicateListenerAddressRule, Type: doc.rType, Name: doc.name,
+					Message: fmt.Sprintf("listener %q also binds to port %v", name, la.port),
+				})
+				break
+			}
+		}
+		seen[doc.name] = la
+		names = append(names, doc.name)
+	}
+
+	return issues
+}
+
+// lintShadowedRoutes checks that no route of a virtual host is shadowed by a previous one. Only
+// the path of the routes is taken into account, and routes that also match on headers, query
+// parameters or any other property of the requests are never considered to shadow other routes.
+func lintShadowedRoutes(doc document) Issues {
+	vhosts := []object{}
+	switch doc.rType {
+	case envoy.VirtualHost:
+		vhosts = append(vhosts, doc.obj)
+	case envoy.Route, envoy.Listener:
+		walk(doc.obj, "", func(key string, obj object) {
+			if key == "virtual_hosts" {
+				vhosts = append(vhosts, obj)
+			}
+		})
+	default:
+		return nil
+	}
+
+	issues := Issues{}
+	for _, vhost := range vhosts {
+		routes := objects(vhost["routes"])
+		for j := range routes {
+			for i := 0; i < j; i++ {
+				a, _ := routes[i]["match"].(object)
+				b, _ := routes[j]["match"].(object)
+				if shadows(a, b) {
+					issues = append(issues, Issue{
+						Severity: SeverityWarning, Rule: ShadowedRouteRule, Type: doc.rType, Name: doc.name,
+						Message: fmt.Sprintf("route %d of virtual host %q is shadowed by route %d", j, vhost["name"], i),
+					})
+					break
+				}
+			}
+		}
+	}
+	return issues
+}
+
+// shadows returns true if route match 'a' matches all the requests that route match 'b' does
+func shadows(a, b object) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	for _, restriction := range []string{"headers", "query_parameters", "grpc", "runtime_fraction", "tls_context", "dynamic_metadata"} {
+		if _, ok := a[restriction]; ok {
+			return false
+		}
+	}
+
+	caseSensitive := func(m object) bool {
+		cs, ok := m["case_sensitive"].(bool)
+		return !ok || cs
+	}
+	if caseSensitive(a) && !caseSensitive(b) {
+		return false
+	}
+	normalize := func(s string) string {
+		if !caseSensitive(a) {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+
+	bPath, bHasPath := b["path"].(string)
+	if !bHasPath {
+		bPath, bHasPath = b["prefix"].(string)
+	}
+
+	if prefix, ok := a["prefix"].(string); ok {
+		if prefix == "" || prefix == "/" {
+			return true
+		}
+		return bHasPath && strings.HasPrefix(normalize(bPath), normalize(prefix))
+	}
+	if path, ok := a["path"].(string); ok {
+		other, isPath := b["path"].(string)
+		return isPath && normalize(other) == normalize(path)
+	}
+	return false
+}
+
+// walk calls fn for each object nested in v, along with the key of the field that holds it.
+// The objects in lists get the key of the field that holds the list.
+func walk(v interface{}, key string, fn func(key string, obj object)) {
+	switch o := v.(type) {
+	case object:
+		fn(key, o)
+		for k, child := range o {
+			walk(child, k, fn)
+		}
+	case []interface{}:
+		for _, child := range o {
+			walk(child, key, fn)
+		}
+	}
+}
+
+// objects returns the objects in a list, ignoring any other values
+func objects(v interface{}) []object {
+	list, _ := v.([]interface{})
+	objs := make([]object, 0, len(list))
+	for _, item := range list {
+		if obj, ok := item.(object); ok {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// unique returns the sorted list of distinct values
+func unique(values []string) []string {
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	list := make([]string, 0, len(set))
+	for v := range set {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	return list
+}
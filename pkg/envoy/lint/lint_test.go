@@ -0,0 +1,289 @@
+package envoy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources "github.com/3scale/marin3r/pkg/envoy/resources"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+)
+
+// testResources decodes the given yaml resources, indexed by name, into a Resources struct
+func testResources(t *testing.T, version envoy.APIVersion, resources map[envoy.Type]map[string]string) Resources {
+	generator := envoy_resources.NewGenerator(version)
+	decoder := envoy_serializer.NewResourceUnmarshaller(envoy_serializer.YAML, version)
+
+	r := Resources{}
+	for rType, values := range resources {
+		r[rType] = map[string]envoy.Resource{}
+		for name, value := range values {
+			res := generator.New(rType)
+			if err := decoder.Unmarshal(value, res); err != nil {
+				t.Fatalf("unable to decode test resource %s %q: %s", rType, name, err)
+			}
+			r[rType][name] = res
+		}
+	}
+	return r
+}
+
+const (
+	testCluster = `
+name: cluster
+connect_timeout: 1s
+type: STRICT_DNS
+`
+	testSDSCluster = `
+name: cluster
+connect_timeout: 1s
+type: STRICT_DNS
+transport_socket:
+  name: envoy.transport_sockets.tls
+  typed_config:
+    "@type": type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext
+    common_tls_context:
+      tls_certificate_sds_secret_configs:
+        - name: client-cert
+          sds_config: { ads: {}, resource_api_version: V3 }
+      validation_context_sds_secret_config:
+        name: ca
+        sds_config: { path: /etc/envoy/ca.yaml }
+`
+	testRoute = `
+name: route
+virtual_hosts:
+  - name: vhost
+    domains: ["*"]
+    routes:
+      - match: { prefix: /api }
+        route: { cluster: cluster }
+      - match: { path: /api/status }
+        route: { cluster: cluster }
+      - match: { prefix: /ap, headers: [{ name: x-version, exact_match: v2 }] }
+        route: { cluster: cluster }
+      - match: { prefix: /apiv2, case_sensitive: false }
+        route:
+          weighted_clusters:
+            clusters:
+              - { name: cluster, weight: 50 }
+              - { name: canary, weight: 50 }
+      - match: { prefix: / }
+        route: { cluster: missing }
+`
+	testTCPListener = `
+name: %s
+address: { socket_address: { address: %s, port_value: 8443 } }
+filter_chains:
+  - filters:
+    - name: envoy.filters.network.tcp_proxy
+      typed_config:
+        "@type": type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy
+        stat_prefix: tcp
+        cluster: %s
+`
+	testDeltaListener = `
+name: http
+address: { socket_address: { address: 0.0.0.0, port_value: 8080 } }
+filter_chains:
+  - filters:
+    - name: envoy.filters.network.http_connection_manager
+      typed_config:
+        "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+        stat_prefix: http
+        scoped_routes:
+          name: scopes
+          scope_key_builder: { fragments: [{ header_value_extractor: { name: x-scope, index: 0 } }] }
+          rds_config_source: { ads: {}, resource_api_version: V3 }
+          scoped_rds:
+            scoped_rds_config_source:
+              api_config_source:
+                api_type: DELTA_GRPC
+                transport_api_version: V3
+                grpc_services: [{ envoy_grpc: { cluster_name: xds } }]
+              resource_api_version: V3
+        http_filters:
+          - name: envoy.filters.http.router
+            config_discovery:
+              config_source: { ads: {}, resource_api_version: V3 }
+              type_urls: [type.googleapis.com/envoy.extensions.filters.http.router.v3.Router]
+`
+	testVHDSRoute = `
+name: route
+vhds:
+  config_source:
+    api_config_source:
+      api_type: GRPC
+      transport_api_version: V3
+      grpc_services: [{ envoy_grpc: { cluster_name: xds } }]
+    resource_api_version: V3
+`
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   envoy.APIVersion
+		resources map[envoy.Type]map[string]string
+		want      Issues
+		wantErr   bool
+	}{
+		{
+			name:    "No issues",
+			version: envoy.APIv3,
+			resources: map[envoy.Type]map[string]string{
+				envoy.Cluster:  {"cluster": testCluster},
+				envoy.Endpoint: {"cluster": "{cluster_name: cluster}"},
+				envoy.Listener: {"listener": fmt.Sprintf(testTCPListener, "listener", "0.0.0.0", "cluster")},
+			},
+			want: Issues{},
+		},
+		{
+			name:    "Name mismatch",
+			version: envoy.APIv3,
+			resources: map[envoy.Type]map[string]string{
+				envoy.Cluster:  {"cluster": testCluster, "other": testCluster},
+				envoy.Endpoint: {"other": "{cluster_name: cluster}"},
+			},
+			want: Issues{
+				{Severity: SeverityError, Rule: NameMismatchRule, Type: envoy.Endpoint, Name: "other", Message: `the cluster_name field of the resource is "cluster"`},
+				{Severity: SeverityError, Rule: NameMismatchRule, Type: envoy.Cluster, Name: "other", Message: `the name field of the resource is "cluster"`},
+			},
+		},
+		{
+			name:    "Unknown clusters and shadowed routes",
+			version: envoy.APIv3,
+			resources: map[envoy.Type]map[string]string{
+				envoy.Cluster: {"cluster": testCluster},
+				envoy.Route:   {"route": testRoute},
+			},
+			want: Issues{
+				{Severity: SeverityError, Rule: UnknownClusterRule, Type: envoy.Route, Name: "route", Message: `cluster "canary" is not defined`},
+				{Severity: SeverityError, Rule: UnknownClusterRule, Type: envoy.Route, Name: "route", Message: `cluster "missing" is not defined`},
+				{Severity: SeverityWarning, Rule: ShadowedRouteRule, Type: envoy.Route, Name: "route", Message: `route 1 of virtual host "vhost" is shadowed by route 0`},
+			},
+		},
+		{
+			name:    "Unknown SDS secrets",
+			version: envoy.APIv3,
+			resources: map[envoy.Type]map[string]string{
+				envoy.Cluster: {"cluster": testSDSCluster},
+			},
+			want: Issues{
+				{Severity: SeverityError, Rule: UnknownSecretRule, Type: envoy.Cluster, Name: "cluster", Message: `secret "client-cert" is not defined`},
+			},
+		},
+		{
+			name:    "Known SDS secrets",
+			version: envoy.APIv3,
+			resources: map[envoy.Type]map[string]string{
+				envoy.Cluster: {"cluster": testSDSCluster},
+				envoy.Secret:  {"client-cert": "{name: client-cert}"},
+			},
+			want: Issues{},
+		},
+		{
+			name:    "Duplicate listener addresses",
+			version: envoy.APIv3,
+			resources: map[envoy.Type]map[string]string{
+				envoy.Cluster: {"cluster": testCluster},
+				envoy.Listener: {
+					"a": fmt.Sprintf(testTCPListener, "a", "127.0.0.1", "cluster"),
+					"b": fmt.Sprintf(testTCPListener, "b", "0.0.0.0", "cluster"),
+					"c": fmt.Sprintf(testTCPListener, "c", "127.0.0.2", "unknown"),
+				},
+			},
+			want: Issues{
+				{Severity: SeverityError, Rule: DuplicateListenerAddressRule, Type: envoy.Listener, Name: "b", Message: `listener "a" also binds to port 8443`},
+				{Severity: SeverityError, Rule: UnknownClusterRule, Type: envoy.Listener, Name: "c", Message: `cluster "unknown" is not defined`},
+				{Severity: SeverityError, Rule: DuplicateListenerAddressRule, Type: envoy.Listener, Name: "c", Message: `listener "b" also binds to port 8443`},
+			},
+		},
+		{
+			name:    "Config sources of resources only served over delta xDS",
+			version: envoy.APIv3,
+			resources: map[envoy.Type]map[string]string{
+				envoy.Route:    {"route": testVHDSRoute},
+				envoy.Listener: {"http": testDeltaListener},
+			},
+			want: Issues{
+				{Severity: SeverityError, Rule: DeltaConfigSourceRule, Type: envoy.Route, Name: "route",
+					Message: "the config source of the virtual host discovery service (VHDS) must have the DELTA_GRPC api type"},
+				{Severity: SeverityError, Rule: DeltaConfigSourceRule, Type: envoy.Listener, Name: "http",
+					Message: "the config source of the extension config discovery service (ECDS) must have the DELTA_GRPC api type"},
+			},
+		},
+		{
+			name:    "v2 resources",
+			version: envoy.APIv2,
+			resources: map[envoy.Type]map[string]string{
+				envoy.Route: {"route": `
+name: other
+virtual_hosts:
+  - name: vhost
+    domains: ["*"]
+    routes:
+      - match: { prefix: /api }
+        route: { cluster: missing }
+`},
+			},
+			want: Issues{
+				{Severity: SeverityError, Rule: NameMismatchRule, Type: envoy.Route, Name: "route", Message: `the name field of the resource is "other"`},
+				{Severity: SeverityError, Rule: UnknownClusterRule, Type: envoy.Route, Name: "route", Message: `cluster "missing" is not defined`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lint(tt.version, testResources(t, tt.version, tt.resources))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Lint() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssues_Errors(t *testing.T) {
+	issues := Issues{
+		{Severity: SeverityError, Rule: UnknownClusterRule},
+		{Severity: SeverityWarning, Rule: ShadowedRouteRule},
+	}
+	if got := issues.Errors(); !reflect.DeepEqual(got, Issues{issues[0]}) {
+		t.Errorf("Issues.Errors() = %v, want %v", got, Issues{issues[0]})
+	}
+	if got := issues.Warnings(); !reflect.DeepEqual(got, Issues{issues[1]}) {
+		t.Errorf("Issues.Warnings() = %v, want %v", got, Issues{issues[1]})
+	}
+}
+
+func Test_shadows(t *testing.T) {
+	tests := []struct {
+		name string
+		a    object
+		b    object
+		want bool
+	}{
+		{"Prefix covers prefix", object{"prefix": "/a"}, object{"prefix": "/ab"}, true},
+		{"Prefix covers path", object{"prefix": "/a"}, object{"path": "/a/b"}, true},
+		{"Prefix does not cover prefix", object{"prefix": "/ab"}, object{"prefix": "/a"}, false},
+		{"Root prefix covers regex", object{"prefix": "/"}, object{"safe_regex": object{"regex": ".*"}}, true},
+		{"Prefix does not cover regex", object{"prefix": "/a"}, object{"safe_regex": object{"regex": "/a.*"}}, false},
+		{"Path covers same path", object{"path": "/a"}, object{"path": "/a"}, true},
+		{"Path does not cover prefix", object{"path": "/a"}, object{"prefix": "/a"}, false},
+		{"Headers restrict the match", object{"prefix": "/", "headers": []interface{}{}}, object{"prefix": "/a"}, false},
+		{"Case sensitive does not cover case insensitive", object{"prefix": "/a"}, object{"prefix": "/ab", "case_sensitive": false}, false},
+		{"Case insensitive covers case sensitive", object{"prefix": "/A", "case_sensitive": false}, object{"prefix": "/ab"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shadows(tt.a, tt.b); got != tt.want {
+				t.Errorf("shadows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
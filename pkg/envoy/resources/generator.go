@@ -11,7 +11,11 @@ import (
 type Generator interface {
 	New(rType envoy.Type) envoy.Resource
 	NewSecret(string, string, string) envoy.Resource
+	NewValidationContextSecret(string, string) envoy.Resource
+	NewGenericSecret(string, string) envoy.Resource
+	NewSessionTicketKeysSecret(string, []string) envoy.Resource
 	NewSecretFromPath(string, string, string) envoy.Resource
+	NewClusterLoadAssignment(string, []envoy.LocalityEndpoints) envoy.Resource
 }
 
 // NewGenerator returns a generator struct for the given API version
@@ -5,7 +5,9 @@ import (
 	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	envoy_api_v2_auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	envoy_api_v2_core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	envoy_api_v2_endpoint "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	envoy_service_discovery_v2 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	wrappers "github.com/golang/protobuf/ptypes/wrappers"
 )
 
 // Generator returns a strcut that implements the envoy_resources.Generator
@@ -58,6 +60,57 @@ func (g Generator) NewSecret(name, privateKey, certificateChain string) envoy.Re
 	}
 }
 
+// NewValidationContextSecret returns a new envoy secret holding a validation
+// context with the given bundle of trusted CA certificates.
+func (g Generator) NewValidationContextSecret(name, trustedCA string) envoy.Resource {
+
+	return &envoy_api_v2_auth.Secret{
+		Name: name,
+		Type: &envoy_api_v2_auth.Secret_ValidationContext{
+			ValidationContext: &envoy_api_v2_auth.CertificateValidationContext{
+				TrustedCa: &envoy_api_v2_core.DataSource{
+					Specifier: &envoy_api_v2_core.DataSource_InlineBytes{InlineBytes: []byte(trustedCA)},
+				},
+			},
+		},
+	}
+}
+
+// NewGenericSecret returns a new envoy secret of generic type, that
+// filters like the HMAC or OAuth2 ones can consume.
+func (g Generator) NewGenericSecret(name, secret string) envoy.Resource {
+
+	return &envoy_api_v2_auth.Secret{
+		Name: name,
+		Type: &envoy_api_v2_auth.Secret_GenericSecret{
+			GenericSecret: &envoy_api_v2_auth.GenericSecret{
+				Secret: &envoy_api_v2_core.DataSource{
+					Specifier: &envoy_api_v2_core.DataSource_InlineBytes{InlineBytes: []byte(secret)},
+				},
+			},
+		},
+	}
+}
+
+// NewSessionTicketKeysSecret returns a new envoy secret with the given TLS session
+// ticket keys. The first key is used to encrypt the new sessions.
+func (g Generator) NewSessionTicketKeysSecret(name string, keys []string) envoy.Resource {
+
+	sources := make([]*envoy_api_v2_core.DataSource, 0, len(keys))
+	for _, key := range keys {
+		sources = append(sources, &envoy_api_v2_core.DataSource{
+			Specifier: &envoy_api_v2_core.DataSource_InlineBytes{InlineBytes: []byte(key)},
+		})
+	}
+
+	return &envoy_api_v2_auth.Secret{
+		Name: name,
+		Type: &envoy_api_v2_auth.Secret_SessionTicketKeys{
+			SessionTicketKeys: &envoy_api_v2_auth.TlsSessionTicketKeys{Keys: sources},
+		},
+	}
+}
+
 // NewSecretFromPath returns an envoy secret that uses path sds to get the certificate from
 // a path and reload it whenever the certificate files change
 func (g Generator) NewSecretFromPath(name, certificateChainPath, privateKeyPath string) envoy.Resource {
@@ -78,3 +131,49 @@ func (g Generator) NewSecretFromPath(name, certificateChainPath, privateKeyPath
 		},
 	}
 }
+
+// NewClusterLoadAssignment returns a ClusterLoadAssignment for the given cluster
+// with the hosts of each locality. Draining hosts are reported with the DRAINING
+// health status so envoy stops sending new requests to them.
+func (g Generator) NewClusterLoadAssignment(clusterName string, localities []envoy.LocalityEndpoints) envoy.Resource {
+
+	endpoints := make([]*envoy_api_v2_endpoint.LocalityLbEndpoints, 0, len(localities))
+	for _, locality := range localities {
+		lbEndpoints := make([]*envoy_api_v2_endpoint.LbEndpoint, 0, len(locality.Hosts))
+		for _, host := range locality.Hosts {
+			healthStatus := envoy_api_v2_core.HealthStatus_HEALTHY
+			if host.Draining {
+				healthStatus = envoy_api_v2_core.HealthStatus_DRAINING
+			}
+			lbEndpoints = append(lbEndpoints, &envoy_api_v2_endpoint.LbEndpoint{
+				HostIdentifier: &envoy_api_v2_endpoint.LbEndpoint_Endpoint{
+					Endpoint: &envoy_api_v2_endpoint.Endpoint{
+						Address: &envoy_api_v2_core.Address{
+							Address: &envoy_api_v2_core.Address_SocketAddress{
+								SocketAddress: &envoy_api_v2_core.SocketAddress{
+									Address:       host.Address,
+									PortSpecifier: &envoy_api_v2_core.SocketAddress_PortValue{PortValue: host.Port},
+								},
+							},
+						},
+					},
+				},
+				HealthStatus: healthStatus,
+			})
+		}
+
+		lle := &envoy_api_v2_endpoint.LocalityLbEndpoints{LbEndpoints: lbEndpoints}
+		if locality.Region != "" || locality.Zone != "" {
+			lle.Locality = &envoy_api_v2_core.Locality{Region: locality.Region, Zone: locality.Zone}
+		}
+		if locality.Weight > 0 {
+			lle.LoadBalancingWeight = &wrappers.UInt32Value{Value: locality.Weight}
+		}
+		endpoints = append(endpoints, lle)
+	}
+
+	return &envoy_api_v2.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints:   endpoints,
+	}
+}
@@ -17,9 +17,13 @@ package envoy
 import (
 	"testing"
 
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_api_v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	envoy_api_v2_auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	envoy_api_v2_core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	envoy_api_v2_endpoint "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	"github.com/golang/protobuf/proto"
+	wrappers "github.com/golang/protobuf/ptypes/wrappers"
 )
 
 func TestSecretGenerator_New(t *testing.T) {
@@ -66,3 +70,108 @@ func TestSecretGenerator_New(t *testing.T) {
 		})
 	}
 }
+
+func inlineBytes(b string) *envoy_api_v2_core.DataSource {
+	return &envoy_api_v2_core.DataSource{Specifier: &envoy_api_v2_core.DataSource_InlineBytes{InlineBytes: []byte(b)}}
+}
+
+func TestGenerator_NewSecretTypes(t *testing.T) {
+	g := Generator{}
+	tests := []struct {
+		name string
+		got  proto.Message
+		want *envoy_api_v2_auth.Secret
+	}{
+		{
+			name: "Returns a validation context secret",
+			got:  g.NewValidationContextSecret("ca", "bundle"),
+			want: &envoy_api_v2_auth.Secret{
+				Name: "ca",
+				Type: &envoy_api_v2_auth.Secret_ValidationContext{
+					ValidationContext: &envoy_api_v2_auth.CertificateValidationContext{TrustedCa: inlineBytes("bundle")},
+				},
+			},
+		},
+		{
+			name: "Returns a generic secret",
+			got:  g.NewGenericSecret("hmac", "xxxx"),
+			want: &envoy_api_v2_auth.Secret{
+				Name: "hmac",
+				Type: &envoy_api_v2_auth.Secret_GenericSecret{
+					GenericSecret: &envoy_api_v2_auth.GenericSecret{Secret: inlineBytes("xxxx")},
+				},
+			},
+		},
+		{
+			name: "Returns a session ticket keys secret",
+			got:  g.NewSessionTicketKeysSecret("tickets", []string{"key1", "key2"}),
+			want: &envoy_api_v2_auth.Secret{
+				Name: "tickets",
+				Type: &envoy_api_v2_auth.Secret_SessionTicketKeys{
+					SessionTicketKeys: &envoy_api_v2_auth.TlsSessionTicketKeys{
+						Keys: []*envoy_api_v2_core.DataSource{inlineBytes("key1"), inlineBytes("key2")},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !proto.Equal(tt.got, tt.want) {
+				t.Errorf("Generator = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerator_NewClusterLoadAssignment(t *testing.T) {
+	socketAddress := func(address string, port uint32) *envoy_api_v2_core.Address {
+		return &envoy_api_v2_core.Address{
+			Address: &envoy_api_v2_core.Address_SocketAddress{
+				SocketAddress: &envoy_api_v2_core.SocketAddress{
+					Address:       address,
+					PortSpecifier: &envoy_api_v2_core.SocketAddress_PortValue{PortValue: port},
+				},
+			},
+		}
+	}
+
+	got := Generator{}.NewClusterLoadAssignment("cluster", []envoy.LocalityEndpoints{
+		{
+			Region: "region", Zone: "zone-a", Weight: 2,
+			Hosts: []envoy.UpstreamHost{{Address: "10.0.0.1", Port: 8080}, {Address: "10.0.0.2", Port: 8080, Draining: true}},
+		},
+		{Hosts: []envoy.UpstreamHost{{Address: "10.0.0.3", Port: 8080}}},
+	})
+	want := &envoy_api_v2.ClusterLoadAssignment{
+		ClusterName: "cluster",
+		Endpoints: []*envoy_api_v2_endpoint.LocalityLbEndpoints{
+			{
+				Locality:            &envoy_api_v2_core.Locality{Region: "region", Zone: "zone-a"},
+				LoadBalancingWeight: &wrappers.UInt32Value{Value: 2},
+				LbEndpoints: []*envoy_api_v2_endpoint.LbEndpoint{
+					{
+						HostIdentifier: &envoy_api_v2_endpoint.LbEndpoint_Endpoint{Endpoint: &envoy_api_v2_endpoint.Endpoint{Address: socketAddress("10.0.0.1", 8080)}},
+						HealthStatus:   envoy_api_v2_core.HealthStatus_HEALTHY,
+					},
+					{
+						HostIdentifier: &envoy_api_v2_endpoint.LbEndpoint_Endpoint{Endpoint: &envoy_api_v2_endpoint.Endpoint{Address: socketAddress("10.0.0.2", 8080)}},
+						HealthStatus:   envoy_api_v2_core.HealthStatus_DRAINING,
+					},
+				},
+			},
+			{
+				LbEndpoints: []*envoy_api_v2_endpoint.LbEndpoint{
+					{
+						HostIdentifier: &envoy_api_v2_endpoint.LbEndpoint_Endpoint{Endpoint: &envoy_api_v2_endpoint.Endpoint{Address: socketAddress("10.0.0.3", 8080)}},
+						HealthStatus:   envoy_api_v2_core.HealthStatus_HEALTHY,
+					},
+				},
+			},
+		},
+	}
+
+	if !proto.Equal(got, want) {
+		t.Errorf("Generator.NewClusterLoadAssignment() = %v, want %v", got, want)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	envoy_service_runtime_v3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	wrappers "github.com/golang/protobuf/ptypes/wrappers"
 )
 
 // Generator returns a strcut that implements the envoy_resources.Generator
@@ -37,6 +38,15 @@ func (g Generator) New(rType envoy.Type) envoy.Resource {
 	case envoy.Secret:
 		return &envoy_extensions_transport_sockets_tls_v3.Secret{}
 
+	case envoy.ExtensionConfig:
+		return &envoy_config_core_v3.TypedExtensionConfig{}
+
+	case envoy.ScopedRoute:
+		return &envoy_config_route_v3.ScopedRouteConfiguration{}
+
+	case envoy.VirtualHost:
+		return &envoy_config_route_v3.VirtualHost{}
+
 	}
 
 	return nil
@@ -61,6 +71,57 @@ func (g Generator) NewSecret(name, privateKey, certificateChain string) envoy.Re
 	}
 }
 
+// NewValidationContextSecret returns a new envoy secret holding a validation
+// context with the given bundle of trusted CA certificates.
+func (g Generator) NewValidationContextSecret(name, trustedCA string) envoy.Resource {
+
+	return &envoy_extensions_transport_sockets_tls_v3.Secret{
+		Name: name,
+		Type: &envoy_extensions_transport_sockets_tls_v3.Secret_ValidationContext{
+			ValidationContext: &envoy_extensions_transport_sockets_tls_v3.CertificateValidationContext{
+				TrustedCa: &envoy_config_core_v3.DataSource{
+					Specifier: &envoy_config_core_v3.DataSource_InlineBytes{InlineBytes: []byte(trustedCA)},
+				},
+			},
+		},
+	}
+}
+
+// NewGenericSecret returns a new envoy secret of generic type, that
+// filters like the HMAC or OAuth2 ones can consume.
+func (g Generator) NewGenericSecret(name, secret string) envoy.Resource {
+
+	return &envoy_extensions_transport_sockets_tls_v3.Secret{
+		Name: name,
+		Type: &envoy_extensions_transport_sockets_tls_v3.Secret_GenericSecret{
+			GenericSecret: &envoy_extensions_transport_sockets_tls_v3.GenericSecret{
+				Secret: &envoy_config_core_v3.DataSource{
+					Specifier: &envoy_config_core_v3.DataSource_InlineBytes{InlineBytes: []byte(secret)},
+				},
+			},
+		},
+	}
+}
+
+// NewSessionTicketKeysSecret returns a new envoy secret with the given TLS session
+// ticket keys. The first key is used to encrypt the new sessions.
+func (g Generator) NewSessionTicketKeysSecret(name string, keys []string) envoy.Resource {
+
+	sources := make([]*envoy_config_core_v3.DataSource, 0, len(keys))
+	for _, key := range keys {
+		sources = append(sources, &envoy_config_core_v3.DataSource{
+			Specifier: &envoy_config_core_v3.DataSource_InlineBytes{InlineBytes: []byte(key)},
+		})
+	}
+
+	return &envoy_extensions_transport_sockets_tls_v3.Secret{
+		Name: name,
+		Type: &envoy_extensions_transport_sockets_tls_v3.Secret_SessionTicketKeys{
+			SessionTicketKeys: &envoy_extensions_transport_sockets_tls_v3.TlsSessionTicketKeys{Keys: sources},
+		},
+	}
+}
+
 // NewSecretFromPath returns an envoy secret that uses path sds to get the certificate from
 // a path and reload it whenever the certificate files change
 func (g Generator) NewSecretFromPath(name, certificateChainPath, privateKeyPath string) envoy.Resource {
@@ -81,3 +142,49 @@ func (g Generator) NewSecretFromPath(name, certificateChainPath, privateKeyPath
 		},
 	}
 }
+
+// NewClusterLoadAssignment returns a ClusterLoadAssignment for the given cluster
+// with the hosts of each locality. Draining hosts are reported with the DRAINING
+// health status so envoy stops sending new requests to them.
+func (g Generator) NewClusterLoadAssignment(clusterName string, localities []envoy.LocalityEndpoints) envoy.Resource {
+
+	endpoints := make([]*envoy_config_endpoint_v3.LocalityLbEndpoints, 0, len(localities))
+	for _, locality := range localities {
+		lbEndpoints := make([]*envoy_config_endpoint_v3.LbEndpoint, 0, len(locality.Hosts))
+		for _, host := range locality.Hosts {
+			healthStatus := envoy_config_core_v3.HealthStatus_HEALTHY
+			if host.Draining {
+				healthStatus = envoy_config_core_v3.HealthStatus_DRAINING
+			}
+			lbEndpoints = append(lbEndpoints, &envoy_config_endpoint_v3.LbEndpoint{
+				HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{
+					Endpoint: &envoy_config_endpoint_v3.Endpoint{
+						Address: &envoy_config_core_v3.Address{
+							Address: &envoy_config_core_v3.Address_SocketAddress{
+								SocketAddress: &envoy_config_core_v3.SocketAddress{
+									Address:       host.Address,
+									PortSpecifier: &envoy_config_core_v3.SocketAddress_PortValue{PortValue: host.Port},
+								},
+							},
+						},
+					},
+				},
+				HealthStatus: healthStatus,
+			})
+		}
+
+		lle := &envoy_config_endpoint_v3.LocalityLbEndpoints{LbEndpoints: lbEndpoints}
+		if locality.Region != "" || locality.Zone != "" {
+			lle.Locality = &envoy_config_core_v3.Locality{Region: locality.Region, Zone: locality.Zone}
+		}
+		if locality.Weight > 0 {
+			lle.LoadBalancingWeight = &wrappers.UInt32Value{Value: locality.Weight}
+		}
+		endpoints = append(endpoints, lle)
+	}
+
+	return &envoy_config_endpoint_v3.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints:   endpoints,
+	}
+}
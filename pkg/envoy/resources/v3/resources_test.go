@@ -17,9 +17,12 @@ package envoy
 import (
 	"testing"
 
+	envoy "github.com/3scale/marin3r/pkg/envoy"
 	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	"github.com/golang/protobuf/proto"
+	wrappers "github.com/golang/protobuf/ptypes/wrappers"
 )
 
 func TestSecretGenerator_New(t *testing.T) {
@@ -66,3 +69,108 @@ func TestSecretGenerator_New(t *testing.T) {
 		})
 	}
 }
+
+func inlineBytes(b string) *envoy_config_core_v3.DataSource {
+	return &envoy_config_core_v3.DataSource{Specifier: &envoy_config_core_v3.DataSource_InlineBytes{InlineBytes: []byte(b)}}
+}
+
+func TestGenerator_NewSecretTypes(t *testing.T) {
+	g := Generator{}
+	tests := []struct {
+		name string
+		got  proto.Message
+		want *envoy_extensions_transport_sockets_tls_v3.Secret
+	}{
+		{
+			name: "Returns a validation context secret",
+			got:  g.NewValidationContextSecret("ca", "bundle"),
+			want: &envoy_extensions_transport_sockets_tls_v3.Secret{
+				Name: "ca",
+				Type: &envoy_extensions_transport_sockets_tls_v3.Secret_ValidationContext{
+					ValidationContext: &envoy_extensions_transport_sockets_tls_v3.CertificateValidationContext{TrustedCa: inlineBytes("bundle")},
+				},
+			},
+		},
+		{
+			name: "Returns a generic secret",
+			got:  g.NewGenericSecret("hmac", "xxxx"),
+			want: &envoy_extensions_transport_sockets_tls_v3.Secret{
+				Name: "hmac",
+				Type: &envoy_extensions_transport_sockets_tls_v3.Secret_GenericSecret{
+					GenericSecret: &envoy_extensions_transport_sockets_tls_v3.GenericSecret{Secret: inlineBytes("xxxx")},
+				},
+			},
+		},
+		{
+			name: "Returns a session ticket keys secret",
+			got:  g.NewSessionTicketKeysSecret("tickets", []string{"key1", "key2"}),
+			want: &envoy_extensions_transport_sockets_tls_v3.Secret{
+				Name: "tickets",
+				Type: &envoy_extensions_transport_sockets_tls_v3.Secret_SessionTicketKeys{
+					SessionTicketKeys: &envoy_extensions_transport_sockets_tls_v3.TlsSessionTicketKeys{
+						Keys: []*envoy_config_core_v3.DataSource{inlineBytes("key1"), inlineBytes("key2")},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !proto.Equal(tt.got, tt.want) {
+				t.Errorf("Generator = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerator_NewClusterLoadAssignment(t *testing.T) {
+	socketAddress := func(address string, port uint32) *envoy_config_core_v3.Address {
+		return &envoy_config_core_v3.Address{
+			Address: &envoy_config_core_v3.Address_SocketAddress{
+				SocketAddress: &envoy_config_core_v3.SocketAddress{
+					Address:       address,
+					PortSpecifier: &envoy_config_core_v3.SocketAddress_PortValue{PortValue: port},
+				},
+			},
+		}
+	}
+
+	got := Generator{}.NewClusterLoadAssignment("cluster", []envoy.LocalityEndpoints{
+		{
+			Region: "region", Zone: "zone-a", Weight: 2,
+			Hosts: []envoy.UpstreamHost{{Address: "10.0.0.1", Port: 8080}, {Address: "10.0.0.2", Port: 8080, Draining: true}},
+		},
+		{Hosts: []envoy.UpstreamHost{{Address: "10.0.0.3", Port: 8080}}},
+	})
+	want := &envoy_config_endpoint_v3.ClusterLoadAssignment{
+		ClusterName: "cluster",
+		Endpoints: []*envoy_config_endpoint_v3.LocalityLbEndpoints{
+			{
+				Locality:            &envoy_config_core_v3.Locality{Region: "region", Zone: "zone-a"},
+				LoadBalancingWeight: &wrappers.UInt32Value{Value: 2},
+				LbEndpoints: []*envoy_config_endpoint_v3.LbEndpoint{
+					{
+						HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{Endpoint: &envoy_config_endpoint_v3.Endpoint{Address: socketAddress("10.0.0.1", 8080)}},
+						HealthStatus:   envoy_config_core_v3.HealthStatus_HEALTHY,
+					},
+					{
+						HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{Endpoint: &envoy_config_endpoint_v3.Endpoint{Address: socketAddress("10.0.0.2", 8080)}},
+						HealthStatus:   envoy_config_core_v3.HealthStatus_DRAINING,
+					},
+				},
+			},
+			{
+				LbEndpoints: []*envoy_config_endpoint_v3.LbEndpoint{
+					{
+						HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{Endpoint: &envoy_config_endpoint_v3.Endpoint{Address: socketAddress("10.0.0.3", 8080)}},
+						HealthStatus:   envoy_config_core_v3.HealthStatus_HEALTHY,
+					},
+				},
+			},
+		},
+	}
+
+	if !proto.Equal(got, want) {
+		t.Errorf("Generator.NewClusterLoadAssignment() = %v, want %v", got, want)
+	}
+}
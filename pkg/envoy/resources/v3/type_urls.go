@@ -15,5 +15,10 @@ func Mappings() map[envoy.Type]string {
 		envoy.Endpoint: resource_v3.EndpointType,
 		envoy.Secret:   resource_v3.SecretType,
 		envoy.Runtime:  resource_v3.RuntimeType,
+		// go-control-plane v0.9.7 does not define the type URLs
+		// of the ECDS, SRDS and VHDS resources
+		envoy.ExtensionConfig: "type.googleapis.com/envoy.config.core.v3.TypedExtensionConfig",
+		envoy.ScopedRoute:     "type.googleapis.com/envoy.config.route.v3.ScopedRouteConfiguration",
+		envoy.VirtualHost:     "type.googleapis.com/envoy.config.route.v3.VirtualHost",
 	}
 }
@@ -34,7 +34,7 @@ func NewResourceMarshaller(encoding Serialization, version envoy.APIVersion) Res
 		return envoy_serializer_v2.JSON{}
 	}
 
-	return envoy_serializer_v2.JSON{}
+	return envoy_serializer_v3.JSON{}
 
 }
 
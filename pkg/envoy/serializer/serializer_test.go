@@ -0,0 +1,28 @@
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_serializer_v2 "github.com/3scale/marin3r/pkg/envoy/serializer/v2"
+	envoy_serializer_v3 "github.com/3scale/marin3r/pkg/envoy/serializer/v3"
+)
+
+func TestNewResourceMarshaller(t *testing.T) {
+	tests := []struct {
+		name    string
+		version envoy.APIVersion
+		want    ResourceMarshaller
+	}{
+		{"Returns the v2 marshaller", envoy.APIv2, envoy_serializer_v2.JSON{}},
+		{"Returns the v3 marshaller", envoy.APIv3, envoy_serializer_v3.JSON{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewResourceMarshaller(JSON, tt.version); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewResourceMarshaller() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	envoy "github.com/3scale/marin3r/pkg/envoy"
 	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
@@ -168,6 +169,15 @@ func (s JSON) Unmarshal(str string, res envoy.Resource) error {
 	case *envoy_extensions_transport_sockets_tls_v3.Secret:
 		err = jsonpb.Unmarshal(bytes.NewReader([]byte(str)), o)
 
+	case *envoy_config_core_v3.TypedExtensionConfig:
+		err = jsonpb.Unmarshal(bytes.NewReader([]byte(str)), o)
+
+	case *envoy_config_route_v3.ScopedRouteConfiguration:
+		err = jsonpb.Unmarshal(bytes.NewReader([]byte(str)), o)
+
+	case *envoy_config_route_v3.VirtualHost:
+		err = jsonpb.Unmarshal(bytes.NewReader([]byte(str)), o)
+
 	default:
 		err = fmt.Errorf("Unknown resource type")
 	}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
 	_struct "github.com/golang/protobuf/ptypes/struct"
 
 	// This is the list of imports so all proto types are registered.
@@ -227,6 +228,25 @@ var (
 			Fields: map[string]*_struct.Value{
 				"static_layer_0": {Kind: &_struct.Value_StringValue{StringValue: "value"}},
 			}}}
+
+	virtualHostJSON string                             = `{"name":"vhost1","domains":["example.com"]}`
+	virtualHost     *envoy_config_route_v3.VirtualHost = &envoy_config_route_v3.VirtualHost{Name: "vhost1", Domains: []string{"example.com"}}
+
+	scopedRouteJSON string                                          = `{"name":"scope1","route_configuration_name":"route1","key":{"fragments":[{"string_key":"x"}]}}`
+	scopedRoute     *envoy_config_route_v3.ScopedRouteConfiguration = &envoy_config_route_v3.ScopedRouteConfiguration{
+		Name:                   "scope1",
+		RouteConfigurationName: "route1",
+		Key: &envoy_config_route_v3.ScopedRouteConfiguration_Key{
+			Fragments: []*envoy_config_route_v3.ScopedRouteConfiguration_Key_Fragment{
+				{Type: &envoy_config_route_v3.ScopedRouteConfiguration_Key_Fragment_StringKey{StringKey: "x"}},
+			}},
+	}
+
+	extensionConfigJSON string                                     = `{"name":"ext1","typed_config":{"@type":"type.googleapis.com/google.protobuf.Struct","value":{}}}`
+	extensionConfig     *envoy_config_core_v3.TypedExtensionConfig = &envoy_config_core_v3.TypedExtensionConfig{
+		Name:        "ext1",
+		TypedConfig: func() *any.Any { a, _ := ptypes.MarshalAny(&_struct.Struct{}); return a }(),
+	}
 )
 
 func TestJSON_Marshal(t *testing.T) {
@@ -351,6 +371,27 @@ func TestJSON_Unmarshal(t *testing.T) {
 			want:    runtime,
 			wantErr: false,
 		},
+		{
+			name:    "Deserialize virtual host from json",
+			s:       JSON{},
+			args:    args{str: virtualHostJSON, res: &envoy_config_route_v3.VirtualHost{}},
+			want:    virtualHost,
+			wantErr: false,
+		},
+		{
+			name:    "Deserialize scoped route from json",
+			s:       JSON{},
+			args:    args{str: scopedRouteJSON, res: &envoy_config_route_v3.ScopedRouteConfiguration{}},
+			want:    scopedRoute,
+			wantErr: false,
+		},
+		{
+			name:    "Deserialize extension config from json",
+			s:       JSON{},
+			args:    args{str: extensionConfigJSON, res: &envoy_config_core_v3.TypedExtensionConfig{}},
+			want:    extensionConfig,
+			wantErr: false,
+		},
 		{
 			name:    "Error deserializing resource",
 			s:       JSON{},
@@ -61,4 +61,30 @@ const (
 	Secret Type = "Secret"
 	// Runtime is an envoy runtime resource
 	Runtime Type = "Runtime"
+	// ExtensionConfig is an envoy typed extension config resource (ECDS). Only supported in envoy API v3.
+	ExtensionConfig Type = "ExtensionConfig"
+	// ScopedRoute is an envoy scoped route configuration resource (SRDS). Only supported in envoy API v3.
+	ScopedRoute Type = "ScopedRoute"
+	// VirtualHost is an envoy virtual host resource (VHDS). Only supported in envoy API v3.
+	VirtualHost Type = "VirtualHost"
 )
+
+// LocalityEndpoints is a group of upstream hosts that share the same
+// locality, used to generate ClusterLoadAssignment resources
+type LocalityEndpoints struct {
+	Region string
+	Zone   string
+	// Weight is the load balancing weight of the locality, only used by
+	// the clusters that have locality weighted load balancing enabled
+	Weight uint32
+	Hosts  []UpstreamHost
+}
+
+// UpstreamHost is an endpoint of a ClusterLoadAssignment
+type UpstreamHost struct {
+	Address string
+	Port    uint32
+	// Draining is true for the hosts that are terminating, which
+	// should not receive new requests
+	Draining bool
+}
@@ -144,6 +144,7 @@ func (r *BootstrapConfigReconciler) getBootstrapConfigMapObject(ds *operatorv1al
 		AdminAddress:                host,
 		AdminPort:                   port,
 		AdminAccessLogPath:          r.eb.Spec.EnvoyStaticConfig.AdminAccessLogPath,
+		XdsConfigSource:             r.eb.Spec.EnvoyStaticConfig.GetXdsConfigSource(),
 	})
 
 	config, err := bootstrap.GenerateStatic()
@@ -3,6 +3,7 @@ package reconcilers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
 	"github.com/3scale/marin3r/pkg/envoy"
@@ -12,25 +13,24 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/operator-framework/operator-lib/status"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-const (
-	maxRevisions = 10
-)
-
 // RevisionReconciler is a struct with methods to reconcile EnvoyConfig revisions
 type RevisionReconciler struct {
-	ctx    context.Context
-	logger logr.Logger
-	client client.Client
-	scheme *runtime.Scheme
-	ec     *marin3rv1alpha1.EnvoyConfig
+	ctx      context.Context
+	logger   logr.Logger
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	ec       *marin3rv1alpha1.EnvoyConfig
 
 	// This fields are only available once Reconcile()
 	// has been succesfully run
@@ -42,9 +42,9 @@ type RevisionReconciler struct {
 
 // NewRevisionReconciler returns a new RevisionReconciler
 func NewRevisionReconciler(ctx context.Context, logger logr.Logger, client client.Client,
-	s *runtime.Scheme, ec *marin3rv1alpha1.EnvoyConfig) RevisionReconciler {
+	s *runtime.Scheme, recorder record.EventRecorder, ec *marin3rv1alpha1.EnvoyConfig) RevisionReconciler {
 
-	return RevisionReconciler{ctx, logger, client, s, ec, nil, nil, nil, nil}
+	return RevisionReconciler{ctx, logger, client, s, recorder, ec, nil, nil, nil, nil}
 }
 
 // Instance returns the EnvoyConfig the reconciler has been instantiated with
@@ -136,11 +136,69 @@ func (r *RevisionReconciler) Reconcile() (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 	r.revisionList = revisions.SortByPublication(r.DesiredVersion(), list)
+
+	// Remove the taints that have been requested to be removed or
+	// have expired before deciding which revision to publish
+	untainted, untaintAfter := r.isRevisionTaintReconciled(r.Instance().GetTaintTTL(), time.Now())
+	untaintedNames := map[string]bool{}
+	for _, ecr := range untainted {
+		untaintedNames[ecr.GetName()] = true
+		if err := r.client.Status().Update(r.ctx, ecr); err != nil {
+			log.Error(err, "unable to update revision", "Phase", "UntaintRevisions", "Name/Namespace", util.ObjectKey(ecr))
+			return ctrl.Result{}, err
+		}
+		cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionTaintedCondition)
+		log.Info("untainted EnvoyConfigRevision", "Namespace/Name", util.ObjectKey(ecr), "Reason", cond.Reason)
+		r.recorder.Eventf(r.Instance(), corev1.EventTypeNormal, "RevisionUntainted",
+			"Untainted EnvoyConfigRevision %s with version %s: %s", ecr.GetName(), ecr.Spec.Version, cond.Message)
+	}
+	// Taint the revisions that have been NACKed by as many
+	// clients as the quorum set in the taint policy requires
+	for _, ecr := range r.isRevisionNackQuorumReconciled(r.Instance().GetTaintPolicy()) {
+		if err := r.client.Status().Update(r.ctx, ecr); err != nil {
+			log.Error(err, "unable to update revision", "Phase", "TaintRevisions", "Name/Namespace", util.ObjectKey(ecr))
+			return ctrl.Result{}, err
+		}
+		cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionTaintedCondition)
+		log.Info("tainted EnvoyConfigRevision", "Namespace/Name", util.ObjectKey(ecr), "Reason", cond.Reason)
+		r.recorder.Eventf(r.Instance(), corev1.EventTypeWarning, "RevisionTainted",
+			"Tainted EnvoyConfigRevision %s with version %s: %s", ecr.GetName(), ecr.Spec.Version, cond.Message)
+	}
+
 	publishedVersion, cacheState := r.getVersionToPublish()
+
+	// A pinned revision is published whatever the resources spec says
+	if pin := r.Instance().Spec.RevisionPin; pin != nil {
+		if !r.hasRevision(*pin) {
+			err := fmt.Errorf("pinned revision '%s' not found", *pin)
+			log.Error(err, "unable to publish the pinned revision", "Phase", "PinRevision")
+			return ctrl.Result{}, err
+		}
+		publishedVersion, cacheState = *pin, marin3rv1alpha1.PinnedState
+	}
+
+	// With a canary rollout strategy, the version to publish is first rolled out
+	// to the canaries while the previous one is kept published for the rest
+	var canaryVersion string
+	var requeueAfter time.Duration
+	if canary := r.canaryRollout(); canary != nil && cacheState == marin3rv1alpha1.InSyncState {
+		if _, err := metav1.LabelSelectorAsSelector(canary.Selector); err != nil {
+			log.Error(err, "invalid canary selector", "Phase", "CanaryRollout")
+			return ctrl.Result{}, err
+		}
+		publishedVersion, canaryVersion, requeueAfter = r.getCanaryRollout(publishedVersion, canary.GetBakeTime())
+		if canaryVersion != "" {
+			cacheState = marin3rv1alpha1.CanaryState
+		}
+	}
 	r.cacheState = &cacheState
 	r.publishedVersion = &publishedVersion
 
+	// The canary changes are done in place in the revision list, so they
+	// are sent along with the changes to the RevisionPublished condition
+	canaryChanged := r.isRevisionCanaryConditionReconciled(canaryVersion)
 	shouldBeTrue, shouldBeFalse := r.isRevisionPublishedConditionReconciled(r.PublishedVersion())
+	updated := map[string]bool{}
 
 	if shouldBeFalse != nil {
 		for _, ecr := range shouldBeFalse {
@@ -148,6 +206,7 @@ func (r *RevisionReconciler) Reconcile() (ctrl.Result, error) {
 				log.Error(err, "unable to update revision", "Phase", "UnpublishOldRevisions", "Name/Namespace", util.ObjectKey(&ecr))
 				return ctrl.Result{}, err
 			}
+			updated[ecr.GetName()] = true
 		}
 	}
 
@@ -156,22 +215,84 @@ func (r *RevisionReconciler) Reconcile() (ctrl.Result, error) {
 			log.Error(err, "unable to update revision", "Phase", "PublishNewRevision", "Name/Namespace", util.ObjectKey(shouldBeTrue))
 			return ctrl.Result{}, err
 		}
+		updated[shouldBeTrue.GetName()] = true
 		log.Info("updated the published EnvoyConfigRevision", "Namespace/Name", util.ObjectKey(shouldBeTrue))
 	}
 
-	shouldBeDeleted := r.isRevisionRetentionReconciled(maxRevisions)
-	if shouldBeDeleted != nil {
-		for _, ecr := range shouldBeDeleted {
-			if err := r.client.Delete(r.ctx, &ecr); err != nil {
-				log.Error(err, "unable to delete revision", "Phase", "ApplyRevisionRetention", "Name/Namespace", util.ObjectKey(&ecr))
+	for _, ecr := range canaryChanged {
+		if updated[ecr.GetName()] {
+			continue
+		}
+		if err := r.client.Status().Update(r.ctx, &ecr); err != nil {
+			log.Error(err, "unable to update revision", "Phase", "CanaryRollout", "Name/Namespace", util.ObjectKey(&ecr))
+			return ctrl.Result{}, err
+		}
+		if cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionCanaryCondition); cond != nil &&
+			cond.Reason == marin3rv1alpha1.NoCanaryClientsReason {
+			log.Info("no client has been selected as canary, the EnvoyConfigRevision is not promoted", "Namespace/Name", util.ObjectKey(&ecr))
+		} else if ecr.Status.IsCanary() {
+			log.Info("rolling out EnvoyConfigRevision to the canaries", "Namespace/Name", util.ObjectKey(&ecr))
+		}
+	}
+
+	// The UntaintAnnotation is removed once it has been acted on, either by removing
+	// the taint of the revision or by retrying its aborted canary rollout
+	for idx := range r.revisionList.Items {
+		ecr := &r.revisionList.Items[idx]
+		if _, ok := ecr.GetAnnotations()[marin3rv1alpha1.UntaintAnnotation]; !ok {
+			continue
+		}
+		if !untaintedNames[ecr.GetName()] && !ecr.Status.IsCanary() {
+			log.Info("ignored the untaint annotation as the EnvoyConfigRevision is not tainted", "Namespace/Name", util.ObjectKey(ecr))
+			r.recorder.Eventf(r.Instance(), corev1.EventTypeNormal, "UntaintIgnored",
+				"Ignored the untaint request of EnvoyConfigRevision %s with version %s as it is not tainted", ecr.GetName(), ecr.Spec.Version)
+		}
+		if err := r.removeUntaintAnnotation(ecr); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	retention := r.Instance().Spec.RevisionRetention
+	var expireAfter time.Duration
+	if maxAge := retention.GetMaxAge(); maxAge != 0 {
+		var expired []marin3rv1alpha1.EnvoyConfigRevision
+		expired, expireAfter = r.isRevisionAgeReconciled(maxAge, time.Now())
+		for _, ecr := range expired {
+			if err := r.deleteRevision(&ecr, fmt.Sprintf("it has not been published for more than %s", maxAge)); err != nil {
 				return ctrl.Result{}, err
 			}
-			log.Info("deleted old EnvoyConfigRevision", "Namespace/Name", util.ObjectKey(&ecr))
+		}
+	}
+	for _, ecr := range r.isRevisionRetentionReconciled(retention.GetMaxRevisions()) {
+		if err := r.deleteRevision(&ecr, fmt.Sprintf("at most %d revisions are kept", retention.GetMaxRevisions())); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
 
 	log.Info(fmt.Sprintf("CacheState is %s after revision reconcile", cacheState))
-	return ctrl.Result{}, nil
+	// Reconcile again once the bake time of the canary is over to promote it, once
+	// the next taint expires to untaint the revision or once the next revision gets
+	// older than the max age of the retention policy to delete it
+	for _, after := range []time.Duration{untaintAfter, expireAfter} {
+		if after != 0 && (requeueAfter == 0 || after < requeueAfter) {
+			requeueAfter = after
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// removeUntaintAnnotation removes the UntaintAnnotation from a revision, if present
+func (r *RevisionReconciler) removeUntaintAnnotation(ecr *marin3rv1alpha1.EnvoyConfigRevision) error {
+	if _, ok := ecr.GetAnnotations()[marin3rv1alpha1.UntaintAnnotation]; !ok {
+		return nil
+	}
+	patch := client.MergeFrom(ecr.DeepCopy())
+	delete(ecr.Annotations, marin3rv1alpha1.UntaintAnnotation)
+	if err := r.client.Patch(r.ctx, ecr, patch); err != nil {
+		r.logger.Error(err, "unable to remove the untaint annotation", "Phase", "UntaintRevisions", "Name/Namespace", util.ObjectKey(ecr))
+		return err
+	}
+	return nil
 }
 
 // getVersionToPublish takes an EnvoyConfigRevisionList and returns the version that should be
@@ -203,6 +324,152 @@ func (r *RevisionReconciler) getVersionToPublish() (string, string) {
 
 }
 
+// hasRevision returns true if the revision list holds a revision with the given version
+func (r *RevisionReconciler) hasRevision(version string) bool {
+	for _, ecr := range r.revisionList.Items {
+		if ecr.Spec.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// canaryRollout returns the canary rollout configured
+// in the EnvoyConfig, or nil if there is none
+func (r *RevisionReconciler) canaryRollout() *marin3rv1alpha1.CanaryRollout {
+	if r.Instance().Spec.RolloutStrategy == nil {
+		return nil
+	}
+	return r.Instance().Spec.RolloutStrategy.Canary
+}
+
+// getCanaryRollout decides whether the version to publish needs to be rolled out to the canaries first. It
+// returns the version that should be published, the version that should be rolled out to the canaries, if any,
+// and the time left until the canary can be promoted. A canary is only rolled out when there is another untainted
+// revision currently published, so the first revision of an EnvoyConfig is always published straight away. The
+// bake time starts when the RevisionCanary condition is set, and the canary is promoted once it is over, as long as
+// some client has been selected as canary. If any canary returns a NACK the rollout is aborted, whatever the taint
+// policy, and it is not started again for the same version unless the UntaintAnnotation is set in the revision.
+func (r *RevisionReconciler) getCanaryRollout(versionToPublish string, bakeTime time.Duration) (string, string, time.Duration) {
+	var current, candidate *marin3rv1alpha1.EnvoyConfigRevision
+
+	for idx := range r.revisionList.Items {
+		ecr := &r.revisionList.Items[idx]
+		if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) {
+			current = ecr
+		}
+		if ecr.Spec.Version == versionToPublish {
+			candidate = ecr
+		}
+	}
+
+	if current == nil || candidate == nil || current.Spec.Version == versionToPublish ||
+		current.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) {
+		return versionToPublish, "", 0
+	}
+
+	cond := candidate.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionCanaryCondition)
+	if cond == nil || !cond.IsTrue() {
+		if r.isCanaryRolloutAborted(candidate) {
+			return current.Spec.Version, "", 0
+		}
+		return current.Spec.Version, versionToPublish, bakeTime
+	}
+
+	// A revision rejected by any of the canaries is never promoted
+	if candidate.Status.Clients != nil && candidate.Status.Clients.Nacked > 0 {
+		return current.Spec.Version, "", 0
+	}
+
+	elapsed := time.Since(cond.LastTransitionTime.Time)
+	if elapsed < bakeTime {
+		return current.Spec.Version, versionToPublish, bakeTime - elapsed
+	}
+	// A revision no client has been served is not promoted, as
+	// the bake time says nothing about it. Check again later.
+	if !hasCanaryClients(candidate) {
+		return current.Spec.Version, versionToPublish, bakeTime
+	}
+	return versionToPublish, "", 0
+}
+
+// hasCanaryClients returns true if any client is connected to the discovery service
+// with the canary revision
+func hasCanaryClients(ecr *marin3rv1alpha1.EnvoyConfigRevision) bool {
+	return ecr.Status.Clients != nil && ecr.Status.Clients.Connected > 0
+}
+
+// isCanaryRolloutAborted returns true if the last rollout, as reported in the status of the EnvoyConfig,
+// was a rollout of the given revision that got aborted, and the revision has not been untainted since.
+// Depending on the taint policy an aborted revision might not be tainted, so the rollout is also retried
+// when the UntaintAnnotation is set in a revision that is not tainted.
+func (r *RevisionReconciler) isCanaryRolloutAborted(ecr *marin3rv1alpha1.EnvoyConfigRevision) bool {
+	rollout := r.Instance().Status.Rollout
+	if rollout == nil || rollout.Phase != marin3rv1alpha1.RolloutAbortedPhase || rollout.CanaryVersion != ecr.Spec.Version {
+		return false
+	}
+	cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionTaintedCondition)
+	if cond != nil && cond.IsTrue() {
+		return true
+	}
+	if _, retry := ecr.GetAnnotations()[marin3rv1alpha1.UntaintAnnotation]; retry {
+		return false
+	}
+	return cond == nil || !cond.LastTransitionTime.After(rollout.StartedAt.Time)
+}
+
+// isRevisionCanaryConditionReconciled sets the RevisionCanary condition and the canary selection in
+// the status of the revision with the given canary version, and removes them from the other revisions.
+// The changes are done in place in the revision list. Returns the revisions that have changed.
+func (r *RevisionReconciler) isRevisionCanaryConditionReconciled(canaryVersion string) []marin3rv1alpha1.EnvoyConfigRevision {
+	changed := []marin3rv1alpha1.EnvoyConfigRevision{}
+
+	for idx := range r.revisionList.Items {
+		ecr := &r.revisionList.Items[idx]
+
+		if canaryVersion != "" && ecr.Spec.Version == canaryVersion {
+			ok := true
+			cond := status.Condition{
+				Type:    marin3rv1alpha1.RevisionCanaryCondition,
+				Status:  corev1.ConditionTrue,
+				Reason:  marin3rv1alpha1.CanaryRolloutReason,
+				Message: fmt.Sprintf("Version '%s' is being rolled out to the canaries", canaryVersion),
+			}
+			current := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionCanaryCondition)
+			// The bake time of a revision already marked as canary is over, but no client
+			// has been selected as canary, so it is not going to be promoted
+			if current != nil && current.IsTrue() &&
+				time.Since(current.LastTransitionTime.Time) >= r.canaryRollout().GetBakeTime() && !hasCanaryClients(ecr) {
+				cond.Reason = marin3rv1alpha1.NoCanaryClientsReason
+				cond.Message = fmt.Sprintf("Version '%s' is not promoted because no client has been selected as canary", canaryVersion)
+			}
+			// The clients status kept from a previous rollout or publication of
+			// the revision does not apply to the canaries of this rollout
+			if (current == nil || !current.IsTrue()) && ecr.Status.Clients != nil {
+				ecr.Status.Clients = nil
+				ok = false
+			}
+			if ecr.Status.Conditions.SetCondition(cond) {
+				ok = false
+			}
+			if canary := r.canaryRollout(); !equality.Semantic.DeepEqual(ecr.Status.Canary, canary) {
+				ecr.Status.Canary = canary.DeepCopy()
+				ok = false
+			}
+			if !ok {
+				changed = append(changed, *ecr)
+			}
+
+		} else if ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionCanaryCondition) != nil || ecr.Status.Canary != nil {
+			ecr.Status.Conditions.RemoveCondition(marin3rv1alpha1.RevisionCanaryCondition)
+			ecr.Status.Canary = nil
+			changed = append(changed, *ecr)
+		}
+	}
+
+	return changed
+}
+
 // isRevisionPublishedConditionReconciled returns the revisions that need the RevisionPublished condition reconciled.
 // As the first return value returns the EnvoyConfigRevision that needs the condition set to true, nil if update
 // not required. As the second return value returns a list of the EnvoyConfigRevisions that need the condition
@@ -226,6 +493,9 @@ func (r *RevisionReconciler) isRevisionPublishedConditionReconciled(versionToPub
 				Reason:  status.ConditionReason("VersionPublished"),
 				Message: fmt.Sprintf("Version '%s' has been published", versionToPublish),
 			})
+			// The clients status kept from a previous publication of the revision, or
+			// reported for its canaries, does not apply to the clients it is published to
+			ecr.Status.Clients = nil
 			shouldBeTrue = &ecr
 		}
 	}
@@ -236,27 +506,108 @@ func (r *RevisionReconciler) isRevisionPublishedConditionReconciled(versionToPub
 	return shouldBeTrue, shouldBeFalse
 }
 
-// isRevisionRetentionReconciled removes items from the revisionList until the list holds the number of items
-// determined by the 'retention' parameter
+// deleteRevision deletes a revision and records an event in the EnvoyConfig with the reason
+func (r *RevisionReconciler) deleteRevision(ecr *marin3rv1alpha1.EnvoyConfigRevision, reason string) error {
+	if err := r.client.Delete(r.ctx, ecr); err != nil {
+		r.logger.Error(err, "unable to delete revision", "Phase", "ApplyRevisionRetention", "Name/Namespace", util.ObjectKey(ecr))
+		return err
+	}
+	r.logger.Info("deleted old EnvoyConfigRevision", "Namespace/Name", util.ObjectKey(ecr), "Reason", reason)
+	r.recorder.Eventf(r.Instance(), corev1.EventTypeNormal, "RevisionDeleted",
+		"Deleted EnvoyConfigRevision %s with version %s because %s", ecr.GetName(), ecr.Spec.Version, reason)
+	return nil
+}
+
+// isRevisionRetentionReconciled removes items from the revisionList, starting from the oldest ones, until the list
+// holds the number of items determined by the 'retention' parameter. Protected revisions are never removed, so the
+// list can end up holding more items. The removed items are returned and the list is modified "in place".
 func (r *RevisionReconciler) isRevisionRetentionReconciled(retention int) []marin3rv1alpha1.EnvoyConfigRevision {
 
 	var toBeDeleted []marin3rv1alpha1.EnvoyConfigRevision = []marin3rv1alpha1.EnvoyConfigRevision{}
-	var revisionList *[]marin3rv1alpha1.EnvoyConfigRevision = &(r.GetRevisionList().Items)
-
-	for len(*revisionList) > retention {
-		toBeDeleted = append(toBeDeleted, popRevision(revisionList))
+	var kept []marin3rv1alpha1.EnvoyConfigRevision = []marin3rv1alpha1.EnvoyConfigRevision{}
+	protected := r.protectedRevisions()
+	excess := len(r.GetRevisionList().Items) - retention
+
+	for _, ecr := range r.GetRevisionList().Items {
+		if excess > 0 && !protected[ecr.GetName()] {
+			toBeDeleted = append(toBeDeleted, ecr)
+			excess--
+			continue
+		}
+		kept = append(kept, ecr)
 	}
+	r.GetRevisionList().Items = kept
 
 	return toBeDeleted
 }
 
-// popRevision removes an EnvoyConfigRevision from a list of EnvoyConfigRevision resources, starting from
-// the lowest index in the slice. The removed element is returned as a return value and the list is
-// modified "in place".
-func popRevision(list *[]marin3rv1alpha1.EnvoyConfigRevision) marin3rv1alpha1.EnvoyConfigRevision {
-	item := (*list)[0]
-	*list = (*list)[1:]
-	return item
+// isRevisionAgeReconciled removes from the revisionList the items that have not been published for longer
+// than 'maxAge', or that were created before that if they have never been published. Protected revisions are
+// never removed. The removed items are returned and the list is modified "in place", along with the time left
+// until the next of the kept unprotected revisions gets older than 'maxAge', zero if there is none.
+func (r *RevisionReconciler) isRevisionAgeReconciled(maxAge time.Duration, now time.Time) ([]marin3rv1alpha1.EnvoyConfigRevision, time.Duration) {
+
+	var toBeDeleted []marin3rv1alpha1.EnvoyConfigRevision = []marin3rv1alpha1.EnvoyConfigRevision{}
+	var kept []marin3rv1alpha1.EnvoyConfigRevision = []marin3rv1alpha1.EnvoyConfigRevision{}
+	var nextExpiry time.Duration
+	protected := r.protectedRevisions()
+
+	for _, ecr := range r.GetRevisionList().Items {
+		since := ecr.GetCreationTimestamp().Time
+		if ecr.Status.LastPublishedAt != nil {
+			since = ecr.Status.LastPublishedAt.Time
+		}
+		if protected[ecr.GetName()] {
+			kept = append(kept, ecr)
+			continue
+		}
+		left := since.Add(maxAge).Sub(now)
+		if left <= 0 {
+			toBeDeleted = append(toBeDeleted, ecr)
+			continue
+		}
+		if nextExpiry == 0 || left < nextExpiry {
+			nextExpiry = left
+		}
+		kept = append(kept, ecr)
+	}
+	r.GetRevisionList().Items = kept
+
+	return toBeDeleted, nextExpiry
+}
+
+// protectedRevisions returns the names of the revisions that the retention policy never deletes: the
+// revision for the current resources, which holds the highest index in the list, the published, canary
+// and pinned revisions and the most recent untainted revisions, as many as the policy says to keep.
+func (r *RevisionReconciler) protectedRevisions() map[string]bool {
+	protected := map[string]bool{}
+	items := r.GetRevisionList().Items
+
+	var pin *string
+	keepUntainted := int(marin3rv1alpha1.DefaultKeepUntaintedRevisions)
+	if r.Instance() != nil {
+		pin = r.Instance().Spec.RevisionPin
+		keepUntainted = r.Instance().Spec.RevisionRetention.GetKeepUntainted()
+	}
+
+	for idx := len(items) - 1; idx >= 0; idx-- {
+		ecr := items[idx]
+		switch {
+		case idx == len(items)-1:
+		case r.publishedVersion != nil && ecr.Spec.Version == *r.publishedVersion:
+		case pin != nil && ecr.Spec.Version == *pin:
+		case ecr.Status.IsCanary():
+		case keepUntainted > 0 && !ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition):
+		default:
+			continue
+		}
+		protected[ecr.GetName()] = true
+		if !ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) {
+			keepUntainted--
+		}
+	}
+
+	return protected
 }
 
 // newRevisionForCurrentResources generates an EnvoyConfigRevision resource for the current
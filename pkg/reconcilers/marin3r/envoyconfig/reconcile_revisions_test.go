@@ -2,14 +2,15 @@ package reconcilers
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
 	envoy "github.com/3scale/marin3r/pkg/envoy"
 	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
 	"github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/filters"
-	"github.com/3scale/marin3r/pkg/util"
 	"github.com/go-logr/logr"
 	"github.com/operator-framework/operator-lib/status"
 	corev1 "k8s.io/api/core/v1"
@@ -17,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -34,16 +36,17 @@ func init() {
 }
 
 func testRevisionReconcilerBuilder(s *runtime.Scheme, instance *marin3rv1alpha1.EnvoyConfig, objs ...runtime.Object) RevisionReconciler {
-	return RevisionReconciler{context.TODO(), ctrl.Log.WithName("test"), fake.NewFakeClientWithScheme(s, objs...), s, instance, nil, nil, nil, nil}
+	return RevisionReconciler{context.TODO(), ctrl.Log.WithName("test"), fake.NewFakeClientWithScheme(s, objs...), s, record.NewFakeRecorder(10), instance, nil, nil, nil, nil}
 }
 
 func TestNewRevisionReconciler(t *testing.T) {
 	type args struct {
-		ctx    context.Context
-		logger logr.Logger
-		client client.Client
-		s      *runtime.Scheme
-		ec     *marin3rv1alpha1.EnvoyConfig
+		ctx      context.Context
+		logger   logr.Logger
+		client   client.Client
+		s        *runtime.Scheme
+		recorder record.EventRecorder
+		ec       *marin3rv1alpha1.EnvoyConfig
 	}
 	tests := []struct {
 		name string
@@ -52,13 +55,13 @@ func TestNewRevisionReconciler(t *testing.T) {
 	}{
 		{
 			name: "Returns a RevisionReconciler",
-			args: args{context.TODO(), nil, fake.NewFakeClient(), s, nil},
-			want: RevisionReconciler{context.TODO(), nil, fake.NewFakeClient(), s, nil, nil, nil, nil, nil},
+			args: args{context.TODO(), nil, fake.NewFakeClient(), s, nil, nil},
+			want: RevisionReconciler{context.TODO(), nil, fake.NewFakeClient(), s, nil, nil, nil, nil, nil, nil},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewRevisionReconciler(tt.args.ctx, tt.args.logger, tt.args.client, tt.args.s, tt.args.ec); !reflect.DeepEqual(got, tt.want) {
+			if got := NewRevisionReconciler(tt.args.ctx, tt.args.logger, tt.args.client, tt.args.s, tt.args.recorder, tt.args.ec); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewRevisionReconciler() = %v, want %v", got, tt.want)
 			}
 		})
@@ -360,10 +363,12 @@ func TestRevisionReconciler_Reconcile(t *testing.T) {
 		ec     *marin3rv1alpha1.EnvoyConfig
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		want    ctrl.Result
-		wantErr bool
+		name           string
+		fields         fields
+		want           ctrl.Result
+		wantErr        bool
+		wantPublished  string
+		wantCacheState string
 	}{
 		{
 			name: "Creates a new EnvoyConfigRevision, no error and requeue",
@@ -397,7 +402,7 @@ func TestRevisionReconciler_Reconcile(t *testing.T) {
 							Labels: map[string]string{
 								filters.NodeIDTag:   "node",
 								filters.EnvoyAPITag: envoy.APIv3.String(),
-								filters.VersionTag:  util.Hash(&marin3rv1alpha1.EnvoyResources{}),
+								filters.VersionTag:  "c4547474b",
 							},
 						},
 						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{},
@@ -409,7 +414,7 @@ func TestRevisionReconciler_Reconcile(t *testing.T) {
 							Labels: map[string]string{
 								filters.NodeIDTag:   "node",
 								filters.EnvoyAPITag: envoy.APIv3.String(),
-								filters.VersionTag:  util.Hash(&marin3rv1alpha1.EnvoyResources{}),
+								filters.VersionTag:  "c4547474b",
 							},
 						},
 						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{},
@@ -442,7 +447,7 @@ func TestRevisionReconciler_Reconcile(t *testing.T) {
 							Labels: map[string]string{
 								filters.NodeIDTag:   "node",
 								filters.EnvoyAPITag: envoy.APIv3.String(),
-								filters.VersionTag:  util.Hash(&marin3rv1alpha1.EnvoyResources{}),
+								filters.VersionTag:  "c4547474b",
 							},
 						},
 						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{},
@@ -462,6 +467,142 @@ func TestRevisionReconciler_Reconcile(t *testing.T) {
 			want:    ctrl.Result{},
 			wantErr: false,
 		},
+		{
+			name: "Rolls out the EnvoyConfigRevision for current version to the canaries and requeues after the bake time",
+			fields: fields{
+				ctx:    context.TODO(),
+				logger: ctrl.Log.WithName("test"),
+				client: fake.NewFakeClientWithScheme(s,
+					&marin3rv1alpha1.EnvoyConfigRevision{
+						TypeMeta: metav1.TypeMeta{Kind: "EnvoyConfigRevision", APIVersion: "v1alpha1"},
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "ecr0", Namespace: "test",
+							Labels: map[string]string{
+								filters.NodeIDTag:   "node",
+								filters.EnvoyAPITag: envoy.APIv3.String(),
+								filters.VersionTag:  "aaaa",
+							},
+						},
+						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "aaaa"},
+						Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+							Conditions: status.Conditions{{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue}},
+						},
+					},
+					&marin3rv1alpha1.EnvoyConfigRevision{
+						TypeMeta: metav1.TypeMeta{Kind: "EnvoyConfigRevision", APIVersion: "v1alpha1"},
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "ecr1", Namespace: "test",
+							Labels: map[string]string{
+								filters.NodeIDTag:   "node",
+								filters.EnvoyAPITag: envoy.APIv3.String(),
+								filters.VersionTag:  "c4547474b",
+							},
+						},
+						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "c4547474b"},
+					},
+				),
+				scheme: s,
+				ec: &marin3rv1alpha1.EnvoyConfig{
+					TypeMeta:   metav1.TypeMeta{Kind: "EnvoyConfig", APIVersion: "v1alpha1"},
+					ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "test"},
+					Spec: marin3rv1alpha1.EnvoyConfigSpec{
+						NodeID:         "node",
+						EnvoyAPI:       pointer.StringPtr(envoy.APIv3.String()),
+						EnvoyResources: &marin3rv1alpha1.EnvoyResources{},
+						RolloutStrategy: &marin3rv1alpha1.RolloutStrategy{
+							Canary: &marin3rv1alpha1.CanaryRollout{BakeTime: &metav1.Duration{Duration: time.Minute}},
+						},
+					},
+				},
+			},
+			want:    ctrl.Result{RequeueAfter: time.Minute},
+			wantErr: false,
+		},
+		{
+			name: "Publishes the pinned EnvoyConfigRevision",
+			fields: fields{
+				ctx:    context.TODO(),
+				logger: ctrl.Log.WithName("test"),
+				client: fake.NewFakeClientWithScheme(s,
+					&marin3rv1alpha1.EnvoyConfigRevision{
+						TypeMeta: metav1.TypeMeta{Kind: "EnvoyConfigRevision", APIVersion: "v1alpha1"},
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "ecr0", Namespace: "test",
+							Labels: map[string]string{
+								filters.NodeIDTag:   "node",
+								filters.EnvoyAPITag: envoy.APIv3.String(),
+								filters.VersionTag:  "aaaa",
+							},
+						},
+						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "aaaa"},
+						Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+							Conditions: status.Conditions{{Type: marin3rv1alpha1.RevisionTaintedCondition, Status: corev1.ConditionTrue}},
+						},
+					},
+					&marin3rv1alpha1.EnvoyConfigRevision{
+						TypeMeta: metav1.TypeMeta{Kind: "EnvoyConfigRevision", APIVersion: "v1alpha1"},
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "ecr1", Namespace: "test",
+							Labels: map[string]string{
+								filters.NodeIDTag:   "node",
+								filters.EnvoyAPITag: envoy.APIv3.String(),
+								filters.VersionTag:  "c4547474b",
+							},
+						},
+						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "c4547474b"},
+					},
+				),
+				scheme: s,
+				ec: &marin3rv1alpha1.EnvoyConfig{
+					TypeMeta:   metav1.TypeMeta{Kind: "EnvoyConfig", APIVersion: "v1alpha1"},
+					ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "test"},
+					Spec: marin3rv1alpha1.EnvoyConfigSpec{
+						NodeID:         "node",
+						EnvoyAPI:       pointer.StringPtr(envoy.APIv3.String()),
+						EnvoyResources: &marin3rv1alpha1.EnvoyResources{},
+						RevisionPin:    pointer.StringPtr("aaaa"),
+					},
+				},
+			},
+			want:           ctrl.Result{},
+			wantErr:        false,
+			wantPublished:  "aaaa",
+			wantCacheState: marin3rv1alpha1.PinnedState,
+		},
+		{
+			name: "Fails if the pinned EnvoyConfigRevision does not exist",
+			fields: fields{
+				ctx:    context.TODO(),
+				logger: ctrl.Log.WithName("test"),
+				client: fake.NewFakeClientWithScheme(s,
+					&marin3rv1alpha1.EnvoyConfigRevision{
+						TypeMeta: metav1.TypeMeta{Kind: "EnvoyConfigRevision", APIVersion: "v1alpha1"},
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "ecr1", Namespace: "test",
+							Labels: map[string]string{
+								filters.NodeIDTag:   "node",
+								filters.EnvoyAPITag: envoy.APIv3.String(),
+								filters.VersionTag:  "c4547474b",
+							},
+						},
+						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "c4547474b"},
+					},
+				),
+				scheme: s,
+				ec: &marin3rv1alpha1.EnvoyConfig{
+					TypeMeta:   metav1.TypeMeta{Kind: "EnvoyConfig", APIVersion: "v1alpha1"},
+					ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "test"},
+					Spec: marin3rv1alpha1.EnvoyConfigSpec{
+						NodeID:         "node",
+						EnvoyAPI:       pointer.StringPtr(envoy.APIv3.String()),
+						EnvoyResources: &marin3rv1alpha1.EnvoyResources{},
+						RevisionPin:    pointer.StringPtr("aaaa"),
+					},
+				},
+			},
+			want:    ctrl.Result{},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -480,10 +621,56 @@ func TestRevisionReconciler_Reconcile(t *testing.T) {
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("RevisionReconciler.Reconcile() = %v, want %v", got, tt.want)
 			}
+			if tt.wantCacheState != "" && (r.PublishedVersion() != tt.wantPublished || r.GetCacheState() != tt.wantCacheState) {
+				t.Errorf("RevisionReconciler.Reconcile() published = %v/%v, want %v/%v",
+					r.PublishedVersion(), r.GetCacheState(), tt.wantPublished, tt.wantCacheState)
+			}
 		})
 	}
 }
 
+func TestRevisionReconciler_Reconcile_maxAge(t *testing.T) {
+	ec := &marin3rv1alpha1.EnvoyConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "test"},
+		Spec: marin3rv1alpha1.EnvoyConfigSpec{
+			NodeID:         "node",
+			EnvoyAPI:       pointer.StringPtr(envoy.APIv3.String()),
+			EnvoyResources: &marin3rv1alpha1.EnvoyResources{},
+			RevisionRetention: &marin3rv1alpha1.RevisionRetention{
+				MaxAge: &metav1.Duration{Duration: 24 * time.Hour}, KeepUntainted: pointer.Int32Ptr(0),
+			},
+		},
+	}
+	revision := func(name, version string) *marin3rv1alpha1.EnvoyConfigRevision {
+		return &marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "test",
+				Labels: map[string]string{
+					filters.NodeIDTag:   "node",
+					filters.EnvoyAPITag: envoy.APIv3.String(),
+					filters.VersionTag:  version,
+				},
+			},
+			Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: version},
+		}
+	}
+	published := revision("published", "c4547474b")
+	published.Status.Conditions = status.Conditions{{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue}}
+	published.Status.LastPublishedAt = &metav1.Time{Time: time.Now().Add(-time.Hour)}
+	// Last published 2 hours ago, so it expires in 22 hours
+	previous := revision("previous", "aaaa")
+	previous.Status.LastPublishedAt = &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+	r := testRevisionReconcilerBuilder(s, ec, published, previous)
+
+	got, err := r.Reconcile()
+	if err != nil {
+		t.Fatalf("RevisionReconciler.Reconcile() error = %v", err)
+	}
+	if got.RequeueAfter <= 21*time.Hour || got.RequeueAfter > 22*time.Hour {
+		t.Errorf("RevisionReconciler.Reconcile() RequeueAfter = %v, want the time until 'previous' expires", got.RequeueAfter)
+	}
+}
+
 func TestRevisionReconciler_getVersionToPublish(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -555,6 +742,274 @@ func TestRevisionReconciler_getVersionToPublish(t *testing.T) {
 	}
 }
 
+func TestRevisionReconciler_getCanaryRollout(t *testing.T) {
+	revision := func(version string, conditions ...status.Condition) marin3rv1alpha1.EnvoyConfigRevision {
+		return marin3rv1alpha1.EnvoyConfigRevision{
+			Spec:   marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: version},
+			Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{Conditions: conditions},
+		}
+	}
+	condition := func(cType status.ConditionType, ago time.Duration) status.Condition {
+		return status.Condition{Type: cType, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(time.Now().Add(-ago))}
+	}
+	nacked := func(ecr marin3rv1alpha1.EnvoyConfigRevision) marin3rv1alpha1.EnvoyConfigRevision {
+		ecr.Status.Clients = &marin3rv1alpha1.ClientsStatus{Connected: 10, InSync: 9, Nacked: 1}
+		return ecr
+	}
+	served := func(ecr marin3rv1alpha1.EnvoyConfigRevision) marin3rv1alpha1.EnvoyConfigRevision {
+		ecr.Status.Clients = &marin3rv1alpha1.ClientsStatus{Connected: 1, InSync: 1}
+		return ecr
+	}
+	annotated := func(ecr marin3rv1alpha1.EnvoyConfigRevision) marin3rv1alpha1.EnvoyConfigRevision {
+		ecr.SetAnnotations(map[string]string{marin3rv1alpha1.UntaintAnnotation: "true"})
+		return ecr
+	}
+	bakeTime := 10 * time.Minute
+
+	tests := []struct {
+		name             string
+		items            []marin3rv1alpha1.EnvoyConfigRevision
+		rollout          *marin3rv1alpha1.RolloutStatus
+		versionToPublish string
+		wantPublished    string
+		wantCanary       string
+		wantRequeue      bool
+	}{
+		{
+			name:             "Publishes the first revision straight away",
+			items:            []marin3rv1alpha1.EnvoyConfigRevision{revision("xxxx")},
+			versionToPublish: "xxxx",
+			wantPublished:    "xxxx",
+		},
+		{
+			name:             "Keeps the published revision if there is nothing to roll out",
+			items:            []marin3rv1alpha1.EnvoyConfigRevision{revision("xxxx", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour))},
+			versionToPublish: "xxxx",
+			wantPublished:    "xxxx",
+		},
+		{
+			name: "Starts rolling out a new revision to the canaries",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				revision("xxxx"),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "aaaa",
+			wantCanary:       "xxxx",
+			wantRequeue:      true,
+		},
+		{
+			name: "Keeps the canary during the bake time",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				revision("xxxx", condition(marin3rv1alpha1.RevisionCanaryCondition, time.Minute)),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "aaaa",
+			wantCanary:       "xxxx",
+			wantRequeue:      true,
+		},
+		{
+			name: "Promotes the canary after the bake time",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				served(revision("xxxx", condition(marin3rv1alpha1.RevisionCanaryCondition, 11*time.Minute))),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "xxxx",
+		},
+		{
+			name: "Does not promote the canary if no client has been selected",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				revision("xxxx", condition(marin3rv1alpha1.RevisionCanaryCondition, 11*time.Minute)),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "aaaa",
+			wantCanary:       "xxxx",
+			wantRequeue:      true,
+		},
+		{
+			name: "Publishes straight away if the published revision is tainted",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour), condition(marin3rv1alpha1.RevisionTaintedCondition, time.Minute)),
+				revision("xxxx"),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "xxxx",
+		},
+		{
+			name: "Aborts the rollout if a canary returns a NACK",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				nacked(revision("xxxx", condition(marin3rv1alpha1.RevisionCanaryCondition, 11*time.Minute))),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "aaaa",
+		},
+		{
+			name: "Does not start an aborted rollout again",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				revision("xxxx"),
+			},
+			rollout: &marin3rv1alpha1.RolloutStatus{
+				Phase: marin3rv1alpha1.RolloutAbortedPhase, CanaryVersion: "xxxx", StartedAt: metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "aaaa",
+		},
+		{
+			name: "Starts an aborted rollout again once the revision is untainted",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				revision("xxxx", status.Condition{Type: marin3rv1alpha1.RevisionTaintedCondition, Status: corev1.ConditionFalse,
+					LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Minute))}),
+			},
+			rollout: &marin3rv1alpha1.RolloutStatus{
+				Phase: marin3rv1alpha1.RolloutAbortedPhase, CanaryVersion: "xxxx", StartedAt: metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "aaaa",
+			wantCanary:       "xxxx",
+			wantRequeue:      true,
+		},
+		{
+			name: "Starts an aborted rollout again if an untainted revision has the untaint annotation",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				annotated(nacked(revision("xxxx"))),
+			},
+			rollout: &marin3rv1alpha1.RolloutStatus{
+				Phase: marin3rv1alpha1.RolloutAbortedPhase, CanaryVersion: "xxxx", StartedAt: metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "aaaa",
+			wantCanary:       "xxxx",
+			wantRequeue:      true,
+		},
+		{
+			name: "Does not start an aborted rollout again while the revision is tainted",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				revision("aaaa", condition(marin3rv1alpha1.RevisionPublishedCondition, time.Hour)),
+				annotated(revision("xxxx", condition(marin3rv1alpha1.RevisionTaintedCondition, time.Minute))),
+			},
+			rollout: &marin3rv1alpha1.RolloutStatus{
+				Phase: marin3rv1alpha1.RolloutAbortedPhase, CanaryVersion: "xxxx", StartedAt: metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+			versionToPublish: "xxxx",
+			wantPublished:    "aaaa",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRevisionReconcilerBuilder(s, &marin3rv1alpha1.EnvoyConfig{
+				Status: marin3rv1alpha1.EnvoyConfigStatus{Rollout: tt.rollout},
+			})
+			r.revisionList = &marin3rv1alpha1.EnvoyConfigRevisionList{Items: tt.items}
+			gotPublished, gotCanary, gotRequeue := r.getCanaryRollout(tt.versionToPublish, bakeTime)
+			if gotPublished != tt.wantPublished {
+				t.Errorf("RevisionReconciler.getCanaryRollout() got = %v, want %v", gotPublished, tt.wantPublished)
+			}
+			if gotCanary != tt.wantCanary {
+				t.Errorf("RevisionReconciler.getCanaryRollout() got1 = %v, want %v", gotCanary, tt.wantCanary)
+			}
+			if (gotRequeue > 0) != tt.wantRequeue || gotRequeue > bakeTime {
+				t.Errorf("RevisionReconciler.getCanaryRollout() got2 = %v, want requeue %v", gotRequeue, tt.wantRequeue)
+			}
+		})
+	}
+}
+
+func TestRevisionReconciler_isRevisionCanaryConditionReconciled(t *testing.T) {
+	canary := &marin3rv1alpha1.CanaryRollout{Percentage: pointer.Int32Ptr(20)}
+	ec := &marin3rv1alpha1.EnvoyConfig{Spec: marin3rv1alpha1.EnvoyConfigSpec{
+		RolloutStrategy: &marin3rv1alpha1.RolloutStrategy{Canary: canary},
+	}}
+	canaryStatus := func(version string) marin3rv1alpha1.EnvoyConfigRevision {
+		return marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: version},
+			Spec:       marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: version},
+			Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+				Conditions: status.Conditions{{
+					Type:               marin3rv1alpha1.RevisionCanaryCondition,
+					Status:             corev1.ConditionTrue,
+					Reason:             marin3rv1alpha1.CanaryRolloutReason,
+					Message:            fmt.Sprintf("Version '%s' is being rolled out to the canaries", version),
+					LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Minute)),
+				}},
+				Canary: canary,
+			},
+		}
+	}
+	baked := func(ecr marin3rv1alpha1.EnvoyConfigRevision) marin3rv1alpha1.EnvoyConfigRevision {
+		ecr.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Hour))
+		return ecr
+	}
+
+	tests := []struct {
+		name          string
+		items         []marin3rv1alpha1.EnvoyConfigRevision
+		canaryVersion string
+		wantChanged   []string
+		wantCanary    string
+		wantReason    status.ConditionReason
+	}{
+		{
+			name: "Marks the revision as canary",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				{ObjectMeta: metav1.ObjectMeta{Name: "aaaa"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "aaaa"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "xxxx"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "xxxx"}},
+			},
+			canaryVersion: "xxxx",
+			wantChanged:   []string{"xxxx"},
+			wantCanary:    "xxxx",
+		},
+		{
+			name:          "Does nothing if the canary is already marked",
+			items:         []marin3rv1alpha1.EnvoyConfigRevision{canaryStatus("xxxx")},
+			canaryVersion: "xxxx",
+			wantChanged:   []string{},
+			wantCanary:    "xxxx",
+		},
+		{
+			name:          "Reports that no client has been selected once the bake time is over",
+			items:         []marin3rv1alpha1.EnvoyConfigRevision{baked(canaryStatus("xxxx"))},
+			canaryVersion: "xxxx",
+			wantChanged:   []string{"xxxx"},
+			wantCanary:    "xxxx",
+			wantReason:    marin3rv1alpha1.NoCanaryClientsReason,
+		},
+		{
+			name:          "Removes the canary mark from other revisions",
+			items:         []marin3rv1alpha1.EnvoyConfigRevision{canaryStatus("aaaa"), canaryStatus("xxxx")},
+			canaryVersion: "",
+			wantChanged:   []string{"aaaa", "xxxx"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRevisionReconcilerBuilder(s, ec)
+			r.revisionList = &marin3rv1alpha1.EnvoyConfigRevisionList{Items: tt.items}
+			changed := []string{}
+			for _, ecr := range r.isRevisionCanaryConditionReconciled(tt.canaryVersion) {
+				changed = append(changed, ecr.GetName())
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("RevisionReconciler.isRevisionCanaryConditionReconciled() = %v, want %v", changed, tt.wantChanged)
+			}
+			for _, ecr := range r.revisionList.Items {
+				if ecr.Status.IsCanary() != (ecr.Spec.Version == tt.wantCanary) {
+					t.Errorf("RevisionReconciler.isRevisionCanaryConditionReconciled() revision %s canary = %v", ecr.Spec.Version, ecr.Status.IsCanary())
+				}
+				if cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionCanaryCondition); tt.wantReason != "" && cond.Reason != tt.wantReason {
+					t.Errorf("RevisionReconciler.isRevisionCanaryConditionReconciled() revision %s reason = %v, want %v", ecr.Spec.Version, cond.Reason, tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
 func TestRevisionReconciler_isRevisionPublishedConditionReconciled(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -850,6 +1305,32 @@ func TestRevisionReconciler_isRevisionRetentionReconciled(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Keeps the pinned revision",
+			fields: fields{nil, nil, nil, nil,
+				&marin3rv1alpha1.EnvoyConfig{Spec: marin3rv1alpha1.EnvoyConfigSpec{RevisionPin: pointer.StringPtr("2")}},
+				nil, nil, nil,
+				&marin3rv1alpha1.EnvoyConfigRevisionList{
+					Items: []marin3rv1alpha1.EnvoyConfigRevision{
+						{ObjectMeta: metav1.ObjectMeta{Name: "ecr1"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "1"}},
+						{ObjectMeta: metav1.ObjectMeta{Name: "ecr2"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "2"}},
+						{ObjectMeta: metav1.ObjectMeta{Name: "ecr3"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "3"}},
+						{ObjectMeta: metav1.ObjectMeta{Name: "ecr4"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "4"}},
+					},
+				},
+			},
+			args: args{retention: 2},
+			wantTrimmed: []marin3rv1alpha1.EnvoyConfigRevision{
+				{ObjectMeta: metav1.ObjectMeta{Name: "ecr1"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "1"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "ecr3"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "3"}},
+			},
+			wantList: &marin3rv1alpha1.EnvoyConfigRevisionList{
+				Items: []marin3rv1alpha1.EnvoyConfigRevision{
+					{ObjectMeta: metav1.ObjectMeta{Name: "ecr2"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "2"}},
+					{ObjectMeta: metav1.ObjectMeta{Name: "ecr4"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "4"}},
+				},
+			},
+		},
 		{
 			name: "List is not modified if elements within 'retention' parameter",
 			fields: fields{nil, nil, nil, nil, nil, nil, nil, nil,
@@ -891,38 +1372,146 @@ func TestRevisionReconciler_isRevisionRetentionReconciled(t *testing.T) {
 	}
 }
 
-func Test_popRevision(t *testing.T) {
-	type args struct {
-		list *[]marin3rv1alpha1.EnvoyConfigRevision
+func TestRevisionReconciler_isRevisionAgeReconciled(t *testing.T) {
+	now := time.Now()
+	ecr := func(name string, created time.Duration, published *time.Duration, tainted bool) marin3rv1alpha1.EnvoyConfigRevision {
+		ecr := marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-created))},
+			Spec:       marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: name},
+		}
+		if published != nil {
+			ecr.Status.LastPublishedAt = &metav1.Time{Time: now.Add(-*published)}
+		}
+		if tainted {
+			ecr.Status.Conditions = status.Conditions{{Type: marin3rv1alpha1.RevisionTaintedCondition, Status: corev1.ConditionTrue}}
+		}
+		return ecr
+	}
+	hours := func(h int) *time.Duration { d := time.Duration(h) * time.Hour; return &d }
+
+	r := &RevisionReconciler{
+		ec: &marin3rv1alpha1.EnvoyConfig{Spec: marin3rv1alpha1.EnvoyConfigSpec{
+			RevisionRetention: &marin3rv1alpha1.RevisionRetention{KeepUntainted: pointer.Int32Ptr(1)},
+		}},
+		publishedVersion: pointer.StringPtr("ecr3"),
+		revisionList: &marin3rv1alpha1.EnvoyConfigRevisionList{
+			Items: []marin3rv1alpha1.EnvoyConfigRevision{
+				// Published a long time ago
+				ecr("ecr1", 96*time.Hour, hours(72), false),
+				// Published recently
+				ecr("ecr2", 96*time.Hour, hours(1), false),
+				// Published now, old but protected
+				ecr("ecr3", 96*time.Hour, hours(72), false),
+				// Never published
+				ecr("ecr4", 48*time.Hour, nil, true),
+				// Resources version, old but protected
+				ecr("ecr5", 48*time.Hour, nil, true),
+			},
+		},
+	}
+
+	got, gotExpiry := r.isRevisionAgeReconciled(24*time.Hour, now)
+	names := func(list []marin3rv1alpha1.EnvoyConfigRevision) []string {
+		n := []string{}
+		for _, ecr := range list {
+			n = append(n, ecr.GetName())
+		}
+		return n
+	}
+	if want := []string{"ecr1", "ecr4"}; !reflect.DeepEqual(names(got), want) {
+		t.Errorf("RevisionReconciler.isRevisionAgeReconciled() = %v, want %v", names(got), want)
+	}
+	if want := []string{"ecr2", "ecr3", "ecr5"}; !reflect.DeepEqual(names(r.GetRevisionList().Items), want) {
+		t.Errorf("RevisionReconciler.isRevisionAgeReconciled() list = %v, want %v", names(r.GetRevisionList().Items), want)
+	}
+	// ecr2 is the only unprotected revision kept
+	if want := 23 * time.Hour; gotExpiry != want {
+		t.Errorf("RevisionReconciler.isRevisionAgeReconciled() expiry = %v, want %v", gotExpiry, want)
+	}
+}
+
+func TestRevisionReconciler_protectedRevisions(t *testing.T) {
+	tainted := status.Conditions{{Type: marin3rv1alpha1.RevisionTaintedCondition, Status: corev1.ConditionTrue}}
+	canary := marin3rv1alpha1.EnvoyConfigRevisionStatus{
+		Canary:     &marin3rv1alpha1.CanaryRollout{},
+		Conditions: status.Conditions{{Type: marin3rv1alpha1.RevisionCanaryCondition, Status: corev1.ConditionTrue}},
+	}
+	list := func() *marin3rv1alpha1.EnvoyConfigRevisionList {
+		return &marin3rv1alpha1.EnvoyConfigRevisionList{
+			Items: []marin3rv1alpha1.EnvoyConfigRevision{
+				{ObjectMeta: metav1.ObjectMeta{Name: "ecr1"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "1"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "ecr2"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "2"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "ecr3"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "3"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "ecr4"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "4"},
+					Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{Conditions: tainted}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "ecr5"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "5"}, Status: canary},
+				{ObjectMeta: metav1.ObjectMeta{Name: "ecr6"}, Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "6"},
+					Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{Conditions: tainted}},
+			},
+		}
 	}
 	tests := []struct {
-		name     string
-		list     []marin3rv1alpha1.EnvoyConfigRevision
-		wantItem marin3rv1alpha1.EnvoyConfigRevision
-		wantList []marin3rv1alpha1.EnvoyConfigRevision
+		name             string
+		retention        *marin3rv1alpha1.RevisionRetention
+		pin              *string
+		publishedVersion *string
+		want             map[string]bool
 	}{
 		{
-			name: "Pops an element from the list",
-			list: []marin3rv1alpha1.EnvoyConfigRevision{
-				{ObjectMeta: metav1.ObjectMeta{Name: "ecr1"}},
-				{ObjectMeta: metav1.ObjectMeta{Name: "ecr2"}},
-				{ObjectMeta: metav1.ObjectMeta{Name: "ecr3"}},
-			},
-			wantItem: marin3rv1alpha1.EnvoyConfigRevision{ObjectMeta: metav1.ObjectMeta{Name: "ecr1"}},
-			wantList: []marin3rv1alpha1.EnvoyConfigRevision{
-				{ObjectMeta: metav1.ObjectMeta{Name: "ecr2"}},
-				{ObjectMeta: metav1.ObjectMeta{Name: "ecr3"}},
-			},
+			name: "Protects the resources revision and the most recent untainted one by default",
+			want: map[string]bool{"ecr6": true, "ecr5": true},
+		},
+		{
+			name:             "Protects the published and pinned revisions",
+			pin:              pointer.StringPtr("1"),
+			publishedVersion: pointer.StringPtr("2"),
+			want:             map[string]bool{"ecr6": true, "ecr5": true, "ecr2": true, "ecr1": true},
+		},
+		{
+			name:      "Protects the given number of untainted revisions",
+			retention: &marin3rv1alpha1.RevisionRetention{KeepUntainted: pointer.Int32Ptr(3)},
+			want:      map[string]bool{"ecr6": true, "ecr5": true, "ecr3": true, "ecr2": true},
+		},
+		{
+			name:      "Protects no untainted revision",
+			retention: &marin3rv1alpha1.RevisionRetention{KeepUntainted: pointer.Int32Ptr(0)},
+			want:      map[string]bool{"ecr6": true, "ecr5": true},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := popRevision(&tt.list); !reflect.DeepEqual(got, tt.wantItem) {
-				t.Errorf("popRevision() = %v, want %v", got, tt.wantItem)
+			r := &RevisionReconciler{
+				ec: &marin3rv1alpha1.EnvoyConfig{Spec: marin3rv1alpha1.EnvoyConfigSpec{
+					RevisionRetention: tt.retention,
+					RevisionPin:       tt.pin,
+				}},
+				publishedVersion: tt.publishedVersion,
+				revisionList:     list(),
 			}
-			if !reflect.DeepEqual(tt.list, tt.wantList) {
-				t.Errorf("popRevision() = %v, want %v", tt.list, tt.wantList)
+			if got := r.protectedRevisions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RevisionReconciler.protectedRevisions() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestRevisionReconciler_deleteRevision(t *testing.T) {
+	ecr := &marin3rv1alpha1.EnvoyConfigRevision{
+		ObjectMeta: metav1.ObjectMeta{Name: "ecr1", Namespace: "test"},
+		Spec:       marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "1"},
+	}
+	r := testRevisionReconcilerBuilder(s, &marin3rv1alpha1.EnvoyConfig{ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "test"}}, ecr)
+	recorder := record.NewFakeRecorder(1)
+	r.recorder = recorder
+
+	if err := r.deleteRevision(ecr, "at most 3 revisions are kept"); err != nil {
+		t.Fatalf("RevisionReconciler.deleteRevision() error = %v", err)
+	}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "ecr1", Namespace: "test"}, ecr); err == nil {
+		t.Errorf("RevisionReconciler.deleteRevision() did not delete the revision")
+	}
+	want := "Normal RevisionDeleted Deleted EnvoyConfigRevision ecr1 with version 1 because at most 3 revisions are kept"
+	if got := <-recorder.Events; got != want {
+		t.Errorf("RevisionReconciler.deleteRevision() event = %q, want %q", got, want)
+	}
+}
@@ -10,6 +10,10 @@ import (
 	"github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/revisions"
 
 	"github.com/operator-framework/operator-lib/status"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -18,32 +22,64 @@ const (
 )
 
 // OnError returns a function that should be called when the envoy xDS server receives
-// a NACK to a discovery response from any of the gateways
+// a NACK to a discovery response from any of the gateways. The revision is only tainted
+// if the taint policy of the EnvoyConfig that owns it is "Immediate". With other policies
+// the NACKs are reported in the clients status of the revision, and the EnvoyConfig
+// controller taints it once a quorum is reached. As several discovery service replicas
+// might receive NACKs for the same revision, the taint is written using optimistic locking
+// and retried on conflicts.
 func OnError(cl client.Client) func(nodeID, version, msg string, envoyAPI envoy.APIVersion) error {
 
 	return func(nodeID, version, msg string, envoyAPI envoy.APIVersion) error {
 
-		// Get the envoyconfig that corresponds to the envoy node that returned the error
-		ecr, err := revisions.Get(context.Background(), cl, "",
-			filters.ByNodeID(nodeID), filters.ByVersion(version), filters.ByEnvoyAPI(envoyAPI))
-		if err != nil {
-			return err
-		}
-
-		if !ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) {
-			patch := client.MergeFrom(ecr.DeepCopy())
-			ecr.Status.Conditions.SetCondition(status.Condition{
-				Type:    marin3rv1alpha1.RevisionTaintedCondition,
-				Status:  "True",
-				Reason:  status.ConditionReason("GatewayReturnedNACK"),
-				Message: fmt.Sprintf("A gateway returned NACK to the discovery response: '%s'", msg),
-			})
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			// Get the envoyconfig that corresponds to the envoy node that returned the error
+			ecr, err := revisions.Get(context.Background(), cl, "",
+				filters.ByNodeID(nodeID), filters.ByVersion(version), filters.ByEnvoyAPI(envoyAPI))
+			if err != nil {
+				return err
+			}
 
-			if err := cl.Status().Patch(context.Background(), ecr, patch); err != nil {
+			mode, err := taintPolicyMode(cl, ecr)
+			if err != nil {
 				return err
 			}
-		}
 
-		return nil
+			if mode == marin3rv1alpha1.ImmediateTaintPolicy &&
+				!ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) {
+				patch := client.MergeFromWithOptions(ecr.DeepCopy(), client.MergeFromWithOptimisticLock{})
+				ecr.Status.Conditions.SetCondition(status.Condition{
+					Type:    marin3rv1alpha1.RevisionTaintedCondition,
+					Status:  "True",
+					Reason:  status.ConditionReason("GatewayReturnedNACK"),
+					Message: fmt.Sprintf("A gateway returned NACK to the discovery response: '%s'", msg),
+				})
+
+				if err := cl.Status().Patch(context.Background(), ecr, patch); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+}
+
+// taintPolicyMode returns the mode of the taint policy of the EnvoyConfig that owns the
+// revision. Revisions not owned by an EnvoyConfig use the "Immediate" mode.
+func taintPolicyMode(cl client.Client, ecr *marin3rv1alpha1.EnvoyConfigRevision) (marin3rv1alpha1.TaintPolicyMode, error) {
+	owner := metav1.GetControllerOf(ecr)
+	if owner == nil || owner.Kind != "EnvoyConfig" {
+		return marin3rv1alpha1.ImmediateTaintPolicy, nil
+	}
+
+	ec := &marin3rv1alpha1.EnvoyConfig{}
+	key := types.NamespacedName{Name: owner.Name, Namespace: ecr.GetNamespace()}
+	if err := cl.Get(context.Background(), key, ec); err != nil {
+		if errors.IsNotFound(err) {
+			return marin3rv1alpha1.ImmediateTaintPolicy, nil
+		}
+		return "", err
 	}
+	return ec.GetTaintPolicy().GetMode(), nil
 }
@@ -1,6 +1,7 @@
 package rollback
 
 import (
+	"context"
 	"testing"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
@@ -8,7 +9,9 @@ import (
 	"github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/filters"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -23,6 +26,34 @@ func init() {
 	)
 }
 
+func testOwnedRevision() *marin3rv1alpha1.EnvoyConfigRevision {
+	return &marin3rv1alpha1.EnvoyConfigRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "ecr1", Namespace: "test",
+			ResourceVersion: "1",
+			Labels: map[string]string{
+				filters.NodeIDTag:   "node",
+				filters.EnvoyAPITag: envoy.APIv3.String(),
+				filters.VersionTag:  "xxxx",
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: marin3rv1alpha1.GroupVersion.String(), Kind: "EnvoyConfig",
+				Name: "ec", Controller: pointer.BoolPtr(true),
+			}},
+		},
+	}
+}
+
+func testOwner(mode marin3rv1alpha1.TaintPolicyMode) *marin3rv1alpha1.EnvoyConfig {
+	return &marin3rv1alpha1.EnvoyConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "test"},
+		Spec: marin3rv1alpha1.EnvoyConfigSpec{
+			NodeID:      "node",
+			TaintPolicy: &marin3rv1alpha1.TaintPolicy{Mode: &mode},
+		},
+	}
+}
+
 func TestOnError(t *testing.T) {
 	type args struct {
 		nodeID   string
@@ -31,10 +62,11 @@ func TestOnError(t *testing.T) {
 		envoyAPI envoy.APIVersion
 	}
 	tests := []struct {
-		name    string
-		cl      client.Client
-		args    args
-		wantErr bool
+		name        string
+		cl          client.Client
+		args        args
+		wantErr     bool
+		wantTainted bool
 	}{
 		{
 			name: "Returns a function that does not return error when called",
@@ -43,6 +75,7 @@ func TestOnError(t *testing.T) {
 					TypeMeta: metav1.TypeMeta{Kind: "EnvoyConfigRevision", APIVersion: "v1alpha1"},
 					ObjectMeta: metav1.ObjectMeta{
 						Name: "ecr1", Namespace: "test",
+						ResourceVersion: "1",
 						Labels: map[string]string{
 							filters.NodeIDTag:   "node",
 							filters.EnvoyAPITag: envoy.APIv3.String(),
@@ -50,8 +83,39 @@ func TestOnError(t *testing.T) {
 						},
 					},
 				}),
-			args:    args{"node", "xxxx", "test", envoy.APIv3},
-			wantErr: false,
+			args:        args{"node", "xxxx", "test", envoy.APIv3},
+			wantErr:     false,
+			wantTainted: true,
+		},
+		{
+			name: "Taints the revision if the taint policy of the owner is Immediate",
+			cl: fake.NewFakeClientWithScheme(s,
+				testOwnedRevision(),
+				testOwner(marin3rv1alpha1.ImmediateTaintPolicy),
+			),
+			args:        args{"node", "xxxx", "test", envoy.APIv3},
+			wantErr:     false,
+			wantTainted: true,
+		},
+		{
+			name: "Does not taint the revision if the taint policy of the owner is Quorum",
+			cl: fake.NewFakeClientWithScheme(s,
+				testOwnedRevision(),
+				testOwner(marin3rv1alpha1.QuorumTaintPolicy),
+			),
+			args:        args{"node", "xxxx", "test", envoy.APIv3},
+			wantErr:     false,
+			wantTainted: false,
+		},
+		{
+			name: "Does not taint the revision if the taint policy of the owner is ReportOnly",
+			cl: fake.NewFakeClientWithScheme(s,
+				testOwnedRevision(),
+				testOwner(marin3rv1alpha1.ReportOnlyTaintPolicy),
+			),
+			args:        args{"node", "xxxx", "test", envoy.APIv3},
+			wantErr:     false,
+			wantTainted: false,
 		},
 		{
 			name:    "Returns a function that does returns an error when called",
@@ -69,6 +133,17 @@ func TestOnError(t *testing.T) {
 				t.Errorf("OnError() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				return
+			}
+
+			ecr := &marin3rv1alpha1.EnvoyConfigRevision{}
+			if err := tt.cl.Get(context.TODO(), types.NamespacedName{Name: "ecr1", Namespace: "test"}, ecr); err != nil {
+				t.Fatalf("unable to get revision: %v", err)
+			}
+			if got := ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition); got != tt.wantTainted {
+				t.Errorf("OnError() tainted = %v, want %v", got, tt.wantTainted)
+			}
 
 		})
 	}
@@ -1,11 +1,14 @@
 package reconcilers
 
 import (
+	"fmt"
 	"reflect"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
 	"github.com/operator-framework/operator-lib/status"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // IsStatusReconciled calculates the status of the resource
@@ -36,8 +39,31 @@ func IsStatusReconciled(ec *marin3rv1alpha1.EnvoyConfig, cacheState, publishedVe
 		ok = false
 	}
 
+	clients := generateClientsStatus(list)
+	if !equality.Semantic.DeepEqual(ec.Status.Clients, clients) {
+		ec.Status.Clients = clients
+		ok = false
+	}
+
+	rollout := generateRolloutStatus(ec, publishedVersion, list)
+	if !equality.Semantic.DeepEqual(ec.Status.Rollout, rollout) {
+		ec.Status.Rollout = rollout
+		ok = false
+	}
+
 	// Reconcile the CacheOutOfSyncCondition
-	if desiredVersion != publishedVersion && !ec.Status.Conditions.IsTrueFor(marin3rv1alpha1.CacheOutOfSyncCondition) {
+	if desiredVersion != publishedVersion && cacheState == marin3rv1alpha1.PinnedState &&
+		!isTrueWithReason(ec.Status.Conditions, marin3rv1alpha1.CacheOutOfSyncCondition, "RevisionPinned") {
+		ec.Status.Conditions.SetCondition(status.Condition{
+			Type:    marin3rv1alpha1.CacheOutOfSyncCondition,
+			Status:  corev1.ConditionTrue,
+			Reason:  "RevisionPinned",
+			Message: fmt.Sprintf("Pinned revision '%s' is published instead of the desired resources spec", publishedVersion),
+		})
+		ok = false
+
+	} else if desiredVersion != publishedVersion && cacheState != marin3rv1alpha1.PinnedState &&
+		!isTrueWithReason(ec.Status.Conditions, marin3rv1alpha1.CacheOutOfSyncCondition, "CantPublishDesiredVersion") {
 		ec.Status.Conditions.SetCondition(status.Condition{
 			Type:    marin3rv1alpha1.CacheOutOfSyncCondition,
 			Status:  corev1.ConditionTrue,
@@ -78,6 +104,12 @@ func IsStatusReconciled(ec *marin3rv1alpha1.EnvoyConfig, cacheState, publishedVe
 	return ok
 }
 
+// isTrueWithReason returns true if the condition of the given type is true with the given reason
+func isTrueWithReason(conditions status.Conditions, t status.ConditionType, reason status.ConditionReason) bool {
+	cond := conditions.GetCondition(t)
+	return cond != nil && cond.IsTrue() && cond.Reason == reason
+}
+
 func generateRevisionList(list *marin3rv1alpha1.EnvoyConfigRevisionList) []marin3rv1alpha1.ConfigRevisionRef {
 
 	revisionList := make([]marin3rv1alpha1.ConfigRevisionRef, len(list.Items))
@@ -96,3 +128,75 @@ func generateRevisionList(list *marin3rv1alpha1.EnvoyConfigRevisionList) []marin
 
 	return revisionList
 }
+
+// generateClientsStatus returns the summary of the clients status reported
+// by the published revision, without the per client details
+func generateClientsStatus(list *marin3rv1alpha1.EnvoyConfigRevisionList) *marin3rv1alpha1.ClientsStatus {
+
+	for _, ecr := range list.Items {
+		if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) && ecr.Status.Clients != nil {
+			return &marin3rv1alpha1.ClientsStatus{
+				Connected: ecr.Status.Clients.Connected,
+				InSync:    ecr.Status.Clients.InSync,
+				Nacked:    ecr.Status.Clients.Nacked,
+				Summary:   ecr.Status.Clients.Summary,
+			}
+		}
+	}
+
+	return nil
+}
+
+// generateRolloutStatus returns the progress of the canary rollout. While a revision is being rolled out
+// to the canaries the status is taken from it, and once it is over the previous status is kept with the
+// phase set to either promoted or aborted. Returns nil if there is no canary rollout strategy configured.
+func generateRolloutStatus(ec *marin3rv1alpha1.EnvoyConfig, publishedVersion string,
+	list *marin3rv1alpha1.EnvoyConfigRevisionList) *marin3rv1alpha1.RolloutStatus {
+
+	if ec.Spec.RolloutStrategy == nil || ec.Spec.RolloutStrategy.Canary == nil {
+		return nil
+	}
+
+	for _, ecr := range list.Items {
+		if !ecr.Status.IsCanary() {
+			continue
+		}
+		cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionCanaryCondition)
+		promoteAt := metav1.NewTime(cond.LastTransitionTime.Add(ecr.Status.Canary.GetBakeTime()))
+		phase := marin3rv1alpha1.RolloutCanaryPhase
+		if cond.Reason == marin3rv1alpha1.NoCanaryClientsReason {
+			phase = marin3rv1alpha1.RolloutNoCanariesPhase
+		}
+		rollout := &marin3rv1alpha1.RolloutStatus{
+			Phase:         phase,
+			CanaryVersion: ecr.Spec.Version,
+			StableVersion: publishedVersion,
+			StartedAt:     cond.LastTransitionTime,
+			PromoteAt:     &promoteAt,
+		}
+		if ecr.Status.Clients != nil {
+			rollout.Canaries = &marin3rv1alpha1.ClientsStatus{
+				Connected: ecr.Status.Clients.Connected,
+				InSync:    ecr.Status.Clients.InSync,
+				Summary:   ecr.Status.Clients.Summary,
+			}
+		}
+		return rollout
+	}
+
+	previous := ec.Status.Rollout
+	if previous == nil || (previous.Phase != marin3rv1alpha1.RolloutCanaryPhase && previous.Phase != marin3rv1alpha1.RolloutNoCanariesPhase) {
+		return previous
+	}
+
+	rollout := previous.DeepCopy()
+	rollout.PromoteAt = nil
+	rollout.Canaries = nil
+	if publishedVersion == previous.CanaryVersion {
+		rollout.Phase = marin3rv1alpha1.RolloutPromotedPhase
+		rollout.StableVersion = publishedVersion
+	} else {
+		rollout.Phase = marin3rv1alpha1.RolloutAbortedPhase
+	}
+	return rollout
+}
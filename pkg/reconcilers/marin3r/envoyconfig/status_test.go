@@ -3,10 +3,12 @@ package reconcilers
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
 	"github.com/operator-framework/operator-lib/status"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -120,6 +122,48 @@ func TestIsStatusReconciled(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "CacheOutOfSyncCondition needs to report the pinned revision, returns false",
+			args: args{
+				ec: &marin3rv1alpha1.EnvoyConfig{
+					Status: marin3rv1alpha1.EnvoyConfigStatus{
+						DesiredVersion:   "6ddbcdf795",
+						PublishedVersion: "1",
+						CacheState:       marin3rv1alpha1.PinnedState,
+						ConfigRevisions:  []marin3rv1alpha1.ConfigRevisionRef{},
+						Conditions: status.Conditions{
+							{Type: marin3rv1alpha1.CacheOutOfSyncCondition, Status: corev1.ConditionTrue, Reason: "CantPublishDesiredVersion"},
+							{Type: marin3rv1alpha1.RollbackFailedCondition, Status: corev1.ConditionFalse},
+						},
+					},
+				},
+				cacheState:       marin3rv1alpha1.PinnedState,
+				publishedVersion: "1",
+				list:             &marin3rv1alpha1.EnvoyConfigRevisionList{},
+			},
+			want: false,
+		},
+		{
+			name: "CacheOutOfSyncCondition already reports the pinned revision, returns true",
+			args: args{
+				ec: &marin3rv1alpha1.EnvoyConfig{
+					Status: marin3rv1alpha1.EnvoyConfigStatus{
+						DesiredVersion:   "6ddbcdf795",
+						PublishedVersion: "1",
+						CacheState:       marin3rv1alpha1.PinnedState,
+						ConfigRevisions:  []marin3rv1alpha1.ConfigRevisionRef{},
+						Conditions: status.Conditions{
+							{Type: marin3rv1alpha1.CacheOutOfSyncCondition, Status: corev1.ConditionTrue, Reason: "RevisionPinned"},
+							{Type: marin3rv1alpha1.RollbackFailedCondition, Status: corev1.ConditionFalse},
+						},
+					},
+				},
+				cacheState:       marin3rv1alpha1.PinnedState,
+				publishedVersion: "1",
+				list:             &marin3rv1alpha1.EnvoyConfigRevisionList{},
+			},
+			want: true,
+		},
 		{
 			name: "DesiredVersion needs update, return false",
 			args: args{
@@ -264,3 +308,148 @@ func Test_generateRevisionList(t *testing.T) {
 		})
 	}
 }
+
+func Test_generateClientsStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		list *marin3rv1alpha1.EnvoyConfigRevisionList
+		want *marin3rv1alpha1.ClientsStatus
+	}{
+		{
+			name: "Returns the summary of the published revision",
+			list: &marin3rv1alpha1.EnvoyConfigRevisionList{
+				Items: []marin3rv1alpha1.EnvoyConfigRevision{
+					{
+						Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+							Conditions: status.Conditions{{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionFalse}},
+						},
+					},
+					{
+						Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+							Conditions: status.Conditions{{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue}},
+							Clients: &marin3rv1alpha1.ClientsStatus{
+								Connected: 2, InSync: 1, Summary: "1/2 clients on version xxxx",
+								Details: []marin3rv1alpha1.ClientStatus{{StreamID: "v3/sotw/1"}, {StreamID: "v3/sotw/2"}},
+							},
+						},
+					},
+				},
+			},
+			want: &marin3rv1alpha1.ClientsStatus{Connected: 2, InSync: 1, Summary: "1/2 clients on version xxxx"},
+		},
+		{
+			name: "Returns nil if there is no published revision",
+			list: &marin3rv1alpha1.EnvoyConfigRevisionList{
+				Items: []marin3rv1alpha1.EnvoyConfigRevision{{}},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := generateClientsStatus(tt.list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generateClientsStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generateRolloutStatus(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+	ec := func(rollout *marin3rv1alpha1.RolloutStatus) *marin3rv1alpha1.EnvoyConfig {
+		return &marin3rv1alpha1.EnvoyConfig{
+			Spec: marin3rv1alpha1.EnvoyConfigSpec{
+				RolloutStrategy: &marin3rv1alpha1.RolloutStrategy{Canary: &marin3rv1alpha1.CanaryRollout{}},
+			},
+			Status: marin3rv1alpha1.EnvoyConfigStatus{Rollout: rollout},
+		}
+	}
+	inProgress := &marin3rv1alpha1.RolloutStatus{
+		Phase:         marin3rv1alpha1.RolloutCanaryPhase,
+		CanaryVersion: "xxxx",
+		StableVersion: "aaaa",
+		StartedAt:     started,
+		PromoteAt:     func(t metav1.Time) *metav1.Time { return &t }(metav1.NewTime(started.Add(marin3rv1alpha1.DefaultCanaryBakeTime))),
+		Canaries:      &marin3rv1alpha1.ClientsStatus{Connected: 1, InSync: 1, Summary: "1/1 clients on version xxxx"},
+	}
+
+	tests := []struct {
+		name             string
+		ec               *marin3rv1alpha1.EnvoyConfig
+		publishedVersion string
+		list             *marin3rv1alpha1.EnvoyConfigRevisionList
+		want             *marin3rv1alpha1.RolloutStatus
+	}{
+		{
+			name:             "Returns nil without a canary rollout strategy",
+			ec:               &marin3rv1alpha1.EnvoyConfig{},
+			publishedVersion: "aaaa",
+			list:             &marin3rv1alpha1.EnvoyConfigRevisionList{},
+			want:             nil,
+		},
+		{
+			name:             "Returns the progress of the canary",
+			ec:               ec(nil),
+			publishedVersion: "aaaa",
+			list: &marin3rv1alpha1.EnvoyConfigRevisionList{Items: []marin3rv1alpha1.EnvoyConfigRevision{
+				{Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "aaaa"}},
+				{
+					Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "xxxx"},
+					Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+						Conditions: status.Conditions{{Type: marin3rv1alpha1.RevisionCanaryCondition, Status: corev1.ConditionTrue, LastTransitionTime: started}},
+						Canary:     &marin3rv1alpha1.CanaryRollout{},
+						Clients: &marin3rv1alpha1.ClientsStatus{
+							Connected: 1, InSync: 1, Summary: "1/1 clients on version xxxx",
+							Details: []marin3rv1alpha1.ClientStatus{{StreamID: "v3/sotw/1", InSync: true}},
+						},
+					},
+				},
+			}},
+			want: inProgress,
+		},
+		{
+			name:             "Reports that no client has been selected as canary",
+			ec:               ec(nil),
+			publishedVersion: "aaaa",
+			list: &marin3rv1alpha1.EnvoyConfigRevisionList{Items: []marin3rv1alpha1.EnvoyConfigRevision{
+				{
+					Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "xxxx"},
+					Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+						Conditions: status.Conditions{{Type: marin3rv1alpha1.RevisionCanaryCondition, Status: corev1.ConditionTrue,
+							Reason: marin3rv1alpha1.NoCanaryClientsReason, LastTransitionTime: started}},
+						Canary: &marin3rv1alpha1.CanaryRollout{},
+					},
+				},
+			}},
+			want: &marin3rv1alpha1.RolloutStatus{
+				Phase: marin3rv1alpha1.RolloutNoCanariesPhase, CanaryVersion: "xxxx", StableVersion: "aaaa", StartedAt: started,
+				PromoteAt: inProgress.PromoteAt,
+			},
+		},
+		{
+			name:             "Returns the rollout as promoted",
+			ec:               ec(inProgress),
+			publishedVersion: "xxxx",
+			list:             &marin3rv1alpha1.EnvoyConfigRevisionList{},
+			want: &marin3rv1alpha1.RolloutStatus{
+				Phase: marin3rv1alpha1.RolloutPromotedPhase, CanaryVersion: "xxxx", StableVersion: "xxxx", StartedAt: started,
+			},
+		},
+		{
+			name:             "Returns the rollout as aborted",
+			ec:               ec(inProgress),
+			publishedVersion: "aaaa",
+			list:             &marin3rv1alpha1.EnvoyConfigRevisionList{},
+			want: &marin3rv1alpha1.RolloutStatus{
+				Phase: marin3rv1alpha1.RolloutAbortedPhase, CanaryVersion: "xxxx", StableVersion: "aaaa", StartedAt: started,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := generateRolloutStatus(tt.ec, tt.publishedVersion, tt.list); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("generateRolloutStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package reconcilers
+
+import (
+	"fmt"
+	"time"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"github.com/operator-framework/operator-lib/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// maxTaintHistory is the maximum number of records kept
+	// in the taint history of a revision
+	maxTaintHistory = 10
+)
+
+// isRevisionTaintReconciled removes the taint of the revisions that have the UntaintAnnotation and, if 'ttl'
+// is not zero, of the ones that have been tainted for longer than 'ttl'. The changes are done in place in the
+// revision list. Returns the revisions that have been untainted, and the time left until the next taint
+// expires, zero if there is none.
+func (r *RevisionReconciler) isRevisionTaintReconciled(ttl time.Duration, now time.Time) ([]*marin3rv1alpha1.EnvoyConfigRevision, time.Duration) {
+	changed := []*marin3rv1alpha1.EnvoyConfigRevision{}
+	var nextExpiry time.Duration
+
+	for idx := range r.revisionList.Items {
+		ecr := &r.revisionList.Items[idx]
+		_, requested := ecr.GetAnnotations()[marin3rv1alpha1.UntaintAnnotation]
+		cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionTaintedCondition)
+
+		switch {
+		case cond == nil || !cond.IsTrue():
+			continue
+
+		case requested:
+			untaint(ecr, marin3rv1alpha1.UntaintedByUserReason, "Taint removed at the request of a user", now)
+			changed = append(changed, ecr)
+
+		case ttl != 0:
+			left := cond.LastTransitionTime.Add(ttl).Sub(now)
+			if left <= 0 {
+				untaint(ecr, marin3rv1alpha1.TaintExpiredReason, fmt.Sprintf("Taint expired after %s", ttl), now)
+				changed = append(changed, ecr)
+			} else if nextExpiry == 0 || left < nextExpiry {
+				nextExpiry = left
+			}
+		}
+	}
+
+	return changed, nextExpiry
+}
+
+// isRevisionNackQuorumReconciled taints the revisions whose clients status reports as many NACKs as the quorum
+// set by 'policy' requires. Only the "Quorum" mode taints revisions here, as in "Immediate" mode the discovery
+// service taints them as soon as a NACK is received. The changes are done in place in the revision list. Returns
+// the revisions that have been tainted.
+func (r *RevisionReconciler) isRevisionNackQuorumReconciled(policy *marin3rv1alpha1.TaintPolicy) []*marin3rv1alpha1.EnvoyConfigRevision {
+	changed := []*marin3rv1alpha1.EnvoyConfigRevision{}
+	if policy.GetMode() != marin3rv1alpha1.QuorumTaintPolicy {
+		return changed
+	}
+
+	for idx := range r.revisionList.Items {
+		ecr := &r.revisionList.Items[idx]
+		clients := ecr.Status.Clients
+		// The clients status of the revisions that are not being served is the
+		// last one reported, which has already been checked
+		served := ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) || ecr.Status.IsCanary()
+		if clients == nil || !served || ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) ||
+			!policy.IsQuorumReached(clients.Nacked, clients.Connected) {
+			continue
+		}
+		taint(ecr, marin3rv1alpha1.NackQuorumReachedReason,
+			fmt.Sprintf("%d/%d clients returned NACK to the discovery response: '%s'",
+				clients.Nacked, clients.Connected, lastNackMessage(ecr)))
+		changed = append(changed, ecr)
+	}
+
+	return changed
+}
+
+// lastNackMessage returns the message of the most recent
+// NACK returned for the version of the revision
+func lastNackMessage(ecr *marin3rv1alpha1.EnvoyConfigRevision) string {
+	var last *marin3rv1alpha1.ClientStatus
+	for idx := range ecr.Status.Clients.Details {
+		client := &ecr.Status.Clients.Details[idx]
+		if client.NackedVersion != ecr.Spec.Version {
+			continue
+		}
+		if last == nil || (client.NackedAt != nil && last.NackedAt != nil && last.NackedAt.Before(client.NackedAt)) {
+			last = client
+		}
+	}
+	if last == nil {
+		return ""
+	}
+	return last.NackMessage
+}
+
+// taint sets the RevisionTainted condition of a revision to true
+func taint(ecr *marin3rv1alpha1.EnvoyConfigRevision, reason status.ConditionReason, msg string) {
+	ecr.Status.Conditions.SetCondition(status.Condition{
+		Type:    marin3rv1alpha1.RevisionTaintedCondition,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: msg,
+	})
+	ecr.Status.Tainted = pointer.BoolPtr(true)
+}
+
+// untaint removes the taint of a revision, setting the RevisionTainted condition to false,
+// and keeps a record of the removed taint in the taint history of the revision
+func untaint(ecr *marin3rv1alpha1.EnvoyConfigRevision, reason status.ConditionReason, msg string, now time.Time) {
+	cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionTaintedCondition)
+
+	ecr.Status.TaintHistory = append(ecr.Status.TaintHistory, marin3rv1alpha1.TaintRecord{
+		Reason:        cond.Reason,
+		Message:       cond.Message,
+		TaintedAt:     cond.LastTransitionTime,
+		UntaintedAt:   metav1.NewTime(now),
+		UntaintReason: reason,
+	})
+	if len(ecr.Status.TaintHistory) > maxTaintHistory {
+		ecr.Status.TaintHistory = ecr.Status.TaintHistory[len(ecr.Status.TaintHistory)-maxTaintHistory:]
+	}
+
+	ecr.Status.Conditions.SetCondition(status.Condition{
+		Type:    marin3rv1alpha1.RevisionTaintedCondition,
+		Status:  corev1.ConditionFalse,
+		Reason:  reason,
+		Message: msg,
+	})
+	ecr.Status.Tainted = pointer.BoolPtr(false)
+}
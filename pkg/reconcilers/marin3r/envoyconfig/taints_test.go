@@ -0,0 +1,329 @@
+package reconcilers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	"github.com/3scale/marin3r/pkg/reconcilers/marin3r/envoyconfig/filters"
+	"github.com/operator-framework/operator-lib/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+func TestRevisionReconciler_isRevisionTaintReconciled(t *testing.T) {
+	now := time.Now()
+	ecr := func(name string, taintedFor time.Duration, annotated bool) marin3rv1alpha1.EnvoyConfigRevision {
+		ecr := marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: name},
+		}
+		if taintedFor != 0 {
+			ecr.Status.Conditions = status.Conditions{{
+				Type:               marin3rv1alpha1.RevisionTaintedCondition,
+				Status:             corev1.ConditionTrue,
+				Reason:             "GatewayReturnedNACK",
+				Message:            "nack",
+				LastTransitionTime: metav1.NewTime(now.Add(-taintedFor)),
+			}}
+		}
+		if annotated {
+			ecr.SetAnnotations(map[string]string{marin3rv1alpha1.UntaintAnnotation: "true"})
+		}
+		return ecr
+	}
+	names := func(list []*marin3rv1alpha1.EnvoyConfigRevision) []string {
+		n := []string{}
+		for _, ecr := range list {
+			n = append(n, ecr.GetName())
+		}
+		return n
+	}
+
+	tests := []struct {
+		name           string
+		items          []marin3rv1alpha1.EnvoyConfigRevision
+		ttl            time.Duration
+		wantUntainted  []string
+		wantNextExpiry time.Duration
+	}{
+		{
+			name: "Untaints the revisions with the untaint annotation",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				ecr("ecr1", time.Hour, true), ecr("ecr2", time.Hour, false), ecr("ecr3", 0, true),
+			},
+			wantUntainted: []string{"ecr1"},
+		},
+		{
+			name: "Untaints the revisions with expired taints",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				ecr("ecr1", 2*time.Hour, false), ecr("ecr2", 30*time.Minute, false), ecr("ecr3", 45*time.Minute, false),
+			},
+			ttl:            time.Hour,
+			wantUntainted:  []string{"ecr1"},
+			wantNextExpiry: 15 * time.Minute,
+		},
+		{
+			name: "Taints do not expire without ttl",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{
+				ecr("ecr1", 200*time.Hour, false),
+			},
+			wantUntainted: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RevisionReconciler{revisionList: &marin3rv1alpha1.EnvoyConfigRevisionList{Items: tt.items}}
+			got, next := r.isRevisionTaintReconciled(tt.ttl, now)
+			if !reflect.DeepEqual(names(got), tt.wantUntainted) {
+				t.Errorf("RevisionReconciler.isRevisionTaintReconciled() = %v, want %v", names(got), tt.wantUntainted)
+			}
+			if next != tt.wantNextExpiry {
+				t.Errorf("RevisionReconciler.isRevisionTaintReconciled() next expiry = %v, want %v", next, tt.wantNextExpiry)
+			}
+			for _, ecr := range got {
+				if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) || ecr.Status.IsTainted() {
+					t.Errorf("RevisionReconciler.isRevisionTaintReconciled() %s is still tainted", ecr.GetName())
+				}
+			}
+		})
+	}
+}
+
+func Test_untaint(t *testing.T) {
+	now := time.Now()
+	taintedAt := metav1.NewTime(now.Add(-time.Hour))
+	ecr := &marin3rv1alpha1.EnvoyConfigRevision{}
+	for i := 0; i < maxTaintHistory+1; i++ {
+		ecr.Status.Conditions = status.Conditions{{
+			Type:               marin3rv1alpha1.RevisionTaintedCondition,
+			Status:             corev1.ConditionTrue,
+			Reason:             "GatewayReturnedNACK",
+			Message:            "nack",
+			LastTransitionTime: taintedAt,
+		}}
+		untaint(ecr, marin3rv1alpha1.UntaintedByUserReason, "msg", now)
+	}
+
+	if len(ecr.Status.TaintHistory) != maxTaintHistory {
+		t.Errorf("untaint() kept %d taint records, want %d", len(ecr.Status.TaintHistory), maxTaintHistory)
+	}
+	want := marin3rv1alpha1.TaintRecord{
+		Reason:        "GatewayReturnedNACK",
+		Message:       "nack",
+		TaintedAt:     taintedAt,
+		UntaintedAt:   metav1.NewTime(now),
+		UntaintReason: marin3rv1alpha1.UntaintedByUserReason,
+	}
+	if got := ecr.Status.TaintHistory[maxTaintHistory-1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("untaint() taint record = %v, want %v", got, want)
+	}
+	if cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionTaintedCondition); !cond.IsFalse() || cond.Reason != marin3rv1alpha1.UntaintedByUserReason {
+		t.Errorf("untaint() condition = %v, want false with reason %s", cond, marin3rv1alpha1.UntaintedByUserReason)
+	}
+}
+
+func TestRevisionReconciler_Reconcile_untaint(t *testing.T) {
+	version := "c4547474b"
+	ec := &marin3rv1alpha1.EnvoyConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "test"},
+		Spec: marin3rv1alpha1.EnvoyConfigSpec{
+			NodeID:         "node",
+			EnvoyAPI:       pointer.StringPtr(envoy.APIv3.String()),
+			EnvoyResources: &marin3rv1alpha1.EnvoyResources{},
+		},
+	}
+	ecr := &marin3rv1alpha1.EnvoyConfigRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "ecr", Namespace: "test",
+			Labels: map[string]string{
+				filters.NodeIDTag:   "node",
+				filters.EnvoyAPITag: envoy.APIv3.String(),
+				filters.VersionTag:  version,
+			},
+			Annotations: map[string]string{marin3rv1alpha1.UntaintAnnotation: "true"},
+		},
+		Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: version},
+		Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+			Conditions: status.Conditions{{Type: marin3rv1alpha1.RevisionTaintedCondition, Status: corev1.ConditionTrue}},
+		},
+	}
+	r := testRevisionReconcilerBuilder(s, ec, ecr)
+
+	if _, err := r.Reconcile(); err != nil {
+		t.Fatalf("RevisionReconciler.Reconcile() error = %v", err)
+	}
+	if r.PublishedVersion() != version || r.GetCacheState() != marin3rv1alpha1.InSyncState {
+		t.Errorf("RevisionReconciler.Reconcile() published = %v/%v, want %v/%v",
+			r.PublishedVersion(), r.GetCacheState(), version, marin3rv1alpha1.InSyncState)
+	}
+
+	got := &marin3rv1alpha1.EnvoyConfigRevision{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "ecr", Namespace: "test"}, got); err != nil {
+		t.Fatalf("error getting the EnvoyConfigRevision: %v", err)
+	}
+	if got.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionTaintedCondition) || len(got.Status.TaintHistory) != 1 {
+		t.Errorf("RevisionReconciler.Reconcile() did not untaint the revision: %v", got.Status)
+	}
+	if _, ok := got.GetAnnotations()[marin3rv1alpha1.UntaintAnnotation]; ok {
+		t.Errorf("RevisionReconciler.Reconcile() did not remove the untaint annotation")
+	}
+}
+
+func TestRevisionReconciler_Reconcile_retryAbortedRollout(t *testing.T) {
+	version := "c4547474b"
+	ec := &marin3rv1alpha1.EnvoyConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "ec", Namespace: "test"},
+		Spec: marin3rv1alpha1.EnvoyConfigSpec{
+			NodeID:          "node",
+			EnvoyAPI:        pointer.StringPtr(envoy.APIv3.String()),
+			EnvoyResources:  &marin3rv1alpha1.EnvoyResources{},
+			RolloutStrategy: &marin3rv1alpha1.RolloutStrategy{Canary: &marin3rv1alpha1.CanaryRollout{}},
+		},
+		Status: marin3rv1alpha1.EnvoyConfigStatus{
+			Rollout: &marin3rv1alpha1.RolloutStatus{
+				Phase: marin3rv1alpha1.RolloutAbortedPhase, CanaryVersion: version, StartedAt: metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+		},
+	}
+	revision := func(name, version string) *marin3rv1alpha1.EnvoyConfigRevision {
+		return &marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "test",
+				Labels: map[string]string{
+					filters.NodeIDTag:   "node",
+					filters.EnvoyAPITag: envoy.APIv3.String(),
+					filters.VersionTag:  version,
+				},
+			},
+			Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: version},
+		}
+	}
+	published := revision("published", "aaaa")
+	published.Status.Conditions = status.Conditions{{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue}}
+	// The rollout was aborted by a NACK that did not reach the quorum, so the revision is not tainted
+	aborted := revision("aborted", version)
+	aborted.SetAnnotations(map[string]string{marin3rv1alpha1.UntaintAnnotation: "true"})
+	aborted.Status.Clients = &marin3rv1alpha1.ClientsStatus{Connected: 2, Nacked: 1}
+	r := testRevisionReconcilerBuilder(s, ec, published, aborted)
+
+	if _, err := r.Reconcile(); err != nil {
+		t.Fatalf("RevisionReconciler.Reconcile() error = %v", err)
+	}
+	if r.PublishedVersion() != "aaaa" || r.GetCacheState() != marin3rv1alpha1.CanaryState {
+		t.Errorf("RevisionReconciler.Reconcile() published = %v/%v, want %v/%v",
+			r.PublishedVersion(), r.GetCacheState(), "aaaa", marin3rv1alpha1.CanaryState)
+	}
+
+	got := &marin3rv1alpha1.EnvoyConfigRevision{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "aborted", Namespace: "test"}, got); err != nil {
+		t.Fatalf("error getting the EnvoyConfigRevision: %v", err)
+	}
+	if !got.Status.IsCanary() || got.Status.Clients != nil {
+		t.Errorf("RevisionReconciler.Reconcile() did not start the rollout again: %v", got.Status)
+	}
+	if _, ok := got.GetAnnotations()[marin3rv1alpha1.UntaintAnnotation]; ok {
+		t.Errorf("RevisionReconciler.Reconcile() did not remove the untaint annotation")
+	}
+}
+
+func TestRevisionReconciler_isRevisionNackQuorumReconciled(t *testing.T) {
+	t1 := metav1.NewTime(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	t2 := metav1.NewTime(t1.Add(time.Minute))
+	ecr := func(name string, connected int, nacks ...*metav1.Time) marin3rv1alpha1.EnvoyConfigRevision {
+		details := []marin3rv1alpha1.ClientStatus{}
+		for idx, at := range nacks {
+			details = append(details, marin3rv1alpha1.ClientStatus{
+				StreamID: fmt.Sprintf("v3/sotw/%d", idx), NackedVersion: name, NackMessage: at.Format(time.RFC3339), NackedAt: at,
+			})
+		}
+		return marin3rv1alpha1.EnvoyConfigRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: name},
+			Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+				Conditions: status.Conditions{
+					{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue},
+				},
+				Clients: &marin3rv1alpha1.ClientsStatus{Connected: connected, Nacked: len(nacks), Details: details},
+			},
+		}
+	}
+	unpublished := func(ecr marin3rv1alpha1.EnvoyConfigRevision) marin3rv1alpha1.EnvoyConfigRevision {
+		ecr.Status.Conditions = status.Conditions{}
+		return ecr
+	}
+	mode := func(m marin3rv1alpha1.TaintPolicyMode) *marin3rv1alpha1.TaintPolicyMode { return &m }
+
+	tests := []struct {
+		name        string
+		items       []marin3rv1alpha1.EnvoyConfigRevision
+		policy      *marin3rv1alpha1.TaintPolicy
+		wantTainted []string
+		wantMessage string
+	}{
+		{
+			name:        "Does not taint revisions without a taint policy",
+			items:       []marin3rv1alpha1.EnvoyConfigRevision{ecr("ecr1", 2, &t1, &t2)},
+			policy:      nil,
+			wantTainted: []string{},
+		},
+		{
+			name:        "Does not taint revisions in ReportOnly mode",
+			items:       []marin3rv1alpha1.EnvoyConfigRevision{ecr("ecr1", 2, &t1, &t2)},
+			policy:      &marin3rv1alpha1.TaintPolicy{Mode: mode(marin3rv1alpha1.ReportOnlyTaintPolicy)},
+			wantTainted: []string{},
+		},
+		{
+			name:  "Taints the revisions that reach the minimum number of clients",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{ecr("ecr1", 10, &t1), ecr("ecr2", 10, &t1, &t2)},
+			policy: &marin3rv1alpha1.TaintPolicy{
+				Mode: mode(marin3rv1alpha1.QuorumTaintPolicy), MinClients: pointer.Int32Ptr(2),
+			},
+			wantTainted: []string{"ecr2"},
+			wantMessage: "2/10 clients returned NACK to the discovery response: '" + t2.Format(time.RFC3339) + "'",
+		},
+		{
+			name:  "Taints the revisions that reach the percentage of clients",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{ecr("ecr1", 4, &t1), ecr("ecr2", 2, &t1)},
+			policy: &marin3rv1alpha1.TaintPolicy{
+				Mode: mode(marin3rv1alpha1.QuorumTaintPolicy), Percentage: pointer.Int32Ptr(50),
+			},
+			wantTainted: []string{"ecr2"},
+			wantMessage: "1/2 clients returned NACK to the discovery response: '" + t1.Format(time.RFC3339) + "'",
+		},
+		{
+			name:  "Does not taint revisions with the last status reported before they were unpublished",
+			items: []marin3rv1alpha1.EnvoyConfigRevision{unpublished(ecr("ecr1", 2, &t1, &t2))},
+			policy: &marin3rv1alpha1.TaintPolicy{
+				Mode: mode(marin3rv1alpha1.QuorumTaintPolicy), MinClients: pointer.Int32Ptr(1),
+			},
+			wantTainted: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RevisionReconciler{revisionList: &marin3rv1alpha1.EnvoyConfigRevisionList{Items: tt.items}}
+			got := r.isRevisionNackQuorumReconciled(tt.policy)
+			names := []string{}
+			for _, ecr := range got {
+				names = append(names, ecr.GetName())
+				cond := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.RevisionTaintedCondition)
+				if !cond.IsTrue() || cond.Reason != marin3rv1alpha1.NackQuorumReachedReason || !ecr.Status.IsTainted() {
+					t.Errorf("RevisionReconciler.isRevisionNackQuorumReconciled() %s is not tainted", ecr.GetName())
+				}
+				if cond.Message != tt.wantMessage {
+					t.Errorf("RevisionReconciler.isRevisionNackQuorumReconciled() message = %v, want %v", cond.Message, tt.wantMessage)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantTainted) {
+				t.Errorf("RevisionReconciler.isRevisionNackQuorumReconciled() = %v, want %v", names, tt.wantTainted)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package reconcilers
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
@@ -48,14 +49,17 @@ func (r *CacheReconciler) Reconcile(req types.NamespacedName, resources *marin3r
 		return ctrl.Result{}, err
 	}
 
-	oldSnap, err := r.xdsCache.GetSnapshot(nodeID)
-	// Publish the generated snapshot when the version is different from the published one. We look specifically
-	// for the version of the "Secret" resources because secrets can change even when the spec hasn't changed.
-	// Publish the snapshot when an error retrieving the published one occurs as it means that no snpshot has already
-	// been written to the cache for that specific nodeID.
-	if snap.GetVersion(envoy.Secret) != oldSnap.GetVersion(envoy.Secret) || err != nil {
+	oldSnap, err := xdss.GetLatestSnapshot(r.xdsCache, nodeID)
+	// Publish the generated snapshot when the version is different from the last one written, which might still be
+	// waiting to be published. We look specifically for the version of the "Secret" and "Endpoint" resources because
+	// secrets and the endpoints generated from EndpointSlices can change even when the spec hasn't changed. Publish the
+	// snapshot when an error retrieving the last one occurs as it means that no snpshot has already been written to the
+	// cache for that specific nodeID.
+	if snap.GetVersion(envoy.Secret) != oldSnap.GetVersion(envoy.Secret) ||
+		snap.GetVersion(envoy.Endpoint) != oldSnap.GetVersion(envoy.Endpoint) || err != nil {
 
-		r.logger.Info("Writing new snapshot to xDS cache", "Version", version, "NodeID", nodeID, "Secrets Hash", snap.GetVersion(envoy.Secret))
+		r.logger.Info("Writing new snapshot to xDS cache", "Version", version, "NodeID", nodeID,
+			"Secrets Hash", snap.GetVersion(envoy.Secret), "Endpoints Hash", snap.GetVersion(envoy.Endpoint))
 
 		if err := r.xdsCache.SetSnapshot(nodeID, snap); err != nil {
 			return ctrl.Result{}, err
@@ -83,6 +87,19 @@ func (r *CacheReconciler) GenerateSnapshot(req types.NamespacedName, resources *
 		snap.SetResource(endpoint.Name, res)
 	}
 
+	for idx, se := range resources.ServiceEndpoints {
+		sePath := field.NewPath("spec", "resources").Child("serviceEndpoints").Index(idx)
+		if _, ok := snap.GetResources(envoy.Endpoint)[se.Name]; ok {
+			return nil, resourceLoaderError(req, se.Name, sePath.Child("name"),
+				fmt.Sprintf("Endpoint resource '%s' is already defined in spec.resources.endpoints", se.Name))
+		}
+		res, err := r.newServiceEndpointsResource(req, se, sePath)
+		if err != nil {
+			return nil, err
+		}
+		snap.SetResource(se.Name, res)
+	}
+
 	for idx, cluster := range resources.Clusters {
 		res := r.generator.New(envoy.Cluster)
 		if err := r.decoder.Unmarshal(cluster.Value, res); err != nil {
@@ -131,28 +148,69 @@ func (r *CacheReconciler) GenerateSnapshot(req types.NamespacedName, resources *
 		snap.SetResource(runtime.Name, res)
 	}
 
-	for idx, secret := range resources.Secrets {
-		s := &corev1.Secret{}
-		key := types.NamespacedName{
-			Name:      secret.Ref.Name,
-			Namespace: secret.Ref.Namespace,
+	for idx, extensionConfig := range resources.ExtensionConfigs {
+		res := r.generator.New(envoy.ExtensionConfig)
+		if res == nil {
+			return nil,
+				resourceLoaderError(
+					req, extensionConfig.Value, field.NewPath("spec", "resources").Child("extensionConfigs").Index(idx).Child("value"),
+					"ExtensionConfig resources are only supported in envoy API v3",
+				)
 		}
-		if err := r.client.Get(r.ctx, key, s); err != nil {
-			return nil, fmt.Errorf("%s", err.Error())
+		if err := r.decoder.Unmarshal(extensionConfig.Value, res); err != nil {
+			return nil,
+				resourceLoaderError(
+					req, extensionConfig.Value, field.NewPath("spec", "resources").Child("extensionConfigs").Index(idx).Child("value"),
+					fmt.Sprintf("Invalid envoy resource value: '%s'", err),
+				)
 		}
+		snap.SetResource(extensionConfig.Name, res)
+	}
 
-		// Validate secret holds a certificate
-		if s.Type == "kubernetes.io/tls" {
-			res := r.generator.NewSecret(secret.Name, string(s.Data[secretPrivateKey]), string(s.Data[secretCertificate]))
-			snap.SetResource(secret.Name, res)
-		} else {
-			err := resourceLoaderError(
-				req, secret.Ref, field.NewPath("spec", "resources").Child("secrets").Index(idx).Child("ref"),
-				"Only 'kubernetes.io/tls' type secrets allowed",
-			)
-			return nil, fmt.Errorf("%s", err.Error())
+	for idx, scopedRoute := range resources.ScopedRoutes {
+		res := r.generator.New(envoy.ScopedRoute)
+		if res == nil {
+			return nil,
+				resourceLoaderError(
+					req, scopedRoute.Value, field.NewPath("spec", "resources").Child("scopedRoutes").Index(idx).Child("value"),
+					"ScopedRoute resources are only supported in envoy API v3",
+				)
+		}
+		if err := r.decoder.Unmarshal(scopedRoute.Value, res); err != nil {
+			return nil,
+				resourceLoaderError(
+					req, scopedRoute.Value, field.NewPath("spec", "resources").Child("scopedRoutes").Index(idx).Child("value"),
+					fmt.Sprintf("Invalid envoy resource value: '%s'", err),
+				)
+		}
+		snap.SetResource(scopedRoute.Name, res)
+	}
+
+	for idx, virtualHost := range resources.VirtualHosts {
+		res := r.generator.New(envoy.VirtualHost)
+		if res == nil {
+			return nil,
+				resourceLoaderError(
+					req, virtualHost.Value, field.NewPath("spec", "resources").Child("virtualHosts").Index(idx).Child("value"),
+					"VirtualHost resources are only supported in envoy API v3",
+				)
+		}
+		if err := r.decoder.Unmarshal(virtualHost.Value, res); err != nil {
+			return nil,
+				resourceLoaderError(
+					req, virtualHost.Value, field.NewPath("spec", "resources").Child("virtualHosts").Index(idx).Child("value"),
+					fmt.Sprintf("Invalid envoy resource value: '%s'", err),
+				)
+		}
+		snap.SetResource(virtualHost.Name, res)
+	}
 
+	for idx, secret := range resources.Secrets {
+		res, err := r.newSecretResource(req, secret, field.NewPath("spec", "resources").Child("secrets").Index(idx))
+		if err != nil {
+			return nil, err
 		}
+		snap.SetResource(secret.Name, res)
 	}
 
 	// Secrets are runtime calculated resourcesso its contents are not included in the spec. This means
@@ -162,10 +220,143 @@ func (r *CacheReconciler) GenerateSnapshot(req types.NamespacedName, resources *
 	secretsHash := util.Hash(snap.GetResources(envoy.Secret))
 	snap.SetVersion(envoy.Secret, fmt.Sprintf("%s-%s", version, secretsHash))
 
+	// Same as secrets, the endpoints generated from EndpointSlices change without changes in the spec. The
+	// hash of the endpoints is appended to their version so envoy gets EDS-only updates when they change.
+	if len(resources.ServiceEndpoints) > 0 {
+		endpointsHash := util.Hash(snap.GetResources(envoy.Endpoint))
+		snap.SetVersion(envoy.Endpoint, fmt.Sprintf("%s-%s", version, endpointsHash))
+	}
+
 	return snap, nil
 
 }
 
+// newSecretResource generates the envoy Secret resource of the given type from
+// the Kubernetes Secret or ConfigMap referenced by the EnvoySecretResource
+func (r *CacheReconciler) newSecretResource(req types.NamespacedName, secret marin3rv1alpha1.EnvoySecretResource, secretPath *field.Path) (envoy.Resource, error) {
+
+	secretType := secret.GetType()
+
+	if secret.ConfigMapRef != nil {
+		if secretType != marin3rv1alpha1.ValidationContextSecretType {
+			return nil, resourceLoaderError(req, secret.ConfigMapRef, secretPath.Child("configMapRef"),
+				fmt.Sprintf("ConfigMaps can only be used for '%s' type secrets", marin3rv1alpha1.ValidationContextSecretType))
+		}
+		if secret.Ref.Name != "" {
+			return nil, resourceLoaderError(req, secret.Ref, secretPath.Child("ref"), "Only one of 'ref' and 'configMapRef' can be set")
+		}
+
+		cm := &corev1.ConfigMap{}
+		key := types.NamespacedName{Name: secret.ConfigMapRef.Name, Namespace: secret.ConfigMapRef.Namespace}
+		if key.Namespace == "" {
+			key.Namespace = req.Namespace
+		}
+		if err := r.client.Get(r.ctx, key, cm); err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		data := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		caKey := marin3rv1alpha1.DefaultValidationContextKey
+		if len(secret.Keys) > 0 {
+			caKey = secret.Keys[0]
+		}
+		if _, ok := data[caKey]; !ok {
+			return nil, resourceLoaderError(req, secret.Keys, secretPath.Child("keys"),
+				fmt.Sprintf("Key '%s' not found in ConfigMap %s", caKey, key))
+		}
+		return r.generator.NewValidationContextSecret(secret.Name, string(data[caKey])), nil
+	}
+
+	s := &corev1.Secret{}
+	key := types.NamespacedName{
+		Name:      secret.Ref.Name,
+		Namespace: secret.Ref.Namespace,
+	}
+	if err := r.client.Get(r.ctx, key, s); err != nil {
+		return nil, fmt.Errorf("%s", err.Error())
+	}
+
+	// lookup returns the values of the given keys, failing if any of them is missing
+	lookup := func(keys ...string) ([]string, error) {
+		values := make([]string, 0, len(keys))
+		for _, k := range keys {
+			v, ok := s.Data[k]
+			if !ok {
+				return nil, resourceLoaderError(req, secret.Keys, secretPath.Child("keys"),
+					fmt.Sprintf("Key '%s' not found in Secret %s", k, key))
+			}
+			values = append(values, string(v))
+		}
+		return values, nil
+	}
+
+	switch secretType {
+
+	case marin3rv1alpha1.TLSCertificateSecretType:
+		// Validate secret holds a certificate
+		if s.Type != corev1.SecretTypeTLS {
+			return nil, resourceLoaderError(
+				req, secret.Ref, secretPath.Child("ref"),
+				"Only 'kubernetes.io/tls' type secrets allowed",
+			)
+		}
+		if len(secret.Keys) > 0 {
+			return nil, resourceLoaderError(req, secret.Keys, secretPath.Child("keys"),
+				fmt.Sprintf("Keys cannot be set for '%s' type secrets", secretType))
+		}
+		return r.generator.NewSecret(secret.Name, string(s.Data[secretPrivateKey]), string(s.Data[secretCertificate])), nil
+
+	case marin3rv1alpha1.ValidationContextSecretType:
+		caKey := marin3rv1alpha1.DefaultValidationContextKey
+		if len(secret.Keys) > 0 {
+			caKey = secret.Keys[0]
+		}
+		values, err := lookup(caKey)
+		if err != nil {
+			return nil, err
+		}
+		return r.generator.NewValidationContextSecret(secret.Name, values[0]), nil
+
+	case marin3rv1alpha1.GenericSecretType:
+		if len(secret.Keys) != 1 {
+			return nil, resourceLoaderError(req, secret.Keys, secretPath.Child("keys"),
+				fmt.Sprintf("Exactly one key must be set for '%s' type secrets", secretType))
+		}
+		values, err := lookup(secret.Keys...)
+		if err != nil {
+			return nil, err
+		}
+		return r.generator.NewGenericSecret(secret.Name, values[0]), nil
+
+	case marin3rv1alpha1.SessionTicketKeysSecretType:
+		keys := secret.Keys
+		if len(keys) == 0 {
+			for k := range s.Data {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+		}
+		if len(keys) == 0 {
+			return nil, resourceLoaderError(req, secret.Ref, secretPath.Child("ref"),
+				fmt.Sprintf("Secret %s holds no session ticket keys", key))
+		}
+		values, err := lookup(keys...)
+		if err != nil {
+			return nil, err
+		}
+		return r.generator.NewSessionTicketKeysSecret(secret.Name, values), nil
+
+	default:
+		return nil, resourceLoaderError(req, secret.Type, secretPath.Child("type"),
+			fmt.Sprintf("Unknown secret type '%s'", secretType))
+	}
+}
+
 func resourceLoaderError(req types.NamespacedName, value interface{}, resPath *field.Path, msg string) error {
 	return errors.NewInvalid(
 		schema.GroupKind{Group: "envoy", Kind: "EnvoyConfig"},
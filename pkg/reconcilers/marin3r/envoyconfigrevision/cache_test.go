@@ -31,8 +31,10 @@ import (
 	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
 	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/go-logr/logr"
+	"github.com/golang/protobuf/proto"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -524,6 +526,57 @@ func TestCacheReconciler_GenerateSnapshot(t *testing.T) {
 			wantErr: true,
 			want:    xdss_v2.NewSnapshot(&cache_v2.Snapshot{}),
 		},
+		{
+			name: "Loads v3 extension resources into the snapshot",
+			fields: fields{
+				ctx:       context.TODO(),
+				logger:    ctrl.Log.WithName("test"),
+				client:    fake.NewFakeClient(),
+				xdsCache:  xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)),
+				decoder:   envoy_serializer.NewResourceUnmarshaller(envoy_serializer.JSON, envoy.APIv3),
+				generator: envoy_resources_v3.Generator{},
+			},
+			args: args{
+				req: types.NamespacedName{Name: "xx", Namespace: "xx"},
+				resources: &marin3rv1alpha1.EnvoyResources{
+					ScopedRoutes: []marin3rv1alpha1.EnvoyResource{
+						{Name: "scope", Value: "{\"name\": \"scope\", \"route_configuration_name\": \"route\"}"},
+					},
+					VirtualHosts: []marin3rv1alpha1.EnvoyResource{
+						{Name: "vhost", Value: "{\"name\": \"vhost\", \"domains\": [\"example.com\"]}"},
+					}},
+				version: "xxxx",
+			},
+			want: func() xdss.Snapshot {
+				snap := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)).NewSnapshot("xxxx")
+				snap.SetResource("scope", &envoy_config_route_v3.ScopedRouteConfiguration{Name: "scope", RouteConfigurationName: "route"})
+				snap.SetResource("vhost", &envoy_config_route_v3.VirtualHost{Name: "vhost", Domains: []string{"example.com"}})
+				snap.SetVersion(envoy.Secret, "xxxx-557db659d4")
+				return snap
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "Error, extension resources with envoy API v2",
+			fields: fields{
+				ctx:       context.TODO(),
+				logger:    ctrl.Log.WithName("test"),
+				client:    fake.NewFakeClient(),
+				xdsCache:  xdss_v2.NewCache(cache_v2.NewSnapshotCache(true, cache_v2.IDHash{}, nil)),
+				decoder:   envoy_serializer.NewResourceUnmarshaller(envoy_serializer.JSON, envoy.APIv2),
+				generator: envoy_resources_v2.Generator{},
+			},
+			args: args{
+				req: types.NamespacedName{Name: "xx", Namespace: "xx"},
+				resources: &marin3rv1alpha1.EnvoyResources{
+					VirtualHosts: []marin3rv1alpha1.EnvoyResource{
+						{Name: "vhost", Value: "{\"name\": \"vhost\"}"},
+					}},
+				version: "xxxx",
+			},
+			wantErr: true,
+			want:    xdss_v2.NewSnapshot(&cache_v2.Snapshot{}),
+		},
 		{
 			name: "Loads secret resources into the snapshot (v2)",
 			fields: fields{
@@ -750,3 +803,107 @@ func Test_calculateSecretsHash(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheReconciler_newSecretResource(t *testing.T) {
+	objects := []runtime.Object{
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "default"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key"), "ca.crt": []byte("ca")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "default"},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       map[string][]byte{"hmac": []byte("hmac"), "ticket-b": []byte("b"), "ticket-a": []byte("a")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "bundle", Namespace: "test"},
+			Data:       map[string]string{"ca.crt": "ca", "other.crt": "other"},
+		},
+	}
+	g := envoy_resources_v3.Generator{}
+	tests := []struct {
+		name    string
+		secret  marin3rv1alpha1.EnvoySecretResource
+		want    envoy.Resource
+		wantErr bool
+	}{
+		{
+			name:   "Generates a TLS certificate by default",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Ref: corev1.SecretReference{Name: "tls", Namespace: "default"}},
+			want:   g.NewSecret("s", "key", "cert"),
+		},
+		{
+			name: "Fails for TLS certificates with non TLS secrets",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.TLSCertificateSecretType,
+				Ref: corev1.SecretReference{Name: "opaque", Namespace: "default"}},
+			wantErr: true,
+		},
+		{
+			name: "Generates a validation context from the ca.crt key of a Secret",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.ValidationContextSecretType,
+				Ref: corev1.SecretReference{Name: "tls", Namespace: "default"}},
+			want: g.NewValidationContextSecret("s", "ca"),
+		},
+		{
+			name: "Generates a validation context from a ConfigMap in the namespace of the EnvoyConfig",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.ValidationContextSecretType,
+				ConfigMapRef: &marin3rv1alpha1.ConfigMapReference{Name: "bundle"}, Keys: []string{"other.crt"}},
+			want: g.NewValidationContextSecret("s", "other"),
+		},
+		{
+			name: "Fails for ConfigMaps with other types",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.GenericSecretType,
+				ConfigMapRef: &marin3rv1alpha1.ConfigMapReference{Name: "bundle"}, Keys: []string{"ca.crt"}},
+			wantErr: true,
+		},
+		{
+			name: "Generates a generic secret",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.GenericSecretType,
+				Ref: corev1.SecretReference{Name: "opaque", Namespace: "default"}, Keys: []string{"hmac"}},
+			want: g.NewGenericSecret("s", "hmac"),
+		},
+		{
+			name: "Fails for generic secrets without key",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.GenericSecretType,
+				Ref: corev1.SecretReference{Name: "opaque", Namespace: "default"}},
+			wantErr: true,
+		},
+		{
+			name: "Fails for missing keys",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.GenericSecretType,
+				Ref: corev1.SecretReference{Name: "opaque", Namespace: "default"}, Keys: []string{"missing"}},
+			wantErr: true,
+		},
+		{
+			name: "Generates session ticket keys from all the keys in alphabetical order",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.SessionTicketKeysSecretType,
+				Ref: corev1.SecretReference{Name: "opaque", Namespace: "default"}},
+			want: g.NewSessionTicketKeysSecret("s", []string{"hmac", "a", "b"}),
+		},
+		{
+			name: "Generates session ticket keys from the given keys",
+			secret: marin3rv1alpha1.EnvoySecretResource{Name: "s", Type: marin3rv1alpha1.SessionTicketKeysSecretType,
+				Ref: corev1.SecretReference{Name: "opaque", Namespace: "default"}, Keys: []string{"ticket-b", "ticket-a"}},
+			want: g.NewSessionTicketKeysSecret("s", []string{"b", "a"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &CacheReconciler{
+				ctx:       context.TODO(),
+				logger:    ctrl.Log.WithName("test"),
+				client:    fake.NewFakeClient(objects...),
+				generator: g,
+			}
+			got, err := r.newSecretResource(types.NamespacedName{Name: "ec", Namespace: "test"}, tt.secret, field.NewPath("spec", "resources").Child("secrets").Index(0))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CacheReconciler.newSecretResource() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !proto.Equal(got, tt.want) {
+				t.Errorf("CacheReconciler.newSecretResource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package reconcilers
+
+import (
+	"fmt"
+	"sort"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newServiceEndpointsResource generates the envoy ClusterLoadAssignment resource with
+// the endpoints held in the EndpointSlices of the Service referenced by the EnvoyServiceEndpointsResource
+func (r *CacheReconciler) newServiceEndpointsResource(req types.NamespacedName,
+	se marin3rv1alpha1.EnvoyServiceEndpointsResource, sePath *field.Path) (envoy.Resource, error) {
+
+	list := &discoveryv1beta1.EndpointSliceList{}
+	if err := r.client.List(r.ctx, list, client.InNamespace(req.Namespace),
+		client.MatchingLabels{discoveryv1beta1.LabelServiceName: se.ServiceName}); err != nil {
+		return nil, fmt.Errorf("unable to list the EndpointSlices of Service %q: %w", se.ServiceName, err)
+	}
+
+	localities := map[locality]*envoy.LocalityEndpoints{}
+	seen := map[string]bool{}
+
+	for _, slice := range list.Items {
+		if slice.AddressType != discoveryv1beta1.AddressTypeIPv4 && slice.AddressType != discoveryv1beta1.AddressTypeIPv6 {
+			continue
+		}
+
+		port, err := endpointSlicePort(slice, se.PortName)
+		if err != nil {
+			return nil, resourceLoaderError(req, se.PortName, sePath.Child("portName"), err.Error())
+		}
+		if port == 0 {
+			continue
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			host, ok := upstreamHost(endpoint, port)
+			if !ok {
+				continue
+			}
+
+			key := localityOf(endpoint)
+			le, ok := localities[key]
+			if !ok {
+				le = &envoy.LocalityEndpoints{Region: key.region, Zone: key.zone}
+				localities[key] = le
+			}
+
+			for _, address := range endpoint.Addresses {
+				// Endpoints can be duplicated in several slices while the
+				// endpointslice controller moves them around
+				id := fmt.Sprintf("%s:%d", address, port)
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				host.Address = address
+				le.Hosts = append(le.Hosts, host)
+				if !host.Draining {
+					le.Weight++
+				}
+			}
+		}
+	}
+
+	// Sort localities and hosts so the generated resource,
+	// and therefore its hash, is always the same
+	sorted := make([]envoy.LocalityEndpoints, 0, len(localities))
+	for _, le := range localities {
+		hosts := le.Hosts
+		if len(hosts) == 0 {
+			continue
+		}
+		sort.Slice(hosts, func(i, j int) bool { return hosts[i].Address < hosts[j].Address })
+		sorted = append(sorted, *le)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Region != sorted[j].Region {
+			return sorted[i].Region < sorted[j].Region
+		}
+		return sorted[i].Zone < sorted[j].Zone
+	})
+
+	return r.generator.NewClusterLoadAssignment(se.Name, sorted), nil
+}
+
+// endpointSlicePort returns the number of the port with the given name in the
+// EndpointSlice, or 0 if there is none. An empty name matches the only port of
+// the slice, and it is an error if the slice has several ports.
+func endpointSlicePort(slice discoveryv1beta1.EndpointSlice, name string) (uint32, error) {
+
+	if name == "" && len(slice.Ports) > 1 {
+		return 0, fmt.Errorf("Service %s exposes several ports, a port name must be set", slice.GetLabels()[discoveryv1beta1.LabelServiceName])
+	}
+
+	for _, port := range slice.Ports {
+		if port.Port == nil {
+			continue
+		}
+		if (port.Name == nil && name == "") || (port.Name != nil && *port.Name == name) {
+			return uint32(*port.Port), nil
+		}
+	}
+
+	return 0, nil
+}
+
+// upstreamHost returns the UpstreamHost for the given endpoint, without the address. The ready
+// endpoints are healthy and the ones that are not ready but still serving, which are terminating,
+// are draining. False is returned for the endpoints that should not receive traffic.
+func upstreamHost(endpoint discoveryv1beta1.Endpoint, port uint32) (envoy.UpstreamHost, bool) {
+
+	// A nil ready condition must be interpreted as ready
+	if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+		return envoy.UpstreamHost{Port: port}, true
+	}
+	if endpoint.Conditions.Serving != nil && *endpoint.Conditions.Serving {
+		return envoy.UpstreamHost{Port: port, Draining: true}, true
+	}
+	return envoy.UpstreamHost{}, false
+}
+
+// locality identifies the region and zone of an endpoint
+type locality struct {
+	region string
+	zone   string
+}
+
+// localityOf returns the locality of an endpoint from its topology labels
+func localityOf(endpoint discoveryv1beta1.Endpoint) locality {
+	return locality{
+		region: endpoint.Topology[corev1.LabelTopologyRegion],
+		zone:   endpoint.Topology[corev1.LabelTopologyZone],
+	}
+}
@@ -0,0 +1,176 @@
+package reconcilers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_resources_v3 "github.com/3scale/marin3r/pkg/envoy/resources/v3"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/golang/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testEndpointSlice(name, service string, ports []discoveryv1beta1.EndpointPort, endpoints ...discoveryv1beta1.Endpoint) *discoveryv1beta1.EndpointSlice {
+	return &discoveryv1beta1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1beta1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1beta1.AddressTypeIPv4,
+		Ports:       ports,
+		Endpoints:   endpoints,
+	}
+}
+
+func testEndpoint(address, zone string, ready, serving bool) discoveryv1beta1.Endpoint {
+	return discoveryv1beta1.Endpoint{
+		Addresses:  []string{address},
+		Conditions: discoveryv1beta1.EndpointConditions{Ready: pointer.BoolPtr(ready), Serving: pointer.BoolPtr(serving)},
+		Topology:   map[string]string{corev1.LabelTopologyRegion: "region", corev1.LabelTopologyZone: zone},
+	}
+}
+
+func TestCacheReconciler_newServiceEndpointsResource(t *testing.T) {
+	httpPorts := []discoveryv1beta1.EndpointPort{
+		{Name: pointer.StringPtr("http"), Port: pointer.Int32Ptr(8080)},
+		{Name: pointer.StringPtr("admin"), Port: pointer.Int32Ptr(9090)},
+	}
+	objects := []runtime.Object{
+		testEndpointSlice("web-1", "web", httpPorts,
+			testEndpoint("10.0.0.2", "zone-a", true, true),
+			testEndpoint("10.0.0.1", "zone-a", true, true),
+			testEndpoint("10.0.1.1", "zone-b", false, true),
+			testEndpoint("10.0.1.2", "zone-b", false, false),
+		),
+		// Endpoints duplicated while they move between slices
+		testEndpointSlice("web-2", "web", httpPorts,
+			testEndpoint("10.0.0.1", "zone-a", true, true),
+			testEndpoint("10.0.1.3", "zone-b", true, true),
+		),
+		testEndpointSlice("single-1", "single", []discoveryv1beta1.EndpointPort{{Port: pointer.Int32Ptr(80)}},
+			discoveryv1beta1.Endpoint{Addresses: []string{"10.0.2.1"}},
+		),
+	}
+	g := envoy_resources_v3.Generator{}
+	tests := []struct {
+		name    string
+		se      marin3rv1alpha1.EnvoyServiceEndpointsResource
+		want    envoy.Resource
+		wantErr bool
+	}{
+		{
+			name: "Generates the endpoints of the named port grouped by locality",
+			se:   marin3rv1alpha1.EnvoyServiceEndpointsResource{Name: "web", ServiceName: "web", PortName: "http"},
+			want: g.NewClusterLoadAssignment("web", []envoy.LocalityEndpoints{
+				{Region: "region", Zone: "zone-a", Weight: 2, Hosts: []envoy.UpstreamHost{
+					{Address: "10.0.0.1", Port: 8080}, {Address: "10.0.0.2", Port: 8080},
+				}},
+				{Region: "region", Zone: "zone-b", Weight: 1, Hosts: []envoy.UpstreamHost{
+					{Address: "10.0.1.1", Port: 8080, Draining: true}, {Address: "10.0.1.3", Port: 8080},
+				}},
+			}),
+		},
+		{
+			name: "Generates the endpoints of the only port of the Service",
+			se:   marin3rv1alpha1.EnvoyServiceEndpointsResource{Name: "single", ServiceName: "single"},
+			want: g.NewClusterLoadAssignment("single", []envoy.LocalityEndpoints{
+				{Weight: 1, Hosts: []envoy.UpstreamHost{{Address: "10.0.2.1", Port: 80}}},
+			}),
+		},
+		{
+			name: "Generates no endpoints for unknown ports",
+			se:   marin3rv1alpha1.EnvoyServiceEndpointsResource{Name: "web", ServiceName: "web", PortName: "grpc"},
+			want: g.NewClusterLoadAssignment("web", []envoy.LocalityEndpoints{}),
+		},
+		{
+			name: "Generates no endpoints for Services without EndpointSlices",
+			se:   marin3rv1alpha1.EnvoyServiceEndpointsResource{Name: "missing", ServiceName: "missing"},
+			want: g.NewClusterLoadAssignment("missing", []envoy.LocalityEndpoints{}),
+		},
+		{
+			name:    "Fails without port name for Services with several ports",
+			se:      marin3rv1alpha1.EnvoyServiceEndpointsResource{Name: "web", ServiceName: "web"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &CacheReconciler{
+				ctx:       context.TODO(),
+				logger:    ctrl.Log.WithName("test"),
+				client:    fake.NewFakeClient(objects...),
+				generator: g,
+			}
+			got, err := r.newServiceEndpointsResource(types.NamespacedName{Name: "ec", Namespace: "default"}, tt.se,
+				field.NewPath("spec", "resources").Child("serviceEndpoints").Index(0))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CacheReconciler.newServiceEndpointsResource() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !proto.Equal(got, tt.want) {
+				t.Errorf("CacheReconciler.newServiceEndpointsResource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheReconciler_GenerateSnapshot_ServiceEndpoints(t *testing.T) {
+	resources := &marin3rv1alpha1.EnvoyResources{
+		ServiceEndpoints: []marin3rv1alpha1.EnvoyServiceEndpointsResource{{Name: "web", ServiceName: "web"}},
+	}
+	ports := []discoveryv1beta1.EndpointPort{{Port: pointer.Int32Ptr(8080)}}
+	newReconciler := func(objects ...runtime.Object) *CacheReconciler {
+		return &CacheReconciler{
+			ctx:       context.TODO(),
+			logger:    ctrl.Log.WithName("test"),
+			client:    fake.NewFakeClient(objects...),
+			xdsCache:  xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)),
+			decoder:   envoy_serializer.NewResourceUnmarshaller(envoy_serializer.JSON, envoy.APIv3),
+			generator: envoy_resources_v3.Generator{},
+		}
+	}
+	req := types.NamespacedName{Name: "ec", Namespace: "default"}
+
+	snap1, err := newReconciler(testEndpointSlice("web-1", "web", ports, testEndpoint("10.0.0.1", "zone-a", true, true))).
+		GenerateSnapshot(req, resources, "xxxx")
+	if err != nil {
+		t.Fatalf("CacheReconciler.GenerateSnapshot() error = %v", err)
+	}
+	snap2, err := newReconciler(testEndpointSlice("web-1", "web", ports, testEndpoint("10.0.0.2", "zone-a", true, true))).
+		GenerateSnapshot(req, resources, "xxxx")
+	if err != nil {
+		t.Fatalf("CacheReconciler.GenerateSnapshot() error = %v", err)
+	}
+
+	if !strings.HasPrefix(snap1.GetVersion(envoy.Endpoint), "xxxx-") {
+		t.Errorf("CacheReconciler.GenerateSnapshot() endpoints version = %v, want the hash of the endpoints appended", snap1.GetVersion(envoy.Endpoint))
+	}
+	if snap1.GetVersion(envoy.Endpoint) == snap2.GetVersion(envoy.Endpoint) {
+		t.Errorf("CacheReconciler.GenerateSnapshot() endpoints version did not change with the endpoints")
+	}
+	if snap1.GetVersion(envoy.Cluster) != "xxxx" || snap2.GetVersion(envoy.Cluster) != "xxxx" {
+		t.Errorf("CacheReconciler.GenerateSnapshot() changed the version of other resource types")
+	}
+
+	_, err = newReconciler().GenerateSnapshot(req, &marin3rv1alpha1.EnvoyResources{
+		Endpoints:        []marin3rv1alpha1.EnvoyResource{{Name: "web", Value: `{"cluster_name": "web"}`}},
+		ServiceEndpoints: resources.ServiceEndpoints,
+	}, "xxxx")
+	if err == nil {
+		t.Errorf("CacheReconciler.GenerateSnapshot() did not fail for duplicated endpoint names")
+	}
+}
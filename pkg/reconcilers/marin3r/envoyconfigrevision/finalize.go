@@ -8,8 +8,12 @@ import (
 
 // CleanupLogic executes finalization code for EnvoyConfigRevision resources
 func CleanupLogic(ecr *marin3rv1alpha1.EnvoyConfigRevision, xdssCache xdss.Cache, log logr.Logger) {
+	if key, ok := SnapshotKey(ecr); ok {
+		xdssCache.ClearSnapshot(key)
+		log.Info("Successfully cleared xDS server cache", "XDSS", string(ecr.GetEnvoyAPIVersion()), "NodeID", key)
+	}
 	if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) {
-		xdssCache.ClearSnapshot(ecr.Spec.NodeID)
-		log.Info("Successfully cleared xDS server cache", "XDSS", string(ecr.GetEnvoyAPIVersion()), "NodeID", ecr.Spec.NodeID)
+		// The canary snapshot of the last promoted revision is kept until the nodeID goes away
+		xdssCache.ClearSnapshot(xdss.CanaryKey(ecr.Spec.NodeID))
 	}
 }
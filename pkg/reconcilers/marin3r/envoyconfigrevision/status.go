@@ -2,10 +2,15 @@ package reconcilers
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strings"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"github.com/3scale/marin3r/pkg/discoveryservice/registry"
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_lint "github.com/3scale/marin3r/pkg/envoy/lint"
 	"github.com/operator-framework/operator-lib/status"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
@@ -13,8 +18,11 @@ import (
 	"k8s.io/utils/pointer"
 )
 
-// IsStatusReconciled calculates the status of the resource
-func IsStatusReconciled(ecr *marin3rv1alpha1.EnvoyConfigRevision, xdssCache xdss.Cache) bool {
+// IsStatusReconciled calculates the status of the resource. The status of the
+// connected clients is only calculated if clientRegistry is not nil, and only the
+// clients of the given discovery service replica are updated.
+func IsStatusReconciled(ecr *marin3rv1alpha1.EnvoyConfigRevision, xdssCache xdss.Cache,
+	clientRegistry *registry.Registry, replica string) bool {
 
 	ok := true
 
@@ -32,6 +40,15 @@ func IsStatusReconciled(ecr *marin3rv1alpha1.EnvoyConfigRevision, xdssCache xdss
 		}
 	}
 
+	lintCond := calculateResourcesLintPassedCondition(ecr, xdssCache)
+	if lintCond != nil {
+		current := ecr.Status.Conditions.GetCondition(marin3rv1alpha1.ResourcesLintPassedCondition)
+		if current == nil || current.Status != lintCond.Status || current.Reason != lintCond.Reason || current.Message != lintCond.Message {
+			ecr.Status.Conditions.SetCondition(*lintCond)
+			ok = false
+		}
+	}
+
 	// Set status.published and status.lastPublishedAt fields
 	if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) && !ecr.Status.IsPublished() {
 		ecr.Status.Published = pointer.BoolPtr(true)
@@ -51,21 +68,75 @@ func IsStatusReconciled(ecr *marin3rv1alpha1.EnvoyConfigRevision, xdssCache xdss
 		ok = false
 	}
 
+	// Set status.clients field
+	if clientRegistry != nil && !IsClientsStatusReconciled(ecr, clientRegistry, replica) {
+		ok = false
+	}
+
 	return ok
 }
 
-func calculateResourcesInSyncCondition(ecr *marin3rv1alpha1.EnvoyConfigRevision, xdssCache xdss.Cache) *status.Condition {
+// IsClientsStatusReconciled calculates the status.clients field of the resource for the
+// clients connected to the given discovery service replica, keeping the clients reported
+// by other replicas.
+func IsClientsStatusReconciled(ecr *marin3rv1alpha1.EnvoyConfigRevision, clientRegistry *registry.Registry, replica string) bool {
+	key, _ := SnapshotKey(ecr)
+	clients := calculateClientsStatus(ecr, replica, clientRegistry.Clients(key, ecr.GetEnvoyAPIVersion()))
+	if !equality.Semantic.DeepEqual(ecr.Status.Clients, clients) {
+		ecr.Status.Clients = clients
+		return false
+	}
+	return true
+}
+
+// PruneClientsStatus removes from status.clients the clients reported by the discovery
+// service replicas for which isAlive returns false. Returns true if any client is removed. The
+// last reported status of a revision that is no longer published is kept as is.
+func PruneClientsStatus(ecr *marin3rv1alpha1.EnvoyConfigRevision, isAlive func(replica string) bool) bool {
+	if _, ok := SnapshotKey(ecr); !ok || ecr.Status.Clients == nil {
+		return false
+	}
+
+	details := []marin3rv1alpha1.ClientStatus{}
+	for _, client := range ecr.Status.Clients.Details {
+		if isAlive(client.Replica) {
+			details = append(details, client)
+		}
+	}
+	if len(details) == len(ecr.Status.Clients.Details) {
+		return false
+	}
+
+	ecr.Status.Clients = summarizeClients(ecr.Spec.Version, details)
+	return true
+}
 
+// SnapshotKey returns the key of the xDS server cache snapshot the revision is published
+// to, which is the nodeID for the published revision and the canary key of the nodeID for
+// the revision being rolled out to the canaries. False is returned for other revisions.
+func SnapshotKey(ecr *marin3rv1alpha1.EnvoyConfigRevision) (string, bool) {
 	if ecr.Status.Conditions.IsTrueFor(marin3rv1alpha1.RevisionPublishedCondition) {
-		// Check what is currently written in the xds server cache
-		snap, err := xdssCache.GetSnapshot(ecr.Spec.NodeID)
+		return ecr.Spec.NodeID, true
+	}
+	if ecr.Status.IsCanary() {
+		return xdss.CanaryKey(ecr.Spec.NodeID), true
+	}
+	return ecr.Spec.NodeID, false
+}
+
+func calculateResourcesInSyncCondition(ecr *marin3rv1alpha1.EnvoyConfigRevision, xdssCache xdss.Cache) *status.Condition {
+
+	if key, ok := SnapshotKey(ecr); ok {
+		// Check what is currently written in the xds server cache, including
+		// the snapshots that are still waiting to be published
+		snap, err := xdss.GetLatestSnapshot(xdssCache, key)
 		// OutOfSync if NodeID not found or resources version different that expected
 		if err != nil {
 			return &status.Condition{
 				Type:    marin3rv1alpha1.ResourcesInSyncCondition,
 				Reason:  "SnapshotDoesNotExist",
 				Status:  corev1.ConditionFalse,
-				Message: fmt.Sprintf("A snapshot for nodeID %q does not yet exist in the xDS server cache", ecr.Spec.NodeID),
+				Message: fmt.Sprintf("A snapshot for nodeID %q does not yet exist in the xDS server cache", key),
 			}
 		}
 
@@ -74,7 +145,7 @@ func calculateResourcesInSyncCondition(ecr *marin3rv1alpha1.EnvoyConfigRevision,
 				Type:    marin3rv1alpha1.ResourcesInSyncCondition,
 				Reason:  "SnapshotVersionDiffers",
 				Status:  corev1.ConditionFalse,
-				Message: fmt.Sprintf("The snapshot for nodeID %q holds resources version %q", ecr.Spec.NodeID, snap.GetVersion(envoy.Cluster)),
+				Message: fmt.Sprintf("The snapshot for nodeID %q holds resources version %q", key, snap.GetVersion(envoy.Cluster)),
 			}
 		}
 
@@ -88,3 +159,156 @@ func calculateResourcesInSyncCondition(ecr *marin3rv1alpha1.EnvoyConfigRevision,
 
 	return nil
 }
+
+// maxLintIssuesInMessage is the maximum number of lint issues
+// listed in the message of the ResourcesLintPassed condition
+const maxLintIssuesInMessage = 10
+
+// calculateResourcesLintPassedCondition lints the resources of the revision that are
+// currently in the xDS server cache. Nil is returned if the revision is not published to
+// the cache, so the last calculated condition is kept.
+func calculateResourcesLintPassedCondition(ecr *marin3rv1alpha1.EnvoyConfigRevision, xdssCache xdss.Cache) *status.Condition {
+
+	key, ok := SnapshotKey(ecr)
+	if !ok {
+		return nil
+	}
+	snap, err := xdss.GetLatestSnapshot(xdssCache, key)
+	if err != nil || snap.GetVersion(envoy.Cluster) != ecr.Spec.Version {
+		return nil
+	}
+
+	resources := envoy_lint.Resources{}
+	for _, rType := range []envoy.Type{envoy.Endpoint, envoy.Cluster, envoy.Route, envoy.ScopedRoute, envoy.VirtualHost,
+		envoy.Listener, envoy.Secret, envoy.Runtime, envoy.ExtensionConfig} {
+		resources[rType] = snap.GetResources(rType)
+	}
+
+	issues, err := envoy_lint.Lint(ecr.GetEnvoyAPIVersion(), resources)
+	if err != nil {
+		return &status.Condition{
+			Type:    marin3rv1alpha1.ResourcesLintPassedCondition,
+			Reason:  marin3rv1alpha1.LintErrorsReason,
+			Status:  corev1.ConditionFalse,
+			Message: fmt.Sprintf("Unable to lint the resources: %s", err),
+		}
+	}
+
+	cond := &status.Condition{
+		Type:    marin3rv1alpha1.ResourcesLintPassedCondition,
+		Reason:  marin3rv1alpha1.LintPassedReason,
+		Status:  corev1.ConditionTrue,
+		Message: "No issues found in the resources",
+	}
+	if len(issues) == 0 {
+		return cond
+	}
+
+	if errors := issues.Errors(); len(errors) > 0 {
+		cond.Reason = marin3rv1alpha1.LintErrorsReason
+		cond.Status = corev1.ConditionFalse
+	} else {
+		cond.Reason = marin3rv1alpha1.LintWarningsReason
+	}
+
+	msgs := []string{}
+	for i, issue := range issues {
+		if i == maxLintIssuesInMessage {
+			msgs = append(msgs, fmt.Sprintf("and %d more", len(issues)-i))
+			break
+		}
+		msgs = append(msgs, issue.String())
+	}
+	cond.Message = fmt.Sprintf("%d errors and %d warnings found: %s",
+		len(issues.Errors()), len(issues.Warnings()), strings.Join(msgs, "; "))
+
+	return cond
+}
+
+// calculateClientsStatus returns the status of the clients connected to the given discovery service
+// replica, along with the clients reported by the other replicas. The last calculated status is
+// returned if the revision is not published to the cache, so the NACKs that made a revision be
+// rolled back can still be inspected.
+func calculateClientsStatus(ecr *marin3rv1alpha1.EnvoyConfigRevision, replica string, clients []registry.Client) *marin3rv1alpha1.ClientsStatus {
+
+	if _, ok := SnapshotKey(ecr); !ok {
+		return ecr.Status.Clients
+	}
+
+	// Keep the clients reported by other replicas
+	details := []marin3rv1alpha1.ClientStatus{}
+	if ecr.Status.Clients != nil {
+		for _, client := range ecr.Status.Clients.Details {
+			if client.Replica != replica {
+				details = append(details, client)
+			}
+		}
+	}
+
+	for _, client := range clients {
+		nacked, msg, nackedAt := client.NackedVersion(xdss.ConfigVersion)
+		cs := marin3rv1alpha1.ClientStatus{
+			StreamID:    client.Key.String(),
+			Replica:     replica,
+			NodeID:      client.NodeID,
+			PeerAddress: client.PeerAddress,
+			// Status timestamps only have seconds precision
+			ConnectedAt:   metav1.NewTime(client.ConnectedAt).Rfc3339Copy(),
+			AckedVersion:  client.AckedVersion(xdss.ConfigVersion),
+			NackedVersion: nacked,
+			NackMessage:   msg,
+		}
+		if nacked != "" {
+			t := metav1.NewTime(nackedAt).Rfc3339Copy()
+			cs.NackedAt = &t
+		}
+		cs.InSync = cs.AckedVersion == ecr.Spec.Version
+		details = append(details, cs)
+	}
+
+	// Sort by replica so all the replicas write the clients in the same order
+	sort.SliceStable(details, func(i, j int) bool { return details[i].Replica < details[j].Replica })
+
+	return summarizeClients(ecr.Spec.Version, details)
+}
+
+// summarizeClients counts the clients in the given stream details. An envoy can open several
+// streams, one per resource type when it does not use ADS, so the streams are grouped by the
+// IP address and node ID of the client. A client is in sync when all its streams are, and has
+// NACKed the version when any of its streams has.
+func summarizeClients(version string, details []marin3rv1alpha1.ClientStatus) *marin3rv1alpha1.ClientsStatus {
+	inSync := map[string]bool{}
+	nacked := map[string]bool{}
+	for _, stream := range details {
+		key := clientKey(stream)
+		if synced, ok := inSync[key]; !ok || synced {
+			inSync[key] = stream.InSync
+		}
+		nacked[key] = nacked[key] || stream.NackedVersion == version
+	}
+
+	cs := &marin3rv1alpha1.ClientsStatus{Connected: len(inSync)}
+	for key := range inSync {
+		if inSync[key] {
+			cs.InSync++
+		}
+		if nacked[key] {
+			cs.Nacked++
+		}
+	}
+	if len(details) > 0 {
+		cs.Details = details
+	}
+	cs.Summary = fmt.Sprintf("%d/%d clients on version %s", cs.InSync, cs.Connected, version)
+	return cs
+}
+
+// clientKey returns the key that identifies the client that opened a stream. Streams
+// without a known peer address can't be grouped and are counted as separate clients.
+func clientKey(stream marin3rv1alpha1.ClientStatus) string {
+	host, _, err := net.SplitHostPort(stream.PeerAddress)
+	if err != nil || host == "" {
+		return stream.Replica + "/" + stream.StreamID
+	}
+	return host + "/" + stream.NodeID
+}
@@ -2,14 +2,21 @@ package reconcilers
 
 import (
 	"testing"
+	"time"
 
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	"github.com/3scale/marin3r/pkg/discoveryservice/registry"
 	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
 	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
+	"github.com/3scale/marin3r/pkg/envoy"
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resource_v3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 	"github.com/operator-framework/operator-lib/status"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
 )
@@ -83,6 +90,12 @@ func TestIsStatusReconciled(t *testing.T) {
 									Reason:  "EnvoyConficRevisionResourcesSynced",
 									Message: "EnvoyConfigRevision resources successfully synced with xDS server cache",
 								},
+								{
+									Type:    marin3rv1alpha1.ResourcesLintPassedCondition,
+									Status:  corev1.ConditionTrue,
+									Reason:  marin3rv1alpha1.LintPassedReason,
+									Message: "No issues found in the resources",
+								},
 							},
 						},
 					}
@@ -181,7 +194,7 @@ func TestIsStatusReconciled(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ecr := tt.args.envoyConfigRevisionFactory()
-			if got := IsStatusReconciled(ecr, tt.args.xdssCacheFactory()); got != tt.want {
+			if got := IsStatusReconciled(ecr, tt.args.xdssCacheFactory(), nil, ""); got != tt.want {
 				t.Errorf("IsStatusReconciled() = %v, want %v", got, tt.want)
 			}
 		})
@@ -218,6 +231,27 @@ func Test_calculateResourcesInSyncCondition(t *testing.T) {
 			},
 			want: corev1.ConditionTrue,
 		},
+		{
+			name: "Returns condition true for a canary revision in the canary snapshot",
+			args: args{
+				envoyConfigRevisionFactory: func() *marin3rv1alpha1.EnvoyConfigRevision {
+					return &marin3rv1alpha1.EnvoyConfigRevision{
+						Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{
+							Version: "xxxx",
+							NodeID:  "test",
+						},
+						Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+							Conditions: status.Conditions{
+								{Type: marin3rv1alpha1.RevisionCanaryCondition, Status: corev1.ConditionTrue},
+							},
+							Canary: &marin3rv1alpha1.CanaryRollout{},
+						},
+					}
+				},
+				xdssCacheFactory: testCacheGenerator(xdss.CanaryKey("test"), "xxxx"),
+			},
+			want: corev1.ConditionTrue,
+		},
 		{
 			name: "Returns condition false if snapshot not found for spec.nodeID",
 			args: args{
@@ -270,3 +304,338 @@ func Test_calculateResourcesInSyncCondition(t *testing.T) {
 		})
 	}
 }
+
+func Test_calculateResourcesLintPassedCondition(t *testing.T) {
+	route := &envoy_config_route_v3.RouteConfiguration{
+		Name: "route",
+		VirtualHosts: []*envoy_config_route_v3.VirtualHost{{
+			Name:    "vhost",
+			Domains: []string{"*"},
+			Routes: []*envoy_config_route_v3.Route{
+				{
+					Match:  &envoy_config_route_v3.RouteMatch{PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: "/"}},
+					Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{ClusterSpecifier: &envoy_config_route_v3.RouteAction_Cluster{Cluster: "cluster"}}},
+				},
+				{
+					Match:  &envoy_config_route_v3.RouteMatch{PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: "/api"}},
+					Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{ClusterSpecifier: &envoy_config_route_v3.RouteAction_Cluster{Cluster: "cluster"}}},
+				},
+			},
+		}},
+	}
+	cacheWith := func(version string, clusters ...cache_types.Resource) func() xdss.Cache {
+		return func() xdss.Cache {
+			cache := xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil))
+			snap := cache_v3.NewSnapshot(version,
+				[]cache_types.Resource{},
+				clusters,
+				[]cache_types.Resource{route},
+				[]cache_types.Resource{},
+				[]cache_types.Resource{},
+				[]cache_types.Resource{},
+			)
+			cache.SetSnapshot("test", xdss_v3.NewSnapshot(&snap))
+			return cache
+		}
+	}
+	published := func() *marin3rv1alpha1.EnvoyConfigRevision {
+		return &marin3rv1alpha1.EnvoyConfigRevision{
+			Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "xxxx", NodeID: "test"},
+			Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+				Conditions: status.Conditions{
+					{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name                       string
+		envoyConfigRevisionFactory func() *marin3rv1alpha1.EnvoyConfigRevision
+		xdssCacheFactory           func() xdss.Cache
+		wantStatus                 corev1.ConditionStatus
+		wantReason                 status.ConditionReason
+		wantNil                    bool
+	}{
+		{
+			name:                       "Returns condition false on lint errors",
+			envoyConfigRevisionFactory: published,
+			xdssCacheFactory:           cacheWith("xxxx"),
+			wantStatus:                 corev1.ConditionFalse,
+			wantReason:                 marin3rv1alpha1.LintErrorsReason,
+		},
+		{
+			name:                       "Returns condition true on lint warnings",
+			envoyConfigRevisionFactory: published,
+			xdssCacheFactory:           cacheWith("xxxx", &envoy_config_cluster_v3.Cluster{Name: "cluster"}),
+			wantStatus:                 corev1.ConditionTrue,
+			wantReason:                 marin3rv1alpha1.LintWarningsReason,
+		},
+		{
+			name:                       "Returns nil if the snapshot holds another version",
+			envoyConfigRevisionFactory: published,
+			xdssCacheFactory:           cacheWith("zzzz"),
+			wantNil:                    true,
+		},
+		{
+			name: "Returns nil if the revision is not published",
+			envoyConfigRevisionFactory: func() *marin3rv1alpha1.EnvoyConfigRevision {
+				return &marin3rv1alpha1.EnvoyConfigRevision{Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "xxxx", NodeID: "test"}}
+			},
+			xdssCacheFactory: cacheWith("xxxx"),
+			wantNil:          true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calculateResourcesLintPassedCondition(tt.envoyConfigRevisionFactory(), tt.xdssCacheFactory())
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("calculateResourcesLintPassedCondition() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Status != tt.wantStatus || got.Reason != tt.wantReason {
+				t.Errorf("calculateResourcesLintPassedCondition() = %v, want status %v and reason %v", got, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_calculateClientsStatus(t *testing.T) {
+	connectedAt := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	nackedAt := metav1.NewTime(connectedAt)
+	published := &marin3rv1alpha1.EnvoyConfigRevision{
+		Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{
+			Version:  "xxxx",
+			NodeID:   "test",
+			EnvoyAPI: pointer.StringPtr(string(envoy.APIv3)),
+		},
+		Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+			Conditions: status.Conditions{
+				{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		ecr     *marin3rv1alpha1.EnvoyConfigRevision
+		replica string
+		clients []registry.Client
+		want    *marin3rv1alpha1.ClientsStatus
+	}{
+		{
+			name: "Reports the clients in sync",
+			ecr:  published,
+			clients: []registry.Client{
+				{
+					Key: registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: 1}, NodeID: "test", PeerAddress: "10.0.0.1:5000", ConnectedAt: connectedAt,
+					Types: []registry.TypeStatus{
+						{TypeURL: resource_v3.ClusterType, AckedVersion: "xxxx"},
+						{TypeURL: resource_v3.SecretType, AckedVersion: "xxxx-yyyy"},
+					},
+				},
+				{
+					Key: registry.StreamKey{API: envoy.APIv3, Kind: registry.Delta, ID: 1}, NodeID: "test", PeerAddress: "10.0.0.2:5000", ConnectedAt: connectedAt,
+					Types: []registry.TypeStatus{
+						{TypeURL: resource_v3.ClusterType, AckedVersion: "zzzz"},
+						{TypeURL: resource_v3.ListenerType, AckedVersion: "zzzz", NackedVersion: "xxxx", NackMessage: "error", NackedAt: connectedAt, LastUpdate: connectedAt},
+					},
+				},
+			},
+			want: &marin3rv1alpha1.ClientsStatus{
+				Connected: 2,
+				InSync:    1,
+				Nacked:    1,
+				Summary:   "1/2 clients on version xxxx",
+				Details: []marin3rv1alpha1.ClientStatus{
+					{StreamID: "v3/sotw/1", NodeID: "test", PeerAddress: "10.0.0.1:5000", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+					{StreamID: "v3/delta/1", NodeID: "test", PeerAddress: "10.0.0.2:5000", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "zzzz", NackedVersion: "xxxx", NackMessage: "error", NackedAt: &nackedAt, InSync: false},
+				},
+			},
+		},
+		{
+			name:    "Reports no clients connected",
+			ecr:     published,
+			clients: []registry.Client{},
+			want:    &marin3rv1alpha1.ClientsStatus{Connected: 0, InSync: 0, Summary: "0/0 clients on version xxxx"},
+		},
+		{
+			name: "Keeps the clients of other replicas",
+			ecr: func() *marin3rv1alpha1.EnvoyConfigRevision {
+				ecr := published.DeepCopy()
+				ecr.Status.Clients = &marin3rv1alpha1.ClientsStatus{
+					Details: []marin3rv1alpha1.ClientStatus{
+						{StreamID: "v3/sotw/1", Replica: "ds-a", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+						{StreamID: "v3/sotw/1", Replica: "ds-b", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "zzzz"},
+						{StreamID: "v3/sotw/1", Replica: "ds-c", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+					},
+				}
+				return ecr
+			}(),
+			replica: "ds-b",
+			clients: []registry.Client{
+				{
+					Key: registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: 2}, NodeID: "test", ConnectedAt: connectedAt,
+					Types: []registry.TypeStatus{{TypeURL: resource_v3.ClusterType, AckedVersion: "xxxx"}},
+				},
+			},
+			want: &marin3rv1alpha1.ClientsStatus{
+				Connected: 3,
+				InSync:    3,
+				Summary:   "3/3 clients on version xxxx",
+				Details: []marin3rv1alpha1.ClientStatus{
+					{StreamID: "v3/sotw/1", Replica: "ds-a", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+					{StreamID: "v3/sotw/2", Replica: "ds-b", NodeID: "test", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+					{StreamID: "v3/sotw/1", Replica: "ds-c", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+				},
+			},
+		},
+		{
+			name: "Counts the streams of the same envoy as a single client",
+			ecr:  published,
+			clients: []registry.Client{
+				{
+					Key: registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: 1}, NodeID: "test", PeerAddress: "10.0.0.1:5000", ConnectedAt: connectedAt,
+					Types: []registry.TypeStatus{{TypeURL: resource_v3.ClusterType, AckedVersion: "xxxx"}},
+				},
+				{
+					Key: registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: 2}, NodeID: "test", PeerAddress: "10.0.0.1:5001", ConnectedAt: connectedAt,
+					Types: []registry.TypeStatus{{TypeURL: resource_v3.ListenerType, AckedVersion: "zzzz", NackedVersion: "xxxx", NackMessage: "error", NackedAt: connectedAt, LastUpdate: connectedAt}},
+				},
+				{
+					Key: registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: 3}, NodeID: "test", PeerAddress: "10.0.0.2:5000", ConnectedAt: connectedAt,
+					Types: []registry.TypeStatus{{TypeURL: resource_v3.ClusterType, AckedVersion: "xxxx"}},
+				},
+				{
+					Key: registry.StreamKey{API: envoy.APIv3, Kind: registry.SotW, ID: 4}, NodeID: "test", PeerAddress: "10.0.0.2:5001", ConnectedAt: connectedAt,
+					Types: []registry.TypeStatus{{TypeURL: resource_v3.ListenerType, AckedVersion: "xxxx"}},
+				},
+			},
+			want: &marin3rv1alpha1.ClientsStatus{
+				Connected: 2,
+				InSync:    1,
+				Nacked:    1,
+				Summary:   "1/2 clients on version xxxx",
+				Details: []marin3rv1alpha1.ClientStatus{
+					{StreamID: "v3/sotw/1", NodeID: "test", PeerAddress: "10.0.0.1:5000", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+					{StreamID: "v3/sotw/2", NodeID: "test", PeerAddress: "10.0.0.1:5001", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "zzzz", NackedVersion: "xxxx", NackMessage: "error", NackedAt: &nackedAt, InSync: false},
+					{StreamID: "v3/sotw/3", NodeID: "test", PeerAddress: "10.0.0.2:5000", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+					{StreamID: "v3/sotw/4", NodeID: "test", PeerAddress: "10.0.0.2:5001", ConnectedAt: metav1.NewTime(connectedAt), AckedVersion: "xxxx", InSync: true},
+				},
+			},
+		},
+		{
+			name:    "Returns nil if the revision has never been published",
+			ecr:     &marin3rv1alpha1.EnvoyConfigRevision{Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "xxxx", NodeID: "test"}},
+			clients: []registry.Client{{Key: registry.StreamKey{API: envoy.APIv2, Kind: registry.SotW, ID: 1}, NodeID: "test"}},
+			want:    nil,
+		},
+		{
+			name: "Keeps the last status if the revision is no longer published",
+			ecr: &marin3rv1alpha1.EnvoyConfigRevision{
+				Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "xxxx", NodeID: "test"},
+				Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+					Clients: &marin3rv1alpha1.ClientsStatus{
+						Connected: 1,
+						Nacked:    1,
+						Summary:   "0/1 clients on version xxxx",
+						Details: []marin3rv1alpha1.ClientStatus{
+							{StreamID: "v3/sotw/1", NodeID: "test", PeerAddress: "10.0.0.1:5000", ConnectedAt: metav1.NewTime(connectedAt),
+								NackedVersion: "xxxx", NackMessage: "error", NackedAt: &nackedAt},
+						},
+					},
+				},
+			},
+			clients: []registry.Client{},
+			want: &marin3rv1alpha1.ClientsStatus{
+				Connected: 1,
+				Nacked:    1,
+				Summary:   "0/1 clients on version xxxx",
+				Details: []marin3rv1alpha1.ClientStatus{
+					{StreamID: "v3/sotw/1", NodeID: "test", PeerAddress: "10.0.0.1:5000", ConnectedAt: metav1.NewTime(connectedAt),
+						NackedVersion: "xxxx", NackMessage: "error", NackedAt: &nackedAt},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateClientsStatus(tt.ecr, tt.replica, tt.clients); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("calculateClientsStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneClientsStatus(t *testing.T) {
+	ecr := &marin3rv1alpha1.EnvoyConfigRevision{
+		Spec: marin3rv1alpha1.EnvoyConfigRevisionSpec{Version: "xxxx"},
+		Status: marin3rv1alpha1.EnvoyConfigRevisionStatus{
+			Conditions: status.Conditions{
+				{Type: marin3rv1alpha1.RevisionPublishedCondition, Status: corev1.ConditionTrue},
+			},
+			Clients: &marin3rv1alpha1.ClientsStatus{
+				Connected: 2,
+				InSync:    1,
+				Summary:   "1/2 clients on version xxxx",
+				Details: []marin3rv1alpha1.ClientStatus{
+					{StreamID: "v3/sotw/1", Replica: "ds-a", AckedVersion: "xxxx", InSync: true},
+					{StreamID: "v3/sotw/1", Replica: "ds-b", AckedVersion: "zzzz"},
+				},
+			},
+		},
+	}
+
+	unpublished := ecr.DeepCopy()
+	unpublished.Status.Conditions = status.Conditions{}
+
+	tests := []struct {
+		name    string
+		ecr     *marin3rv1alpha1.EnvoyConfigRevision
+		isAlive func(string) bool
+		want    bool
+		wantCS  *marin3rv1alpha1.ClientsStatus
+	}{
+		{
+			name:    "Keeps the clients of live replicas",
+			ecr:     ecr,
+			isAlive: func(string) bool { return true },
+			want:    false,
+			wantCS:  ecr.Status.Clients,
+		},
+		{
+			name:    "Keeps the last status of revisions that are no longer published",
+			ecr:     unpublished,
+			isAlive: func(replica string) bool { return replica == "ds-a" },
+			want:    false,
+			wantCS:  ecr.Status.Clients,
+		},
+		{
+			name:    "Removes the clients of dead replicas",
+			ecr:     ecr,
+			isAlive: func(replica string) bool { return replica == "ds-a" },
+			want:    true,
+			wantCS: &marin3rv1alpha1.ClientsStatus{
+				Connected: 1,
+				InSync:    1,
+				Summary:   "1/1 clients on version xxxx",
+				Details: []marin3rv1alpha1.ClientStatus{
+					{StreamID: "v3/sotw/1", Replica: "ds-a", AckedVersion: "xxxx", InSync: true},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.ecr.DeepCopy()
+			if pruned := PruneClientsStatus(got, tt.isAlive); pruned != tt.want {
+				t.Errorf("PruneClientsStatus() = %v, want %v", pruned, tt.want)
+			}
+			if !equality.Semantic.DeepEqual(got.Status.Clients, tt.wantCS) {
+				t.Errorf("PruneClientsStatus() clients = %v, want %v", got.Status.Clients, tt.wantCS)
+			}
+		})
+	}
+}
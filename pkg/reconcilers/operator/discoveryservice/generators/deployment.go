@@ -2,6 +2,7 @@ package generators
 
 import (
 	"fmt"
+	"strings"
 
 	operatorv1alpha1 "github.com/3scale/marin3r/apis/operator/v1alpha1"
 	"github.com/3scale/marin3r/pkg/reconcilers/lockedresources"
@@ -13,7 +14,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (cfg *GeneratorOptions) Deployment(hash string) lockedresources.GeneratorFunction {
+func (cfg *GeneratorOptions) Deployment() lockedresources.GeneratorFunction {
 
 	return func() client.Object {
 
@@ -28,18 +29,14 @@ func (cfg *GeneratorOptions) Deployment(hash string) lockedresources.GeneratorFu
 				Labels:    cfg.labels(),
 			},
 			Spec: appsv1.DeploymentSpec{
-				Replicas: pointer.Int32Ptr(1),
+				Replicas: pointer.Int32Ptr(cfg.Replicas),
 				Selector: &metav1.LabelSelector{
 					MatchLabels: cfg.labels(),
 				},
 				Template: corev1.PodTemplateSpec{
 					ObjectMeta: metav1.ObjectMeta{
 						CreationTimestamp: metav1.Time{},
-						Labels: func() (labels map[string]string) {
-							labels = cfg.labels()
-							labels[operatorv1alpha1.DiscoveryServiceCertificateHashLabelKey] = hash
-							return
-						}(),
+						Labels:            cfg.labels(),
 					},
 					Spec: corev1.PodSpec{
 						Volumes: []corev1.Volume{
@@ -73,24 +70,79 @@ func (cfg *GeneratorOptions) Deployment(hash string) lockedresources.GeneratorFu
 										"--ca-certificate-path=/etc/marin3r/tls/ca",
 										func() string { return fmt.Sprintf("--xdss-port=%v", cfg.XdsServerPort) }(),
 										func() string { return fmt.Sprintf("--metrics-addr=:%v", cfg.MetricsServerPort) }(),
+										func() string { return fmt.Sprintf("--health-probe-addr=:%v", cfg.HealthProbePort) }(),
+										// Leader election is always enabled, also with one replica, as rolling
+										// updates run the old and the new pods side by side for a while
+										"--enable-leader-election",
+									}
+									if cfg.RestServerPort != 0 {
+										args = append(args, fmt.Sprintf("--xdss-rest-port=%v", cfg.RestServerPort))
+									}
+									if cfg.NodeIDAuthorization.Policy != "" {
+										args = append(args, fmt.Sprintf("--node-id-authorization=%s", cfg.NodeIDAuthorization.Policy))
+									}
+									for _, m := range cfg.NodeIDAuthorization.Mappings {
+										args = append(args, fmt.Sprintf("--node-id-mapping=%s=%s", m.Identity, strings.Join(m.NodeIDs, ",")))
+									}
+									if cfg.NodeHash.Kind != "" {
+										args = append(args, fmt.Sprintf("--node-hash=%s", cfg.NodeHash.Kind))
+									}
+									if cfg.NodeHash.MetadataField != "" {
+										args = append(args, fmt.Sprintf("--node-hash-metadata-field=%s", cfg.NodeHash.MetadataField))
+									}
+									if cfg.NodeHash.Regex != "" {
+										args = append(args, fmt.Sprintf("--node-hash-regex=%s", cfg.NodeHash.Regex))
+									}
+									for _, prefix := range cfg.NodeHash.Prefixes {
+										args = append(args, fmt.Sprintf("--node-hash-prefix=%s", prefix))
+									}
+									args = append(args, grpcServerArgs(cfg.GrpcServerOptions)...)
+									args = append(args, snapshotPublishingArgs(cfg.SnapshotPublishing)...)
+									if cfg.DebugServer != nil {
+										args = append(args, fmt.Sprintf("--debug-server-port=%v", cfg.DebugServer.Port))
+										for _, identity := range cfg.DebugServer.AllowedIdentities {
+											args = append(args, fmt.Sprintf("--debug-server-allowed-identity=%s", identity))
+										}
 									}
 									if cfg.Debug {
 										args = append(args, "--debug")
 									}
 									return
 								}(),
-								Ports: []corev1.ContainerPort{
-									{
-										Name:          "discovery",
-										ContainerPort: int32(cfg.XdsServerPort),
-										Protocol:      corev1.ProtocolTCP,
-									},
-									{
-										Name:          "metrics",
-										ContainerPort: int32(cfg.MetricsServerPort),
-										Protocol:      corev1.ProtocolTCP,
-									},
-								},
+								Ports: func() (ports []corev1.ContainerPort) {
+									ports = []corev1.ContainerPort{
+										{
+											Name:          "discovery",
+											ContainerPort: int32(cfg.XdsServerPort),
+											Protocol:      corev1.ProtocolTCP,
+										},
+										{
+											Name:          "metrics",
+											ContainerPort: int32(cfg.MetricsServerPort),
+											Protocol:      corev1.ProtocolTCP,
+										},
+										{
+											Name:          "health",
+											ContainerPort: int32(cfg.HealthProbePort),
+											Protocol:      corev1.ProtocolTCP,
+										},
+									}
+									if cfg.RestServerPort != 0 {
+										ports = append(ports, corev1.ContainerPort{
+											Name:          "discovery-rest",
+											ContainerPort: int32(cfg.RestServerPort),
+											Protocol:      corev1.ProtocolTCP,
+										})
+									}
+									if cfg.DebugServer != nil {
+										ports = append(ports, corev1.ContainerPort{
+											Name:          "debug",
+											ContainerPort: int32(cfg.DebugServer.Port),
+											Protocol:      corev1.ProtocolTCP,
+										})
+									}
+									return
+								}(),
 								Env: []corev1.EnvVar{
 									{Name: "WATCH_NAMESPACE", Value: cfg.Namespace},
 									{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{
@@ -100,6 +152,34 @@ func (cfg *GeneratorOptions) Deployment(hash string) lockedresources.GeneratorFu
 										},
 									}},
 								},
+								LivenessProbe: &corev1.Probe{
+									Handler: corev1.Handler{
+										HTTPGet: &corev1.HTTPGetAction{
+											Path:   "/healthz",
+											Port:   intstr.FromString("health"),
+											Scheme: corev1.URISchemeHTTP,
+										},
+									},
+									InitialDelaySeconds: 15,
+									TimeoutSeconds:      1,
+									PeriodSeconds:       10,
+									SuccessThreshold:    1,
+									FailureThreshold:    3,
+								},
+								ReadinessProbe: &corev1.Probe{
+									Handler: corev1.Handler{
+										HTTPGet: &corev1.HTTPGetAction{
+											Path:   "/readyz",
+											Port:   intstr.FromString("health"),
+											Scheme: corev1.URISchemeHTTP,
+										},
+									},
+									InitialDelaySeconds: 5,
+									TimeoutSeconds:      1,
+									PeriodSeconds:       5,
+									SuccessThreshold:    1,
+									FailureThreshold:    3,
+								},
 								Resources: cfg.DeploymentResources,
 								VolumeMounts: []corev1.VolumeMount{
 									{
@@ -146,3 +226,55 @@ func (cfg *GeneratorOptions) Deployment(hash string) lockedresources.GeneratorFu
 		}
 	}
 }
+
+// grpcServerArgs returns the discovery service flags for the
+// gRPC server options that are set
+func grpcServerArgs(opts *operatorv1alpha1.GrpcServerOptions) []string {
+	args := []string{}
+	if opts == nil {
+		return args
+	}
+
+	if opts.MaxConcurrentStreams != nil {
+		args = append(args, fmt.Sprintf("--grpc-max-concurrent-streams=%v", *opts.MaxConcurrentStreams))
+	}
+	if opts.KeepaliveMinTime != nil {
+		args = append(args, fmt.Sprintf("--grpc-keepalive-min-time=%s", opts.KeepaliveMinTime.Duration))
+	}
+	if opts.KeepalivePermitWithoutStream != nil {
+		args = append(args, fmt.Sprintf("--grpc-keepalive-permit-without-stream=%t", *opts.KeepalivePermitWithoutStream))
+	}
+	if opts.KeepaliveTime != nil {
+		args = append(args, fmt.Sprintf("--grpc-keepalive-time=%s", opts.KeepaliveTime.Duration))
+	}
+	if opts.KeepaliveTimeout != nil {
+		args = append(args, fmt.Sprintf("--grpc-keepalive-timeout=%s", opts.KeepaliveTimeout.Duration))
+	}
+	if opts.MaxConnectionAge != nil {
+		args = append(args, fmt.Sprintf("--grpc-max-connection-age=%s", opts.MaxConnectionAge.Duration))
+	}
+	if opts.MaxConnectionAgeGrace != nil {
+		args = append(args, fmt.Sprintf("--grpc-max-connection-age-grace=%s", opts.MaxConnectionAgeGrace.Duration))
+	}
+	if opts.GracefulStopTimeout != nil {
+		args = append(args, fmt.Sprintf("--grpc-graceful-stop-timeout=%s", opts.GracefulStopTimeout.Duration))
+	}
+	return args
+}
+
+// snapshotPublishingArgs returns the discovery service flags
+// for the snapshot publishing options that are set
+func snapshotPublishingArgs(opts *operatorv1alpha1.SnapshotPublishing) []string {
+	args := []string{}
+	if opts == nil {
+		return args
+	}
+
+	if opts.DebounceWindow != nil {
+		args = append(args, fmt.Sprintf("--snapshot-debounce-window=%s", opts.DebounceWindow.Duration))
+	}
+	if opts.MaxDelay != nil {
+		args = append(args, fmt.Sprintf("--snapshot-max-delay=%s", opts.MaxDelay.Duration))
+	}
+	return args
+}
@@ -15,13 +15,9 @@ import (
 )
 
 func TestGeneratorOptions_Deployment(t *testing.T) {
-	type args struct {
-		hash string
-	}
 	tests := []struct {
 		name string
 		opts GeneratorOptions
-		args args
 		want client.Object
 	}{
 		{"Generates a Deployment",
@@ -36,13 +32,31 @@ func TestGeneratorOptions_Deployment(t *testing.T) {
 				ServerCertificateDuration:         time.Duration(10), // 90 days,
 				ClientCertificateDuration:         time.Duration(10),
 				XdsServerPort:                     1000,
+				RestServerPort:                    1002,
 				MetricsServerPort:                 1001,
+				HealthProbePort:                   1003,
 				ServiceType:                       operatorv1alpha1.ClusterIPType,
 				DeploymentImage:                   "test:latest",
 				DeploymentResources:               corev1.ResourceRequirements{},
-				Debug:                             true,
+				Replicas:                          2,
+				NodeIDAuthorization: operatorv1alpha1.NodeIDAuthorization{
+					Policy:   operatorv1alpha1.MappingNodeIDAuthorizationPolicy,
+					Mappings: []operatorv1alpha1.NodeIDMapping{{Identity: "envoy", NodeIDs: []string{"node1", "node2"}}},
+				},
+				NodeHash: operatorv1alpha1.NodeHash{
+					Kind:  operatorv1alpha1.RegexNodeHashKind,
+					Regex: "^(.*)-[a-z0-9]+$",
+				},
+				GrpcServerOptions: &operatorv1alpha1.GrpcServerOptions{
+					MaxConcurrentStreams: func() *uint32 { var i uint32 = 100; return &i }(),
+					MaxConnectionAge:     &metav1.Duration{Duration: 30 * time.Minute},
+				},
+				SnapshotPublishing: &operatorv1alpha1.SnapshotPublishing{
+					DebounceWindow: &metav1.Duration{Duration: 200 * time.Millisecond},
+				},
+				DebugServer: &operatorv1alpha1.DebugServer{Port: 1004, AllowedIdentities: []string{"admin"}},
+				Debug:       true,
 			},
-			args{hash: "hash"},
 			&appsv1.Deployment{
 				TypeMeta: metav1.TypeMeta{
 					Kind:       "Deployment",
@@ -59,7 +73,7 @@ func TestGeneratorOptions_Deployment(t *testing.T) {
 					},
 				},
 				Spec: appsv1.DeploymentSpec{
-					Replicas: pointer.Int32Ptr(1),
+					Replicas: pointer.Int32Ptr(2),
 					Selector: &metav1.LabelSelector{
 						MatchLabels: map[string]string{
 							"app.kubernetes.io/name":       "marin3r",
@@ -72,11 +86,10 @@ func TestGeneratorOptions_Deployment(t *testing.T) {
 						ObjectMeta: metav1.ObjectMeta{
 							CreationTimestamp: metav1.Time{},
 							Labels: map[string]string{
-								"app.kubernetes.io/name":                                 "marin3r",
-								"app.kubernetes.io/managed-by":                           "marin3r-operator",
-								"app.kubernetes.io/component":                            "discovery-service",
-								"app.kubernetes.io/instance":                             "test",
-								operatorv1alpha1.DiscoveryServiceCertificateHashLabelKey: "hash",
+								"app.kubernetes.io/name":       "marin3r",
+								"app.kubernetes.io/managed-by": "marin3r-operator",
+								"app.kubernetes.io/component":  "discovery-service",
+								"app.kubernetes.io/instance":   "test",
 							}},
 						Spec: corev1.PodSpec{
 							Volumes: []corev1.Volume{
@@ -109,6 +122,18 @@ func TestGeneratorOptions_Deployment(t *testing.T) {
 										"--ca-certificate-path=/etc/marin3r/tls/ca",
 										"--xdss-port=1000",
 										"--metrics-addr=:1001",
+										"--health-probe-addr=:1003",
+										"--enable-leader-election",
+										"--xdss-rest-port=1002",
+										"--node-id-authorization=Mapping",
+										"--node-id-mapping=envoy=node1,node2",
+										"--node-hash=Regex",
+										"--node-hash-regex=^(.*)-[a-z0-9]+$",
+										"--grpc-max-concurrent-streams=100",
+										"--grpc-max-connection-age=30m0s",
+										"--snapshot-debounce-window=200ms",
+										"--debug-server-port=1004",
+										"--debug-server-allowed-identity=admin",
 										"--debug",
 									},
 									Ports: []corev1.ContainerPort{
@@ -122,6 +147,21 @@ func TestGeneratorOptions_Deployment(t *testing.T) {
 											ContainerPort: int32(1001),
 											Protocol:      corev1.ProtocolTCP,
 										},
+										{
+											Name:          "health",
+											ContainerPort: int32(1003),
+											Protocol:      corev1.ProtocolTCP,
+										},
+										{
+											Name:          "discovery-rest",
+											ContainerPort: int32(1002),
+											Protocol:      corev1.ProtocolTCP,
+										},
+										{
+											Name:          "debug",
+											ContainerPort: int32(1004),
+											Protocol:      corev1.ProtocolTCP,
+										},
 									},
 									Env: []corev1.EnvVar{
 										{Name: "WATCH_NAMESPACE", Value: "default"},
@@ -132,6 +172,34 @@ func TestGeneratorOptions_Deployment(t *testing.T) {
 											},
 										}},
 									},
+									LivenessProbe: &corev1.Probe{
+										Handler: corev1.Handler{
+											HTTPGet: &corev1.HTTPGetAction{
+												Path:   "/healthz",
+												Port:   intstr.FromString("health"),
+												Scheme: corev1.URISchemeHTTP,
+											},
+										},
+										InitialDelaySeconds: 15,
+										TimeoutSeconds:      1,
+										PeriodSeconds:       10,
+										SuccessThreshold:    1,
+										FailureThreshold:    3,
+									},
+									ReadinessProbe: &corev1.Probe{
+										Handler: corev1.Handler{
+											HTTPGet: &corev1.HTTPGetAction{
+												Path:   "/readyz",
+												Port:   intstr.FromString("health"),
+												Scheme: corev1.URISchemeHTTP,
+											},
+										},
+										InitialDelaySeconds: 5,
+										TimeoutSeconds:      1,
+										PeriodSeconds:       5,
+										SuccessThreshold:    1,
+										FailureThreshold:    3,
+									},
 									Resources: corev1.ResourceRequirements{},
 									VolumeMounts: []corev1.VolumeMount{
 										{
@@ -181,9 +249,25 @@ func TestGeneratorOptions_Deployment(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := tt.opts
-			if got := cfg.Deployment(tt.args.hash)(); !equality.Semantic.DeepEqual(got, tt.want) {
+			if got := cfg.Deployment()(); !equality.Semantic.DeepEqual(got, tt.want) {
 				t.Errorf("GeneratorOptions.Deployment() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestGeneratorOptions_Deployment_leaderElection(t *testing.T) {
+	for _, replicas := range []int32{1, 3} {
+		cfg := GeneratorOptions{InstanceName: "test", Namespace: "default", Replicas: replicas}
+		args := cfg.Deployment()().(*appsv1.Deployment).Spec.Template.Spec.Containers[0].Args
+		found := false
+		for _, arg := range args {
+			if arg == "--enable-leader-election" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("GeneratorOptions.Deployment() with %d replicas args = %v, want --enable-leader-election", replicas, args)
+		}
+	}
+}
@@ -19,10 +19,18 @@ type GeneratorOptions struct {
 	ServerCertificateDuration         time.Duration
 	ClientCertificateDuration         time.Duration
 	XdsServerPort                     int32
+	RestServerPort                    int32
 	MetricsServerPort                 int32
+	HealthProbePort                   int32
 	ServiceType                       operatorv1alpha1.ServiceType
 	DeploymentImage                   string
 	DeploymentResources               corev1.ResourceRequirements
+	Replicas                          int32
+	NodeIDAuthorization               operatorv1alpha1.NodeIDAuthorization
+	NodeHash                          operatorv1alpha1.NodeHash
+	GrpcServerOptions                 *operatorv1alpha1.GrpcServerOptions
+	SnapshotPublishing                *operatorv1alpha1.SnapshotPublishing
+	DebugServer                       *operatorv1alpha1.DebugServer
 	Debug                             bool
 }
 
@@ -7,6 +7,7 @@ import (
 	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
 	operatorv1alpha1 "github.com/3scale/marin3r/apis/operator/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -63,6 +64,31 @@ func TestGeneratorOptions_Role(t *testing.T) {
 						Resources: []string{"secrets"},
 						Verbs:     []string{"get", "list", "watch"},
 					},
+					{
+						APIGroups: []string{corev1.SchemeGroupVersion.Group},
+						Resources: []string{"pods"},
+						Verbs:     []string{"get"},
+					},
+					{
+						APIGroups: []string{corev1.SchemeGroupVersion.Group},
+						Resources: []string{"configmaps"},
+						Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+					},
+					{
+						APIGroups: []string{corev1.SchemeGroupVersion.Group},
+						Resources: []string{"services"},
+						Verbs:     []string{"get", "list", "watch"},
+					},
+					{
+						APIGroups: []string{discoveryv1beta1.SchemeGroupVersion.Group},
+						Resources: []string{"endpointslices"},
+						Verbs:     []string{"get", "list", "watch"},
+					},
+					{
+						APIGroups: []string{corev1.SchemeGroupVersion.Group},
+						Resources: []string{"events"},
+						Verbs:     []string{"create", "patch"},
+					},
 					{
 						APIGroups: []string{marin3rv1alpha1.GroupVersion.Group},
 						Resources: []string{rbacv1.ResourceAll},
@@ -38,20 +38,31 @@ func (cfg *GeneratorOptions) Service() lockedresources.GeneratorFunction {
 				}(),
 				Selector:        cfg.labels(),
 				SessionAffinity: corev1.ServiceAffinityNone,
-				Ports: []corev1.ServicePort{
-					{
-						Name:       "discovery",
-						Port:       cfg.XdsServerPort,
-						Protocol:   corev1.ProtocolTCP,
-						TargetPort: intstr.FromString("discovery"),
-					},
-					{
-						Name:       "metrics",
-						Port:       cfg.MetricsServerPort,
-						Protocol:   corev1.ProtocolTCP,
-						TargetPort: intstr.FromString("metrics"),
-					},
-				},
+				Ports: func() (ports []corev1.ServicePort) {
+					ports = []corev1.ServicePort{
+						{
+							Name:       "discovery",
+							Port:       cfg.XdsServerPort,
+							Protocol:   corev1.ProtocolTCP,
+							TargetPort: intstr.FromString("discovery"),
+						},
+						{
+							Name:       "metrics",
+							Port:       cfg.MetricsServerPort,
+							Protocol:   corev1.ProtocolTCP,
+							TargetPort: intstr.FromString("metrics"),
+						},
+					}
+					if cfg.RestServerPort != 0 {
+						ports = append(ports, corev1.ServicePort{
+							Name:       "discovery-rest",
+							Port:       cfg.RestServerPort,
+							Protocol:   corev1.ProtocolTCP,
+							TargetPort: intstr.FromString("discovery-rest"),
+						})
+					}
+					return
+				}(),
 			},
 		}
 	}
@@ -34,6 +34,7 @@ func TestGeneratorOptions_Service(t *testing.T) {
 				ServerCertificateDuration:         time.Duration(10 * time.Second), // 90 days,
 				ClientCertificateDuration:         time.Duration(10 * time.Second),
 				XdsServerPort:                     1000,
+				RestServerPort:                    1002,
 				MetricsServerPort:                 1001,
 				ServiceType:                       operatorv1alpha1.ClusterIPType,
 				DeploymentImage:                   "test:latest",
@@ -79,6 +80,12 @@ func TestGeneratorOptions_Service(t *testing.T) {
 							Protocol:   corev1.ProtocolTCP,
 							TargetPort: intstr.FromString("metrics"),
 						},
+						{
+							Name:       "discovery-rest",
+							Port:       1002,
+							Protocol:   corev1.ProtocolTCP,
+							TargetPort: intstr.FromString("discovery-rest"),
+						},
 					},
 				},
 			},
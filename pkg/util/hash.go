@@ -1,6 +1,7 @@
 package util
 
 import (
+	"encoding/json"
 	"fmt"
 	"hash"
 	"hash/fnv"
@@ -28,3 +29,16 @@ func Hash(o interface{}) string {
 	DeepHashObject(hasher, o)
 	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
 }
+
+// HashJSON returns the hash of the JSON encoding of the object, which
+// doesn't change when fields that are omitted when empty are added to
+// its type. The object must be encodable as JSON.
+func HashJSON(o interface{}) string {
+	data, err := json.Marshal(o)
+	if err != nil {
+		panic(err)
+	}
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
@@ -0,0 +1,72 @@
+package envoyconfigv1alpha1validator
+
+import (
+	"context"
+	"net/http"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// ValidatePath is the path where the webhook server listens
+	// for admission requests
+	ValidatePath string = "/envoyconfig-v1alpha1-validate"
+)
+
+// EnvoyConfigValidator validates the envoy resources of EnvoyConfigs
+type EnvoyConfigValidator struct {
+	decoder *admission.Decoder
+}
+
+// Handle rejects the EnvoyConfigs whose envoy resources are not valid. Updates that don't change
+// the envoy resources are always allowed, so EnvoyConfigs created before the webhook was enabled,
+// or that are no longer valid for a newer release, can still be updated and deleted.
+func (a *EnvoyConfigValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	ec := &marin3rv1alpha1.EnvoyConfig{}
+
+	err := a.decoder.Decode(req, ec)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if req.Operation == admissionv1.Update {
+		if ec.GetDeletionTimestamp() != nil {
+			return admission.Allowed("")
+		}
+		old := &marin3rv1alpha1.EnvoyConfig{}
+		if err := a.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		// The resources are decoded with the serialization and envoy API declared in
+		// the spec, so they need to be validated again if any of those change
+		if equality.Semantic.DeepEqual(old.Spec.EnvoyResources, ec.Spec.EnvoyResources) &&
+			old.GetSerialization() == ec.GetSerialization() && old.GetEnvoyAPIVersion() == ec.GetEnvoyAPIVersion() {
+			return admission.Allowed("")
+		}
+	}
+
+	if errs := Validate(ec); len(errs) > 0 {
+		status := errors.NewInvalid(marin3rv1alpha1.GroupVersion.WithKind("EnvoyConfig").GroupKind(), ec.GetName(), errs).Status()
+		return admission.Response{
+			AdmissionResponse: admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result:  &status,
+			},
+		}
+	}
+
+	return admission.Allowed("")
+}
+
+// EnvoyConfigValidator implements admission.DecoderInjector.
+// A decoder will be automatically injected.
+
+// InjectDecoder injects the decoder.
+func (a *EnvoyConfigValidator) InjectDecoder(d *admission.Decoder) error {
+	a.decoder = d
+	return nil
+}
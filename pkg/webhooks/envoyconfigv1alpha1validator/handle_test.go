@@ -0,0 +1,172 @@
+package envoyconfigv1alpha1validator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func init() {
+	scheme.Scheme.AddKnownTypes(marin3rv1alpha1.GroupVersion,
+		&marin3rv1alpha1.EnvoyConfig{},
+		&marin3rv1alpha1.EnvoyConfigList{},
+	)
+}
+
+func testRequest(raw string) admission.Request {
+	return admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			UID:       "xxxx",
+			Kind:      metav1.GroupVersionKind{Group: "marin3r.3scale.net", Version: "v1alpha1", Kind: "EnvoyConfig"},
+			Resource:  metav1.GroupVersionResource{Group: "marin3r.3scale.net", Version: "v1alpha1", Resource: "envoyconfigs"},
+			Namespace: "default",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: []byte(raw)},
+		},
+	}
+}
+
+func testUpdateRequest(old, raw string) admission.Request {
+	req := testRequest(raw)
+	req.Operation = admissionv1.Update
+	req.OldObject = runtime.RawExtension{Raw: []byte(old)}
+	return req
+}
+
+// testEnvoyConfigJSON returns an EnvoyConfig with a single cluster with the
+// given value, with the deletion timestamp set if 'deleted' is true
+func testEnvoyConfigJSON(cluster string, deleted bool) string {
+	metadata := `{"name": "test", "namespace": "default"}`
+	if deleted {
+		metadata = `{"name": "test", "namespace": "default", "deletionTimestamp": "2021-01-01T00:00:00Z"}`
+	}
+	return fmt.Sprintf(`{
+		"apiVersion": "marin3r.3scale.net/v1alpha1",
+		"kind": "EnvoyConfig",
+		"metadata": %s,
+		"spec": {
+			"nodeID": "test",
+			"envoyAPI": "v3",
+			"envoyResources": {"clusters": [{"name": "cluster", "value": %q}]}
+		}
+	}`, metadata, cluster)
+}
+
+func TestEnvoyConfigValidator_Handle(t *testing.T) {
+	validCluster := `{"name": "cluster", "connect_timeout": "1s"}`
+	invalidCluster := `{"name": "cluster", "conect_timeout": "1s"}`
+
+	tests := []struct {
+		name        string
+		req         admission.Request
+		wantAllowed bool
+		wantCode    int32
+		wantCauses  []string
+	}{
+		{
+			name: "Allows a valid EnvoyConfig",
+			req: testRequest(`
+				{
+					"apiVersion": "marin3r.3scale.net/v1alpha1",
+					"kind": "EnvoyConfig",
+					"metadata": {"name": "test", "namespace": "default"},
+					"spec": {
+						"nodeID": "test",
+						"envoyAPI": "v3",
+						"envoyResources": {
+							"clusters": [{"name": "cluster", "value": "{\"name\": \"cluster\", \"connect_timeout\": \"1s\"}"}]
+						}
+					}
+				}
+			`),
+			wantAllowed: true,
+			wantCode:    http.StatusOK,
+			wantCauses:  []string{},
+		},
+		{
+			name: "Rejects an EnvoyConfig with invalid resources",
+			req: testRequest(`
+				{
+					"apiVersion": "marin3r.3scale.net/v1alpha1",
+					"kind": "EnvoyConfig",
+					"metadata": {"name": "test", "namespace": "default"},
+					"spec": {
+						"nodeID": "test",
+						"envoyAPI": "v3",
+						"envoyResources": {
+							"clusters": [{"name": "cluster", "value": "{\"name\": \"cluster\", \"conect_timeout\": \"1s\"}"}]
+						}
+					}
+				}
+			`),
+			wantAllowed: false,
+			wantCode:    http.StatusUnprocessableEntity,
+			wantCauses:  []string{"spec.envoyResources.clusters[0].value"},
+		},
+		{
+			name:        "Allows an update that does not change the envoy resources",
+			req:         testUpdateRequest(testEnvoyConfigJSON(invalidCluster, false), testEnvoyConfigJSON(invalidCluster, false)),
+			wantAllowed: true,
+			wantCode:    http.StatusOK,
+			wantCauses:  []string{},
+		},
+		{
+			name:        "Rejects an update that changes the envoy resources to invalid ones",
+			req:         testUpdateRequest(testEnvoyConfigJSON(validCluster, false), testEnvoyConfigJSON(invalidCluster, false)),
+			wantAllowed: false,
+			wantCode:    http.StatusUnprocessableEntity,
+			wantCauses:  []string{"spec.envoyResources.clusters[0].value"},
+		},
+		{
+			name:        "Allows the updates of an EnvoyConfig that is being deleted",
+			req:         testUpdateRequest(testEnvoyConfigJSON(validCluster, true), testEnvoyConfigJSON(invalidCluster, true)),
+			wantAllowed: true,
+			wantCode:    http.StatusOK,
+			wantCauses:  []string{},
+		},
+		{
+			name:        "Returns an error if the object cannot be decoded",
+			req:         testRequest(`{"apiVersion": "marin3r.3scale.net/v1alpha1", "kind": "EnvoyConfig", "spec": []}`),
+			wantAllowed: false,
+			wantCode:    http.StatusBadRequest,
+			wantCauses:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder, _ := admission.NewDecoder(scheme.Scheme)
+			a := &EnvoyConfigValidator{}
+			a.InjectDecoder(decoder)
+
+			got := a.Handle(context.TODO(), tt.req)
+			if got.Allowed != tt.wantAllowed {
+				t.Fatalf("EnvoyConfigValidator.Handle() allowed = %v, want %v", got.Allowed, tt.wantAllowed)
+			}
+			if got.Result.Code != tt.wantCode {
+				t.Errorf("EnvoyConfigValidator.Handle() code = %v, want %v", got.Result.Code, tt.wantCode)
+			}
+			causes := []string{}
+			if got.Result.Details != nil {
+				for _, cause := range got.Result.Details.Causes {
+					causes = append(causes, cause.Field)
+				}
+			}
+			if len(causes) != len(tt.wantCauses) {
+				t.Fatalf("EnvoyConfigValidator.Handle() causes = %v, want %v", causes, tt.wantCauses)
+			}
+			for idx := range causes {
+				if causes[idx] != tt.wantCauses[idx] {
+					t.Errorf("EnvoyConfigValidator.Handle() causes = %v, want %v", causes, tt.wantCauses)
+				}
+			}
+		})
+	}
+}
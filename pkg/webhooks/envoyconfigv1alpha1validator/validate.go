@@ -0,0 +1,233 @@
+package envoyconfigv1alpha1validator
+
+import (
+	"fmt"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	xdss "github.com/3scale/marin3r/pkg/discoveryservice/xdss"
+	xdss_v2 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v2"
+	xdss_v3 "github.com/3scale/marin3r/pkg/discoveryservice/xdss/v3"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_lint "github.com/3scale/marin3r/pkg/envoy/lint"
+	envoy_resources "github.com/3scale/marin3r/pkg/envoy/resources"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	cache_v2 "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
+	cache_v3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validator is implemented by the envoy proto messages, which
+// are generated with the protoc-gen-validate plugin
+type validator interface {
+	Validate() error
+}
+
+// resourceList is a list of envoy resources of a given
+// type and the field of EnvoyResources that holds them
+type resourceList struct {
+	field     string
+	rType     envoy.Type
+	resources []marin3rv1alpha1.EnvoyResource
+}
+
+// Validate checks the envoy resources of an EnvoyConfig. Each resource is decoded with the serialization
+// and envoy API version declared in the spec and validated against the rules of its proto definition. A
+// snapshot is then built with all the resources to check that it is consistent, which means that the
+// endpoints and routes referenced by clusters and listeners exist. The config sources of the extension
+// configs, scoped routes and virtual hosts must use the incremental variant of the xDS protocol, the only
+// one they are served over. Secrets are not validated, as they are loaded from Kubernetes Secrets by the
+// discovery service, but the endpoints generated from Services are taken into account in the consistency
+// check. Returns the errors found, with the path to the field that holds the invalid value.
+func Validate(ec *marin3rv1alpha1.EnvoyConfig) field.ErrorList {
+	_, errs := validate(ec)
+	return errs
+}
+
+// Lint runs the linter of the envoy package over the resources of an EnvoyConfig, which must be valid.
+// Placeholders are used for the secrets and the endpoints generated from Services, as their contents
+// are only known at runtime.
+func Lint(ec *marin3rv1alpha1.EnvoyConfig) (envoy_lint.Issues, error) {
+	snap, errs := validate(ec)
+	if len(errs) > 0 {
+		return nil, errs.ToAggregate()
+	}
+
+	generator := envoy_resources.NewGenerator(ec.GetEnvoyAPIVersion())
+	for _, secret := range ec.Spec.EnvoyResources.Secrets {
+		snap.SetResource(secret.Name, generator.NewSecret(secret.Name, "", ""))
+	}
+
+	return envoy_lint.Lint(ec.GetEnvoyAPIVersion(), lintResources(snap))
+}
+
+// lintResources returns the resources of a snapshot in the format the linter takes
+func lintResources(snap xdss.Snapshot) envoy_lint.Resources {
+	resources := envoy_lint.Resources{}
+	for _, rType := range []envoy.Type{envoy.Endpoint, envoy.Cluster, envoy.Route, envoy.ScopedRoute, envoy.VirtualHost,
+		envoy.Listener, envoy.Secret, envoy.Runtime, envoy.ExtensionConfig} {
+		resources[rType] = snap.GetResources(rType)
+	}
+	return resources
+}
+
+// ResourcePath returns the path to the field of the EnvoyConfig that holds the envoy
+// resource with the given type and name, or the path to spec.envoyResources if there
+// is no such resource.
+func ResourcePath(ec *marin3rv1alpha1.EnvoyConfig, rType envoy.Type, name string) *field.Path {
+	resourcesPath := field.NewPath("spec", "envoyResources")
+	if ec.Spec.EnvoyResources == nil {
+		return resourcesPath
+	}
+
+	for _, list := range resourceLists(ec.Spec.EnvoyResources) {
+		if list.rType != rType {
+			continue
+		}
+		for idx, resource := range list.resources {
+			if resource.Name == name {
+				return resourcesPath.Child(list.field).Index(idx)
+			}
+		}
+	}
+	for idx, se := range ec.Spec.EnvoyResources.ServiceEndpoints {
+		if rType == envoy.Endpoint && se.Name == name {
+			return resourcesPath.Child("serviceEndpoints").Index(idx)
+		}
+	}
+	for idx, secret := range ec.Spec.EnvoyResources.Secrets {
+		if rType == envoy.Secret && secret.Name == name {
+			return resourcesPath.Child("secrets").Index(idx)
+		}
+	}
+
+	return resourcesPath
+}
+
+// resourceLists returns the lists of envoy resources held in EnvoyResources
+func resourceLists(resources *marin3rv1alpha1.EnvoyResources) []resourceList {
+	return []resourceList{
+		{"endpoints", envoy.Endpoint, resources.Endpoints},
+		{"clusters", envoy.Cluster, resources.Clusters},
+		{"routes", envoy.Route, resources.Routes},
+		{"listeners", envoy.Listener, resources.Listeners},
+		{"runtime", envoy.Runtime, resources.Runtimes},
+		{"extensionConfigs", envoy.ExtensionConfig, resources.ExtensionConfigs},
+		{"scopedRoutes", envoy.ScopedRoute, resources.ScopedRoutes},
+		{"virtualHosts", envoy.VirtualHost, resources.VirtualHosts},
+	}
+}
+
+// Decode decodes the envoy resources held in an EnvoyResources struct with the given serialization and
+// envoy API version, without validating them against the rules of their proto definitions. Secrets and
+// the endpoints generated from Services are not decoded, as their contents are only known at runtime.
+// Returns the resources indexed by type and name, and the errors found, with the path to the field that
+// holds the value that could not be decoded.
+func Decode(resources *marin3rv1alpha1.EnvoyResources, serialization envoy_serializer.Serialization,
+	version envoy.APIVersion) (map[envoy.Type]map[string]envoy.Resource, field.ErrorList) {
+	return decode(resources, serialization, version, false)
+}
+
+// decode decodes the envoy resources, validating them against
+// the rules of their proto definitions if validateProtos is true
+func decode(resources *marin3rv1alpha1.EnvoyResources, serialization envoy_serializer.Serialization,
+	version envoy.APIVersion, validateProtos bool) (map[envoy.Type]map[string]envoy.Resource, field.ErrorList) {
+
+	errs := field.ErrorList{}
+	resourcesPath := field.NewPath("spec", "envoyResources")
+	if resources == nil {
+		return nil, append(errs, field.Required(resourcesPath, ""))
+	}
+
+	decoder := envoy_serializer.NewResourceUnmarshaller(serialization, version)
+	generator := envoy_resources.NewGenerator(version)
+	decoded := map[envoy.Type]map[string]envoy.Resource{}
+
+	for _, list := range resourceLists(resources) {
+		for idx, resource := range list.resources {
+			valuePath := resourcesPath.Child(list.field).Index(idx).Child("value")
+
+			res := generator.New(list.rType)
+			if res == nil {
+				errs = append(errs, field.Invalid(valuePath, resource.Value,
+					fmt.Sprintf("%s resources are not supported in envoy API %s", list.rType, version)))
+				continue
+			}
+			if err := decoder.Unmarshal(resource.Value, res); err != nil {
+				errs = append(errs, field.Invalid(valuePath, resource.Value, fmt.Sprintf("Invalid envoy resource value: '%s'", err)))
+				continue
+			}
+			if v, ok := res.(validator); ok && validateProtos {
+				if err := v.Validate(); err != nil {
+					errs = append(errs, field.Invalid(valuePath, resource.Value, fmt.Sprintf("Invalid envoy resource: '%s'", err)))
+					continue
+				}
+			}
+			if decoded[list.rType] == nil {
+				decoded[list.rType] = map[string]envoy.Resource{}
+			}
+			decoded[list.rType][resource.Name] = res
+		}
+	}
+
+	return decoded, errs
+}
+
+// validate validates the EnvoyConfig and returns the snapshot built with its resources
+func validate(ec *marin3rv1alpha1.EnvoyConfig) (xdss.Snapshot, field.ErrorList) {
+	resourcesPath := field.NewPath("spec", "envoyResources")
+	resources := ec.Spec.EnvoyResources
+
+	decoded, errs := decode(resources, ec.GetSerialization(), ec.GetEnvoyAPIVersion(), true)
+	if resources == nil {
+		return nil, errs
+	}
+
+	generator := envoy_resources.NewGenerator(ec.GetEnvoyAPIVersion())
+	snap := newSnapshot(ec.GetEnvoyAPIVersion())
+	for _, byName := range decoded {
+		for name, res := range byName {
+			snap.SetResource(name, res)
+		}
+	}
+
+	// The endpoints generated from Services are only known at runtime,
+	// so empty ones are used to check the consistency of the snapshot
+	for idx, se := range resources.ServiceEndpoints {
+		if _, ok := snap.GetResources(envoy.Endpoint)[se.Name]; ok {
+			errs = append(errs, field.Duplicate(resourcesPath.Child("serviceEndpoints").Index(idx).Child("name"), se.Name))
+			continue
+		}
+		snap.SetResource(se.Name, generator.NewClusterLoadAssignment(se.Name, nil))
+	}
+
+	// The consistency check is meaningless if some resources could not be loaded
+	if len(errs) > 0 {
+		return snap, errs
+	}
+
+	if err := snap.Consistent(); err != nil {
+		errs = append(errs, field.Invalid(resourcesPath, err.Error(), "The envoy resources are not consistent"))
+	}
+
+	// The extension configs, scoped routes and virtual hosts are only served over the incremental
+	// variant of the xDS protocol, so the clients that request them otherwise would never get them
+	issues, err := envoy_lint.Lint(ec.GetEnvoyAPIVersion(), lintResources(snap))
+	if err != nil {
+		return snap, append(errs, field.InternalError(resourcesPath, err))
+	}
+	for _, issue := range issues {
+		if issue.Rule == envoy_lint.DeltaConfigSourceRule {
+			errs = append(errs, field.Forbidden(ResourcePath(ec, issue.Type, issue.Name).Child("value"), issue.Message))
+		}
+	}
+
+	return snap, errs
+}
+
+// newSnapshot returns an empty snapshot for the given envoy API version
+func newSnapshot(version envoy.APIVersion) xdss.Snapshot {
+	if version == envoy.APIv3 {
+		return xdss_v3.NewCache(cache_v3.NewSnapshotCache(true, cache_v3.IDHash{}, nil)).NewSnapshot("")
+	}
+	return xdss_v2.NewCache(cache_v2.NewSnapshotCache(true, cache_v2.IDHash{}, nil)).NewSnapshot("")
+}
@@ -0,0 +1,222 @@
+package envoyconfigv1alpha1validator
+
+import (
+	"reflect"
+	"testing"
+
+	marin3rv1alpha1 "github.com/3scale/marin3r/apis/marin3r/v1alpha1"
+	envoy "github.com/3scale/marin3r/pkg/envoy"
+	envoy_lint "github.com/3scale/marin3r/pkg/envoy/lint"
+	envoy_serializer "github.com/3scale/marin3r/pkg/envoy/serializer"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	edsCluster    = `{"name": "cluster", "type": "EDS", "eds_cluster_config": {"eds_config": {"ads": {}}}}`
+	staticCluster = `{"name": "cluster", "connect_timeout": "1s"}`
+	endpoint      = `{"cluster_name": "cluster"}`
+	ecdsListener  = `{"name": "http", "address": {"socket_address": {"address": "0.0.0.0", "port_value": 8080}},
+		"filter_chains": [{"filters": [{"name": "envoy.filters.network.http_connection_manager", "typed_config": {
+		"@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+		"stat_prefix": "http", "route_config": {"name": "local"}, "http_filters": [{"name": "envoy.filters.http.router",
+		"config_discovery": {"config_source": {"ads": {}}, "type_urls": ["type.googleapis.com/envoy.extensions.filters.http.router.v3.Router"]}}]}}]}]}`
+)
+
+func testEnvoyConfig(api envoy.APIVersion, resources *marin3rv1alpha1.EnvoyResources) *marin3rv1alpha1.EnvoyConfig {
+	return &marin3rv1alpha1.EnvoyConfig{
+		Spec: marin3rv1alpha1.EnvoyConfigSpec{
+			NodeID:         "test",
+			EnvoyAPI:       pointer.StringPtr(api.String()),
+			Serialization:  pointer.StringPtr(string(envoy_serializer.JSON)),
+			EnvoyResources: resources,
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		ec         *marin3rv1alpha1.EnvoyConfig
+		wantFields []string
+	}{
+		{
+			name: "Valid resources",
+			ec: testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+				Clusters:  []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: edsCluster}},
+				Endpoints: []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: endpoint}},
+			}),
+			wantFields: []string{},
+		},
+		{
+			name: "Valid resources with the endpoints generated from a Service",
+			ec: testEnvoyConfig(envoy.APIv2, &marin3rv1alpha1.EnvoyResources{
+				Clusters:         []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: edsCluster}},
+				ServiceEndpoints: []marin3rv1alpha1.EnvoyServiceEndpointsResource{{Name: "cluster", ServiceName: "svc"}},
+			}),
+			wantFields: []string{},
+		},
+		{
+			name:       "Missing resources",
+			ec:         testEnvoyConfig(envoy.APIv3, nil),
+			wantFields: []string{"spec.envoyResources"},
+		},
+		{
+			name: "Resource that cannot be decoded",
+			ec: testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+				Clusters: []marin3rv1alpha1.EnvoyResource{
+					{Name: "cluster", Value: staticCluster},
+					{Name: "other", Value: `{"name": "other", "conect_timeout": "1s"}`},
+				},
+			}),
+			wantFields: []string{"spec.envoyResources.clusters[1].value"},
+		},
+		{
+			name: "Resource that does not pass the proto validation",
+			ec: testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+				Endpoints: []marin3rv1alpha1.EnvoyResource{{Name: "endpoint", Value: `{"cluster_name": ""}`}},
+			}),
+			wantFields: []string{"spec.envoyResources.endpoints[0].value"},
+		},
+		{
+			name: "Resource type not supported in the envoy API version",
+			ec: testEnvoyConfig(envoy.APIv2, &marin3rv1alpha1.EnvoyResources{
+				VirtualHosts: []marin3rv1alpha1.EnvoyResource{{Name: "vhost", Value: `{"name": "vhost"}`}},
+			}),
+			wantFields: []string{"spec.envoyResources.virtualHosts[0].value"},
+		},
+		{
+			name: "Endpoints generated from a Service that are already defined",
+			ec: testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+				Clusters:         []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: edsCluster}},
+				Endpoints:        []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: endpoint}},
+				ServiceEndpoints: []marin3rv1alpha1.EnvoyServiceEndpointsResource{{Name: "cluster", ServiceName: "svc"}},
+			}),
+			wantFields: []string{"spec.envoyResources.serviceEndpoints[0].name"},
+		},
+		{
+			name: "Extension configs requested with the state of the world variant of the protocol",
+			ec: testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+				Listeners: []marin3rv1alpha1.EnvoyResource{{Name: "http", Value: ecdsListener}},
+			}),
+			wantFields: []string{"spec.envoyResources.listeners[0].value"},
+		},
+		{
+			name: "Inconsistent resources",
+			ec: testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+				Clusters: []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: edsCluster}},
+			}),
+			wantFields: []string{"spec.envoyResources"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Validate(tt.ec)
+			fields := []string{}
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if len(fields) != len(tt.wantFields) {
+				t.Fatalf("Validate() = %v, want errors in %v", errs, tt.wantFields)
+			}
+			for idx := range fields {
+				if fields[idx] != tt.wantFields[idx] {
+					t.Errorf("Validate() = %v, want errors in %v", errs, tt.wantFields)
+				}
+			}
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	tlsCluster := `{"name": "cluster", "connect_timeout": "1s", "transport_socket": {"name": "envoy.transport_sockets.tls", "typed_config": {
+		"@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+		"common_tls_context": {"tls_certificate_sds_secret_configs": [{"name": "cert", "sds_config": {"ads": {}}}]}}}}`
+
+	tests := []struct {
+		name      string
+		ec        *marin3rv1alpha1.EnvoyConfig
+		wantRules []envoy_lint.Rule
+		wantErr   bool
+	}{
+		{
+			name: "Secrets are taken into account",
+			ec: testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+				Clusters: []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: tlsCluster}},
+				Secrets:  []marin3rv1alpha1.EnvoySecretResource{{Name: "cert"}},
+			}),
+			wantRules: []envoy_lint.Rule{},
+		},
+		{
+			name: "Returns the lint issues",
+			ec: testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+				Clusters: []marin3rv1alpha1.EnvoyResource{{Name: "other", Value: tlsCluster}},
+			}),
+			wantRules: []envoy_lint.Rule{envoy_lint.NameMismatchRule, envoy_lint.UnknownSecretRule},
+		},
+		{
+			name:    "Returns an error for invalid EnvoyConfigs",
+			ec:      testEnvoyConfig(envoy.APIv3, nil),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := Lint(tt.ec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Lint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			rules := []envoy_lint.Rule{}
+			for _, issue := range issues {
+				rules = append(rules, issue.Rule)
+			}
+			if !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Errorf("Lint() = %v, want issues for rules %v", issues, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestResourcePath(t *testing.T) {
+	ec := testEnvoyConfig(envoy.APIv3, &marin3rv1alpha1.EnvoyResources{
+		Clusters:         []marin3rv1alpha1.EnvoyResource{{Name: "a", Value: staticCluster}, {Name: "b", Value: staticCluster}},
+		ServiceEndpoints: []marin3rv1alpha1.EnvoyServiceEndpointsResource{{Name: "b", ServiceName: "svc"}},
+	})
+
+	tests := []struct {
+		name  string
+		rType envoy.Type
+		rName string
+		want  string
+	}{
+		{"Resource in a list", envoy.Cluster, "b", "spec.envoyResources.clusters[1]"},
+		{"Endpoints generated from a Service", envoy.Endpoint, "b", "spec.envoyResources.serviceEndpoints[0]"},
+		{"Unknown resource", envoy.Route, "b", "spec.envoyResources"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResourcePath(ec, tt.rType, tt.rName).String(); got != tt.want {
+				t.Errorf("ResourcePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	resources := &marin3rv1alpha1.EnvoyResources{
+		Clusters:  []marin3rv1alpha1.EnvoyResource{{Name: "cluster", Value: staticCluster}},
+		Endpoints: []marin3rv1alpha1.EnvoyResource{{Name: "endpoint", Value: `{"cluster_name": ""}`}},
+		Listeners: []marin3rv1alpha1.EnvoyResource{{Name: "listener", Value: `{"name": "listener", "adress": {}}`}},
+	}
+
+	got, errs := Decode(resources, envoy_serializer.JSON, envoy.APIv3)
+	if len(errs) != 1 || errs[0].Field != "spec.envoyResources.listeners[0].value" {
+		t.Errorf("Decode() errs = %v, want an error for the listener", errs)
+	}
+	// Resources that do not pass the proto validation are decoded
+	if len(got[envoy.Cluster]) != 1 || len(got[envoy.Endpoint]) != 1 || len(got[envoy.Listener]) != 0 {
+		t.Errorf("Decode() = %v", got)
+	}
+}